@@ -65,6 +65,60 @@ const (
 	// LoadBalancerAnnotationReservedIP is the identity of a reserved IP to attach when the load balancer is created.
 	// Updates reconcile attachment when the value changes; removing the annotation or setting an empty value detaches.
 	LoadBalancerAnnotationReservedIP = "loadbalancer.k8s.thalassa.cloud/reserved-ip"
+
+	// LoadBalancerAnnotationEmptyEndpointsPolicy determines the load balancer targets when a Service with externalTrafficPolicy=Local has no ready endpoints.
+	// Must be one of keep-all, detach-all or last-known-good. Defaults to the loadBalancer.emptyEndpointsPolicy cloud config setting, which defaults to keep-all.
+	// keep-all: All nodes stay attached and the health check marks them down.
+	// detach-all: All nodes are detached from the target groups.
+	// last-known-good: The nodes that last hosted a ready endpoint stay attached.
+	LoadBalancerAnnotationEmptyEndpointsPolicy = "loadbalancer.k8s.thalassa.cloud/empty-endpoints-policy"
+
+	// LoadBalancerAnnotationDriftRemediation determines what happens when the periodic drift detection finds load balancer resources
+	// that differ from the desired state. Must be one of repair or alert. Defaults to the loadBalancer.driftDetection.remediation cloud config setting, which defaults to repair.
+	// repair: The drift is reported and the Service is reconciled to restore the desired state.
+	// alert: The drift is only reported as Event and metric.
+	LoadBalancerAnnotationDriftRemediation = "loadbalancer.k8s.thalassa.cloud/drift-remediation"
+
+	// LoadBalancerAnnotationReconcile set to "paused" stops the CCM from changing or deleting the load balancer of the Service,
+	// e.g. while it is edited by hand during an incident. The current load balancer status is still reported.
+	LoadBalancerAnnotationReconcile = "loadbalancer.k8s.thalassa.cloud/reconcile"
+
+	// LoadBalancerAnnotationDeleteProtection is a boolean that enables delete protection on the loadbalancer.
+	// When the annotation is not set, the delete protection of the loadbalancer is left unchanged.
+	LoadBalancerAnnotationDeleteProtection = "loadbalancer.k8s.thalassa.cloud/delete-protection"
+
+	// LoadBalancerAnnotationDeletionPolicy determines what happens to the loadbalancer when the Service is deleted. Must be one of delete or retain. Default is delete.
+	// delete: The loadbalancer, its listeners, target groups and managed security group are deleted.
+	// retain: Listeners, target groups and the managed security group are deleted. The loadbalancer and its reserved IP are kept,
+	// the ownership labels are removed and the origin is recorded in annotations for adoption by a new Service.
+	LoadBalancerAnnotationDeletionPolicy = "loadbalancer.k8s.thalassa.cloud/deletion-policy"
+
+	// LoadBalancerAnnotationID is the identity of an existing loadbalancer in the cluster VPC to adopt instead of creating a new one.
+	// The loadbalancer is labeled as owned by the Service, and listeners and target groups on the ports of the Service are taken over.
+	// Listeners on other ports, or owned by another Service, are reported as conflicts and left unchanged.
+	LoadBalancerAnnotationID = "loadbalancer.k8s.thalassa.cloud/id"
+
+	// LoadBalancerAnnotationSharedGroup places the Service on the loadbalancer shared by all Services with the same group name,
+	// instead of creating a loadbalancer per Service. The Services must use distinct ports; a port used by another Service is reported as conflict.
+	// The shared loadbalancer is deleted when the last Service of the group is deleted.
+	LoadBalancerAnnotationSharedGroup = "loadbalancer.k8s.thalassa.cloud/shared-group"
+
+	// LoadBalancerAnnotationInternalCompanion is a boolean that adds an internal loadbalancer next to the public loadbalancer of the Service. Default is false.
+	// Both loadbalancers forward to the same target groups and their addresses are published in the Service status.
+	// Setting it to false deletes the internal loadbalancer. Cannot be combined with the shared-group annotation and is ignored for internal loadbalancers.
+	LoadBalancerAnnotationInternalCompanion = "loadbalancer.k8s.thalassa.cloud/internal-companion"
+	// LoadBalancerAnnotationInternalCompanionSubnet is the ID or slug of the subnet for the internal loadbalancer. Default is the subnet of the public loadbalancer.
+	// Can only be used upon creation of the internal loadbalancer.
+	LoadBalancerAnnotationInternalCompanionSubnet = "loadbalancer.k8s.thalassa.cloud/internal-companion-subnet"
+
+	// LoadBalancerAnnotationRecreate is a token that recreates the loadbalancer whenever it is set to a new value, e.g. a timestamp.
+	// Settings that cannot be changed in place, such as the internal setting or the subnet of an internal loadbalancer, are applied this way.
+	// A new loadbalancer is created and configured, takes over the reserved IP and the Service status, and the old loadbalancer is deleted.
+	LoadBalancerAnnotationRecreate = "loadbalancer.k8s.thalassa.cloud/recreate"
+
+	// LoadBalancerAnnotationTargetNodePool restricts the targets of the Service to the machines of a node pool, when the target
+	// selector mode of the cloud config is enabled. The value is matched against the node pool label the CCM sets on the machines.
+	LoadBalancerAnnotationTargetNodePool = "loadbalancer.k8s.thalassa.cloud/target-node-pool"
 )
 
 const (
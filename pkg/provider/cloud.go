@@ -13,7 +13,10 @@ import (
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
@@ -25,6 +28,10 @@ import (
 const (
 	// ProviderName is the name of the Thalassa Cloud provider
 	ProviderName = "thalassacloud"
+
+	// informerResyncPeriod is the resync period of the shared informer factory.
+	// Periodic reconciliation is handled by the resync loops of the provider.
+	informerResyncPeriod = 0
 )
 
 var scheme = runtime.NewScheme()
@@ -39,15 +46,27 @@ func init() {
 type Cloud struct {
 	config CloudConfig
 
-	iaasClient *iaas.Client
+	iaasClient CloudAPI
+
+	// vpc is the VPC of the cluster, read when the provider is created
+	vpc *iaas.Vpc
 
 	endpointSlicesClient clientset.Interface
-	endpointSliceWatcher *EndpointSliceWatcher
+
+	// loadbalancer and instances are constructed once in Initialize
+	loadbalancer *loadbalancer
+	instances    *instancesV2
+
+	// informerFactory is shared by all controllers and caches of the provider
+	informerFactory informers.SharedInformerFactory
+
+	eventRecorder record.EventRecorder
 }
 
 type CloudConfig struct {
-	InstancesV2  InstancesV2Config  `yaml:"instancesV2"`
-	LoadBalancer LoadBalancerConfig `yaml:"loadBalancer"`
+	InstancesV2   InstancesV2Config   `yaml:"instancesV2"`
+	LoadBalancer  LoadBalancerConfig  `yaml:"loadBalancer"`
+	VolumeLabeler VolumeLabelerConfig `yaml:"volumeLabeler"`
 
 	Organisation     string           `yaml:"organisation"`
 	Project          string           `yaml:"project"`
@@ -76,6 +95,38 @@ type LoadBalancerConfig struct {
 
 	// CreationPollTimeout determines how many seconds to wait for the load balancer creation
 	CreationPollTimeout *int `yaml:"creationPollTimeout,omitempty"`
+
+	// EmptyEndpointsPolicy is the default policy for Services with externalTrafficPolicy=Local without ready endpoints.
+	// Must be one of keep-all, detach-all or last-known-good. Can be overridden per Service with an annotation.
+	EmptyEndpointsPolicy EmptyEndpointsPolicy `yaml:"emptyEndpointsPolicy,omitempty"`
+
+	// ResyncWorkers is the number of Services the internal resync queue reconciles in parallel. Defaults to 1.
+	// Reconciles of the same Service are always serialized with the service controller.
+	ResyncWorkers *int `yaml:"resyncWorkers,omitempty"`
+
+	// LoadBalancerClass is the spec.loadBalancerClass of the Services reconciled by the CCM, e.g. thalassa.cloud/vpc.
+	// Services with another class are left to other load balancer implementations. Empty disables support for classes.
+	LoadBalancerClass string `yaml:"loadBalancerClass,omitempty"`
+
+	// HandleServicesWithoutClass determines if LoadBalancer Services without spec.loadBalancerClass are reconciled. Defaults to true.
+	HandleServicesWithoutClass *bool `yaml:"handleServicesWithoutClass,omitempty"`
+
+	// DriftDetection configures the periodic comparison of the cloud state against the desired state of all LoadBalancer Services
+	DriftDetection DriftDetectionConfig `yaml:"driftDetection,omitempty"`
+
+	// TargetSelector configures target groups that select the machines of the nodes by label, instead of attaching them explicitly
+	TargetSelector TargetSelectorConfig `yaml:"targetSelector,omitempty"`
+}
+
+type DriftDetectionConfig struct {
+	// Enabled activates the periodic drift detection
+	Enabled bool `yaml:"enabled"`
+
+	// Interval determines how many seconds to wait between two drift detection runs. Defaults to 600.
+	Interval *int `yaml:"interval,omitempty"`
+
+	// Remediation is the default remediation for drifted Services, repair or alert. Can be overridden per Service with an annotation.
+	Remediation DriftRemediation `yaml:"remediation,omitempty"`
 }
 
 type InstancesV2Config struct {
@@ -83,6 +134,19 @@ type InstancesV2Config struct {
 	Enabled bool `yaml:"enabled"`
 	// ZoneAndRegionEnabled indicates if need to get Region and zone labels from the cloud provider
 	ZoneAndRegionEnabled bool `yaml:"zoneAndRegionEnabled"`
+	// NodeMatchStrategies are the strategies tried in order to match a node to its machine: providerID, slug, name, internalIP, macAddress and label
+	NodeMatchStrategies []NodeMatchStrategy `yaml:"nodeMatchStrategies,omitempty"`
+	// NodeMatchLabel is the machine label compared with the same node label, or the node name, by the label strategy
+	NodeMatchLabel string `yaml:"nodeMatchLabel,omitempty"`
+	// MachineIndexRefreshInterval is the age in seconds after which the machine index used to match nodes is refreshed by
+	// the next lookup
+	MachineIndexRefreshInterval *int `yaml:"machineIndexRefreshInterval,omitempty"`
+	// NodeAddresses configures the addresses reported for nodes
+	NodeAddresses NodeAddressesConfig `yaml:"nodeAddresses,omitempty"`
+	// MachineLabels are the keys of the machine labels copied to the node, under the k8s.thalassa.cloud/ prefix
+	MachineLabels []string `yaml:"machineLabels,omitempty"`
+	// NodeMaintenance configures tainting the nodes of machines marked for maintenance
+	NodeMaintenance NodeMaintenanceConfig `yaml:"nodeMaintenance,omitempty"`
 }
 
 // createDefaultCloudConfig creates a CloudConfig object filled with default values.
@@ -93,10 +157,27 @@ func createDefaultCloudConfig() CloudConfig {
 			Enabled:              true,
 			CreationPollInterval: ptr.To(int(defaultLoadBalancerCreatePollInterval.Seconds())),
 			CreationPollTimeout:  ptr.To(int(defaultLoadBalancerCreatePollTimeout.Seconds())),
+			EmptyEndpointsPolicy: DefaultEmptyEndpointsPolicy,
+			ResyncWorkers:        ptr.To(defaultServiceResyncWorkers),
+
+			HandleServicesWithoutClass: ptr.To(true),
+			DriftDetection: DriftDetectionConfig{
+				Enabled:     false,
+				Interval:    ptr.To(int(defaultDriftDetectionInterval.Seconds())),
+				Remediation: DefaultDriftRemediation,
+			},
 		},
 		InstancesV2: InstancesV2Config{
 			Enabled:              true,
 			ZoneAndRegionEnabled: true,
+			NodeMatchStrategies:  DefaultNodeMatchStrategies(),
+			NodeMatchLabel:       DefaultNodeMatchLabel,
+
+			MachineIndexRefreshInterval: ptr.To(int(defaultMachineIndexRefreshInterval.Seconds())),
+			NodeMaintenance: NodeMaintenanceConfig{
+				Value:    DefaultNodeMaintenanceValue,
+				Interval: ptr.To(int(defaultNodeMaintenanceInterval.Seconds())),
+			},
 		},
 	}
 }
@@ -107,6 +188,41 @@ func NewCloudConfigFromBytes(configBytes []byte) (CloudConfig, error) {
 	if err != nil {
 		return CloudConfig{}, err
 	}
+	if config.LoadBalancer.EmptyEndpointsPolicy != "" {
+		policy, err := ParseEmptyEndpointsPolicy(string(config.LoadBalancer.EmptyEndpointsPolicy))
+		if err != nil {
+			return CloudConfig{}, err
+		}
+		config.LoadBalancer.EmptyEndpointsPolicy = policy
+	}
+	if config.LoadBalancer.DriftDetection.Remediation != "" {
+		remediation, err := ParseDriftRemediation(string(config.LoadBalancer.DriftDetection.Remediation))
+		if err != nil {
+			return CloudConfig{}, err
+		}
+		config.LoadBalancer.DriftDetection.Remediation = remediation
+	}
+	for idx, value := range config.InstancesV2.NodeMatchStrategies {
+		strategy, err := ParseNodeMatchStrategy(string(value))
+		if err != nil {
+			return CloudConfig{}, err
+		}
+		config.InstancesV2.NodeMatchStrategies[idx] = strategy
+	}
+	if config.InstancesV2.NodeAddresses.PrimaryIPFamily != "" {
+		family, err := ParseIPFamily(string(config.InstancesV2.NodeAddresses.PrimaryIPFamily))
+		if err != nil {
+			return CloudConfig{}, err
+		}
+		config.InstancesV2.NodeAddresses.PrimaryIPFamily = family
+	}
+	if config.LoadBalancer.TargetSelector.Enabled && strings.TrimSpace(config.Cluster) == "" {
+		return CloudConfig{}, fmt.Errorf("loadBalancer.targetSelector is enabled, but no cluster is set")
+	}
+	config.LoadBalancer.LoadBalancerClass = strings.TrimSpace(config.LoadBalancer.LoadBalancerClass)
+	if config.LoadBalancer.LoadBalancerClass == "" && !ptr.Deref(config.LoadBalancer.HandleServicesWithoutClass, true) {
+		return CloudConfig{}, fmt.Errorf("loadBalancer.handleServicesWithoutClass is disabled, but no loadBalancer.loadBalancerClass is set")
+	}
 	return config, nil
 }
 
@@ -189,14 +305,21 @@ func thalassaCloudProviderFactory(config io.Reader) (cloudprovider.Interface, er
 
 	return &Cloud{
 		config:               cloudConf,
-		iaasClient:           iaasClient,
+		iaasClient:           newCloudAPI(iaasClient),
+		vpc:                  vpc,
 		endpointSlicesClient: nil,
 	}, nil
 }
 
 // Initialize provides the Cloud with a kubernetes client builder and may spawn goroutines
 // to perform housekeeping activities within the Cloud provider.
+// The load balancer and instances implementations are constructed once here; their goroutines
+// run until the stop channel is closed, e.g. when the leader election is lost.
 func (c *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder, stop <-chan struct{}) {
+	if c.config.InstancesV2.Enabled {
+		c.instances = c.newInstancesV2()
+	}
+
 	client, err := clientBuilder.Client("endpoint-slices")
 	if err != nil {
 		klog.Errorf("failed to get endpoint-slices client: %v", err)
@@ -204,19 +327,72 @@ func (c *Cloud) Initialize(clientBuilder cloudprovider.ControllerClientBuilder,
 	}
 
 	c.endpointSlicesClient = client
-}
 
-// LoadBalancer returns a balancer interface. Also returns true if the interface is supported, false otherwise.
-func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
-	if !c.config.LoadBalancer.Enabled {
-		return nil, false
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartStructuredLogging(0)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	c.eventRecorder = eventBroadcaster.NewRecorder(scheme, corev1.EventSource{Component: "thalassa-cloud-controller-manager"})
+	if c.instances != nil {
+		c.instances.recorder = c.eventRecorder
+	}
+
+	// Register the informers used by the provider before starting the shared informer factory
+	c.informerFactory = informers.NewSharedInformerFactory(client, informerResyncPeriod)
+	c.informerFactory.Core().V1().Services().Informer()
+	c.informerFactory.Core().V1().Nodes().Informer()
+	c.informerFactory.Discovery().V1().EndpointSlices().Informer()
+	var volumeLabeler *volumeLabeler
+	if c.config.VolumeLabeler.Enabled {
+		volumeLabeler = newVolumeLabeler(c.config.VolumeLabeler, c.iaasClient, client, c.informerFactory, c.eventRecorder)
 	}
+	c.informerFactory.Start(stop)
 
-	// Create context for the loadbalancer informers
+	if volumeLabeler != nil {
+		volumeLabeler.run(stop)
+	}
+
+	if c.instances != nil && c.config.InstancesV2.NodeMaintenance.Enabled {
+		maintenance := &nodeMaintenanceController{
+			config:       c.config.InstancesV2.NodeMaintenance,
+			machineIndex: c.instances.machineIndex,
+			kubeClient:   client,
+			nodeLister:   c.informerFactory.Core().V1().Nodes().Lister(),
+			recorder:     c.eventRecorder,
+		}
+		maintenance.run(stop)
+	}
+
+	if c.config.LoadBalancer.Enabled {
+		c.loadbalancer = c.newLoadBalancer()
+		c.loadbalancer.run(stop)
+	}
+
+	go func() {
+		<-stop
+		klog.Infof("stopping %s cloud provider", ProviderName)
+		if c.loadbalancer != nil {
+			c.loadbalancer.cleanup()
+		}
+		c.informerFactory.Shutdown()
+		eventBroadcaster.Shutdown()
+	}()
+}
+
+// newLoadBalancer constructs the load balancer implementation. Its goroutines are started by run.
+func (c *Cloud) newLoadBalancer() *loadbalancer {
+	registerMetrics()
+
+	// Create context for the loadbalancer goroutines
 	ctx, cancel := context.WithCancel(context.Background())
 
+	machineIndex := newMachineIndex(c.iaasClient, c.config.VpcIdentity, time.Duration(ptr.Deref(c.config.InstancesV2.MachineIndexRefreshInterval, 0))*time.Second)
+	if c.instances != nil {
+		machineIndex = c.instances.machineIndex
+	}
+
 	lb := &loadbalancer{
-		iaasClient: c.iaasClient,
+		iaasClient:   c.iaasClient,
+		machineIndex: machineIndex,
 
 		config:           c.config.LoadBalancer,
 		additionalLabels: c.config.AdditionalLabels,
@@ -225,7 +401,12 @@ func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 		defaultSubnet: c.config.DefaultSubnet,
 		cluster:       c.config.Cluster,
 
-		endpointSlicesClient: c.endpointSlicesClient,
+		kubeClient:      c.endpointSlicesClient,
+		informerFactory: c.informerFactory,
+		serviceLister:   c.informerFactory.Core().V1().Services().Lister(),
+		nodeLister:      c.informerFactory.Core().V1().Nodes().Lister(),
+
+		recorder: c.eventRecorder,
 
 		ctx:    ctx,
 		cancel: cancel,
@@ -237,21 +418,49 @@ func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
 		workqueue.TypedRateLimitingQueueConfig[string]{Name: "loadbalancer-service-resync"},
 	)
 
-	// Create a stop channel for the endpoint slice watcher
-	stopCh := make(chan struct{})
-
-	// Create the endpoint slice watcher with the resync callback
-	lb.endpointSliceWatcher = NewEndpointSliceWatcher(c.endpointSlicesClient, stopCh, lb.triggerServiceResync)
-
 	// Set up the node filter with the endpoint slice lister
 	lb.nodeFilter = &NodeFilter{
-		epSliceLister: lb.endpointSliceWatcher.epSliceInformer.Discovery().V1().EndpointSlices().Lister(),
+		epSliceLister: c.informerFactory.Discovery().V1().EndpointSlices().Lister(),
+		defaultPolicy: c.config.LoadBalancer.EmptyEndpointsPolicy,
+		recorder:      c.eventRecorder,
 	}
+	return lb
+}
 
-	// Start the service queue processor
-	lb.startServiceQueueProcessor()
+func (c *Cloud) newInstancesV2() *instancesV2 {
+	refreshInterval := time.Duration(ptr.Deref(c.config.InstancesV2.MachineIndexRefreshInterval, 0)) * time.Second
+	var externalIPs *externalIPIndex
+	addressesConfig := c.config.InstancesV2.NodeAddresses
+	if ptr.Deref(addressesConfig.ReservedIPs, true) || addressesConfig.NatGatewayIPs {
+		externalIPs = newExternalIPIndex(c.iaasClient, c.config.VpcIdentity, refreshInterval, ptr.Deref(addressesConfig.ReservedIPs, true), addressesConfig.NatGatewayIPs)
+	}
+	var targetSelector TargetSelectorConfig
+	if c.config.LoadBalancer.Enabled {
+		targetSelector = c.config.LoadBalancer.TargetSelector
+	}
+	return &instancesV2{
+		iaasClient:     c.iaasClient,
+		machineIndex:   newMachineIndex(c.iaasClient, c.config.VpcIdentity, refreshInterval),
+		externalIPs:    externalIPs,
+		targetSelector: targetSelector,
+		vpc:            c.vpc,
+
+		config:           &c.config.InstancesV2,
+		additionalLabels: c.config.AdditionalLabels,
 
-	return lb, true
+		vpcIdentity:   c.config.VpcIdentity,
+		defaultSubnet: c.config.DefaultSubnet,
+		cluster:       c.config.Cluster,
+	}
+}
+
+// LoadBalancer returns a balancer interface. Also returns true if the interface is supported, false otherwise.
+// The same instance, constructed in Initialize, is returned on every call.
+func (c *Cloud) LoadBalancer() (cloudprovider.LoadBalancer, bool) {
+	if !c.config.LoadBalancer.Enabled || c.loadbalancer == nil {
+		return nil, false
+	}
+	return c.loadbalancer, true
 }
 
 // Instances returns an instances interface. Also returns true if the interface is supported, false otherwise.
@@ -259,20 +468,12 @@ func (c *Cloud) Instances() (cloudprovider.Instances, bool) {
 	return nil, false
 }
 
+// InstancesV2 returns the instancesV2 interface constructed in Initialize. Also returns true if the interface is supported, false otherwise.
 func (c *Cloud) InstancesV2() (cloudprovider.InstancesV2, bool) {
-	if !c.config.InstancesV2.Enabled {
+	if !c.config.InstancesV2.Enabled || c.instances == nil {
 		return nil, false
 	}
-	return &instancesV2{
-		iaasClient: c.iaasClient,
-
-		config:           &c.config.InstancesV2,
-		additionalLabels: c.config.AdditionalLabels,
-
-		vpcIdentity:   c.config.VpcIdentity,
-		defaultSubnet: c.config.DefaultSubnet,
-		cluster:       c.config.Cluster,
-	}, true
+	return c.instances, true
 }
 
 // Zones returns a zones interface. Also returns true if the interface is supported, false otherwise.
@@ -0,0 +1,188 @@
+package provider
+
+import (
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+)
+
+// fakeClientBuilder implements cloudprovider.ControllerClientBuilder with a fake clientset
+type fakeClientBuilder struct {
+	client clientset.Interface
+}
+
+func (b *fakeClientBuilder) Config(name string) (*restclient.Config, error) {
+	return &restclient.Config{}, nil
+}
+
+func (b *fakeClientBuilder) ConfigOrDie(name string) *restclient.Config {
+	return &restclient.Config{}
+}
+
+func (b *fakeClientBuilder) Client(name string) (clientset.Interface, error) {
+	return b.client, nil
+}
+
+func (b *fakeClientBuilder) ClientOrDie(name string) clientset.Interface {
+	return b.client
+}
+
+func newTestCloud() *Cloud {
+	config := createDefaultCloudConfig()
+	config.VpcIdentity = "vpc-test"
+	config.DefaultSubnet = "subnet-test"
+	config.Cluster = "cluster-test"
+	return &Cloud{config: config}
+}
+
+// waitForGoroutines waits until the number of goroutines drops to at most max
+func waitForGoroutines(max int) int {
+	var current int
+	for i := 0; i < 50; i++ {
+		current = runtime.NumGoroutine()
+		if current <= max {
+			return current
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return current
+}
+
+func TestCloud_LoadBalancerIsConstructedOnce(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	cloud := newTestCloud()
+	stop := make(chan struct{})
+	cloud.Initialize(&fakeClientBuilder{client: fake.NewSimpleClientset()}, stop)
+
+	first, ok := cloud.LoadBalancer()
+	require.True(t, ok)
+	afterFirstCall := runtime.NumGoroutine()
+
+	for i := 0; i < 25; i++ {
+		lb, ok := cloud.LoadBalancer()
+		require.True(t, ok)
+		assert.Same(t, first, lb)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), afterFirstCall, "repeated LoadBalancer() calls must not start goroutines")
+
+	instances, ok := cloud.InstancesV2()
+	require.True(t, ok)
+	again, _ := cloud.InstancesV2()
+	assert.Same(t, instances, again)
+
+	// closing the stop channel (e.g. on leader election loss) stops all provider goroutines
+	close(stop)
+	assert.LessOrEqual(t, waitForGoroutines(baseline), baseline, "goroutines leaked after the stop channel was closed")
+
+	lb := first.(*loadbalancer)
+	assert.True(t, lb.serviceQueue.ShuttingDown())
+	assert.Error(t, lb.ctx.Err())
+}
+
+func TestCloud_LoadBalancerDisabled(t *testing.T) {
+	cloud := newTestCloud()
+	cloud.config.LoadBalancer.Enabled = false
+	stop := make(chan struct{})
+	defer close(stop)
+	cloud.Initialize(&fakeClientBuilder{client: fake.NewSimpleClientset()}, stop)
+
+	lb, ok := cloud.LoadBalancer()
+	assert.False(t, ok)
+	assert.Nil(t, lb)
+}
+
+func TestNewCloudConfigFromBytes_DriftDetection(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("loadBalancer:\n  enabled: true\n"))
+	require.NoError(t, err)
+	assert.False(t, config.LoadBalancer.DriftDetection.Enabled, "drift detection is opt-in")
+	assert.Equal(t, 600, *config.LoadBalancer.DriftDetection.Interval)
+	assert.Equal(t, DriftRemediationRepair, config.LoadBalancer.DriftDetection.Remediation)
+
+	config, err = NewCloudConfigFromBytes([]byte("loadBalancer:\n  driftDetection:\n    enabled: true\n    interval: 60\n    remediation: Alert\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 60, *config.LoadBalancer.DriftDetection.Interval)
+	assert.Equal(t, DriftRemediationAlert, config.LoadBalancer.DriftDetection.Remediation)
+
+	_, err = NewCloudConfigFromBytes([]byte("loadBalancer:\n  driftDetection:\n    remediation: ignore\n"))
+	assert.Error(t, err)
+}
+
+func TestNewCloudConfigFromBytes_LoadBalancerClass(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("loadBalancer:\n  enabled: true\n"))
+	require.NoError(t, err)
+	assert.Empty(t, config.LoadBalancer.LoadBalancerClass)
+	assert.True(t, *config.LoadBalancer.HandleServicesWithoutClass)
+
+	config, err = NewCloudConfigFromBytes([]byte("loadBalancer:\n  loadBalancerClass: \" thalassa.cloud/vpc \"\n  handleServicesWithoutClass: false\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "thalassa.cloud/vpc", config.LoadBalancer.LoadBalancerClass)
+	assert.False(t, *config.LoadBalancer.HandleServicesWithoutClass)
+
+	_, err = NewCloudConfigFromBytes([]byte("loadBalancer:\n  handleServicesWithoutClass: false\n"))
+	assert.Error(t, err, "a provider handling no Services at all is a configuration error")
+}
+
+func TestNewCloudConfigFromBytes_NodeMatchStrategies(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("instancesV2:\n  enabled: true\n"))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultNodeMatchStrategies(), config.InstancesV2.NodeMatchStrategies)
+	assert.Equal(t, "kubernetes.io/hostname", config.InstancesV2.NodeMatchLabel)
+
+	config, err = NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeMatchStrategies: [ProviderID, internalip]\n  nodeMatchLabel: example.com/node\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []NodeMatchStrategy{NodeMatchStrategyProviderID, NodeMatchStrategyInternalIP}, config.InstancesV2.NodeMatchStrategies)
+	assert.Equal(t, "example.com/node", config.InstancesV2.NodeMatchLabel)
+
+	_, err = NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeMatchStrategies: [hostname]\n"))
+	assert.Error(t, err)
+}
+
+func TestNewCloudConfigFromBytes_NodeAddresses(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeAddresses:\n    interfaces: [default, eth1]\n    includeSecondaryInterfaces: true\n    primaryIPFamily: ipv6\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "eth1"}, config.InstancesV2.NodeAddresses.Interfaces)
+	assert.True(t, config.InstancesV2.NodeAddresses.IncludeSecondaryInterfaces)
+	assert.Equal(t, corev1.IPv6Protocol, config.InstancesV2.NodeAddresses.PrimaryIPFamily)
+
+	_, err = NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeAddresses:\n    primaryIPFamily: dual\n"))
+	assert.Error(t, err)
+}
+
+func TestNewCloudConfigFromBytes_NodeMaintenance(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeMaintenance:\n    enabled: true\n"))
+	require.NoError(t, err)
+	assert.True(t, config.InstancesV2.NodeMaintenance.Enabled)
+	assert.Equal(t, DefaultNodeMaintenanceValue, config.InstancesV2.NodeMaintenance.Value)
+	assert.Equal(t, 60, *config.InstancesV2.NodeMaintenance.Interval)
+
+	config, err = NewCloudConfigFromBytes([]byte("instancesV2:\n  nodeMaintenance:\n    enabled: true\n    label: example.com/evacuation\n    value: \"\"\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/evacuation", config.InstancesV2.NodeMaintenance.Label)
+	assert.Empty(t, config.InstancesV2.NodeMaintenance.Value)
+}
+
+func TestNewCloudConfigFromBytes_TargetSelector(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("cluster: prod\nloadBalancer:\n  targetSelector:\n    enabled: true\n    nodePoolLabel: example.com/pool\n"))
+	require.NoError(t, err)
+	assert.True(t, config.LoadBalancer.TargetSelector.Enabled)
+	assert.Equal(t, "example.com/pool", config.LoadBalancer.TargetSelector.NodePoolLabel)
+
+	_, err = NewCloudConfigFromBytes([]byte("loadBalancer:\n  targetSelector:\n    enabled: true\n"))
+	assert.Error(t, err)
+}
+
+func TestNewCloudConfigFromBytes_VolumeLabeler(t *testing.T) {
+	config, err := NewCloudConfigFromBytes([]byte("volumeLabeler:\n  enabled: true\n  csiDrivers:\n  - example.com/csi\n  nodeAffinity: false\n"))
+	require.NoError(t, err)
+	assert.True(t, config.VolumeLabeler.Enabled)
+	assert.Equal(t, []string{"example.com/csi"}, config.VolumeLabeler.CSIDrivers)
+	assert.False(t, *config.VolumeLabeler.NodeAffinity)
+}
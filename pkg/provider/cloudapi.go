@@ -0,0 +1,164 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"github.com/thalassa-cloud/client-go/pkg/client"
+)
+
+//go:generate go run go.uber.org/mock/mockgen@v0.6.0 -typed -destination=cloudapi_mock_test.go -package=provider -mock_names=CloudAPI=mockCloudAPI . CloudAPI
+
+// CloudAPI is the part of the Thalassa Cloud IaaS API used by the provider. It is implemented by the IaaS client
+// returned by newCloudAPI, and can be wrapped to add behaviour to all calls, e.g. caching, metrics or rate limiting,
+// or replaced in tests.
+type CloudAPI interface {
+	VpcAPI
+	RegionAPI
+	MachineAPI
+	LoadbalancerAPI
+	ListenerAPI
+	TargetGroupAPI
+	SecurityGroupAPI
+	ReservedIPAPI
+	NatGatewayAPI
+	VolumeAPI
+}
+
+// VpcAPI reads the VPC of the cluster
+type VpcAPI interface {
+	GetVpc(ctx context.Context, identity string) (*iaas.Vpc, error)
+}
+
+// RegionAPI reads the regions and their zones
+type RegionAPI interface {
+	ListRegions(ctx context.Context, listRequest *iaas.ListRegionsRequest) ([]iaas.Region, error)
+}
+
+// MachineAPI reads the machines backing the nodes of the cluster
+type MachineAPI interface {
+	ListMachines(ctx context.Context, listRequest *iaas.ListMachinesRequest) ([]iaas.Machine, error)
+	GetMachine(ctx context.Context, identity string) (*iaas.Machine, error)
+	UpdateMachine(ctx context.Context, identity string, update iaas.UpdateMachine) (*iaas.Machine, error)
+}
+
+// LoadbalancerAPI manages the load balancers of LoadBalancer Services
+type LoadbalancerAPI interface {
+	ListLoadbalancers(ctx context.Context, listRequest *iaas.ListLoadbalancersRequest) ([]iaas.VpcLoadbalancer, error)
+	GetLoadbalancer(ctx context.Context, loadbalancerIdentity string) (*iaas.VpcLoadbalancer, error)
+	CreateLoadbalancer(ctx context.Context, create iaas.CreateLoadbalancer) (*iaas.VpcLoadbalancer, error)
+	UpdateLoadbalancer(ctx context.Context, loadbalancerIdentity string, update iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)
+	// UpdateLoadbalancerSecurityGroups updates the load balancer like UpdateLoadbalancer, but always sends the security
+	// groups of the update, so an empty list detaches all security groups.
+	UpdateLoadbalancerSecurityGroups(ctx context.Context, loadbalancerIdentity string, update iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)
+	DeleteLoadbalancer(ctx context.Context, loadbalancerIdentity string) error
+}
+
+// ListenerAPI manages the listeners of load balancers
+type ListenerAPI interface {
+	ListListeners(ctx context.Context, listRequest *iaas.ListLoadbalancerListenersRequest) ([]iaas.VpcLoadbalancerListener, error)
+	CreateListener(ctx context.Context, loadbalancerID string, create iaas.CreateListener) (*iaas.VpcLoadbalancerListener, error)
+	UpdateListener(ctx context.Context, loadbalancerID string, listenerID string, update iaas.UpdateListener) (*iaas.VpcLoadbalancerListener, error)
+	DeleteListener(ctx context.Context, loadbalancerID string, listenerID string) error
+}
+
+// TargetGroupAPI manages the target groups of load balancers and the machines attached to them
+type TargetGroupAPI interface {
+	ListTargetGroups(ctx context.Context, listRequest *iaas.ListTargetGroupsRequest) ([]iaas.VpcLoadbalancerTargetGroup, error)
+	CreateTargetGroup(ctx context.Context, create iaas.CreateTargetGroup) (*iaas.VpcLoadbalancerTargetGroup, error)
+	UpdateTargetGroup(ctx context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)
+	// UpdateTargetGroupSelector updates the target group like UpdateTargetGroup, but always sends the target selector
+	// of the update, so an empty selector removes the target selector of the target group.
+	UpdateTargetGroupSelector(ctx context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)
+	DeleteTargetGroup(ctx context.Context, deleteRequest iaas.DeleteTargetGroupRequest) error
+	SetTargetGroupServerAttachments(ctx context.Context, setRequest iaas.TargetGroupAttachmentsBatch) error
+}
+
+// SecurityGroupAPI manages the security groups attached to load balancers
+type SecurityGroupAPI interface {
+	ListSecurityGroups(ctx context.Context, listRequest *iaas.ListSecurityGroupsRequest) ([]iaas.SecurityGroup, error)
+	CreateSecurityGroup(ctx context.Context, create iaas.CreateSecurityGroupRequest) (*iaas.SecurityGroup, error)
+	UpdateSecurityGroup(ctx context.Context, identity string, update iaas.UpdateSecurityGroupRequest) (*iaas.SecurityGroup, error)
+	DeleteSecurityGroup(ctx context.Context, identity string) error
+}
+
+// ReservedIPAPI reads the reserved IPs attached to machines
+type ReservedIPAPI interface {
+	ListReservedIPs(ctx context.Context, listRequest *iaas.ListReservedIPsRequest) ([]iaas.ReservedIP, error)
+}
+
+// NatGatewayAPI reads the NAT gateways of the VPC
+type NatGatewayAPI interface {
+	ListNatGateways(ctx context.Context, listRequest *iaas.ListNatGatewaysRequest) ([]iaas.VpcNatGateway, error)
+}
+
+// VolumeAPI reads the block volumes referenced by PersistentVolumes
+type VolumeAPI interface {
+	GetVolume(ctx context.Context, identity string) (*iaas.Volume, error)
+}
+
+// iaasCloudAPI is the CloudAPI backed by the IaaS client
+type iaasCloudAPI struct {
+	*iaas.Client
+}
+
+var _ CloudAPI = (*iaasCloudAPI)(nil)
+
+// newCloudAPI returns the CloudAPI of the IaaS client
+func newCloudAPI(iaasClient *iaas.Client) CloudAPI {
+	return &iaasCloudAPI{Client: iaasClient}
+}
+
+// UpdateLoadbalancerSecurityGroups updates the load balancer and always sends its security group attachments. The
+// field is omitted by iaas.Client when the list is empty, so the API would keep the last security group attached.
+func (c *iaasCloudAPI) UpdateLoadbalancerSecurityGroups(ctx context.Context, loadbalancerIdentity string, update iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error) {
+	if loadbalancerIdentity == "" {
+		return nil, fmt.Errorf("identity of the loadbalancer to update is required")
+	}
+	securityGroups := update.SecurityGroupAttachments
+	if securityGroups == nil {
+		securityGroups = []string{}
+	}
+	body := struct {
+		iaas.UpdateLoadbalancer
+		SecurityGroupAttachments []string `json:"securityGroupAttachments"`
+	}{UpdateLoadbalancer: update, SecurityGroupAttachments: securityGroups}
+
+	var loadbalancer *iaas.VpcLoadbalancer
+	if err := c.put(ctx, fmt.Sprintf("%s/%s", iaas.LoadbalancerEndpoint, loadbalancerIdentity), body, &loadbalancer); err != nil {
+		return loadbalancer, err
+	}
+	return loadbalancer, nil
+}
+
+// UpdateTargetGroupSelector updates the target group and always sends its target selector. The field is omitted by
+// iaas.Client when the selector is empty, so the API would keep the target selector of the target group.
+func (c *iaasCloudAPI) UpdateTargetGroupSelector(ctx context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error) {
+	if update.Identity == "" {
+		return nil, fmt.Errorf("identity of the target group to update is required")
+	}
+	selector := update.TargetSelector
+	if selector == nil {
+		selector = map[string]string{}
+	}
+	body := struct {
+		iaas.UpdateTargetGroup
+		TargetSelector map[string]string `json:"targetSelector"`
+	}{UpdateTargetGroup: update.UpdateTargetGroup, TargetSelector: selector}
+
+	var targetGroup *iaas.VpcLoadbalancerTargetGroup
+	if err := c.put(ctx, fmt.Sprintf("%s/%s", iaas.TargetGroupEndpoint, update.Identity), body, &targetGroup); err != nil {
+		return targetGroup, err
+	}
+	return targetGroup, nil
+}
+
+// put sends an update the typed methods of iaas.Client cannot express, and decodes the response into result
+func (c *iaasCloudAPI) put(ctx context.Context, path string, body any, result any) error {
+	resp, err := c.Do(ctx, c.R().SetBody(body).SetResult(result), client.PUT, path)
+	if err != nil {
+		return err
+	}
+	return c.Check(resp)
+}
@@ -0,0 +1,1129 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: github.com/thalassa-cloud/cloud-provider-thalassa/pkg/provider (interfaces: CloudAPI)
+//
+// Generated by this command:
+//
+//	mockgen -typed -destination=cloudapi_mock_test.go -package=provider -mock_names=CloudAPI=mockCloudAPI . CloudAPI
+//
+
+// Package provider is a generated GoMock package.
+package provider
+
+import (
+	context "context"
+	reflect "reflect"
+
+	iaas "github.com/thalassa-cloud/client-go/iaas"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// mockCloudAPI is a mock of CloudAPI interface.
+type mockCloudAPI struct {
+	ctrl     *gomock.Controller
+	recorder *mockCloudAPIMockRecorder
+	isgomock struct{}
+}
+
+// mockCloudAPIMockRecorder is the mock recorder for mockCloudAPI.
+type mockCloudAPIMockRecorder struct {
+	mock *mockCloudAPI
+}
+
+// NewmockCloudAPI creates a new mock instance.
+func NewmockCloudAPI(ctrl *gomock.Controller) *mockCloudAPI {
+	mock := &mockCloudAPI{ctrl: ctrl}
+	mock.recorder = &mockCloudAPIMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *mockCloudAPI) EXPECT() *mockCloudAPIMockRecorder {
+	return m.recorder
+}
+
+// CreateListener mocks base method.
+func (m *mockCloudAPI) CreateListener(ctx context.Context, loadbalancerID string, create iaas.CreateListener) (*iaas.VpcLoadbalancerListener, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateListener", ctx, loadbalancerID, create)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancerListener)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateListener indicates an expected call of CreateListener.
+func (mr *mockCloudAPIMockRecorder) CreateListener(ctx, loadbalancerID, create any) *mockCloudAPICreateListenerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateListener", reflect.TypeOf((*mockCloudAPI)(nil).CreateListener), ctx, loadbalancerID, create)
+	return &mockCloudAPICreateListenerCall{Call: call}
+}
+
+// mockCloudAPICreateListenerCall wrap *gomock.Call
+type mockCloudAPICreateListenerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPICreateListenerCall) Return(arg0 *iaas.VpcLoadbalancerListener, arg1 error) *mockCloudAPICreateListenerCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPICreateListenerCall) Do(f func(context.Context, string, iaas.CreateListener) (*iaas.VpcLoadbalancerListener, error)) *mockCloudAPICreateListenerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPICreateListenerCall) DoAndReturn(f func(context.Context, string, iaas.CreateListener) (*iaas.VpcLoadbalancerListener, error)) *mockCloudAPICreateListenerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// CreateLoadbalancer mocks base method.
+func (m *mockCloudAPI) CreateLoadbalancer(ctx context.Context, create iaas.CreateLoadbalancer) (*iaas.VpcLoadbalancer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateLoadbalancer", ctx, create)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateLoadbalancer indicates an expected call of CreateLoadbalancer.
+func (mr *mockCloudAPIMockRecorder) CreateLoadbalancer(ctx, create any) *mockCloudAPICreateLoadbalancerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateLoadbalancer", reflect.TypeOf((*mockCloudAPI)(nil).CreateLoadbalancer), ctx, create)
+	return &mockCloudAPICreateLoadbalancerCall{Call: call}
+}
+
+// mockCloudAPICreateLoadbalancerCall wrap *gomock.Call
+type mockCloudAPICreateLoadbalancerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPICreateLoadbalancerCall) Return(arg0 *iaas.VpcLoadbalancer, arg1 error) *mockCloudAPICreateLoadbalancerCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPICreateLoadbalancerCall) Do(f func(context.Context, iaas.CreateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPICreateLoadbalancerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPICreateLoadbalancerCall) DoAndReturn(f func(context.Context, iaas.CreateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPICreateLoadbalancerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// CreateSecurityGroup mocks base method.
+func (m *mockCloudAPI) CreateSecurityGroup(ctx context.Context, create iaas.CreateSecurityGroupRequest) (*iaas.SecurityGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSecurityGroup", ctx, create)
+	ret0, _ := ret[0].(*iaas.SecurityGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateSecurityGroup indicates an expected call of CreateSecurityGroup.
+func (mr *mockCloudAPIMockRecorder) CreateSecurityGroup(ctx, create any) *mockCloudAPICreateSecurityGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSecurityGroup", reflect.TypeOf((*mockCloudAPI)(nil).CreateSecurityGroup), ctx, create)
+	return &mockCloudAPICreateSecurityGroupCall{Call: call}
+}
+
+// mockCloudAPICreateSecurityGroupCall wrap *gomock.Call
+type mockCloudAPICreateSecurityGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPICreateSecurityGroupCall) Return(arg0 *iaas.SecurityGroup, arg1 error) *mockCloudAPICreateSecurityGroupCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPICreateSecurityGroupCall) Do(f func(context.Context, iaas.CreateSecurityGroupRequest) (*iaas.SecurityGroup, error)) *mockCloudAPICreateSecurityGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPICreateSecurityGroupCall) DoAndReturn(f func(context.Context, iaas.CreateSecurityGroupRequest) (*iaas.SecurityGroup, error)) *mockCloudAPICreateSecurityGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// CreateTargetGroup mocks base method.
+func (m *mockCloudAPI) CreateTargetGroup(ctx context.Context, create iaas.CreateTargetGroup) (*iaas.VpcLoadbalancerTargetGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateTargetGroup", ctx, create)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancerTargetGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateTargetGroup indicates an expected call of CreateTargetGroup.
+func (mr *mockCloudAPIMockRecorder) CreateTargetGroup(ctx, create any) *mockCloudAPICreateTargetGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTargetGroup", reflect.TypeOf((*mockCloudAPI)(nil).CreateTargetGroup), ctx, create)
+	return &mockCloudAPICreateTargetGroupCall{Call: call}
+}
+
+// mockCloudAPICreateTargetGroupCall wrap *gomock.Call
+type mockCloudAPICreateTargetGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPICreateTargetGroupCall) Return(arg0 *iaas.VpcLoadbalancerTargetGroup, arg1 error) *mockCloudAPICreateTargetGroupCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPICreateTargetGroupCall) Do(f func(context.Context, iaas.CreateTargetGroup) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPICreateTargetGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPICreateTargetGroupCall) DoAndReturn(f func(context.Context, iaas.CreateTargetGroup) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPICreateTargetGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// DeleteListener mocks base method.
+func (m *mockCloudAPI) DeleteListener(ctx context.Context, loadbalancerID, listenerID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteListener", ctx, loadbalancerID, listenerID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteListener indicates an expected call of DeleteListener.
+func (mr *mockCloudAPIMockRecorder) DeleteListener(ctx, loadbalancerID, listenerID any) *mockCloudAPIDeleteListenerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteListener", reflect.TypeOf((*mockCloudAPI)(nil).DeleteListener), ctx, loadbalancerID, listenerID)
+	return &mockCloudAPIDeleteListenerCall{Call: call}
+}
+
+// mockCloudAPIDeleteListenerCall wrap *gomock.Call
+type mockCloudAPIDeleteListenerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIDeleteListenerCall) Return(arg0 error) *mockCloudAPIDeleteListenerCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIDeleteListenerCall) Do(f func(context.Context, string, string) error) *mockCloudAPIDeleteListenerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIDeleteListenerCall) DoAndReturn(f func(context.Context, string, string) error) *mockCloudAPIDeleteListenerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// DeleteLoadbalancer mocks base method.
+func (m *mockCloudAPI) DeleteLoadbalancer(ctx context.Context, loadbalancerIdentity string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteLoadbalancer", ctx, loadbalancerIdentity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteLoadbalancer indicates an expected call of DeleteLoadbalancer.
+func (mr *mockCloudAPIMockRecorder) DeleteLoadbalancer(ctx, loadbalancerIdentity any) *mockCloudAPIDeleteLoadbalancerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteLoadbalancer", reflect.TypeOf((*mockCloudAPI)(nil).DeleteLoadbalancer), ctx, loadbalancerIdentity)
+	return &mockCloudAPIDeleteLoadbalancerCall{Call: call}
+}
+
+// mockCloudAPIDeleteLoadbalancerCall wrap *gomock.Call
+type mockCloudAPIDeleteLoadbalancerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIDeleteLoadbalancerCall) Return(arg0 error) *mockCloudAPIDeleteLoadbalancerCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIDeleteLoadbalancerCall) Do(f func(context.Context, string) error) *mockCloudAPIDeleteLoadbalancerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIDeleteLoadbalancerCall) DoAndReturn(f func(context.Context, string) error) *mockCloudAPIDeleteLoadbalancerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// DeleteSecurityGroup mocks base method.
+func (m *mockCloudAPI) DeleteSecurityGroup(ctx context.Context, identity string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSecurityGroup", ctx, identity)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSecurityGroup indicates an expected call of DeleteSecurityGroup.
+func (mr *mockCloudAPIMockRecorder) DeleteSecurityGroup(ctx, identity any) *mockCloudAPIDeleteSecurityGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSecurityGroup", reflect.TypeOf((*mockCloudAPI)(nil).DeleteSecurityGroup), ctx, identity)
+	return &mockCloudAPIDeleteSecurityGroupCall{Call: call}
+}
+
+// mockCloudAPIDeleteSecurityGroupCall wrap *gomock.Call
+type mockCloudAPIDeleteSecurityGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIDeleteSecurityGroupCall) Return(arg0 error) *mockCloudAPIDeleteSecurityGroupCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIDeleteSecurityGroupCall) Do(f func(context.Context, string) error) *mockCloudAPIDeleteSecurityGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIDeleteSecurityGroupCall) DoAndReturn(f func(context.Context, string) error) *mockCloudAPIDeleteSecurityGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// DeleteTargetGroup mocks base method.
+func (m *mockCloudAPI) DeleteTargetGroup(ctx context.Context, deleteRequest iaas.DeleteTargetGroupRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteTargetGroup", ctx, deleteRequest)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteTargetGroup indicates an expected call of DeleteTargetGroup.
+func (mr *mockCloudAPIMockRecorder) DeleteTargetGroup(ctx, deleteRequest any) *mockCloudAPIDeleteTargetGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTargetGroup", reflect.TypeOf((*mockCloudAPI)(nil).DeleteTargetGroup), ctx, deleteRequest)
+	return &mockCloudAPIDeleteTargetGroupCall{Call: call}
+}
+
+// mockCloudAPIDeleteTargetGroupCall wrap *gomock.Call
+type mockCloudAPIDeleteTargetGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIDeleteTargetGroupCall) Return(arg0 error) *mockCloudAPIDeleteTargetGroupCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIDeleteTargetGroupCall) Do(f func(context.Context, iaas.DeleteTargetGroupRequest) error) *mockCloudAPIDeleteTargetGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIDeleteTargetGroupCall) DoAndReturn(f func(context.Context, iaas.DeleteTargetGroupRequest) error) *mockCloudAPIDeleteTargetGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// GetLoadbalancer mocks base method.
+func (m *mockCloudAPI) GetLoadbalancer(ctx context.Context, loadbalancerIdentity string) (*iaas.VpcLoadbalancer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLoadbalancer", ctx, loadbalancerIdentity)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoadbalancer indicates an expected call of GetLoadbalancer.
+func (mr *mockCloudAPIMockRecorder) GetLoadbalancer(ctx, loadbalancerIdentity any) *mockCloudAPIGetLoadbalancerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoadbalancer", reflect.TypeOf((*mockCloudAPI)(nil).GetLoadbalancer), ctx, loadbalancerIdentity)
+	return &mockCloudAPIGetLoadbalancerCall{Call: call}
+}
+
+// mockCloudAPIGetLoadbalancerCall wrap *gomock.Call
+type mockCloudAPIGetLoadbalancerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIGetLoadbalancerCall) Return(arg0 *iaas.VpcLoadbalancer, arg1 error) *mockCloudAPIGetLoadbalancerCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIGetLoadbalancerCall) Do(f func(context.Context, string) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIGetLoadbalancerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIGetLoadbalancerCall) DoAndReturn(f func(context.Context, string) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIGetLoadbalancerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// GetMachine mocks base method.
+func (m *mockCloudAPI) GetMachine(ctx context.Context, identity string) (*iaas.Machine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMachine", ctx, identity)
+	ret0, _ := ret[0].(*iaas.Machine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMachine indicates an expected call of GetMachine.
+func (mr *mockCloudAPIMockRecorder) GetMachine(ctx, identity any) *mockCloudAPIGetMachineCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMachine", reflect.TypeOf((*mockCloudAPI)(nil).GetMachine), ctx, identity)
+	return &mockCloudAPIGetMachineCall{Call: call}
+}
+
+// mockCloudAPIGetMachineCall wrap *gomock.Call
+type mockCloudAPIGetMachineCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIGetMachineCall) Return(arg0 *iaas.Machine, arg1 error) *mockCloudAPIGetMachineCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIGetMachineCall) Do(f func(context.Context, string) (*iaas.Machine, error)) *mockCloudAPIGetMachineCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIGetMachineCall) DoAndReturn(f func(context.Context, string) (*iaas.Machine, error)) *mockCloudAPIGetMachineCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// GetVolume mocks base method.
+func (m *mockCloudAPI) GetVolume(ctx context.Context, identity string) (*iaas.Volume, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVolume", ctx, identity)
+	ret0, _ := ret[0].(*iaas.Volume)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVolume indicates an expected call of GetVolume.
+func (mr *mockCloudAPIMockRecorder) GetVolume(ctx, identity any) *mockCloudAPIGetVolumeCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVolume", reflect.TypeOf((*mockCloudAPI)(nil).GetVolume), ctx, identity)
+	return &mockCloudAPIGetVolumeCall{Call: call}
+}
+
+// mockCloudAPIGetVolumeCall wrap *gomock.Call
+type mockCloudAPIGetVolumeCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIGetVolumeCall) Return(arg0 *iaas.Volume, arg1 error) *mockCloudAPIGetVolumeCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIGetVolumeCall) Do(f func(context.Context, string) (*iaas.Volume, error)) *mockCloudAPIGetVolumeCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIGetVolumeCall) DoAndReturn(f func(context.Context, string) (*iaas.Volume, error)) *mockCloudAPIGetVolumeCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// GetVpc mocks base method.
+func (m *mockCloudAPI) GetVpc(ctx context.Context, identity string) (*iaas.Vpc, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetVpc", ctx, identity)
+	ret0, _ := ret[0].(*iaas.Vpc)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetVpc indicates an expected call of GetVpc.
+func (mr *mockCloudAPIMockRecorder) GetVpc(ctx, identity any) *mockCloudAPIGetVpcCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetVpc", reflect.TypeOf((*mockCloudAPI)(nil).GetVpc), ctx, identity)
+	return &mockCloudAPIGetVpcCall{Call: call}
+}
+
+// mockCloudAPIGetVpcCall wrap *gomock.Call
+type mockCloudAPIGetVpcCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIGetVpcCall) Return(arg0 *iaas.Vpc, arg1 error) *mockCloudAPIGetVpcCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIGetVpcCall) Do(f func(context.Context, string) (*iaas.Vpc, error)) *mockCloudAPIGetVpcCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIGetVpcCall) DoAndReturn(f func(context.Context, string) (*iaas.Vpc, error)) *mockCloudAPIGetVpcCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListListeners mocks base method.
+func (m *mockCloudAPI) ListListeners(ctx context.Context, listRequest *iaas.ListLoadbalancerListenersRequest) ([]iaas.VpcLoadbalancerListener, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListListeners", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.VpcLoadbalancerListener)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListListeners indicates an expected call of ListListeners.
+func (mr *mockCloudAPIMockRecorder) ListListeners(ctx, listRequest any) *mockCloudAPIListListenersCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListListeners", reflect.TypeOf((*mockCloudAPI)(nil).ListListeners), ctx, listRequest)
+	return &mockCloudAPIListListenersCall{Call: call}
+}
+
+// mockCloudAPIListListenersCall wrap *gomock.Call
+type mockCloudAPIListListenersCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListListenersCall) Return(arg0 []iaas.VpcLoadbalancerListener, arg1 error) *mockCloudAPIListListenersCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListListenersCall) Do(f func(context.Context, *iaas.ListLoadbalancerListenersRequest) ([]iaas.VpcLoadbalancerListener, error)) *mockCloudAPIListListenersCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListListenersCall) DoAndReturn(f func(context.Context, *iaas.ListLoadbalancerListenersRequest) ([]iaas.VpcLoadbalancerListener, error)) *mockCloudAPIListListenersCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListLoadbalancers mocks base method.
+func (m *mockCloudAPI) ListLoadbalancers(ctx context.Context, listRequest *iaas.ListLoadbalancersRequest) ([]iaas.VpcLoadbalancer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListLoadbalancers", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.VpcLoadbalancer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListLoadbalancers indicates an expected call of ListLoadbalancers.
+func (mr *mockCloudAPIMockRecorder) ListLoadbalancers(ctx, listRequest any) *mockCloudAPIListLoadbalancersCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListLoadbalancers", reflect.TypeOf((*mockCloudAPI)(nil).ListLoadbalancers), ctx, listRequest)
+	return &mockCloudAPIListLoadbalancersCall{Call: call}
+}
+
+// mockCloudAPIListLoadbalancersCall wrap *gomock.Call
+type mockCloudAPIListLoadbalancersCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListLoadbalancersCall) Return(arg0 []iaas.VpcLoadbalancer, arg1 error) *mockCloudAPIListLoadbalancersCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListLoadbalancersCall) Do(f func(context.Context, *iaas.ListLoadbalancersRequest) ([]iaas.VpcLoadbalancer, error)) *mockCloudAPIListLoadbalancersCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListLoadbalancersCall) DoAndReturn(f func(context.Context, *iaas.ListLoadbalancersRequest) ([]iaas.VpcLoadbalancer, error)) *mockCloudAPIListLoadbalancersCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListMachines mocks base method.
+func (m *mockCloudAPI) ListMachines(ctx context.Context, listRequest *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListMachines", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.Machine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListMachines indicates an expected call of ListMachines.
+func (mr *mockCloudAPIMockRecorder) ListMachines(ctx, listRequest any) *mockCloudAPIListMachinesCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListMachines", reflect.TypeOf((*mockCloudAPI)(nil).ListMachines), ctx, listRequest)
+	return &mockCloudAPIListMachinesCall{Call: call}
+}
+
+// mockCloudAPIListMachinesCall wrap *gomock.Call
+type mockCloudAPIListMachinesCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListMachinesCall) Return(arg0 []iaas.Machine, arg1 error) *mockCloudAPIListMachinesCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListMachinesCall) Do(f func(context.Context, *iaas.ListMachinesRequest) ([]iaas.Machine, error)) *mockCloudAPIListMachinesCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListMachinesCall) DoAndReturn(f func(context.Context, *iaas.ListMachinesRequest) ([]iaas.Machine, error)) *mockCloudAPIListMachinesCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListNatGateways mocks base method.
+func (m *mockCloudAPI) ListNatGateways(ctx context.Context, listRequest *iaas.ListNatGatewaysRequest) ([]iaas.VpcNatGateway, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListNatGateways", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.VpcNatGateway)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListNatGateways indicates an expected call of ListNatGateways.
+func (mr *mockCloudAPIMockRecorder) ListNatGateways(ctx, listRequest any) *mockCloudAPIListNatGatewaysCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListNatGateways", reflect.TypeOf((*mockCloudAPI)(nil).ListNatGateways), ctx, listRequest)
+	return &mockCloudAPIListNatGatewaysCall{Call: call}
+}
+
+// mockCloudAPIListNatGatewaysCall wrap *gomock.Call
+type mockCloudAPIListNatGatewaysCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListNatGatewaysCall) Return(arg0 []iaas.VpcNatGateway, arg1 error) *mockCloudAPIListNatGatewaysCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListNatGatewaysCall) Do(f func(context.Context, *iaas.ListNatGatewaysRequest) ([]iaas.VpcNatGateway, error)) *mockCloudAPIListNatGatewaysCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListNatGatewaysCall) DoAndReturn(f func(context.Context, *iaas.ListNatGatewaysRequest) ([]iaas.VpcNatGateway, error)) *mockCloudAPIListNatGatewaysCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListRegions mocks base method.
+func (m *mockCloudAPI) ListRegions(ctx context.Context, listRequest *iaas.ListRegionsRequest) ([]iaas.Region, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListRegions", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.Region)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListRegions indicates an expected call of ListRegions.
+func (mr *mockCloudAPIMockRecorder) ListRegions(ctx, listRequest any) *mockCloudAPIListRegionsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRegions", reflect.TypeOf((*mockCloudAPI)(nil).ListRegions), ctx, listRequest)
+	return &mockCloudAPIListRegionsCall{Call: call}
+}
+
+// mockCloudAPIListRegionsCall wrap *gomock.Call
+type mockCloudAPIListRegionsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListRegionsCall) Return(arg0 []iaas.Region, arg1 error) *mockCloudAPIListRegionsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListRegionsCall) Do(f func(context.Context, *iaas.ListRegionsRequest) ([]iaas.Region, error)) *mockCloudAPIListRegionsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListRegionsCall) DoAndReturn(f func(context.Context, *iaas.ListRegionsRequest) ([]iaas.Region, error)) *mockCloudAPIListRegionsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListReservedIPs mocks base method.
+func (m *mockCloudAPI) ListReservedIPs(ctx context.Context, listRequest *iaas.ListReservedIPsRequest) ([]iaas.ReservedIP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListReservedIPs", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.ReservedIP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListReservedIPs indicates an expected call of ListReservedIPs.
+func (mr *mockCloudAPIMockRecorder) ListReservedIPs(ctx, listRequest any) *mockCloudAPIListReservedIPsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListReservedIPs", reflect.TypeOf((*mockCloudAPI)(nil).ListReservedIPs), ctx, listRequest)
+	return &mockCloudAPIListReservedIPsCall{Call: call}
+}
+
+// mockCloudAPIListReservedIPsCall wrap *gomock.Call
+type mockCloudAPIListReservedIPsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListReservedIPsCall) Return(arg0 []iaas.ReservedIP, arg1 error) *mockCloudAPIListReservedIPsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListReservedIPsCall) Do(f func(context.Context, *iaas.ListReservedIPsRequest) ([]iaas.ReservedIP, error)) *mockCloudAPIListReservedIPsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListReservedIPsCall) DoAndReturn(f func(context.Context, *iaas.ListReservedIPsRequest) ([]iaas.ReservedIP, error)) *mockCloudAPIListReservedIPsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListSecurityGroups mocks base method.
+func (m *mockCloudAPI) ListSecurityGroups(ctx context.Context, listRequest *iaas.ListSecurityGroupsRequest) ([]iaas.SecurityGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListSecurityGroups", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.SecurityGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListSecurityGroups indicates an expected call of ListSecurityGroups.
+func (mr *mockCloudAPIMockRecorder) ListSecurityGroups(ctx, listRequest any) *mockCloudAPIListSecurityGroupsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSecurityGroups", reflect.TypeOf((*mockCloudAPI)(nil).ListSecurityGroups), ctx, listRequest)
+	return &mockCloudAPIListSecurityGroupsCall{Call: call}
+}
+
+// mockCloudAPIListSecurityGroupsCall wrap *gomock.Call
+type mockCloudAPIListSecurityGroupsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListSecurityGroupsCall) Return(arg0 []iaas.SecurityGroup, arg1 error) *mockCloudAPIListSecurityGroupsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListSecurityGroupsCall) Do(f func(context.Context, *iaas.ListSecurityGroupsRequest) ([]iaas.SecurityGroup, error)) *mockCloudAPIListSecurityGroupsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListSecurityGroupsCall) DoAndReturn(f func(context.Context, *iaas.ListSecurityGroupsRequest) ([]iaas.SecurityGroup, error)) *mockCloudAPIListSecurityGroupsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// ListTargetGroups mocks base method.
+func (m *mockCloudAPI) ListTargetGroups(ctx context.Context, listRequest *iaas.ListTargetGroupsRequest) ([]iaas.VpcLoadbalancerTargetGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTargetGroups", ctx, listRequest)
+	ret0, _ := ret[0].([]iaas.VpcLoadbalancerTargetGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTargetGroups indicates an expected call of ListTargetGroups.
+func (mr *mockCloudAPIMockRecorder) ListTargetGroups(ctx, listRequest any) *mockCloudAPIListTargetGroupsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTargetGroups", reflect.TypeOf((*mockCloudAPI)(nil).ListTargetGroups), ctx, listRequest)
+	return &mockCloudAPIListTargetGroupsCall{Call: call}
+}
+
+// mockCloudAPIListTargetGroupsCall wrap *gomock.Call
+type mockCloudAPIListTargetGroupsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIListTargetGroupsCall) Return(arg0 []iaas.VpcLoadbalancerTargetGroup, arg1 error) *mockCloudAPIListTargetGroupsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIListTargetGroupsCall) Do(f func(context.Context, *iaas.ListTargetGroupsRequest) ([]iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIListTargetGroupsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIListTargetGroupsCall) DoAndReturn(f func(context.Context, *iaas.ListTargetGroupsRequest) ([]iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIListTargetGroupsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// SetTargetGroupServerAttachments mocks base method.
+func (m *mockCloudAPI) SetTargetGroupServerAttachments(ctx context.Context, setRequest iaas.TargetGroupAttachmentsBatch) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetTargetGroupServerAttachments", ctx, setRequest)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetTargetGroupServerAttachments indicates an expected call of SetTargetGroupServerAttachments.
+func (mr *mockCloudAPIMockRecorder) SetTargetGroupServerAttachments(ctx, setRequest any) *mockCloudAPISetTargetGroupServerAttachmentsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetTargetGroupServerAttachments", reflect.TypeOf((*mockCloudAPI)(nil).SetTargetGroupServerAttachments), ctx, setRequest)
+	return &mockCloudAPISetTargetGroupServerAttachmentsCall{Call: call}
+}
+
+// mockCloudAPISetTargetGroupServerAttachmentsCall wrap *gomock.Call
+type mockCloudAPISetTargetGroupServerAttachmentsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPISetTargetGroupServerAttachmentsCall) Return(arg0 error) *mockCloudAPISetTargetGroupServerAttachmentsCall {
+	c.Call = c.Call.Return(arg0)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPISetTargetGroupServerAttachmentsCall) Do(f func(context.Context, iaas.TargetGroupAttachmentsBatch) error) *mockCloudAPISetTargetGroupServerAttachmentsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPISetTargetGroupServerAttachmentsCall) DoAndReturn(f func(context.Context, iaas.TargetGroupAttachmentsBatch) error) *mockCloudAPISetTargetGroupServerAttachmentsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateListener mocks base method.
+func (m *mockCloudAPI) UpdateListener(ctx context.Context, loadbalancerID, listenerID string, update iaas.UpdateListener) (*iaas.VpcLoadbalancerListener, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateListener", ctx, loadbalancerID, listenerID, update)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancerListener)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateListener indicates an expected call of UpdateListener.
+func (mr *mockCloudAPIMockRecorder) UpdateListener(ctx, loadbalancerID, listenerID, update any) *mockCloudAPIUpdateListenerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateListener", reflect.TypeOf((*mockCloudAPI)(nil).UpdateListener), ctx, loadbalancerID, listenerID, update)
+	return &mockCloudAPIUpdateListenerCall{Call: call}
+}
+
+// mockCloudAPIUpdateListenerCall wrap *gomock.Call
+type mockCloudAPIUpdateListenerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateListenerCall) Return(arg0 *iaas.VpcLoadbalancerListener, arg1 error) *mockCloudAPIUpdateListenerCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateListenerCall) Do(f func(context.Context, string, string, iaas.UpdateListener) (*iaas.VpcLoadbalancerListener, error)) *mockCloudAPIUpdateListenerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateListenerCall) DoAndReturn(f func(context.Context, string, string, iaas.UpdateListener) (*iaas.VpcLoadbalancerListener, error)) *mockCloudAPIUpdateListenerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateLoadbalancer mocks base method.
+func (m *mockCloudAPI) UpdateLoadbalancer(ctx context.Context, loadbalancerIdentity string, update iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLoadbalancer", ctx, loadbalancerIdentity, update)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateLoadbalancer indicates an expected call of UpdateLoadbalancer.
+func (mr *mockCloudAPIMockRecorder) UpdateLoadbalancer(ctx, loadbalancerIdentity, update any) *mockCloudAPIUpdateLoadbalancerCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLoadbalancer", reflect.TypeOf((*mockCloudAPI)(nil).UpdateLoadbalancer), ctx, loadbalancerIdentity, update)
+	return &mockCloudAPIUpdateLoadbalancerCall{Call: call}
+}
+
+// mockCloudAPIUpdateLoadbalancerCall wrap *gomock.Call
+type mockCloudAPIUpdateLoadbalancerCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateLoadbalancerCall) Return(arg0 *iaas.VpcLoadbalancer, arg1 error) *mockCloudAPIUpdateLoadbalancerCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateLoadbalancerCall) Do(f func(context.Context, string, iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIUpdateLoadbalancerCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateLoadbalancerCall) DoAndReturn(f func(context.Context, string, iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIUpdateLoadbalancerCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateLoadbalancerSecurityGroups mocks base method.
+func (m *mockCloudAPI) UpdateLoadbalancerSecurityGroups(ctx context.Context, loadbalancerIdentity string, update iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateLoadbalancerSecurityGroups", ctx, loadbalancerIdentity, update)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateLoadbalancerSecurityGroups indicates an expected call of UpdateLoadbalancerSecurityGroups.
+func (mr *mockCloudAPIMockRecorder) UpdateLoadbalancerSecurityGroups(ctx, loadbalancerIdentity, update any) *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateLoadbalancerSecurityGroups", reflect.TypeOf((*mockCloudAPI)(nil).UpdateLoadbalancerSecurityGroups), ctx, loadbalancerIdentity, update)
+	return &mockCloudAPIUpdateLoadbalancerSecurityGroupsCall{Call: call}
+}
+
+// mockCloudAPIUpdateLoadbalancerSecurityGroupsCall wrap *gomock.Call
+type mockCloudAPIUpdateLoadbalancerSecurityGroupsCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall) Return(arg0 *iaas.VpcLoadbalancer, arg1 error) *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall) Do(f func(context.Context, string, iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall) DoAndReturn(f func(context.Context, string, iaas.UpdateLoadbalancer) (*iaas.VpcLoadbalancer, error)) *mockCloudAPIUpdateLoadbalancerSecurityGroupsCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateMachine mocks base method.
+func (m *mockCloudAPI) UpdateMachine(ctx context.Context, identity string, update iaas.UpdateMachine) (*iaas.Machine, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateMachine", ctx, identity, update)
+	ret0, _ := ret[0].(*iaas.Machine)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateMachine indicates an expected call of UpdateMachine.
+func (mr *mockCloudAPIMockRecorder) UpdateMachine(ctx, identity, update any) *mockCloudAPIUpdateMachineCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateMachine", reflect.TypeOf((*mockCloudAPI)(nil).UpdateMachine), ctx, identity, update)
+	return &mockCloudAPIUpdateMachineCall{Call: call}
+}
+
+// mockCloudAPIUpdateMachineCall wrap *gomock.Call
+type mockCloudAPIUpdateMachineCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateMachineCall) Return(arg0 *iaas.Machine, arg1 error) *mockCloudAPIUpdateMachineCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateMachineCall) Do(f func(context.Context, string, iaas.UpdateMachine) (*iaas.Machine, error)) *mockCloudAPIUpdateMachineCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateMachineCall) DoAndReturn(f func(context.Context, string, iaas.UpdateMachine) (*iaas.Machine, error)) *mockCloudAPIUpdateMachineCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateSecurityGroup mocks base method.
+func (m *mockCloudAPI) UpdateSecurityGroup(ctx context.Context, identity string, update iaas.UpdateSecurityGroupRequest) (*iaas.SecurityGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateSecurityGroup", ctx, identity, update)
+	ret0, _ := ret[0].(*iaas.SecurityGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateSecurityGroup indicates an expected call of UpdateSecurityGroup.
+func (mr *mockCloudAPIMockRecorder) UpdateSecurityGroup(ctx, identity, update any) *mockCloudAPIUpdateSecurityGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateSecurityGroup", reflect.TypeOf((*mockCloudAPI)(nil).UpdateSecurityGroup), ctx, identity, update)
+	return &mockCloudAPIUpdateSecurityGroupCall{Call: call}
+}
+
+// mockCloudAPIUpdateSecurityGroupCall wrap *gomock.Call
+type mockCloudAPIUpdateSecurityGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateSecurityGroupCall) Return(arg0 *iaas.SecurityGroup, arg1 error) *mockCloudAPIUpdateSecurityGroupCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateSecurityGroupCall) Do(f func(context.Context, string, iaas.UpdateSecurityGroupRequest) (*iaas.SecurityGroup, error)) *mockCloudAPIUpdateSecurityGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateSecurityGroupCall) DoAndReturn(f func(context.Context, string, iaas.UpdateSecurityGroupRequest) (*iaas.SecurityGroup, error)) *mockCloudAPIUpdateSecurityGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateTargetGroup mocks base method.
+func (m *mockCloudAPI) UpdateTargetGroup(ctx context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTargetGroup", ctx, update)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancerTargetGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateTargetGroup indicates an expected call of UpdateTargetGroup.
+func (mr *mockCloudAPIMockRecorder) UpdateTargetGroup(ctx, update any) *mockCloudAPIUpdateTargetGroupCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTargetGroup", reflect.TypeOf((*mockCloudAPI)(nil).UpdateTargetGroup), ctx, update)
+	return &mockCloudAPIUpdateTargetGroupCall{Call: call}
+}
+
+// mockCloudAPIUpdateTargetGroupCall wrap *gomock.Call
+type mockCloudAPIUpdateTargetGroupCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateTargetGroupCall) Return(arg0 *iaas.VpcLoadbalancerTargetGroup, arg1 error) *mockCloudAPIUpdateTargetGroupCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateTargetGroupCall) Do(f func(context.Context, iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIUpdateTargetGroupCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateTargetGroupCall) DoAndReturn(f func(context.Context, iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIUpdateTargetGroupCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
+
+// UpdateTargetGroupSelector mocks base method.
+func (m *mockCloudAPI) UpdateTargetGroupSelector(ctx context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateTargetGroupSelector", ctx, update)
+	ret0, _ := ret[0].(*iaas.VpcLoadbalancerTargetGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateTargetGroupSelector indicates an expected call of UpdateTargetGroupSelector.
+func (mr *mockCloudAPIMockRecorder) UpdateTargetGroupSelector(ctx, update any) *mockCloudAPIUpdateTargetGroupSelectorCall {
+	mr.mock.ctrl.T.Helper()
+	call := mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTargetGroupSelector", reflect.TypeOf((*mockCloudAPI)(nil).UpdateTargetGroupSelector), ctx, update)
+	return &mockCloudAPIUpdateTargetGroupSelectorCall{Call: call}
+}
+
+// mockCloudAPIUpdateTargetGroupSelectorCall wrap *gomock.Call
+type mockCloudAPIUpdateTargetGroupSelectorCall struct {
+	*gomock.Call
+}
+
+// Return rewrite *gomock.Call.Return
+func (c *mockCloudAPIUpdateTargetGroupSelectorCall) Return(arg0 *iaas.VpcLoadbalancerTargetGroup, arg1 error) *mockCloudAPIUpdateTargetGroupSelectorCall {
+	c.Call = c.Call.Return(arg0, arg1)
+	return c
+}
+
+// Do rewrite *gomock.Call.Do
+func (c *mockCloudAPIUpdateTargetGroupSelectorCall) Do(f func(context.Context, iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIUpdateTargetGroupSelectorCall {
+	c.Call = c.Call.Do(f)
+	return c
+}
+
+// DoAndReturn rewrite *gomock.Call.DoAndReturn
+func (c *mockCloudAPIUpdateTargetGroupSelectorCall) DoAndReturn(f func(context.Context, iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error)) *mockCloudAPIUpdateTargetGroupSelectorCall {
+	c.Call = c.Call.DoAndReturn(f)
+	return c
+}
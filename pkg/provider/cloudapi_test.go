@@ -0,0 +1,26 @@
+package provider
+
+import (
+	"runtime"
+	"testing"
+
+	"go.uber.org/mock/gomock"
+)
+
+// goroutineSafeReporter reports the failures of gomock with t.Errorf, so unexpected calls made by goroutines of the
+// code under test fail the test without calling t.FailNow off the test goroutine. The calling goroutine is stopped,
+// as gomock does not expect Fatalf to return.
+type goroutineSafeReporter struct {
+	*testing.T
+}
+
+func (r goroutineSafeReporter) Fatalf(format string, args ...any) {
+	r.Helper()
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// newMockCloudAPI returns a mock CloudAPI whose expectations are checked when the test ends
+func newMockCloudAPI(t *testing.T) *mockCloudAPI {
+	return NewmockCloudAPI(gomock.NewController(goroutineSafeReporter{T: t}))
+}
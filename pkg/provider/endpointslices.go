@@ -7,7 +7,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/client-go/informers"
-	"k8s.io/client-go/kubernetes"
 	discoverylisters "k8s.io/client-go/listers/discovery/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog/v2"
@@ -15,35 +14,35 @@ import (
 
 type EndpointSliceWatcher struct {
 	informer        cache.SharedIndexInformer
-	epSliceInformer informers.SharedInformerFactory
-	serviceInformer informers.SharedInformerFactory
+	informerFactory informers.SharedInformerFactory
 
 	// Callback function to trigger load balancer resync
 	onEndpointSliceChange func(serviceKey string)
 
+	// serviceFilter selects the Services whose load balancers are reconciled by the provider, nil selects all
+	serviceFilter func(svc *corev1.Service) bool
+
 	// Track services that have externalTrafficPolicy=Local
 	localTrafficServices sync.Map
 
 	mu sync.RWMutex
 }
 
+// NewEndpointSliceWatcher registers endpoint slice and service event handlers on the shared informer factory,
+// starts the informers and waits for their caches to sync.
 func NewEndpointSliceWatcher(
-	client kubernetes.Interface,
+	informerFactory informers.SharedInformerFactory,
 	stopCh <-chan struct{},
 	onEndpointSliceChange func(serviceKey string),
+	serviceFilter func(svc *corev1.Service) bool,
 ) *EndpointSliceWatcher {
 	w := &EndpointSliceWatcher{
 		onEndpointSliceChange: onEndpointSliceChange,
+		serviceFilter:         serviceFilter,
+		informerFactory:       informerFactory,
 	}
 
-	// Create informer factory for endpoint slices
-	epSliceFactory := informers.NewSharedInformerFactory(client, 0)
-	w.epSliceInformer = epSliceFactory
-	w.informer = w.epSliceInformer.Discovery().V1().EndpointSlices().Informer()
-
-	// Create informer factory for services to track externalTrafficPolicy
-	serviceFactory := informers.NewSharedInformerFactory(client, 0)
-	w.serviceInformer = serviceFactory
+	w.informer = informerFactory.Discovery().V1().EndpointSlices().Informer()
 
 	// Add event handlers for endpoint slices
 	w.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
@@ -53,16 +52,15 @@ func NewEndpointSliceWatcher(
 	})
 
 	// Add event handlers for services to track externalTrafficPolicy changes
-	serviceInformer := w.serviceInformer.Core().V1().Services().Informer()
+	serviceInformer := informerFactory.Core().V1().Services().Informer()
 	serviceInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    w.handleServiceAdd,
 		UpdateFunc: w.handleServiceUpdate,
 		DeleteFunc: w.handleServiceDelete,
 	})
 
-	// Start informers
-	epSliceFactory.Start(stopCh)
-	serviceFactory.Start(stopCh)
+	// Start informers; already started informers are left untouched
+	informerFactory.Start(stopCh)
 
 	// Wait for caches to sync
 	cache.WaitForCacheSync(stopCh, w.informer.HasSynced, serviceInformer.HasSynced)
@@ -154,7 +152,7 @@ func (w *EndpointSliceWatcher) handleServiceAdd(obj interface{}) {
 
 	serviceKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
 
-	if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+	if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal && w.isHandledService(svc) {
 		w.localTrafficServices.Store(serviceKey, struct{}{})
 		klog.V(4).Infof("Service %s added with externalTrafficPolicy=Local", serviceKey)
 	}
@@ -179,6 +177,14 @@ func (w *EndpointSliceWatcher) handleServiceUpdate(oldObj, newObj interface{}) {
 	oldPolicy := oldSvc.Spec.ExternalTrafficPolicy
 	newPolicy := newSvc.Spec.ExternalTrafficPolicy
 
+	// Services not handled by the provider are tracked as if they did not use externalTrafficPolicy=Local
+	if !w.isHandledService(oldSvc) {
+		oldPolicy = ""
+	}
+	if !w.isHandledService(newSvc) {
+		newPolicy = ""
+	}
+
 	// If externalTrafficPolicy changed to Local, add to tracking
 	if oldPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal && newPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
 		w.localTrafficServices.Store(serviceKey, struct{}{})
@@ -207,6 +213,11 @@ func (w *EndpointSliceWatcher) handleServiceDelete(obj interface{}) {
 	klog.V(4).Infof("Service %s deleted, removed from local traffic tracking", serviceKey)
 }
 
+// isHandledService returns true if the load balancer of the Service is reconciled by the provider
+func (w *EndpointSliceWatcher) isHandledService(svc *corev1.Service) bool {
+	return w.serviceFilter == nil || w.serviceFilter(svc)
+}
+
 // getServiceKeyFromEndpointSlice extracts the service key from an endpoint slice
 func (w *EndpointSliceWatcher) getServiceKeyFromEndpointSlice(epSlice *discoveryv1.EndpointSlice) string {
 	serviceName, ok := epSlice.Labels[discoveryv1.LabelServiceName]
@@ -222,40 +233,29 @@ func (w *EndpointSliceWatcher) hasLocalTrafficPolicy(serviceKey string) bool {
 	return exists
 }
 
-// hasNodeAssignmentChanged checks if node assignments have changed between two endpoint slices
+// hasNodeAssignmentChanged checks if node assignments have changed between two endpoint slices.
+// Both the nodes with ready endpoints and the nodes with serving terminating endpoints are compared,
+// as the node filter falls back to the latter during rollouts.
 func (w *EndpointSliceWatcher) hasNodeAssignmentChanged(oldEpSlice, newEpSlice *discoveryv1.EndpointSlice) bool {
-	oldNodes := make(map[string]struct{})
-	newNodes := make(map[string]struct{})
-
-	// Collect old nodes
-	for _, ep := range oldEpSlice.Endpoints {
-		if ep.NodeName != nil && ep.Conditions.Ready != nil && *ep.Conditions.Ready {
-			oldNodes[*ep.NodeName] = struct{}{}
-		}
-	}
-
-	// Collect new nodes
-	for _, ep := range newEpSlice.Endpoints {
-		if ep.NodeName != nil && ep.Conditions.Ready != nil && *ep.Conditions.Ready {
-			newNodes[*ep.NodeName] = struct{}{}
-		}
-	}
+	oldReady, oldServing := collectEndpointNodes(oldEpSlice.Endpoints)
+	newReady, newServing := collectEndpointNodes(newEpSlice.Endpoints)
+	return !sameNodeSet(oldReady, newReady) || !sameNodeSet(oldServing, newServing)
+}
 
-	// Check if the sets are different
-	if len(oldNodes) != len(newNodes) {
-		return true
+// sameNodeSet returns true if both sets contain the same node names
+func sameNodeSet(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	for nodeName := range oldNodes {
-		if _, exists := newNodes[nodeName]; !exists {
-			return true
+	for nodeName := range a {
+		if _, exists := b[nodeName]; !exists {
+			return false
 		}
 	}
-
-	return false
+	return true
 }
 
 // GetEndpointSliceLister returns the endpoint slice lister
 func (w *EndpointSliceWatcher) GetEndpointSliceLister() discoverylisters.EndpointSliceLister {
-	return w.epSliceInformer.Discovery().V1().EndpointSlices().Lister()
+	return w.informerFactory.Discovery().V1().EndpointSlices().Lister()
 }
@@ -9,6 +9,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/klog/v2"
 	"k8s.io/utils/ptr"
@@ -37,7 +38,7 @@ func TestEndpointSliceWatcher_NodeAssignmentChanges(t *testing.T) {
 	defer close(stopCh)
 
 	// Create the endpoint slice watcher
-	_ = NewEndpointSliceWatcher(client, stopCh, resyncCallback)
+	_ = NewEndpointSliceWatcher(informers.NewSharedInformerFactory(client, 0), stopCh, resyncCallback, nil)
 
 	// Create a service with externalTrafficPolicy=Local
 	service := &corev1.Service{
@@ -134,7 +135,7 @@ func TestEndpointSliceWatcher_ExternalTrafficPolicyChanges(t *testing.T) {
 	defer close(stopCh)
 
 	// Create the endpoint slice watcher
-	_ = NewEndpointSliceWatcher(client, stopCh, resyncCallback)
+	_ = NewEndpointSliceWatcher(informers.NewSharedInformerFactory(client, 0), stopCh, resyncCallback, nil)
 
 	// Create a service with externalTrafficPolicy=Cluster initially
 	service := &corev1.Service{
@@ -0,0 +1,1274 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"k8s.io/utils/ptr"
+)
+
+// fakeIaas is an in-process fake of the Thalassa IaaS API. It keeps the VPCs, machines, load balancers, listeners,
+// target groups, security groups, reserved IPs and NAT gateways of an organisation, applies the requests of the provider to them
+// and rejects requests the API would reject, e.g. deleting a target group that is still used by a listener.
+// Faults and latency can be injected per request.
+type fakeIaas struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu             sync.Mutex
+	nextID         int
+	vpcs           []*iaas.Vpc
+	machines       []*iaas.Machine
+	regions        []*iaas.Region
+	loadbalancers  []*iaas.VpcLoadbalancer
+	listeners      []*fakeIaasListener
+	targetGroups   []*fakeIaasTargetGroup
+	securityGroups []*iaas.SecurityGroup
+	reservedIPs    []*iaas.ReservedIP
+	natGateways    []*iaas.VpcNatGateway
+	volumes        []*iaas.Volume
+
+	// addresses are the addresses load balancers get from their subnet, used when no reserved IP is attached
+	addresses map[string]string
+	// provisioningReads is the number of reads a load balancer remains provisioning after it is created
+	provisioningReads int
+	pendingReads      map[string]int
+
+	latency  time.Duration
+	faults   []*fakeIaasFault
+	requests []string
+}
+
+// fakeIaasListener is a listener and the load balancer it belongs to
+type fakeIaasListener struct {
+	loadbalancer string
+	targetGroup  string
+	listener     iaas.VpcLoadbalancerListener
+}
+
+// fakeIaasTargetGroup is a target group and the machines attached to it
+type fakeIaasTargetGroup struct {
+	targetGroup iaas.VpcLoadbalancerTargetGroup
+	attachments []string
+}
+
+// fakeIaasFault fails matching requests with a status code
+type fakeIaasFault struct {
+	method  string
+	pattern string
+	status  int
+	// remaining is the number of requests that still fail, a negative value fails all requests
+	remaining int
+}
+
+// newFakeIaas starts a fake IaaS API with the VPC vpc-test, which has a public and a private subnet in region nl-1
+// with the zones nl-1a and nl-1b
+func newFakeIaas(t *testing.T) *fakeIaas {
+	t.Helper()
+	f := &fakeIaas{
+		t: t,
+		regions: []*iaas.Region{{
+			Identity: "region-nl-1",
+			Name:     "NL 1",
+			Slug:     "nl-1",
+			Zones: []iaas.Zone{
+				{Identity: "zone-nl-1a", Name: "NL 1a", Slug: "nl-1a", CloudRegionIdentity: "region-nl-1"},
+				{Identity: "zone-nl-1b", Name: "NL 1b", Slug: "nl-1b", CloudRegionIdentity: "region-nl-1"},
+			},
+		}},
+		vpcs: []*iaas.Vpc{{
+			Identity:    "vpc-test",
+			Name:        "test",
+			Slug:        "test",
+			Status:      "ready",
+			CIDRs:       []string{"10.0.0.0/16"},
+			CloudRegion: &iaas.Region{Identity: "region-nl-1", Name: "NL 1", Slug: "nl-1"},
+			Subnets: []iaas.Subnet{
+				{Identity: "subnet-public", Name: "public", Slug: "public", Cidr: "10.0.0.0/24"},
+				{Identity: "subnet-private", Name: "private", Slug: "private", Cidr: "10.0.1.0/24"},
+			},
+		}},
+		addresses:    map[string]string{},
+		pendingReads: map[string]int{},
+	}
+	f.server = httptest.NewServer(f.routes())
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// client returns the CloudAPI of the provider for the fake API
+func (f *fakeIaas) client() CloudAPI {
+	return newCloudAPI(newTestIaasClient(f.t, f.server.URL))
+}
+
+func (f *fakeIaas) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+iaas.VpcEndpoint, f.listVpcs)
+	mux.HandleFunc("GET "+iaas.VpcEndpoint+"/{vpc}", f.getVpc)
+
+	mux.HandleFunc("GET "+iaas.RegionEndpoint, f.listRegions)
+
+	mux.HandleFunc("GET "+iaas.MachineEndpoint, f.listMachines)
+	mux.HandleFunc("GET "+iaas.MachineEndpoint+"/{machine}", f.getMachine)
+	mux.HandleFunc("PUT "+iaas.MachineEndpoint+"/{machine}", f.updateMachine)
+
+	mux.HandleFunc("GET "+iaas.LoadbalancerEndpoint, f.listLoadbalancers)
+	mux.HandleFunc("POST "+iaas.LoadbalancerEndpoint, f.createLoadbalancer)
+	mux.HandleFunc("GET "+iaas.LoadbalancerEndpoint+"/{lb}", f.getLoadbalancer)
+	mux.HandleFunc("PUT "+iaas.LoadbalancerEndpoint+"/{lb}", f.updateLoadbalancer)
+	mux.HandleFunc("DELETE "+iaas.LoadbalancerEndpoint+"/{lb}", f.deleteLoadbalancer)
+
+	mux.HandleFunc("GET "+iaas.LoadbalancerEndpoint+"/{lb}/listeners", f.listListeners)
+	mux.HandleFunc("POST "+iaas.LoadbalancerEndpoint+"/{lb}/listeners", f.createListener)
+	mux.HandleFunc("GET "+iaas.LoadbalancerEndpoint+"/{lb}/listeners/{listener}", f.getListener)
+	mux.HandleFunc("PUT "+iaas.LoadbalancerEndpoint+"/{lb}/listeners/{listener}", f.updateListener)
+	mux.HandleFunc("DELETE "+iaas.LoadbalancerEndpoint+"/{lb}/listeners/{listener}", f.deleteListener)
+
+	mux.HandleFunc("GET "+iaas.TargetGroupEndpoint, f.listTargetGroups)
+	mux.HandleFunc("POST "+iaas.TargetGroupEndpoint, f.createTargetGroup)
+	mux.HandleFunc("GET "+iaas.TargetGroupEndpoint+"/{tg}", f.getTargetGroup)
+	mux.HandleFunc("PUT "+iaas.TargetGroupEndpoint+"/{tg}", f.updateTargetGroup)
+	mux.HandleFunc("DELETE "+iaas.TargetGroupEndpoint+"/{tg}", f.deleteTargetGroup)
+	mux.HandleFunc("POST "+iaas.TargetGroupEndpoint+"/{tg}/attachments", f.setTargetGroupAttachments)
+
+	mux.HandleFunc("GET "+iaas.SecurityGroupEndpoint, f.listSecurityGroups)
+	mux.HandleFunc("POST "+iaas.SecurityGroupEndpoint, f.createSecurityGroup)
+	mux.HandleFunc("GET "+iaas.SecurityGroupEndpoint+"/{sg}", f.getSecurityGroup)
+	mux.HandleFunc("PUT "+iaas.SecurityGroupEndpoint+"/{sg}", f.updateSecurityGroup)
+	mux.HandleFunc("DELETE "+iaas.SecurityGroupEndpoint+"/{sg}", f.deleteSecurityGroup)
+
+	mux.HandleFunc("GET "+iaas.ReservedIPEndpoint, f.listReservedIPs)
+	mux.HandleFunc("GET "+iaas.ReservedIPEndpoint+"/{rip}", f.getReservedIP)
+
+	mux.HandleFunc("GET "+iaas.NatGatewayEndpoint, f.listNatGateways)
+
+	mux.HandleFunc("GET "+iaas.VolumeEndpoint+"/{volume}", f.getVolume)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		latency := f.latency
+		f.mu.Unlock()
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if r.Method != http.MethodGet {
+			f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		}
+		if status, ok := f.fault(r); ok {
+			writeFakeIaasError(w, status, "injected fault")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// failRequests fails the next requests matching the method and path pattern with the status code. The pattern is
+// matched with path.Match, e.g. /v1/loadbalancers/*/listeners. A negative number of times fails all matching requests.
+func (f *fakeIaas) failRequests(method string, pattern string, status int, times int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fakeIaasFault{method: method, pattern: pattern, status: status, remaining: times})
+}
+
+// setLatency delays all requests
+func (f *fakeIaas) setLatency(latency time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = latency
+}
+
+// setProvisioningReads keeps load balancers created from now on provisioning, without addresses, for the number of reads
+func (f *fakeIaas) setProvisioningReads(reads int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.provisioningReads = reads
+}
+
+func (f *fakeIaas) fault(r *http.Request) (int, bool) {
+	for _, fault := range f.faults {
+		if fault.remaining == 0 || fault.method != r.Method {
+			continue
+		}
+		if matched, _ := path.Match(fault.pattern, r.URL.Path); !matched {
+			continue
+		}
+		if fault.remaining > 0 {
+			fault.remaining--
+		}
+		return fault.status, true
+	}
+	return 0, false
+}
+
+// requestLog returns the mutating requests as "METHOD path", in the order they were received
+func (f *fakeIaas) requestLog() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.requests...)
+}
+
+// resetRequestLog clears the recorded requests
+func (f *fakeIaas) resetRequestLog() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = nil
+}
+
+// addMachine adds a running machine in vpc-test with the given addresses on its default interface
+func (f *fakeIaas) addMachine(identity string, slug string, addresses ...string) *iaas.Machine {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	machine := &iaas.Machine{
+		Identity:         identity,
+		Name:             slug,
+		Slug:             slug,
+		State:            iaas.MachineStateRunning,
+		Status:           iaas.ResourceStatus{Status: "running"},
+		MachineType:      &iaas.MachineType{Identity: "mt-small", Name: "small", Slug: "pgp-small"},
+		Vpc:              &iaas.Vpc{Identity: "vpc-test"},
+		Subnet:           &f.vpcs[0].Subnets[0],
+		Region:           ptr.To("nl-1"),
+		AvailabilityZone: ptr.To("nl-1a"),
+		Interfaces: iaas.VirtualMachineInterfaces{
+			{Name: "default", MacAddress: "52:54:00:00:00:01", IPAddresses: addresses},
+		},
+	}
+	f.machines = append(f.machines, machine)
+	return machine
+}
+
+// addSecurityGroup adds a security group in vpc-test
+func (f *fakeIaas) addSecurityGroup(identity string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.securityGroups = append(f.securityGroups, &iaas.SecurityGroup{
+		Identity: identity,
+		Name:     identity,
+		Slug:     identity,
+		Status:   iaas.SecurityGroupStatusReady,
+		Vpc:      &iaas.Vpc{Identity: "vpc-test"},
+	})
+}
+
+// addLoadbalancer adds a ready load balancer in the public subnet of vpc-test, like a load balancer created outside the
+// cluster
+func (f *fakeIaas) addLoadbalancer(identity string, name string, labels iaas.Labels) *iaas.VpcLoadbalancer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	vpcLoadbalancer := &iaas.VpcLoadbalancer{
+		Identity:       identity,
+		Name:           name,
+		Slug:           name,
+		Labels:         labels,
+		Annotations:    iaas.Annotations{},
+		Status:         "ready",
+		VpcIdentity:    "vpc-test",
+		Vpc:            &iaas.Vpc{Identity: "vpc-test"},
+		SubnetIdentity: "subnet-public",
+		Subnet:         &f.vpcs[0].Subnets[0],
+		SecurityGroups: []iaas.SecurityGroup{},
+	}
+	f.nextID++
+	f.addresses[identity] = fmt.Sprintf("203.0.113.%d", f.nextID)
+	f.loadbalancers = append(f.loadbalancers, vpcLoadbalancer)
+	return vpcLoadbalancer
+}
+
+// addTargetGroup adds a target group in vpc-test
+func (f *fakeIaas) addTargetGroup(identity string, name string, targetPort int, labels iaas.Labels) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.targetGroups = append(f.targetGroups, &fakeIaasTargetGroup{
+		targetGroup: iaas.VpcLoadbalancerTargetGroup{
+			Identity:   identity,
+			Name:       name,
+			Slug:       name,
+			Labels:     labels,
+			Vpc:        &iaas.Vpc{Identity: "vpc-test"},
+			TargetPort: targetPort,
+			Protocol:   iaas.ProtocolTCP,
+		},
+	})
+}
+
+// addLoadbalancerListener adds a listener with the identity to the load balancer
+func (f *fakeIaas) addLoadbalancerListener(loadbalancer string, identity string, create iaas.CreateListener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, err := f.addListener(loadbalancer, create); err != nil {
+		f.t.Fatalf("failed to add listener %s: %v", identity, err)
+	}
+	f.listeners[len(f.listeners)-1].listener.Identity = identity
+}
+
+// addReservedIP adds an available reserved IP in region nl-1
+func (f *fakeIaas) addReservedIP(identity string, address string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reservedIPs = append(f.reservedIPs, &iaas.ReservedIP{
+		Identity:    identity,
+		Name:        identity,
+		Slug:        identity,
+		Region:      f.vpcs[0].CloudRegion,
+		Status:      iaas.ReservedIpStatusAvailable,
+		IPv4Address: address,
+	})
+}
+
+// attachReservedIPToMachine attaches the reserved IP to the machine
+func (f *fakeIaas) attachReservedIPToMachine(identity string, machine string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reservedIP := f.findReservedIP(identity)
+	if reservedIP == nil {
+		f.t.Fatalf("reserved ip %s not found", identity)
+	}
+	reservedIP.Status = iaas.ReservedIpStatusAttached
+	reservedIP.AttachedToResourceIdentity = machine
+}
+
+// addNatGateway adds a NAT gateway with the given addresses in the public subnet of vpc-test
+func (f *fakeIaas) addNatGateway(identity string, v4IP string, v6IP string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.natGateways = append(f.natGateways, &iaas.VpcNatGateway{
+		Identity:       identity,
+		Name:           identity,
+		Slug:           identity,
+		Status:         "ready",
+		VpcIdentity:    "vpc-test",
+		SubnetIdentity: "subnet-public",
+		V4IP:           v4IP,
+		V6IP:           v6IP,
+	})
+}
+
+// addVolume adds a block volume in region nl-1 that can be attached in the given zones
+func (f *fakeIaas) addVolume(identity string, zones ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	volume := &iaas.Volume{
+		Identity: identity,
+		Name:     identity,
+		Slug:     identity,
+		Status:   "ready",
+		Size:     10,
+		Region:   &iaas.Region{Identity: "region-nl-1", Name: "NL 1", Slug: "nl-1"},
+	}
+	for _, region := range f.regions {
+		for _, zone := range region.Zones {
+			if slices.Contains(zones, zone.Slug) {
+				volume.AvailabilityZones = append(volume.AvailabilityZones, zone)
+			}
+		}
+	}
+	f.volumes = append(f.volumes, volume)
+}
+
+// loadbalancerList returns the load balancers as returned by the API, without counting as a read
+func (f *fakeIaas) loadbalancerList() []iaas.VpcLoadbalancer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	loadbalancers := []iaas.VpcLoadbalancer{}
+	for _, vpcLoadbalancer := range f.loadbalancers {
+		loadbalancers = append(loadbalancers, f.renderLoadbalancer(vpcLoadbalancer))
+	}
+	return loadbalancers
+}
+
+// listenerList returns the listeners of all load balancers
+func (f *fakeIaas) listenerList() []iaas.VpcLoadbalancerListener {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	listeners := []iaas.VpcLoadbalancerListener{}
+	for _, listener := range f.listeners {
+		listeners = append(listeners, f.renderListener(listener))
+	}
+	return listeners
+}
+
+// targetGroupList returns the target groups
+func (f *fakeIaas) targetGroupList() []iaas.VpcLoadbalancerTargetGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	targetGroups := []iaas.VpcLoadbalancerTargetGroup{}
+	for _, targetGroup := range f.targetGroups {
+		targetGroups = append(targetGroups, f.renderTargetGroup(targetGroup))
+	}
+	return targetGroups
+}
+
+// securityGroupList returns the security groups
+func (f *fakeIaas) securityGroupList() []iaas.SecurityGroup {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	securityGroups := []iaas.SecurityGroup{}
+	for _, securityGroup := range f.securityGroups {
+		securityGroups = append(securityGroups, *securityGroup)
+	}
+	return securityGroups
+}
+
+// reservedIP returns the reserved IP with the identity
+func (f *fakeIaas) reservedIP(identity string) *iaas.ReservedIP {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if reservedIP := f.findReservedIP(identity); reservedIP != nil {
+		copied := *reservedIP
+		return &copied
+	}
+	return nil
+}
+
+func (f *fakeIaas) newIdentity(prefix string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%d", prefix, f.nextID)
+}
+
+func writeFakeIaasJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeFakeIaasError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeFakeIaasJSON(w, status, map[string]string{"message": fmt.Sprintf(format, args...)})
+}
+
+func (f *fakeIaas) decode(w http.ResponseWriter, r *http.Request, into any) bool {
+	if err := json.NewDecoder(r.Body).Decode(into); err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return false
+	}
+	return true
+}
+
+// matchesListRequest returns true if the resource matches the vpc and label filters of the list request
+func matchesListRequest(r *http.Request, vpcIdentity string, labels map[string]string) bool {
+	for key, values := range r.URL.Query() {
+		if len(values) == 0 {
+			continue
+		}
+		switch {
+		case key == "vpc":
+			if values[0] != vpcIdentity {
+				return false
+			}
+		case strings.HasPrefix(key, "matchLabels[") && strings.HasSuffix(key, "]"):
+			label := strings.TrimSuffix(strings.TrimPrefix(key, "matchLabels["), "]")
+			if labels[label] != values[0] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f *fakeIaas) findVpc(identity string) *iaas.Vpc {
+	for _, vpc := range f.vpcs {
+		if vpc.Identity == identity {
+			return vpc
+		}
+	}
+	return nil
+}
+
+// findSubnet returns the subnet with the identity and its VPC
+func (f *fakeIaas) findSubnet(identity string) (*iaas.Subnet, *iaas.Vpc) {
+	for _, vpc := range f.vpcs {
+		for i := range vpc.Subnets {
+			if vpc.Subnets[i].Identity == identity {
+				return &vpc.Subnets[i], vpc
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeIaas) findMachine(identity string) *iaas.Machine {
+	for _, machine := range f.machines {
+		if machine.Identity == identity {
+			return machine
+		}
+	}
+	return nil
+}
+
+func (f *fakeIaas) findLoadbalancer(identity string) *iaas.VpcLoadbalancer {
+	for _, vpcLoadbalancer := range f.loadbalancers {
+		if vpcLoadbalancer.Identity == identity {
+			return vpcLoadbalancer
+		}
+	}
+	return nil
+}
+
+func (f *fakeIaas) findListener(loadbalancer string, identity string) *fakeIaasListener {
+	for _, listener := range f.listeners {
+		if listener.loadbalancer == loadbalancer && listener.listener.Identity == identity {
+			return listener
+		}
+	}
+	return nil
+}
+
+func (f *fakeIaas) findTargetGroup(identity string) *fakeIaasTargetGroup {
+	for _, targetGroup := range f.targetGroups {
+		if targetGroup.targetGroup.Identity == identity {
+			return targetGroup
+		}
+	}
+	return nil
+}
+
+func (f *fakeIaas) findSecurityGroup(identity string) *iaas.SecurityGroup {
+	for _, securityGroup := range f.securityGroups {
+		if securityGroup.Identity == identity {
+			return securityGroup
+		}
+	}
+	return nil
+}
+
+func (f *fakeIaas) findReservedIP(identity string) *iaas.ReservedIP {
+	for _, reservedIP := range f.reservedIPs {
+		if reservedIP.Identity == identity {
+			return reservedIP
+		}
+	}
+	return nil
+}
+
+// renderLoadbalancer returns the load balancer with its listeners, security groups and addresses
+func (f *fakeIaas) renderLoadbalancer(vpcLoadbalancer *iaas.VpcLoadbalancer) iaas.VpcLoadbalancer {
+	rendered := *vpcLoadbalancer
+	rendered.LoadbalancerListeners = []iaas.VpcLoadbalancerListener{}
+	for _, listener := range f.listeners {
+		if listener.loadbalancer == vpcLoadbalancer.Identity {
+			rendered.LoadbalancerListeners = append(rendered.LoadbalancerListeners, f.renderListener(listener))
+		}
+	}
+	rendered.SecurityGroups = []iaas.SecurityGroup{}
+	for _, attached := range vpcLoadbalancer.SecurityGroups {
+		if securityGroup := f.findSecurityGroup(attached.Identity); securityGroup != nil {
+			rendered.SecurityGroups = append(rendered.SecurityGroups, *securityGroup)
+		}
+	}
+	if f.pendingReads[vpcLoadbalancer.Identity] > 0 {
+		rendered.Status = "provisioning"
+		return rendered
+	}
+	rendered.ExternalIpAddresses = []string{f.addresses[vpcLoadbalancer.Identity]}
+	if reservedIP := f.findReservedIP(vpcLoadbalancer.ReservedIpIdentity); reservedIP != nil {
+		rendered.ReservedIp = reservedIP
+		rendered.ExternalIpAddresses = []string{reservedIP.IPv4Address}
+	}
+	return rendered
+}
+
+// readLoadbalancer renders the load balancer for a read, which moves a provisioning load balancer towards ready
+func (f *fakeIaas) readLoadbalancer(vpcLoadbalancer *iaas.VpcLoadbalancer) iaas.VpcLoadbalancer {
+	if f.pendingReads[vpcLoadbalancer.Identity] > 0 {
+		f.pendingReads[vpcLoadbalancer.Identity]--
+	}
+	return f.renderLoadbalancer(vpcLoadbalancer)
+}
+
+func (f *fakeIaas) renderListener(listener *fakeIaasListener) iaas.VpcLoadbalancerListener {
+	rendered := listener.listener
+	if targetGroup := f.findTargetGroup(listener.targetGroup); targetGroup != nil {
+		rendered.TargetGroup = ptr.To(targetGroup.targetGroup)
+	}
+	return rendered
+}
+
+// renderTargetGroup returns the target group with the listeners using it and its attachments
+func (f *fakeIaas) renderTargetGroup(targetGroup *fakeIaasTargetGroup) iaas.VpcLoadbalancerTargetGroup {
+	rendered := targetGroup.targetGroup
+	rendered.LoadbalancerListeners = []iaas.VpcLoadbalancerListener{}
+	for _, listener := range f.listeners {
+		if listener.targetGroup == targetGroup.targetGroup.Identity {
+			rendered.LoadbalancerListeners = append(rendered.LoadbalancerListeners, listener.listener)
+		}
+	}
+	rendered.LoadbalancerTargetGroupAttachments = []iaas.LoadbalancerTargetGroupAttachment{}
+	attachments := targetGroup.attachments
+	if len(targetGroup.targetGroup.TargetSelector) > 0 {
+		// the API attaches the machines matching the target selector
+		attachments = nil
+		for _, machine := range f.machines {
+			if matchLabels(targetGroup.targetGroup.TargetSelector, machine.Labels) {
+				attachments = append(attachments, machine.Identity)
+			}
+		}
+	}
+	for _, machineIdentity := range attachments {
+		rendered.LoadbalancerTargetGroupAttachments = append(rendered.LoadbalancerTargetGroupAttachments, iaas.LoadbalancerTargetGroupAttachment{
+			Identity:               fmt.Sprintf("%s-%s", targetGroup.targetGroup.Identity, machineIdentity),
+			VirtualMachineInstance: &iaas.Machine{Identity: machineIdentity},
+		})
+	}
+	return rendered
+}
+
+func (f *fakeIaas) listVpcs(w http.ResponseWriter, r *http.Request) {
+	vpcs := []iaas.Vpc{}
+	for _, vpc := range f.vpcs {
+		vpcs = append(vpcs, *vpc)
+	}
+	writeFakeIaasJSON(w, http.StatusOK, vpcs)
+}
+
+func (f *fakeIaas) getVpc(w http.ResponseWriter, r *http.Request) {
+	vpc := f.findVpc(r.PathValue("vpc"))
+	if vpc == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "vpc %s not found", r.PathValue("vpc"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, vpc)
+}
+
+func (f *fakeIaas) listRegions(w http.ResponseWriter, r *http.Request) {
+	regions := []iaas.Region{}
+	for _, region := range f.regions {
+		regions = append(regions, *region)
+	}
+	writeFakeIaasJSON(w, http.StatusOK, regions)
+}
+
+func (f *fakeIaas) listMachines(w http.ResponseWriter, r *http.Request) {
+	machines := []iaas.Machine{}
+	for _, machine := range f.machines {
+		vpcIdentity := ""
+		if machine.Vpc != nil {
+			vpcIdentity = machine.Vpc.Identity
+		}
+		if matchesListRequest(r, vpcIdentity, machine.Labels) {
+			machines = append(machines, *machine)
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, machines)
+}
+
+func (f *fakeIaas) getMachine(w http.ResponseWriter, r *http.Request) {
+	machine := f.findMachine(r.PathValue("machine"))
+	if machine == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "machine %s not found", r.PathValue("machine"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, machine)
+}
+
+func (f *fakeIaas) updateMachine(w http.ResponseWriter, r *http.Request) {
+	machine := f.findMachine(r.PathValue("machine"))
+	if machine == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "machine %s not found", r.PathValue("machine"))
+		return
+	}
+	update := iaas.UpdateMachine{}
+	if !f.decode(w, r, &update) {
+		return
+	}
+	machine.Name = update.Name
+	machine.Description = &update.Description
+	machine.Labels = update.Labels
+	machine.Annotations = update.Annotations
+	if update.State != nil {
+		machine.State = *update.State
+	}
+	if update.DeleteProtection != nil {
+		machine.DeleteProtection = *update.DeleteProtection
+	}
+	writeFakeIaasJSON(w, http.StatusOK, machine)
+}
+
+func (f *fakeIaas) listLoadbalancers(w http.ResponseWriter, r *http.Request) {
+	loadbalancers := []iaas.VpcLoadbalancer{}
+	for _, vpcLoadbalancer := range f.loadbalancers {
+		if matchesListRequest(r, vpcLoadbalancer.VpcIdentity, vpcLoadbalancer.Labels) {
+			loadbalancers = append(loadbalancers, f.readLoadbalancer(vpcLoadbalancer))
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, loadbalancers)
+}
+
+func (f *fakeIaas) getLoadbalancer(w http.ResponseWriter, r *http.Request) {
+	vpcLoadbalancer := f.findLoadbalancer(r.PathValue("lb"))
+	if vpcLoadbalancer == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "loadbalancer %s not found", r.PathValue("lb"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, f.readLoadbalancer(vpcLoadbalancer))
+}
+
+// attachReservedIP attaches the reserved IP to the load balancer, detaching the reserved IP attached before
+func (f *fakeIaas) attachReservedIP(vpcLoadbalancer *iaas.VpcLoadbalancer, identity string) (int, error) {
+	if identity != "" {
+		reservedIP := f.findReservedIP(identity)
+		if reservedIP == nil {
+			return http.StatusBadRequest, fmt.Errorf("reserved ip %s not found", identity)
+		}
+		if reservedIP.Status == iaas.ReservedIpStatusAttached && reservedIP.AttachedToResourceIdentity != vpcLoadbalancer.Identity {
+			return http.StatusConflict, fmt.Errorf("reserved ip %s is attached to %s", identity, reservedIP.AttachedToResourceIdentity)
+		}
+	}
+	if current := f.findReservedIP(vpcLoadbalancer.ReservedIpIdentity); current != nil {
+		current.Status = iaas.ReservedIpStatusAvailable
+		current.AttachedToResourceType = ""
+		current.AttachedToResourceIdentity = ""
+	}
+	vpcLoadbalancer.ReservedIpIdentity = identity
+	if reservedIP := f.findReservedIP(identity); reservedIP != nil {
+		reservedIP.Status = iaas.ReservedIpStatusAttached
+		reservedIP.AttachedToResourceType = "loadbalancer"
+		reservedIP.AttachedToResourceIdentity = vpcLoadbalancer.Identity
+	}
+	return 0, nil
+}
+
+// securityGroupAttachments returns the security groups with the identities, or an error if one does not exist
+func (f *fakeIaas) securityGroupAttachments(identities []string) ([]iaas.SecurityGroup, error) {
+	securityGroups := []iaas.SecurityGroup{}
+	for _, identity := range identities {
+		if f.findSecurityGroup(identity) == nil {
+			return nil, fmt.Errorf("security group %s not found", identity)
+		}
+		securityGroups = append(securityGroups, iaas.SecurityGroup{Identity: identity})
+	}
+	return securityGroups, nil
+}
+
+func (f *fakeIaas) createLoadbalancer(w http.ResponseWriter, r *http.Request) {
+	create := iaas.CreateLoadbalancer{}
+	if !f.decode(w, r, &create) {
+		return
+	}
+	subnet, vpc := f.findSubnet(create.Subnet)
+	if subnet == nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "subnet %s not found", create.Subnet)
+		return
+	}
+	securityGroups, err := f.securityGroupAttachments(create.SecurityGroupAttachments)
+	if err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+
+	vpcLoadbalancer := &iaas.VpcLoadbalancer{
+		Identity:         f.newIdentity("lb"),
+		Name:             create.Name,
+		Slug:             create.Name,
+		Description:      create.Description,
+		Labels:           create.Labels,
+		Annotations:      create.Annotations,
+		Status:           "ready",
+		VpcIdentity:      vpc.Identity,
+		Vpc:              &iaas.Vpc{Identity: vpc.Identity},
+		SubnetIdentity:   subnet.Identity,
+		Subnet:           subnet,
+		DeleteProtection: create.DeleteProtection,
+		SecurityGroups:   securityGroups,
+	}
+	if create.ReservedIpID != nil && *create.ReservedIpID != "" {
+		if status, err := f.attachReservedIP(vpcLoadbalancer, *create.ReservedIpID); err != nil {
+			writeFakeIaasError(w, status, "%v", err)
+			return
+		}
+	}
+	if create.InternalLoadbalancer {
+		f.addresses[vpcLoadbalancer.Identity] = fmt.Sprintf("10.0.1.%d", f.nextID)
+	} else {
+		f.addresses[vpcLoadbalancer.Identity] = fmt.Sprintf("203.0.113.%d", f.nextID)
+	}
+	f.pendingReads[vpcLoadbalancer.Identity] = f.provisioningReads
+	f.loadbalancers = append(f.loadbalancers, vpcLoadbalancer)
+
+	for _, listener := range create.Listeners {
+		if status, err := f.addListener(vpcLoadbalancer.Identity, listener); err != nil {
+			writeFakeIaasError(w, status, "%v", err)
+			return
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusCreated, f.renderLoadbalancer(vpcLoadbalancer))
+}
+
+func (f *fakeIaas) updateLoadbalancer(w http.ResponseWriter, r *http.Request) {
+	vpcLoadbalancer := f.findLoadbalancer(r.PathValue("lb"))
+	if vpcLoadbalancer == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "loadbalancer %s not found", r.PathValue("lb"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	update := iaas.UpdateLoadbalancer{}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &update); err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	// like the API, security groups are only changed if the update has the field
+	securityGroups := vpcLoadbalancer.SecurityGroups
+	if _, ok := fields["securityGroupAttachments"]; ok {
+		if securityGroups, err = f.securityGroupAttachments(update.SecurityGroupAttachments); err != nil {
+			writeFakeIaasError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+	}
+	if update.Subnet != nil && *update.Subnet != vpcLoadbalancer.SubnetIdentity {
+		subnet, vpc := f.findSubnet(*update.Subnet)
+		if subnet == nil || vpc.Identity != vpcLoadbalancer.VpcIdentity {
+			writeFakeIaasError(w, http.StatusBadRequest, "subnet %s not found", *update.Subnet)
+			return
+		}
+		vpcLoadbalancer.SubnetIdentity = subnet.Identity
+		vpcLoadbalancer.Subnet = subnet
+	}
+	if update.ReservedIpID != nil {
+		if status, err := f.attachReservedIP(vpcLoadbalancer, *update.ReservedIpID); err != nil {
+			writeFakeIaasError(w, status, "%v", err)
+			return
+		}
+	}
+	vpcLoadbalancer.Name = update.Name
+	vpcLoadbalancer.Description = update.Description
+	vpcLoadbalancer.Labels = update.Labels
+	vpcLoadbalancer.Annotations = update.Annotations
+	vpcLoadbalancer.DeleteProtection = update.DeleteProtection
+	vpcLoadbalancer.SecurityGroups = securityGroups
+	vpcLoadbalancer.ObjectVersion++
+	writeFakeIaasJSON(w, http.StatusOK, f.renderLoadbalancer(vpcLoadbalancer))
+}
+
+func (f *fakeIaas) deleteLoadbalancer(w http.ResponseWriter, r *http.Request) {
+	vpcLoadbalancer := f.findLoadbalancer(r.PathValue("lb"))
+	if vpcLoadbalancer == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "loadbalancer %s not found", r.PathValue("lb"))
+		return
+	}
+	if vpcLoadbalancer.DeleteProtection {
+		writeFakeIaasError(w, http.StatusConflict, "loadbalancer %s has delete protection enabled", vpcLoadbalancer.Identity)
+		return
+	}
+	_, _ = f.attachReservedIP(vpcLoadbalancer, "")
+
+	listeners := []*fakeIaasListener{}
+	for _, listener := range f.listeners {
+		if listener.loadbalancer != vpcLoadbalancer.Identity {
+			listeners = append(listeners, listener)
+		}
+	}
+	f.listeners = listeners
+	for i, existing := range f.loadbalancers {
+		if existing == vpcLoadbalancer {
+			f.loadbalancers = append(f.loadbalancers[:i], f.loadbalancers[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addListener adds a listener to the load balancer, ports must be unique per load balancer
+func (f *fakeIaas) addListener(loadbalancer string, create iaas.CreateListener) (int, error) {
+	if create.TargetGroup != "" && f.findTargetGroup(create.TargetGroup) == nil {
+		return http.StatusBadRequest, fmt.Errorf("target group %s not found", create.TargetGroup)
+	}
+	for _, listener := range f.listeners {
+		if listener.loadbalancer == loadbalancer && listener.listener.Port == create.Port {
+			return http.StatusConflict, fmt.Errorf("port %d is already used by listener %s", create.Port, listener.listener.Identity)
+		}
+	}
+	f.listeners = append(f.listeners, &fakeIaasListener{
+		loadbalancer: loadbalancer,
+		targetGroup:  create.TargetGroup,
+		listener: iaas.VpcLoadbalancerListener{
+			Identity:              f.newIdentity("listener"),
+			Name:                  create.Name,
+			Slug:                  create.Name,
+			Description:           create.Description,
+			Labels:                create.Labels,
+			Annotations:           create.Annotations,
+			Port:                  create.Port,
+			Protocol:              create.Protocol,
+			MaxConnections:        create.MaxConnections,
+			ConnectionIdleTimeout: create.ConnectionIdleTimeout,
+			AllowedSources:        create.AllowedSources,
+		},
+	})
+	return 0, nil
+}
+
+func (f *fakeIaas) listListeners(w http.ResponseWriter, r *http.Request) {
+	if f.findLoadbalancer(r.PathValue("lb")) == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "loadbalancer %s not found", r.PathValue("lb"))
+		return
+	}
+	listeners := []iaas.VpcLoadbalancerListener{}
+	for _, listener := range f.listeners {
+		if listener.loadbalancer == r.PathValue("lb") && matchesListRequest(r, "", listener.listener.Labels) {
+			listeners = append(listeners, f.renderListener(listener))
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, listeners)
+}
+
+func (f *fakeIaas) createListener(w http.ResponseWriter, r *http.Request) {
+	if f.findLoadbalancer(r.PathValue("lb")) == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "loadbalancer %s not found", r.PathValue("lb"))
+		return
+	}
+	create := iaas.CreateListener{}
+	if !f.decode(w, r, &create) {
+		return
+	}
+	if status, err := f.addListener(r.PathValue("lb"), create); err != nil {
+		writeFakeIaasError(w, status, "%v", err)
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusCreated, f.renderListener(f.listeners[len(f.listeners)-1]))
+}
+
+func (f *fakeIaas) getListener(w http.ResponseWriter, r *http.Request) {
+	listener := f.findListener(r.PathValue("lb"), r.PathValue("listener"))
+	if listener == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "listener %s not found", r.PathValue("listener"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, f.renderListener(listener))
+}
+
+func (f *fakeIaas) updateListener(w http.ResponseWriter, r *http.Request) {
+	listener := f.findListener(r.PathValue("lb"), r.PathValue("listener"))
+	if listener == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "listener %s not found", r.PathValue("listener"))
+		return
+	}
+	update := iaas.UpdateListener{}
+	if !f.decode(w, r, &update) {
+		return
+	}
+	if update.TargetGroup != "" && f.findTargetGroup(update.TargetGroup) == nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "target group %s not found", update.TargetGroup)
+		return
+	}
+	for _, other := range f.listeners {
+		if other != listener && other.loadbalancer == listener.loadbalancer && other.listener.Port == update.Port {
+			writeFakeIaasError(w, http.StatusConflict, "port %d is already used by listener %s", update.Port, other.listener.Identity)
+			return
+		}
+	}
+	listener.targetGroup = update.TargetGroup
+	listener.listener.Name = update.Name
+	listener.listener.Description = update.Description
+	listener.listener.Labels = update.Labels
+	listener.listener.Annotations = update.Annotations
+	listener.listener.Port = update.Port
+	listener.listener.Protocol = update.Protocol
+	listener.listener.MaxConnections = update.MaxConnections
+	listener.listener.ConnectionIdleTimeout = update.ConnectionIdleTimeout
+	listener.listener.AllowedSources = update.AllowedSources
+	listener.listener.ObjectVersion++
+	writeFakeIaasJSON(w, http.StatusOK, f.renderListener(listener))
+}
+
+func (f *fakeIaas) deleteListener(w http.ResponseWriter, r *http.Request) {
+	listener := f.findListener(r.PathValue("lb"), r.PathValue("listener"))
+	if listener == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "listener %s not found", r.PathValue("listener"))
+		return
+	}
+	for i, existing := range f.listeners {
+		if existing == listener {
+			f.listeners = append(f.listeners[:i], f.listeners[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeIaas) listTargetGroups(w http.ResponseWriter, r *http.Request) {
+	targetGroups := []iaas.VpcLoadbalancerTargetGroup{}
+	for _, targetGroup := range f.targetGroups {
+		if matchesListRequest(r, targetGroup.targetGroup.Vpc.Identity, targetGroup.targetGroup.Labels) {
+			targetGroups = append(targetGroups, f.renderTargetGroup(targetGroup))
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, targetGroups)
+}
+
+func (f *fakeIaas) createTargetGroup(w http.ResponseWriter, r *http.Request) {
+	create := iaas.CreateTargetGroup{}
+	if !f.decode(w, r, &create) {
+		return
+	}
+	if f.findVpc(create.Vpc) == nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "vpc %s not found", create.Vpc)
+		return
+	}
+	targetGroup := &fakeIaasTargetGroup{
+		targetGroup: iaas.VpcLoadbalancerTargetGroup{
+			Identity:            f.newIdentity("tg"),
+			Name:                create.Name,
+			Slug:                create.Name,
+			Description:         create.Description,
+			Labels:              create.Labels,
+			Annotations:         create.Annotations,
+			Vpc:                 &iaas.Vpc{Identity: create.Vpc},
+			TargetPort:          create.TargetPort,
+			Protocol:            create.Protocol,
+			TargetSelector:      create.TargetSelector,
+			EnableProxyProtocol: create.EnableProxyProtocol,
+			LoadbalancingPolicy: create.LoadbalancingPolicy,
+			HealthCheck:         create.HealthCheck,
+		},
+	}
+	f.targetGroups = append(f.targetGroups, targetGroup)
+	writeFakeIaasJSON(w, http.StatusCreated, f.renderTargetGroup(targetGroup))
+}
+
+func (f *fakeIaas) getTargetGroup(w http.ResponseWriter, r *http.Request) {
+	targetGroup := f.findTargetGroup(r.PathValue("tg"))
+	if targetGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "target group %s not found", r.PathValue("tg"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, f.renderTargetGroup(targetGroup))
+}
+
+func (f *fakeIaas) updateTargetGroup(w http.ResponseWriter, r *http.Request) {
+	targetGroup := f.findTargetGroup(r.PathValue("tg"))
+	if targetGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "target group %s not found", r.PathValue("tg"))
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	update := iaas.UpdateTargetGroup{}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &update); err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "invalid request body: %v", err)
+		return
+	}
+	targetGroup.targetGroup.Name = update.Name
+	targetGroup.targetGroup.Description = update.Description
+	targetGroup.targetGroup.Labels = update.Labels
+	targetGroup.targetGroup.Annotations = update.Annotations
+	targetGroup.targetGroup.TargetPort = update.TargetPort
+	targetGroup.targetGroup.Protocol = update.Protocol
+	// like the API, the target selector is only changed if the update has the field
+	if _, ok := fields["targetSelector"]; ok {
+		targetGroup.targetGroup.TargetSelector = update.TargetSelector
+	}
+	targetGroup.targetGroup.EnableProxyProtocol = update.EnableProxyProtocol
+	targetGroup.targetGroup.LoadbalancingPolicy = update.LoadbalancingPolicy
+	targetGroup.targetGroup.HealthCheck = update.HealthCheck
+	targetGroup.targetGroup.ObjectVersion++
+	writeFakeIaasJSON(w, http.StatusOK, f.renderTargetGroup(targetGroup))
+}
+
+func (f *fakeIaas) deleteTargetGroup(w http.ResponseWriter, r *http.Request) {
+	targetGroup := f.findTargetGroup(r.PathValue("tg"))
+	if targetGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "target group %s not found", r.PathValue("tg"))
+		return
+	}
+	for _, listener := range f.listeners {
+		if listener.targetGroup == targetGroup.targetGroup.Identity {
+			writeFakeIaasError(w, http.StatusConflict, "target group %s is used by listener %s", targetGroup.targetGroup.Identity, listener.listener.Identity)
+			return
+		}
+	}
+	for i, existing := range f.targetGroups {
+		if existing == targetGroup {
+			f.targetGroups = append(f.targetGroups[:i], f.targetGroups[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeIaas) setTargetGroupAttachments(w http.ResponseWriter, r *http.Request) {
+	targetGroup := f.findTargetGroup(r.PathValue("tg"))
+	if targetGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "target group %s not found", r.PathValue("tg"))
+		return
+	}
+	batch := iaas.TargetGroupAttachmentsBatch{}
+	if !f.decode(w, r, &batch) {
+		return
+	}
+	attachments := []string{}
+	for _, attachment := range batch.Attachments {
+		if f.findMachine(attachment.ServerIdentity) == nil {
+			writeFakeIaasError(w, http.StatusBadRequest, "machine %s not found", attachment.ServerIdentity)
+			return
+		}
+		attachments = append(attachments, attachment.ServerIdentity)
+	}
+	targetGroup.attachments = attachments
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeIaas) listSecurityGroups(w http.ResponseWriter, r *http.Request) {
+	securityGroups := []iaas.SecurityGroup{}
+	for _, securityGroup := range f.securityGroups {
+		if matchesListRequest(r, securityGroup.Vpc.Identity, securityGroup.Labels) {
+			securityGroups = append(securityGroups, *securityGroup)
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, securityGroups)
+}
+
+func (f *fakeIaas) createSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	create := iaas.CreateSecurityGroupRequest{}
+	if !f.decode(w, r, &create) {
+		return
+	}
+	if f.findVpc(create.VpcIdentity) == nil {
+		writeFakeIaasError(w, http.StatusBadRequest, "vpc %s not found", create.VpcIdentity)
+		return
+	}
+	if len(create.Name) == 0 || len(create.Name) > 16 {
+		writeFakeIaasError(w, http.StatusBadRequest, "name %q must be between 1 and 16 characters", create.Name)
+		return
+	}
+	securityGroup := &iaas.SecurityGroup{
+		Identity:              f.newIdentity("sg"),
+		Name:                  create.Name,
+		Slug:                  create.Name,
+		Description:           create.Description,
+		Labels:                create.Labels,
+		Annotations:           create.Annotations,
+		Vpc:                   &iaas.Vpc{Identity: create.VpcIdentity},
+		Status:                iaas.SecurityGroupStatusReady,
+		AllowSameGroupTraffic: create.AllowSameGroupTraffic,
+		IngressRules:          create.IngressRules,
+		EgressRules:           create.EgressRules,
+	}
+	f.securityGroups = append(f.securityGroups, securityGroup)
+	writeFakeIaasJSON(w, http.StatusCreated, securityGroup)
+}
+
+func (f *fakeIaas) getSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	securityGroup := f.findSecurityGroup(r.PathValue("sg"))
+	if securityGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "security group %s not found", r.PathValue("sg"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, securityGroup)
+}
+
+func (f *fakeIaas) updateSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	securityGroup := f.findSecurityGroup(r.PathValue("sg"))
+	if securityGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "security group %s not found", r.PathValue("sg"))
+		return
+	}
+	update := iaas.UpdateSecurityGroupRequest{}
+	if !f.decode(w, r, &update) {
+		return
+	}
+	securityGroup.Name = update.Name
+	securityGroup.Description = update.Description
+	securityGroup.Labels = update.Labels
+	securityGroup.Annotations = update.Annotations
+	securityGroup.AllowSameGroupTraffic = update.AllowSameGroupTraffic
+	if !update.SkipRulesUpdate {
+		securityGroup.IngressRules = update.IngressRules
+		securityGroup.EgressRules = update.EgressRules
+	}
+	securityGroup.ObjectVersion++
+	writeFakeIaasJSON(w, http.StatusOK, securityGroup)
+}
+
+func (f *fakeIaas) deleteSecurityGroup(w http.ResponseWriter, r *http.Request) {
+	securityGroup := f.findSecurityGroup(r.PathValue("sg"))
+	if securityGroup == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "security group %s not found", r.PathValue("sg"))
+		return
+	}
+	for _, vpcLoadbalancer := range f.loadbalancers {
+		for _, attached := range vpcLoadbalancer.SecurityGroups {
+			if attached.Identity == securityGroup.Identity {
+				writeFakeIaasError(w, http.StatusConflict, "security group %s is attached to loadbalancer %s", securityGroup.Identity, vpcLoadbalancer.Identity)
+				return
+			}
+		}
+	}
+	for i, existing := range f.securityGroups {
+		if existing == securityGroup {
+			f.securityGroups = append(f.securityGroups[:i], f.securityGroups[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeIaas) listReservedIPs(w http.ResponseWriter, r *http.Request) {
+	reservedIPs := []iaas.ReservedIP{}
+	for _, reservedIP := range f.reservedIPs {
+		if matchesListRequest(r, "", reservedIP.Labels) {
+			reservedIPs = append(reservedIPs, *reservedIP)
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, reservedIPs)
+}
+
+func (f *fakeIaas) listNatGateways(w http.ResponseWriter, r *http.Request) {
+	natGateways := []iaas.VpcNatGateway{}
+	for _, natGateway := range f.natGateways {
+		if matchesListRequest(r, natGateway.VpcIdentity, natGateway.Labels) {
+			natGateways = append(natGateways, *natGateway)
+		}
+	}
+	writeFakeIaasJSON(w, http.StatusOK, natGateways)
+}
+
+func (f *fakeIaas) getVolume(w http.ResponseWriter, r *http.Request) {
+	for _, volume := range f.volumes {
+		if volume.Identity == r.PathValue("volume") {
+			writeFakeIaasJSON(w, http.StatusOK, volume)
+			return
+		}
+	}
+	writeFakeIaasError(w, http.StatusNotFound, "volume %s not found", r.PathValue("volume"))
+}
+
+func (f *fakeIaas) getReservedIP(w http.ResponseWriter, r *http.Request) {
+	reservedIP := f.findReservedIP(r.PathValue("rip"))
+	if reservedIP == nil {
+		writeFakeIaasError(w, http.StatusNotFound, "reserved ip %s not found", r.PathValue("rip"))
+		return
+	}
+	writeFakeIaasJSON(w, http.StatusOK, reservedIP)
+}
@@ -4,14 +4,15 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sync"
+	"time"
 
-	"github.com/thalassa-cloud/client-go/filters"
 	"github.com/thalassa-cloud/client-go/iaas"
 	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	cloudprovider "k8s.io/cloud-provider"
-	v1helper "k8s.io/cloud-provider/node/helpers"
 	"k8s.io/klog/v2"
 )
 
@@ -21,12 +22,29 @@ var providerIDRegexp = regexp.MustCompile(`^` + ProviderName + `://([0-9A-Za-z_-
 type instancesV2 struct {
 	config *InstancesV2Config
 
-	iaasClient *iaas.Client
+	iaasClient CloudAPI
 
 	additionalLabels map[string]string
 	cluster          string
 	vpcIdentity      string
 	defaultSubnet    string
+
+	// machineIndex caches the machines in the VPC for the nodes without provider ID
+	machineIndex *machineIndex
+	// externalIPs caches the reserved IPs and NAT gateway addresses reported as external IPs, nil if disabled
+	externalIPs *externalIPIndex
+	// targetSelector labels the machines of initialized nodes for the target selectors of load balancer target groups
+	targetSelector TargetSelectorConfig
+
+	// vpc is the VPC of the cluster, read at startup or on the first lookup. regionCatalog are the regions and
+	// zones used to normalize the region and zone of machines, listed on the first lookup. regionCatalogRetry is set
+	// when the regions could not be listed, to list them again on the first lookup after it.
+	cacheMu            sync.Mutex
+	vpc                *iaas.Vpc
+	regionCatalog      *regionCatalog
+	regionCatalogRetry time.Time
+
+	recorder record.EventRecorder
 }
 
 // InstanceExists returns true if the instance for the given node exists according to the cloud provider.
@@ -63,14 +81,26 @@ func (i *instancesV2) InstanceShutdown(ctx context.Context, node *corev1.Node) (
 		return false, err
 	}
 
-	switch vmi.Status.Status {
-	case "deleted":
-		klog.Infof("instance %s is shutdown.", vmi.Name)
-		return true, nil
-	case "unknown":
-		return true, fmt.Errorf("instance is in unknown state")
+	shutdown := isMachineShutdown(vmi)
+	if shutdown {
+		klog.Infof("instance %s is shutdown, state %s", vmi.Identity, vmi.State)
+	}
+	return shutdown, nil
+}
+
+// isMachineShutdown reports if the machine is stopped or being deleted. Machines without state fall back to their
+// status, machines in an unknown state are not considered shut down.
+func isMachineShutdown(machine *iaas.Machine) bool {
+	switch machine.State {
+	case iaas.MachineStateRunning:
+		return false
+	case iaas.MachineStateStopped, iaas.MachineStateDeleting, iaas.MachineStateDeleted:
+		return true
+	case "":
+		return machine.Status.Status == string(iaas.MachineStateDeleted)
 	default:
-		return false, nil
+		klog.Warningf("instance %s is in unknown state %q, assuming it is not shutdown", machine.Identity, machine.State)
+		return false
 	}
 }
 
@@ -80,21 +110,32 @@ func (i *instancesV2) InstanceMetadata(ctx context.Context, node *corev1.Node) (
 	if err != nil {
 		return nil, err
 	}
-	nodeAddresses := i.getNodeAddresses(virtualMachineInstance, node.Status.Addresses)
-
-	region, zone := "", ""
-	// find the vpc
-	vpc, err := i.iaasClient.GetVpc(ctx, i.vpcIdentity)
+	nodeAddresses, err := i.getNodeAddresses(ctx, node, virtualMachineInstance)
 	if err != nil {
 		return nil, err
 	}
-	region = vpc.CloudRegion.Slug
 
-	if virtualMachineInstance.AvailabilityZone != nil {
-		zone = *virtualMachineInstance.AvailabilityZone
+	region, zone := "", ""
+	if i.config != nil && i.config.ZoneAndRegionEnabled {
+		region, zone, err = i.getRegionAndZone(ctx, node, virtualMachineInstance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// a failure to label the machine does not block the node, the load balancer reconcile labels it again. The machines
+	// of nodes excluded from load balancing are not labeled.
+	if i.targetSelector.Enabled {
+		labels := machineTargetLabels(i.targetSelector, i.cluster, node)
+		if !isLoadBalancerNode(node) {
+			labels = nil
+		}
+		if err := ensureMachineLabels(ctx, i.iaasClient, virtualMachineInstance, labels); err != nil {
+			klog.Warningf("failed to label the machine of node %s for target selectors: %v", node.Name, err)
+		}
 	}
 
-	additionalLabels := map[string]string{}
+	additionalLabels := i.getAdditionalLabels(virtualMachineInstance)
 	return &cloudprovider.InstanceMetadata{
 		ProviderID:       getProviderID(virtualMachineInstance.Identity),
 		NodeAddresses:    nodeAddresses,
@@ -105,72 +146,39 @@ func (i *instancesV2) InstanceMetadata(ctx context.Context, node *corev1.Node) (
 	}, nil
 }
 
-func (*instancesV2) getInstanceType(instance *iaas.Machine) string {
-	if instance.MachineType != nil {
-		return instance.MachineType.Slug
+// getVpc returns the VPC of the cluster. It is read once, the region and subnets of a VPC are not expected to change
+// while the provider runs.
+func (i *instancesV2) getVpc(ctx context.Context) (*iaas.Vpc, error) {
+	i.cacheMu.Lock()
+	defer i.cacheMu.Unlock()
+	if i.vpc != nil {
+		return i.vpc, nil
 	}
-	return ""
+	vpc, err := i.iaasClient.GetVpc(ctx, i.vpcIdentity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vpc %s: %v", i.vpcIdentity, err)
+	}
+	i.vpc = vpc
+	return i.vpc, nil
 }
 
-// findVirtualMachine finds a virtual machine instance of the corresponding node
-func (i *instancesV2) findVirtualMachine(ctx context.Context, node *corev1.Node) (*iaas.Machine, error) {
-	// TODO: implement filters in the API
-	machines, err := i.iaasClient.ListMachines(ctx, &iaas.ListMachinesRequest{
-		Filters: []filters.Filter{
-			&filters.FilterKeyValue{
-				Key:   "vpc",
-				Value: i.vpcIdentity,
-			},
-			// &filters.LabelFilter{
-			// 	MatchLabels: map[string]string{
-			// 		"name": node.GetName(),
-			// 	},
-			// },
-		},
-	})
+// getVpcRegion returns the region of the VPC
+func (i *instancesV2) getVpcRegion(ctx context.Context) (string, error) {
+	vpc, err := i.getVpc(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-	for _, machine := range machines {
-		if machine.Vpc == nil {
-			continue
-		}
-		if machine.Vpc.Identity != i.vpcIdentity {
-			continue
-		}
-		if machine.Slug == node.GetName() {
-			return &machine, nil
-		}
+	if vpc.CloudRegion == nil || vpc.CloudRegion.Slug == "" {
+		return "", fmt.Errorf("vpc %s has no region", i.vpcIdentity)
 	}
-	return nil, cloudprovider.InstanceNotFound
+	return vpc.CloudRegion.Slug, nil
 }
 
-func (i *instancesV2) getNodeAddresses(vmi *iaas.Machine, prevAddrs []corev1.NodeAddress) []corev1.NodeAddress {
-	var addrs []corev1.NodeAddress
-	foundInternalIP := false
-	for _, i := range vmi.Interfaces {
-		// TODO: do we handle IPv6 correctly here?
-		if i.Name == "default" && len(i.IPAddresses) > 0 {
-			for _, ip := range i.IPAddresses {
-				v1helper.AddToNodeAddresses(&addrs, corev1.NodeAddress{
-					Type:    corev1.NodeInternalIP,
-					Address: ip,
-				})
-			}
-			foundInternalIP = true
-			break
-		}
-	}
-
-	// fall back to the previously known internal IP on the node
-	if !foundInternalIP {
-		for _, prevAddr := range prevAddrs {
-			if prevAddr.Type == corev1.NodeInternalIP {
-				v1helper.AddToNodeAddresses(&addrs, prevAddr)
-			}
-		}
+func (*instancesV2) getInstanceType(instance *iaas.Machine) string {
+	if instance.MachineType != nil {
+		return instance.MachineType.Slug
 	}
-	return addrs
+	return ""
 }
 
 func getProviderID(machineIdentity string) string {
@@ -0,0 +1,263 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	corev1 "k8s.io/api/core/v1"
+	v1helper "k8s.io/cloud-provider/node/helpers"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultInterfaceName is the name of the primary interface of Thalassa Cloud machines
+	defaultInterfaceName = "default"
+)
+
+// NodeAddressesConfig configures the addresses reported for nodes
+type NodeAddressesConfig struct {
+	// Interfaces are the names of the interfaces whose addresses are the internal IPs of the node. Defaults to the
+	// interface named default, or the first interface of the machine.
+	Interfaces []string `yaml:"interfaces,omitempty"`
+	// Subnets are the identities or slugs of subnets. The interfaces with an address in one of these subnets are
+	// selected, in addition to the interfaces selected by name.
+	Subnets []string `yaml:"subnets,omitempty"`
+	// IncludeSecondaryInterfaces adds the addresses of the interfaces that are not selected as internal IPs, after the
+	// addresses of the selected interfaces
+	IncludeSecondaryInterfaces bool `yaml:"includeSecondaryInterfaces,omitempty"`
+	// PrimaryIPFamily is the IP family of the first internal IP, IPv4 or IPv6. Defaults to the family of the first pod
+	// CIDR of the node, or IPv4.
+	PrimaryIPFamily corev1.IPFamily `yaml:"primaryIPFamily,omitempty"`
+	// ReservedIPs reports the reserved IPs attached to the machine as external IPs of the node. Defaults to true.
+	ReservedIPs *bool `yaml:"reservedIPs,omitempty"`
+	// NatGatewayIPs reports the addresses of the NAT gateways of the VPC as external IPs of nodes without reserved IP
+	// of the same IP family
+	NatGatewayIPs bool `yaml:"natGatewayIPs,omitempty"`
+	// InternalDNSDomain is the domain of the InternalDNS address of nodes, the hostname of the node is prefixed to it.
+	// No InternalDNS address is reported if it is empty.
+	InternalDNSDomain string `yaml:"internalDNSDomain,omitempty"`
+}
+
+// ParseIPFamily validates an IP family value.
+func ParseIPFamily(value string) (corev1.IPFamily, error) {
+	switch {
+	case strings.EqualFold(strings.TrimSpace(value), string(corev1.IPv4Protocol)):
+		return corev1.IPv4Protocol, nil
+	case strings.EqualFold(strings.TrimSpace(value), string(corev1.IPv6Protocol)):
+		return corev1.IPv6Protocol, nil
+	default:
+		return "", fmt.Errorf("invalid ip family: %s, must be one of: %s, %s", value, corev1.IPv4Protocol, corev1.IPv6Protocol)
+	}
+}
+
+// getNodeAddresses returns the addresses of the node: the internal IPs of the selected interfaces of the machine and
+// its external IPs, both ordered by the primary IP family, followed by the hostname and the internal DNS name
+func (i *instancesV2) getNodeAddresses(ctx context.Context, node *corev1.Node, machine *iaas.Machine) ([]corev1.NodeAddress, error) {
+	config := NodeAddressesConfig{}
+	if i.config != nil {
+		config = i.config.NodeAddresses
+	}
+
+	selected, secondary, err := i.selectInterfaces(ctx, config, machine)
+	if err != nil {
+		return nil, err
+	}
+	ips := interfaceAddresses(selected)
+	if config.IncludeSecondaryInterfaces {
+		ips = append(ips, interfaceAddresses(secondary)...)
+	}
+	family := primaryIPFamily(config, node)
+	ips = sortByIPFamily(ips, family)
+
+	var addrs []corev1.NodeAddress
+	for _, ip := range ips {
+		v1helper.AddToNodeAddresses(&addrs, corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: ip.String()})
+	}
+
+	// fall back to the previously known internal IPs of the node
+	if len(addrs) == 0 {
+		for _, prevAddr := range node.Status.Addresses {
+			if prevAddr.Type == corev1.NodeInternalIP {
+				v1helper.AddToNodeAddresses(&addrs, prevAddr)
+			}
+		}
+	}
+
+	if i.externalIPs != nil {
+		externalIPs, err := i.externalIPs.get(ctx, machine.Identity)
+		if err != nil {
+			klog.Warningf("failed to get the external ips of machine %s, reporting the addresses of node %s without external ips: %v", machine.Identity, node.GetName(), err)
+		}
+		for _, ip := range sortByIPFamily(externalIPs, family) {
+			v1helper.AddToNodeAddresses(&addrs, corev1.NodeAddress{Type: corev1.NodeExternalIP, Address: ip.String()})
+		}
+	}
+
+	hostname := nodeHostname(node, machine)
+	if hostname != "" {
+		v1helper.AddToNodeAddresses(&addrs, corev1.NodeAddress{Type: corev1.NodeHostName, Address: hostname})
+		if domain := strings.Trim(config.InternalDNSDomain, "."); domain != "" {
+			shortHostname, _, _ := strings.Cut(hostname, ".")
+			v1helper.AddToNodeAddresses(&addrs, corev1.NodeAddress{Type: corev1.NodeInternalDNS, Address: shortHostname + "." + domain})
+		}
+	}
+	return addrs, nil
+}
+
+// selectInterfaces splits the interfaces of the machine in the interfaces selected by name or subnet, and the others
+func (i *instancesV2) selectInterfaces(ctx context.Context, config NodeAddressesConfig, machine *iaas.Machine) ([]iaas.VirtualMachineInterface, []iaas.VirtualMachineInterface, error) {
+	names := config.Interfaces
+	if len(names) == 0 && len(config.Subnets) == 0 {
+		names = []string{defaultInterfaceName}
+	}
+
+	var prefixes []netip.Prefix
+	if len(config.Subnets) > 0 {
+		var err error
+		prefixes, err = i.subnetPrefixes(ctx, config.Subnets)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var selected, secondary []iaas.VirtualMachineInterface
+	isSelected := make([]bool, len(machine.Interfaces))
+	// interfaces selected by name are ordered as configured
+	for _, name := range names {
+		for idx, iface := range machine.Interfaces {
+			if !isSelected[idx] && iface.Name == name {
+				isSelected[idx] = true
+				selected = append(selected, iface)
+			}
+		}
+	}
+	for idx, iface := range machine.Interfaces {
+		if !isSelected[idx] && interfaceInPrefixes(iface, prefixes) {
+			isSelected[idx] = true
+			selected = append(selected, iface)
+		}
+	}
+	// without configuration, machines without default interface use their first interface
+	if len(selected) == 0 && len(config.Interfaces) == 0 && len(config.Subnets) == 0 && len(machine.Interfaces) > 0 {
+		isSelected[0] = true
+		selected = append(selected, machine.Interfaces[0])
+	}
+	for idx, iface := range machine.Interfaces {
+		if !isSelected[idx] {
+			secondary = append(secondary, iface)
+		}
+	}
+	return selected, secondary, nil
+}
+
+// subnetPrefixes returns the CIDRs of the referenced subnets of the VPC
+func (i *instancesV2) subnetPrefixes(ctx context.Context, subnets []string) ([]netip.Prefix, error) {
+	vpc, err := i.getVpc(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	for _, reference := range subnets {
+		found := false
+		for _, subnet := range vpc.Subnets {
+			if subnet.Identity != reference && subnet.Slug != reference {
+				continue
+			}
+			found = true
+			prefix, err := netip.ParsePrefix(subnet.Cidr)
+			if err != nil {
+				klog.Warningf("subnet %s has an invalid cidr %q: %v", subnet.Identity, subnet.Cidr, err)
+				continue
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+		if !found {
+			klog.Warningf("subnet %s of the node address configuration is not a subnet of vpc %s", reference, i.vpcIdentity)
+		}
+	}
+	return prefixes, nil
+}
+
+// interfaceInPrefixes reports if an address of the interface is in one of the prefixes
+func interfaceInPrefixes(iface iaas.VirtualMachineInterface, prefixes []netip.Prefix) bool {
+	for _, ip := range interfaceAddresses([]iaas.VirtualMachineInterface{iface}) {
+		for _, prefix := range prefixes {
+			if prefix.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// interfaceAddresses returns the valid addresses of the interfaces, in order
+func interfaceAddresses(interfaces []iaas.VirtualMachineInterface) []netip.Addr {
+	var ips []netip.Addr
+	for _, iface := range interfaces {
+		for _, address := range iface.IPAddresses {
+			ip, err := netip.ParseAddr(address)
+			if err != nil {
+				klog.Warningf("interface %s has an invalid address %q: %v", iface.Name, address, err)
+				continue
+			}
+			ips = append(ips, ip.Unmap())
+		}
+	}
+	return ips
+}
+
+// sortByIPFamily moves the addresses of the primary IP family before the others, keeping their order otherwise
+func sortByIPFamily(ips []netip.Addr, primary corev1.IPFamily) []netip.Addr {
+	sorted := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if ipFamily(ip) == primary {
+			sorted = append(sorted, ip)
+		}
+	}
+	for _, ip := range ips {
+		if ipFamily(ip) != primary {
+			sorted = append(sorted, ip)
+		}
+	}
+	return sorted
+}
+
+func ipFamily(ip netip.Addr) corev1.IPFamily {
+	if ip.Is4() {
+		return corev1.IPv4Protocol
+	}
+	return corev1.IPv6Protocol
+}
+
+// primaryIPFamily returns the configured primary IP family, or the family of the first pod CIDR of the node, which
+// follows the primary IP family of the cluster
+func primaryIPFamily(config NodeAddressesConfig, node *corev1.Node) corev1.IPFamily {
+	if config.PrimaryIPFamily != "" {
+		return config.PrimaryIPFamily
+	}
+	podCIDR := node.Spec.PodCIDR
+	if len(node.Spec.PodCIDRs) > 0 {
+		podCIDR = node.Spec.PodCIDRs[0]
+	}
+	if prefix, err := netip.ParsePrefix(podCIDR); err == nil {
+		return ipFamily(prefix.Addr())
+	}
+	return corev1.IPv4Protocol
+}
+
+// nodeHostname returns the hostname of the node as reported by the kubelet, or the slug of the machine
+func nodeHostname(node *corev1.Node, machine *iaas.Machine) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeHostName && address.Address != "" {
+			return address.Address
+		}
+	}
+	if hostname := node.Labels[corev1.LabelHostname]; hostname != "" {
+		return hostname
+	}
+	return machine.Slug
+}
@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstancesV2_GetNodeAddresses(t *testing.T) {
+	dualStack := []iaas.VirtualMachineInterface{
+		{Name: "default", IPAddresses: []string{"fd00::11", "10.0.0.11"}},
+		{Name: "storage", IPAddresses: []string{"10.0.2.11"}},
+	}
+	internalIP := func(address string) corev1.NodeAddress {
+		return corev1.NodeAddress{Type: corev1.NodeInternalIP, Address: address}
+	}
+	hostname := corev1.NodeAddress{Type: corev1.NodeHostName, Address: "worker-1"}
+
+	tests := []struct {
+		name       string
+		config     NodeAddressesConfig
+		interfaces []iaas.VirtualMachineInterface
+		node       *corev1.Node
+		vpcErr     error
+		expected   []corev1.NodeAddress
+		expectErr  bool
+	}{
+		{
+			name:       "default interface, ipv4 first",
+			interfaces: dualStack,
+			expected:   []corev1.NodeAddress{internalIP("10.0.0.11"), internalIP("fd00::11"), hostname},
+		},
+		{
+			name:       "ipv6 primary from the pod cidrs",
+			interfaces: dualStack,
+			node:       &corev1.Node{Spec: corev1.NodeSpec{PodCIDRs: []string{"fd10::/64", "10.244.0.0/24"}}},
+			expected:   []corev1.NodeAddress{internalIP("fd00::11"), internalIP("10.0.0.11"), hostname},
+		},
+		{
+			name:       "configured primary family",
+			config:     NodeAddressesConfig{PrimaryIPFamily: corev1.IPv6Protocol},
+			interfaces: dualStack,
+			expected:   []corev1.NodeAddress{internalIP("fd00::11"), internalIP("10.0.0.11"), hostname},
+		},
+		{
+			name:       "secondary interfaces",
+			config:     NodeAddressesConfig{IncludeSecondaryInterfaces: true},
+			interfaces: dualStack,
+			expected:   []corev1.NodeAddress{internalIP("10.0.0.11"), internalIP("10.0.2.11"), internalIP("fd00::11"), hostname},
+		},
+		{
+			name:       "interface by name",
+			config:     NodeAddressesConfig{Interfaces: []string{"storage"}},
+			interfaces: dualStack,
+			expected:   []corev1.NodeAddress{internalIP("10.0.2.11"), hostname},
+		},
+		{
+			name:       "interface by subnet",
+			config:     NodeAddressesConfig{Subnets: []string{"private"}},
+			interfaces: []iaas.VirtualMachineInterface{{Name: "default", IPAddresses: []string{"10.0.0.11"}}, {Name: "eth1", IPAddresses: []string{"10.0.1.11", "fd01::11"}}},
+			expected:   []corev1.NodeAddress{internalIP("10.0.1.11"), internalIP("fd01::11"), hostname},
+		},
+		{
+			name:       "subnet lookup fails",
+			config:     NodeAddressesConfig{Subnets: []string{"private"}},
+			interfaces: dualStack,
+			vpcErr:     fmt.Errorf("unavailable"),
+			expectErr:  true,
+		},
+		{
+			name:       "first interface without default interface",
+			interfaces: []iaas.VirtualMachineInterface{{Name: "eth0", IPAddresses: []string{"10.0.0.11", "invalid"}}, {Name: "eth1", IPAddresses: []string{"10.0.1.11"}}},
+			expected:   []corev1.NodeAddress{internalIP("10.0.0.11"), hostname},
+		},
+		{
+			name: "previous internal ips without interfaces",
+			node: &corev1.Node{Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				internalIP("10.0.0.99"),
+				{Type: corev1.NodeHostName, Address: "host-99"},
+			}}},
+			expected: []corev1.NodeAddress{internalIP("10.0.0.99"), {Type: corev1.NodeHostName, Address: "host-99"}},
+		},
+		{
+			name:       "internal dns",
+			config:     NodeAddressesConfig{InternalDNSDomain: "cluster.internal."},
+			interfaces: dualStack[:1],
+			node:       &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{corev1.LabelHostname: "worker-1.example.com"}}},
+			expected: []corev1.NodeAddress{
+				internalIP("10.0.0.11"), internalIP("fd00::11"),
+				{Type: corev1.NodeHostName, Address: "worker-1.example.com"},
+				{Type: corev1.NodeInternalDNS, Address: "worker-1.cluster.internal"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			api.EXPECT().GetVpc(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, identity string) (*iaas.Vpc, error) {
+				if tt.vpcErr != nil {
+					return nil, tt.vpcErr
+				}
+				return &iaas.Vpc{Identity: identity, Subnets: []iaas.Subnet{
+					{Identity: "subnet-public", Slug: "public", Cidr: "10.0.0.0/24"},
+					{Identity: "subnet-private", Slug: "private", Cidr: "10.0.1.0/24"},
+				}}, nil
+			}).AnyTimes()
+			instances := &instancesV2{iaasClient: api, vpcIdentity: "vpc-test", config: &InstancesV2Config{NodeAddresses: tt.config}}
+			node := tt.node
+			if node == nil {
+				node = &corev1.Node{}
+			}
+			node.Name = "worker-1"
+
+			addrs, err := instances.getNodeAddresses(context.Background(), node, &iaas.Machine{Identity: "vm-1", Slug: "worker-1", Interfaces: tt.interfaces})
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addrs)
+		})
+	}
+}
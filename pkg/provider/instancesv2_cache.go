@@ -0,0 +1,128 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// defaultMachineIndexRefreshInterval is the default interval between two refreshes of the machine index
+	defaultMachineIndexRefreshInterval = time.Minute
+	// machineIndexMinRefreshInterval is the minimum time between two refreshes triggered by a node without match, so
+	// a burst of new nodes lists the machines only once
+	machineIndexMinRefreshInterval = 10 * time.Second
+)
+
+// machineSnapshot is an immutable view of the machines in the VPC, indexed by identity, slug and IP address
+type machineSnapshot struct {
+	machines   []iaas.Machine
+	byIdentity map[string]*iaas.Machine
+	bySlug     map[string][]*iaas.Machine
+	byIP       map[string][]*iaas.Machine
+}
+
+func newMachineSnapshot(machines []iaas.Machine) *machineSnapshot {
+	snapshot := &machineSnapshot{
+		machines:   machines,
+		byIdentity: make(map[string]*iaas.Machine, len(machines)),
+		bySlug:     make(map[string][]*iaas.Machine, len(machines)),
+		byIP:       make(map[string][]*iaas.Machine, len(machines)),
+	}
+	for idx := range snapshot.machines {
+		machine := &snapshot.machines[idx]
+		snapshot.byIdentity[machine.Identity] = machine
+		snapshot.bySlug[machine.Slug] = append(snapshot.bySlug[machine.Slug], machine)
+		for _, iface := range machine.Interfaces {
+			for _, ip := range iface.IPAddresses {
+				if machines := snapshot.byIP[ip]; len(machines) > 0 && machines[len(machines)-1] == machine {
+					continue
+				}
+				snapshot.byIP[ip] = append(snapshot.byIP[ip], machine)
+			}
+		}
+	}
+	return snapshot
+}
+
+// machineIndex caches the machines in the VPC of the cluster for all node lookups. It is refreshed lazily, by the first
+// lookup after the refresh interval has passed, and on demand when a node has no match, so an idle cluster does not
+// list the machines.
+type machineIndex struct {
+	iaasClient  MachineAPI
+	vpcIdentity string
+
+	refreshInterval time.Duration
+
+	// mu serializes the refreshes, concurrent lookups wait for a running refresh instead of listing again
+	mu        sync.Mutex
+	snapshot  *machineSnapshot
+	refreshed time.Time
+
+	now func() time.Time
+}
+
+func newMachineIndex(iaasClient MachineAPI, vpcIdentity string, refreshInterval time.Duration) *machineIndex {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultMachineIndexRefreshInterval
+	}
+	return &machineIndex{
+		iaasClient:      iaasClient,
+		vpcIdentity:     vpcIdentity,
+		refreshInterval: refreshInterval,
+		now:             time.Now,
+	}
+}
+
+// get returns the current snapshot, listing the machines if the index is empty or older than the refresh interval
+func (m *machineIndex) get(ctx context.Context) (*machineSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snapshot != nil && m.now().Sub(m.refreshed) < m.refreshInterval {
+		return m.snapshot, nil
+	}
+	return m.refreshLocked(ctx)
+}
+
+// refreshOnMiss lists the machines again, unless the index was refreshed less than machineIndexMinRefreshInterval
+// ago. Returns nil if the index was not refreshed.
+func (m *machineIndex) refreshOnMiss(ctx context.Context) (*machineSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snapshot != nil && m.now().Sub(m.refreshed) < machineIndexMinRefreshInterval {
+		return nil, nil
+	}
+	return m.refreshLocked(ctx)
+}
+
+func (m *machineIndex) refreshLocked(ctx context.Context) (*machineSnapshot, error) {
+	machines, err := m.iaasClient.ListMachines(ctx, &iaas.ListMachinesRequest{
+		Filters: []filters.Filter{
+			&filters.FilterKeyValue{
+				Key:   "vpc",
+				Value: m.vpcIdentity,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list machines: %v", err)
+	}
+	// the filter is not applied by every API version, so the VPC is checked again
+	vpcMachines := make([]iaas.Machine, 0, len(machines))
+	for _, machine := range machines {
+		if machine.Vpc == nil || machine.Vpc.Identity != m.vpcIdentity {
+			continue
+		}
+		vpcMachines = append(vpcMachines, machine)
+	}
+	m.snapshot = newMachineSnapshot(vpcMachines)
+	m.refreshed = m.now()
+	klog.V(4).Infof("refreshed the machine index with %d machines in vpc %s", len(vpcMachines), m.vpcIdentity)
+	return m.snapshot, nil
+}
@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	cloudprovider "k8s.io/cloud-provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachineIndex_ConcurrentNodesListOnce(t *testing.T) {
+	var machines []iaas.Machine
+	for n := 0; n < 50; n++ {
+		machines = append(machines, iaas.Machine{
+			Identity:   fmt.Sprintf("vm-%d", n),
+			Slug:       fmt.Sprintf("worker-%d", n),
+			Vpc:        &iaas.Vpc{Identity: "vpc-test"},
+			Interfaces: []iaas.VirtualMachineInterface{{Name: "default", IPAddresses: []string{fmt.Sprintf("10.0.0.%d", n)}}},
+		})
+	}
+	api := newMockCloudAPI(t)
+	api.EXPECT().ListMachines(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+		time.Sleep(10 * time.Millisecond)
+		return machines, nil
+	})
+	api.EXPECT().GetVpc(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, identity string) (*iaas.Vpc, error) {
+		return &iaas.Vpc{Identity: identity, CloudRegion: &iaas.Region{Slug: "nl-1"}}, nil
+	})
+	api.EXPECT().ListRegions(gomock.Any(), gomock.Any()).Return([]iaas.Region{{Identity: "region-nl-1", Slug: "nl-1"}}, nil)
+	instances := &instancesV2{
+		iaasClient:   api,
+		machineIndex: newMachineIndex(api, "vpc-test", 0),
+		vpcIdentity:  "vpc-test",
+		config:       &InstancesV2Config{ZoneAndRegionEnabled: true},
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(machines))
+	for n := range machines {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("worker-%d", n)}}
+			metadata, err := instances.InstanceMetadata(context.Background(), node)
+			if err == nil && metadata.ProviderID != getProviderID(fmt.Sprintf("vm-%d", n)) {
+				err = fmt.Errorf("node worker-%d matched %s", n, metadata.ProviderID)
+			}
+			errs <- err
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+}
+
+func TestMachineIndex_Refresh(t *testing.T) {
+	machines := []iaas.Machine{{Identity: "vm-1", Slug: "worker-1", Vpc: &iaas.Vpc{Identity: "vpc-test"}}}
+	listed := 0
+	api := newMockCloudAPI(t)
+	api.EXPECT().ListMachines(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+		listed++
+		return machines, nil
+	}).AnyTimes()
+	now := time.Now()
+	index := newMachineIndex(api, "vpc-test", time.Minute)
+	index.now = func() time.Time { return now }
+	instances := &instancesV2{iaasClient: api, machineIndex: index, vpcIdentity: "vpc-test", config: &InstancesV2Config{}}
+	ctx := context.Background()
+
+	_, err := instances.findVirtualMachine(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, listed)
+
+	// a machine created after the last refresh is found once the minimum refresh interval has passed
+	machines = append(machines, iaas.Machine{Identity: "vm-2", Slug: "worker-2", Vpc: &iaas.Vpc{Identity: "vpc-test"}})
+	_, err = instances.findVirtualMachine(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}})
+	assert.Equal(t, cloudprovider.InstanceNotFound, err)
+	assert.Equal(t, 1, listed, "a miss right after a refresh does not list again")
+
+	now = now.Add(machineIndexMinRefreshInterval)
+	machine, err := instances.findVirtualMachine(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}})
+	require.NoError(t, err)
+	assert.Equal(t, "vm-2", machine.Identity)
+	assert.Equal(t, 2, listed)
+
+	// a hit does not refresh until the refresh interval has passed
+	now = now.Add(30 * time.Second)
+	_, err = instances.findVirtualMachine(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, listed)
+
+	// the index is only refreshed by a lookup, not when the refresh interval passes
+	now = now.Add(time.Hour)
+	assert.Equal(t, 2, listed)
+	_, err = instances.findVirtualMachine(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, listed)
+}
+
+func TestMachineSnapshot(t *testing.T) {
+	snapshot := newMachineSnapshot([]iaas.Machine{
+		{Identity: "vm-1", Slug: "worker-1", Interfaces: []iaas.VirtualMachineInterface{
+			{Name: "default", IPAddresses: []string{"10.0.0.11", "fd00::11"}},
+			{Name: "secondary", IPAddresses: []string{"10.0.0.11"}},
+		}},
+		{Identity: "vm-2", Slug: "worker-2", Interfaces: []iaas.VirtualMachineInterface{{Name: "default", IPAddresses: []string{"10.0.0.12"}}}},
+	})
+
+	require.Contains(t, snapshot.byIdentity, "vm-2")
+	assert.Equal(t, "worker-2", snapshot.byIdentity["vm-2"].Slug)
+	require.Len(t, snapshot.bySlug["worker-1"], 1)
+	assert.Equal(t, "vm-1", snapshot.bySlug["worker-1"][0].Identity)
+	require.Len(t, snapshot.byIP["10.0.0.11"], 1, "an address on two interfaces of a machine is indexed once")
+	assert.Equal(t, "vm-1", snapshot.byIP["fd00::11"][0].Identity)
+	assert.Empty(t, snapshot.byIP["10.0.0.13"])
+}
@@ -0,0 +1,144 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// externalIPIndex caches the public addresses of machines: the reserved IPs attached to them and the addresses of the
+// NAT gateways of the VPC. It is refreshed on lookup when it is older than the refresh interval.
+type externalIPIndex struct {
+	iaasClient  CloudAPI
+	vpcIdentity string
+
+	refreshInterval time.Duration
+	// reservedIPs and natGateways enable listing the reserved IPs and the NAT gateways of the VPC
+	reservedIPs bool
+	natGateways bool
+
+	mu sync.Mutex
+	// machineIPs are the addresses of the reserved IPs attached to each machine, by machine identity
+	machineIPs map[string][]netip.Addr
+	// natGatewayIPs are the addresses of the NAT gateways of the VPC
+	natGatewayIPs []netip.Addr
+	refreshed     time.Time
+
+	now func() time.Time
+}
+
+func newExternalIPIndex(iaasClient CloudAPI, vpcIdentity string, refreshInterval time.Duration, reservedIPs bool, natGateways bool) *externalIPIndex {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultMachineIndexRefreshInterval
+	}
+	return &externalIPIndex{
+		iaasClient:      iaasClient,
+		vpcIdentity:     vpcIdentity,
+		refreshInterval: refreshInterval,
+		reservedIPs:     reservedIPs,
+		natGateways:     natGateways,
+		now:             time.Now,
+	}
+}
+
+// get returns the external addresses of the machine: its reserved IPs, and the NAT gateway addresses of the IP
+// families without reserved IP
+func (x *externalIPIndex) get(ctx context.Context, machineIdentity string) ([]netip.Addr, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.machineIPs == nil || x.now().Sub(x.refreshed) >= x.refreshInterval {
+		if err := x.refreshLocked(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	addrs := append([]netip.Addr{}, x.machineIPs[machineIdentity]...)
+	for _, natGatewayIP := range x.natGatewayIPs {
+		if !containsIPFamily(addrs, ipFamily(natGatewayIP)) {
+			addrs = append(addrs, natGatewayIP)
+		}
+	}
+	return addrs, nil
+}
+
+func (x *externalIPIndex) refreshLocked(ctx context.Context) error {
+	machineIPs := map[string][]netip.Addr{}
+	if x.reservedIPs {
+		reservedIPs, err := x.iaasClient.ListReservedIPs(ctx, &iaas.ListReservedIPsRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to list reserved ips: %v", err)
+		}
+		for _, reservedIP := range reservedIPs {
+			if reservedIP.Status != iaas.ReservedIpStatusAttached || reservedIP.AttachedToResourceIdentity == "" {
+				continue
+			}
+			// the client defines no resource type for machines, so the reserved IPs attached to other resources are
+			// skipped and the others are indexed by the attached identity, which only matches machine identities
+			if reservedIP.AttachedToResourceType == iaas.ReservedIpAttachedLoadBalancer || reservedIP.AttachedToResourceType == iaas.ReservedIpAttachedNatGateway {
+				continue
+			}
+			machine := reservedIP.AttachedToResourceIdentity
+			machineIPs[machine] = append(machineIPs[machine], parseAddrs(reservedIP.Identity, reservedIP.IPv4Address, reservedIP.IPv6Address)...)
+		}
+	}
+
+	var natGatewayIPs []netip.Addr
+	if x.natGateways {
+		natGateways, err := x.iaasClient.ListNatGateways(ctx, &iaas.ListNatGatewaysRequest{
+			Filters: []filters.Filter{
+				&filters.FilterKeyValue{
+					Key:   "vpc",
+					Value: x.vpcIdentity,
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list nat gateways: %v", err)
+		}
+		for _, natGateway := range natGateways {
+			if natGateway.VpcIdentity != x.vpcIdentity {
+				continue
+			}
+			natGatewayIPs = append(natGatewayIPs, parseAddrs(natGateway.Identity, natGateway.V4IP, natGateway.V6IP)...)
+		}
+	}
+
+	x.machineIPs = machineIPs
+	x.natGatewayIPs = natGatewayIPs
+	x.refreshed = x.now()
+	return nil
+}
+
+// parseAddrs parses the non-empty addresses of a resource, invalid addresses are logged and skipped
+func parseAddrs(resource string, addresses ...string) []netip.Addr {
+	var ips []netip.Addr
+	for _, address := range addresses {
+		if address == "" {
+			continue
+		}
+		ip, err := netip.ParseAddr(address)
+		if err != nil {
+			klog.Warningf("%s has an invalid address %q: %v", resource, address, err)
+			continue
+		}
+		ips = append(ips, ip.Unmap())
+	}
+	return ips
+}
+
+func containsIPFamily(ips []netip.Addr, family corev1.IPFamily) bool {
+	for _, ip := range ips {
+		if ipFamily(ip) == family {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalIPIndex(t *testing.T) {
+	reservedIPs := []iaas.ReservedIP{
+		{Identity: "rip-1", Status: iaas.ReservedIpStatusAttached, AttachedToResourceIdentity: "vm-1", IPv4Address: "198.51.100.1"},
+		{Identity: "rip-2", Status: iaas.ReservedIpStatusAttached, AttachedToResourceIdentity: "vm-2", IPv4Address: "198.51.100.2", IPv6Address: "2001:db8::2"},
+		{Identity: "rip-lb", Status: iaas.ReservedIpStatusAttached, AttachedToResourceType: iaas.ReservedIpAttachedLoadBalancer, AttachedToResourceIdentity: "vm-3", IPv4Address: "198.51.100.3"},
+		{Identity: "rip-nat", Status: iaas.ReservedIpStatusAttached, AttachedToResourceType: iaas.ReservedIpAttachedNatGateway, AttachedToResourceIdentity: "vm-3", IPv4Address: "198.51.100.5"},
+		{Identity: "rip-available", Status: iaas.ReservedIpStatusAvailable, AttachedToResourceIdentity: "vm-3", IPv4Address: "198.51.100.4"},
+	}
+	natGateways := []iaas.VpcNatGateway{
+		{Identity: "nat-1", VpcIdentity: "vpc-test", V4IP: "203.0.113.1", V6IP: "2001:db8::1"},
+		{Identity: "nat-other", VpcIdentity: "vpc-other", V4IP: "203.0.113.9"},
+	}
+	tests := []struct {
+		name        string
+		reservedIPs bool
+		natGateways bool
+		machine     string
+		reservedErr error
+		natErr      error
+		expected    []string
+		expectedErr string
+	}{
+		{name: "reserved ip", reservedIPs: true, machine: "vm-1", expected: []string{"198.51.100.1"}},
+		{name: "dual-stack reserved ip", reservedIPs: true, machine: "vm-2", expected: []string{"198.51.100.2", "2001:db8::2"}},
+		{name: "only attached machine reserved ips", reservedIPs: true, machine: "vm-3", expected: []string{}},
+		{name: "nat gateway for the families without reserved ip", reservedIPs: true, natGateways: true, machine: "vm-1", expected: []string{"198.51.100.1", "2001:db8::1"}},
+		{name: "nat gateway only", natGateways: true, machine: "vm-1", expected: []string{"203.0.113.1", "2001:db8::1"}},
+		{name: "reserved ips fail", reservedIPs: true, machine: "vm-1", reservedErr: fmt.Errorf("unavailable"), expectedErr: "failed to list reserved ips: unavailable"},
+		{name: "nat gateways fail", natGateways: true, machine: "vm-1", natErr: fmt.Errorf("unavailable"), expectedErr: "failed to list nat gateways: unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			if tt.reservedIPs {
+				api.EXPECT().ListReservedIPs(gomock.Any(), gomock.Any()).Return(reservedIPs, tt.reservedErr)
+			}
+			if tt.natGateways {
+				api.EXPECT().ListNatGateways(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, listRequest *iaas.ListNatGatewaysRequest) ([]iaas.VpcNatGateway, error) {
+					require.Len(t, listRequest.Filters, 1)
+					return natGateways, tt.natErr
+				}).MaxTimes(1)
+			}
+			index := newExternalIPIndex(api, "vpc-test", 0, tt.reservedIPs, tt.natGateways)
+
+			ips, err := index.get(context.Background(), tt.machine)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			addresses := []string{}
+			for _, ip := range ips {
+				addresses = append(addresses, ip.String())
+			}
+			assert.Equal(t, tt.expected, addresses)
+		})
+	}
+}
+
+func TestExternalIPIndex_Refresh(t *testing.T) {
+	reservedIPs := []iaas.ReservedIP{}
+	api := newMockCloudAPI(t)
+	api.EXPECT().ListReservedIPs(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListReservedIPsRequest) ([]iaas.ReservedIP, error) {
+		return reservedIPs, nil
+	}).Times(2)
+	now := time.Now()
+	index := newExternalIPIndex(api, "vpc-test", time.Minute, true, false)
+	index.now = func() time.Time { return now }
+
+	ips, err := index.get(context.Background(), "vm-1")
+	require.NoError(t, err)
+	assert.Empty(t, ips)
+
+	reservedIPs = append(reservedIPs, iaas.ReservedIP{Identity: "rip-1", Status: iaas.ReservedIpStatusAttached, AttachedToResourceIdentity: "vm-1", IPv4Address: "198.51.100.1"})
+	ips, err = index.get(context.Background(), "vm-1")
+	require.NoError(t, err)
+	assert.Empty(t, ips, "the reserved ips are cached")
+
+	now = now.Add(time.Minute)
+	ips, err = index.get(context.Background(), "vm-1")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("198.51.100.1")}, ips)
+}
+
+func TestInstancesV2_InstanceMetadataExternalIPs(t *testing.T) {
+	instances, cloud := newTestInstancesV2(t)
+	instances.externalIPs = newExternalIPIndex(instances.iaasClient, "vpc-test", 0, true, true)
+	cloud.addReservedIP("rip-web", "198.51.100.7")
+	cloud.attachReservedIPToMachine("rip-web", "vm-1")
+	cloud.addNatGateway("nat-1", "203.0.113.1", "2001:db8::1")
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+
+	metadata, err := instances.InstanceMetadata(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.11"},
+		{Type: corev1.NodeExternalIP, Address: "198.51.100.7"},
+		{Type: corev1.NodeExternalIP, Address: "2001:db8::1"},
+		{Type: corev1.NodeHostName, Address: "worker-1"},
+	}, metadata.NodeAddresses)
+
+	// the node addresses are reported without external ips when the reserved ips can not be listed
+	instances.externalIPs = newExternalIPIndex(instances.iaasClient, "vpc-test", 0, true, false)
+	cloud.failRequests(http.MethodGet, iaas.ReservedIPEndpoint, http.StatusInternalServerError, 1)
+	metadata, err = instances.InstanceMetadata(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.11"},
+		{Type: corev1.NodeHostName, Address: "worker-1"},
+	}, metadata.NodeAddresses)
+}
@@ -0,0 +1,119 @@
+package provider
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// NodeLabelPrefix is the prefix of the node labels derived from the machine, and of the copied machine labels
+	NodeLabelPrefix = "k8s.thalassa.cloud/"
+
+	// NodeLabelMachineTypeVcpus is the number of vCPUs of the machine type
+	NodeLabelMachineTypeVcpus = NodeLabelPrefix + "machine-type-vcpus"
+	// NodeLabelMachineTypeRAM is the RAM of the machine type in MB
+	NodeLabelMachineTypeRAM = NodeLabelPrefix + "machine-type-ram-mb"
+	// NodeLabelMachineTypeDisk is the disk size of the machine type in GB
+	NodeLabelMachineTypeDisk = NodeLabelPrefix + "machine-type-disk-gb"
+	// NodeLabelMachineImage is the slug of the machine image
+	NodeLabelMachineImage = NodeLabelPrefix + "machine-image"
+	// NodeLabelSubnet is the identity of the subnet of the machine
+	NodeLabelSubnet = NodeLabelPrefix + "subnet"
+	// NodeLabelVpc is the identity of the VPC of the machine
+	NodeLabelVpc = NodeLabelPrefix + "vpc"
+)
+
+// getAdditionalLabels returns the node labels derived from the machine: its machine type, image, subnet and VPC, and
+// the machine labels on the allow-list, copied under the NodeLabelPrefix. Values are sanitized to label syntax, labels
+// without valid value are left out.
+func (i *instancesV2) getAdditionalLabels(machine *iaas.Machine) map[string]string {
+	labels := map[string]string{}
+	setLabel := func(key string, value string) {
+		if sanitized := sanitizeLabelValue(value); sanitized != "" {
+			labels[key] = sanitized
+		}
+	}
+
+	if machine.MachineType != nil {
+		if machine.MachineType.Vcpus > 0 {
+			setLabel(NodeLabelMachineTypeVcpus, strconv.Itoa(machine.MachineType.Vcpus))
+		}
+		if machine.MachineType.RamMb > 0 {
+			setLabel(NodeLabelMachineTypeRAM, strconv.Itoa(machine.MachineType.RamMb))
+		}
+		if machine.MachineType.DiskGb > 0 {
+			setLabel(NodeLabelMachineTypeDisk, strconv.Itoa(machine.MachineType.DiskGb))
+		}
+	}
+	if machine.MachineImage != nil {
+		image := machine.MachineImage.Slug
+		if image == "" {
+			image = machine.MachineImage.Name
+		}
+		setLabel(NodeLabelMachineImage, image)
+	}
+	if machine.Subnet != nil {
+		setLabel(NodeLabelSubnet, machine.Subnet.Identity)
+	}
+	if machine.Vpc != nil {
+		setLabel(NodeLabelVpc, machine.Vpc.Identity)
+	}
+
+	if i.config == nil {
+		return labels
+	}
+	for _, key := range i.config.MachineLabels {
+		value, ok := machine.Labels[key]
+		if !ok {
+			continue
+		}
+		// prefixed machine labels are copied by their name
+		name := key
+		if idx := strings.LastIndex(key, "/"); idx >= 0 {
+			name = key[idx+1:]
+		}
+		nodeKey := NodeLabelPrefix + name
+		if errs := validation.IsQualifiedName(nodeKey); len(errs) > 0 {
+			klog.Warningf("machine label %s of machine %s can not be copied to the node: %s", key, machine.Identity, strings.Join(errs, ", "))
+			continue
+		}
+		if _, exists := labels[nodeKey]; exists {
+			klog.Warningf("machine label %s of machine %s is not copied to the node, %s is already set", key, machine.Identity, nodeKey)
+			continue
+		}
+		setLabel(nodeKey, value)
+	}
+	return labels
+}
+
+// sanitizeLabelValue converts a value to a valid label value: characters other than alphanumerics, '-', '_' and '.'
+// are replaced by '-', the value is truncated to 63 characters and must begin and end with an alphanumeric character.
+// Returns an empty string if nothing valid remains.
+func sanitizeLabelValue(value string) string {
+	sanitized := []byte(value)
+	for idx, c := range sanitized {
+		if !isLabelAlphanumeric(c) && c != '-' && c != '_' && c != '.' {
+			sanitized[idx] = '-'
+		}
+	}
+	result := string(sanitized)
+	if len(result) > validation.LabelValueMaxLength {
+		result = result[:validation.LabelValueMaxLength]
+	}
+	result = strings.TrimFunc(result, func(r rune) bool {
+		return !isLabelAlphanumeric(byte(r))
+	})
+	if len(validation.IsValidLabelValue(result)) > 0 {
+		return ""
+	}
+	return result
+}
+
+func isLabelAlphanumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
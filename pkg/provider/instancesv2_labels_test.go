@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstancesV2_GetAdditionalLabels(t *testing.T) {
+	tests := []struct {
+		name          string
+		machineLabels []string
+		machine       *iaas.Machine
+		expected      map[string]string
+	}{
+		{
+			name:     "no metadata",
+			machine:  &iaas.Machine{Identity: "vm-1"},
+			expected: map[string]string{},
+		},
+		{
+			name: "machine metadata",
+			machine: &iaas.Machine{
+				Identity:     "vm-1",
+				MachineType:  &iaas.MachineType{Slug: "pgp-small", Vcpus: 2, RamMb: 4096, DiskGb: 20},
+				MachineImage: &iaas.MachineImage{Slug: "ubuntu-24-04"},
+				Subnet:       &iaas.Subnet{Identity: "subnet-public"},
+				Vpc:          &iaas.Vpc{Identity: "vpc-test"},
+			},
+			expected: map[string]string{
+				NodeLabelMachineTypeVcpus: "2",
+				NodeLabelMachineTypeRAM:   "4096",
+				NodeLabelMachineTypeDisk:  "20",
+				NodeLabelMachineImage:     "ubuntu-24-04",
+				NodeLabelSubnet:           "subnet-public",
+				NodeLabelVpc:              "vpc-test",
+			},
+		},
+		{
+			name:     "image name is sanitized",
+			machine:  &iaas.Machine{Identity: "vm-1", MachineImage: &iaas.MachineImage{Name: "Ubuntu 24.04 (LTS)"}},
+			expected: map[string]string{NodeLabelMachineImage: "Ubuntu-24.04--LTS"},
+		},
+		{
+			name:          "allow-listed machine labels",
+			machineLabels: []string{"team", "example.com/cost-center", "missing", "vpc", "bad key"},
+			machine: &iaas.Machine{
+				Identity: "vm-1",
+				Vpc:      &iaas.Vpc{Identity: "vpc-test"},
+				Labels: map[string]string{
+					"team":                    "Platform & Infra",
+					"example.com/cost-center": "cc-42",
+					"secret":                  "not-copied",
+					"vpc":                     "other",
+					"bad key":                 "value",
+				},
+			},
+			expected: map[string]string{
+				NodeLabelVpc:                    "vpc-test",
+				NodeLabelPrefix + "team":        "Platform---Infra",
+				NodeLabelPrefix + "cost-center": "cc-42",
+			},
+		},
+		{
+			name:          "label without valid value",
+			machineLabels: []string{"team"},
+			machine:       &iaas.Machine{Identity: "vm-1", Labels: map[string]string{"team": "@@@"}},
+			expected:      map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances := &instancesV2{config: &InstancesV2Config{MachineLabels: tt.machineLabels}}
+			assert.Equal(t, tt.expected, instances.getAdditionalLabels(tt.machine))
+		})
+	}
+}
+
+func TestSanitizeLabelValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{value: "plain-value_1.0", expected: "plain-value_1.0"},
+		{value: "with spaces", expected: "with-spaces"},
+		{value: "-leading-and-trailing-", expected: "leading-and-trailing"},
+		{value: "ünïcode", expected: "n--code"},
+		{value: strings.Repeat("a", 62) + "-b", expected: strings.Repeat("a", 62)},
+		{value: "", expected: ""},
+		{value: "---", expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.expected, sanitizeLabelValue(tt.value))
+		})
+	}
+}
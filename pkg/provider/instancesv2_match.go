@@ -0,0 +1,250 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	cloudprovider "k8s.io/cloud-provider"
+	"k8s.io/klog/v2"
+)
+
+// NodeMatchStrategy determines how a node is matched to the machine backing it
+type NodeMatchStrategy string
+
+const (
+	// NodeMatchStrategyProviderID matches the machine referenced by the provider ID of the node, if it is set
+	NodeMatchStrategyProviderID NodeMatchStrategy = "providerID"
+	// NodeMatchStrategySlug matches the machine with a slug equal to the node name
+	NodeMatchStrategySlug NodeMatchStrategy = "slug"
+	// NodeMatchStrategyName matches the machine with a name equal to the node name, or its hostname if the node is named after its FQDN
+	NodeMatchStrategyName NodeMatchStrategy = "name"
+	// NodeMatchStrategyInternalIP matches the machine with an interface address equal to an internal IP of the node
+	NodeMatchStrategyInternalIP NodeMatchStrategy = "internalIP"
+	// NodeMatchStrategyMACAddress matches the machine with an interface MAC address equal to the MAC address annotation or label of the node
+	NodeMatchStrategyMACAddress NodeMatchStrategy = "macAddress"
+	// NodeMatchStrategyLabel matches the machine with a label value equal to the same label on the node, or the node name
+	NodeMatchStrategyLabel NodeMatchStrategy = "label"
+)
+
+const (
+	// DefaultNodeMatchLabel is the machine label used by the label strategy when none is configured
+	DefaultNodeMatchLabel = corev1.LabelHostname
+
+	// NodeAnnotationMACAddress is the MAC address of the primary interface of a node, used by the macAddress strategy.
+	// It can also be set as a label.
+	NodeAnnotationMACAddress = "k8s.thalassa.cloud/mac-address"
+)
+
+// Event reasons emitted by the instances implementation
+const (
+	EventReasonAmbiguousMachineMatch = "AmbiguousMachineMatch"
+)
+
+// DefaultNodeMatchStrategies returns the strategies used when the cloud config does not specify any
+func DefaultNodeMatchStrategies() []NodeMatchStrategy {
+	return []NodeMatchStrategy{
+		NodeMatchStrategyProviderID,
+		NodeMatchStrategySlug,
+		NodeMatchStrategyName,
+		NodeMatchStrategyInternalIP,
+		NodeMatchStrategyMACAddress,
+		NodeMatchStrategyLabel,
+	}
+}
+
+// ParseNodeMatchStrategy validates a node match strategy value.
+func ParseNodeMatchStrategy(value string) (NodeMatchStrategy, error) {
+	trimmed := strings.TrimSpace(value)
+	for _, strategy := range DefaultNodeMatchStrategies() {
+		if strings.EqualFold(trimmed, string(strategy)) {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid node match strategy: %s, must be one of: %s, %s, %s, %s, %s, %s", value, NodeMatchStrategyProviderID, NodeMatchStrategySlug, NodeMatchStrategyName, NodeMatchStrategyInternalIP, NodeMatchStrategyMACAddress, NodeMatchStrategyLabel)
+}
+
+// nodeMatchStrategies returns the configured strategies, or the defaults
+func (i *instancesV2) nodeMatchStrategies() []NodeMatchStrategy {
+	if i.config != nil && len(i.config.NodeMatchStrategies) > 0 {
+		return i.config.NodeMatchStrategies
+	}
+	return DefaultNodeMatchStrategies()
+}
+
+// nodeMatchLabel returns the configured machine label for the label strategy, or the default
+func (i *instancesV2) nodeMatchLabel() string {
+	if i.config != nil && i.config.NodeMatchLabel != "" {
+		return i.config.NodeMatchLabel
+	}
+	return DefaultNodeMatchLabel
+}
+
+// findVirtualMachine finds the machine of the node by trying the configured strategies in order. The first strategy
+// with a single match wins; a strategy matching several machines is reported and fails the lookup, so a node is never
+// initialized with the wrong machine. The machines are read from the machine index, which is refreshed once when
+// the node has no match, e.g. because its machine was created after the last refresh.
+func (i *instancesV2) findVirtualMachine(ctx context.Context, node *corev1.Node) (*iaas.Machine, error) {
+	strategies := i.nodeMatchStrategies()
+	for _, strategy := range strategies {
+		if strategy == NodeMatchStrategyProviderID && node.Spec.ProviderID != "" {
+			// a node with a provider ID is bound to its machine, the other strategies are not tried
+			return i.findVirtualMachineByProviderID(ctx, node)
+		}
+	}
+
+	snapshot, err := i.machineIndex.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	machine, strategy, matches := i.matchNode(strategies, node, snapshot)
+	if machine == nil {
+		refreshed, err := i.machineIndex.refreshOnMiss(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if refreshed != nil {
+			machine, strategy, matches = i.matchNode(strategies, node, refreshed)
+		}
+	}
+	if machine != nil {
+		klog.V(4).Infof("matched node %s to machine %s using the %s strategy", node.GetName(), machine.Identity, strategy)
+		return machine, nil
+	}
+	if len(matches) == 0 {
+		return nil, cloudprovider.InstanceNotFound
+	}
+
+	identities := make([]string, 0, len(matches))
+	for _, machine := range matches {
+		identities = append(identities, machine.Identity)
+	}
+	err = fmt.Errorf("node %s matches multiple machines using the %s strategy: %s", node.GetName(), strategy, strings.Join(identities, ", "))
+	klog.Warning(err)
+	if i.recorder != nil {
+		i.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonAmbiguousMachineMatch, "Node matches multiple machines using the %s strategy: %s", strategy, strings.Join(identities, ", "))
+	}
+	return nil, err
+}
+
+// matchNode tries the strategies in order against the snapshot. It returns the machine of the first strategy with a
+// single match, or the strategy and the machines of the first strategy with multiple matches.
+func (i *instancesV2) matchNode(strategies []NodeMatchStrategy, node *corev1.Node, snapshot *machineSnapshot) (*iaas.Machine, NodeMatchStrategy, []*iaas.Machine) {
+	for _, strategy := range strategies {
+		matches := i.matchMachines(strategy, node, snapshot)
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			machine := *matches[0]
+			return &machine, strategy, matches
+		default:
+			return nil, strategy, matches
+		}
+	}
+	return nil, "", nil
+}
+
+// findVirtualMachineByProviderID gets the machine referenced by the provider ID of the node
+func (i *instancesV2) findVirtualMachineByProviderID(ctx context.Context, node *corev1.Node) (*iaas.Machine, error) {
+	instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
+	if err != nil {
+		return nil, err
+	}
+	machine, err := i.iaasClient.GetMachine(ctx, instanceID)
+	if err != nil {
+		if thalassaclient.IsNotFound(err) {
+			return nil, cloudprovider.InstanceNotFound
+		}
+		return nil, fmt.Errorf("failed to get machine %s: %v", instanceID, err)
+	}
+	if machine == nil {
+		return nil, cloudprovider.InstanceNotFound
+	}
+	return machine, nil
+}
+
+// matchMachines returns the machines of the snapshot matching the node using the strategy
+func (i *instancesV2) matchMachines(strategy NodeMatchStrategy, node *corev1.Node, snapshot *machineSnapshot) []*iaas.Machine {
+	switch strategy {
+	case NodeMatchStrategyProviderID:
+		return nil
+	case NodeMatchStrategySlug:
+		return snapshot.bySlug[node.GetName()]
+	case NodeMatchStrategyInternalIP:
+		var matches []*iaas.Machine
+		seen := map[string]bool{}
+		for _, address := range node.Status.Addresses {
+			if address.Type != corev1.NodeInternalIP {
+				continue
+			}
+			for _, machine := range snapshot.byIP[address.Address] {
+				if !seen[machine.Identity] {
+					seen[machine.Identity] = true
+					matches = append(matches, machine)
+				}
+			}
+		}
+		return matches
+	}
+
+	var matches []*iaas.Machine
+	for idx := range snapshot.machines {
+		if i.machineMatches(strategy, node, &snapshot.machines[idx]) {
+			matches = append(matches, &snapshot.machines[idx])
+		}
+	}
+	return matches
+}
+
+// machineMatches reports if the machine matches the node using a strategy without index
+func (i *instancesV2) machineMatches(strategy NodeMatchStrategy, node *corev1.Node, machine *iaas.Machine) bool {
+	switch strategy {
+	case NodeMatchStrategyName:
+		if machine.Name == "" {
+			return false
+		}
+		hostname, _, _ := strings.Cut(node.GetName(), ".")
+		return strings.EqualFold(machine.Name, node.GetName()) || strings.EqualFold(machine.Name, hostname)
+	case NodeMatchStrategyMACAddress:
+		mac := nodeMACAddress(node)
+		if mac == "" {
+			return false
+		}
+		for _, iface := range machine.Interfaces {
+			if normalizeMACAddress(iface.MacAddress) == mac {
+				return true
+			}
+		}
+		return false
+	case NodeMatchStrategyLabel:
+		key := i.nodeMatchLabel()
+		value, ok := machine.Labels[key]
+		if !ok || value == "" {
+			return false
+		}
+		if nodeValue, ok := node.Labels[key]; ok {
+			return value == nodeValue
+		}
+		return value == node.GetName()
+	default:
+		return false
+	}
+}
+
+// nodeMACAddress returns the normalized MAC address of the node from its annotation or label
+func nodeMACAddress(node *corev1.Node) string {
+	if mac, ok := node.Annotations[NodeAnnotationMACAddress]; ok {
+		return normalizeMACAddress(mac)
+	}
+	return normalizeMACAddress(node.Labels[NodeAnnotationMACAddress])
+}
+
+// normalizeMACAddress lowercases a MAC address and uses colons as separator, labels do not allow colons
+func normalizeMACAddress(mac string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(mac)), "-", ":")
+}
@@ -0,0 +1,295 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	cloudprovider "k8s.io/cloud-provider"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInstancesV2(t *testing.T) (*instancesV2, *fakeIaas) {
+	cloud := newFakeIaas(t)
+	cloud.addMachine("vm-1", "worker-1", "10.0.0.11")
+	iaasClient := cloud.client()
+	return &instancesV2{
+		config:       &InstancesV2Config{ZoneAndRegionEnabled: true},
+		iaasClient:   iaasClient,
+		machineIndex: newMachineIndex(iaasClient, "vpc-test", 0),
+		cluster:      "cluster-test",
+		vpcIdentity:  "vpc-test",
+	}, cloud
+}
+
+func TestInstancesV2_InstanceMetadata(t *testing.T) {
+	instances, _ := newTestInstancesV2(t)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+
+	metadata, err := instances.InstanceMetadata(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, "thalassacloud://vm-1", metadata.ProviderID)
+	assert.Equal(t, "pgp-small", metadata.InstanceType)
+	assert.Equal(t, "nl-1", metadata.Region)
+	assert.Equal(t, "nl-1a", metadata.Zone)
+	assert.Equal(t, []corev1.NodeAddress{
+		{Type: corev1.NodeInternalIP, Address: "10.0.0.11"},
+		{Type: corev1.NodeHostName, Address: "worker-1"},
+	}, metadata.NodeAddresses)
+}
+
+func TestInstancesV2_InstanceMetadataNotFound(t *testing.T) {
+	instances, _ := newTestInstancesV2(t)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-9"}}
+
+	_, err := instances.InstanceMetadata(context.Background(), node)
+	assert.Equal(t, cloudprovider.InstanceNotFound, err)
+}
+
+func TestInstancesV2_InstanceMetadataFaults(t *testing.T) {
+	instances, cloud := newTestInstancesV2(t)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+
+	cloud.failRequests(http.MethodGet, iaas.MachineEndpoint, http.StatusBadGateway, 1)
+	_, err := instances.InstanceMetadata(context.Background(), node)
+	assert.Error(t, err)
+
+	_, err = instances.InstanceMetadata(context.Background(), node)
+	assert.NoError(t, err, "faults are transient")
+
+	// the region and zone references of the machine are used as is when the regions cannot be listed
+	instances.regionCatalog = nil
+	cloud.failRequests(http.MethodGet, iaas.RegionEndpoint, http.StatusInternalServerError, 1)
+	metadata, err := instances.InstanceMetadata(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, "nl-1", metadata.Region)
+	assert.Equal(t, "nl-1a", metadata.Zone)
+}
+
+func TestInstancesV2_InstanceExistsAndShutdown(t *testing.T) {
+	instances, cloud := newTestInstancesV2(t)
+	tests := []struct {
+		name             string
+		providerID       string
+		fail             bool
+		expectedExists   bool
+		expectedShutdown bool
+		expectError      bool
+	}{
+		{name: "running machine", providerID: "thalassacloud://vm-1", expectedExists: true, expectedShutdown: false},
+		{name: "deleted machine", providerID: "thalassacloud://vm-9", expectedExists: false, expectedShutdown: true},
+		{name: "api failure", providerID: "thalassacloud://vm-1", fail: true, expectError: true},
+		{name: "invalid provider id", providerID: "aws://i-123", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker"}, Spec: corev1.NodeSpec{ProviderID: tt.providerID}}
+
+			if tt.fail {
+				cloud.failRequests(http.MethodGet, iaas.MachineEndpoint+"/*", http.StatusInternalServerError, 1)
+			}
+			exists, err := instances.InstanceExists(context.Background(), node)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedExists, exists)
+			}
+
+			if tt.fail {
+				cloud.failRequests(http.MethodGet, iaas.MachineEndpoint+"/*", http.StatusInternalServerError, 1)
+			}
+			shutdown, err := instances.InstanceShutdown(context.Background(), node)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.expectedShutdown, shutdown)
+			}
+		})
+	}
+}
+
+func TestInstancesV2_InstanceShutdownState(t *testing.T) {
+	tests := []struct {
+		name             string
+		state            iaas.MachineState
+		status           string
+		expectedShutdown bool
+	}{
+		{name: "running", state: iaas.MachineStateRunning, status: "ready", expectedShutdown: false},
+		{name: "stopped", state: iaas.MachineStateStopped, status: "ready", expectedShutdown: true},
+		{name: "deleting", state: iaas.MachineStateDeleting, status: "deleting", expectedShutdown: true},
+		{name: "deleted", state: iaas.MachineStateDeleted, status: "deleted", expectedShutdown: true},
+		{name: "no state with deleted status", status: "deleted", expectedShutdown: true},
+		{name: "no state", status: "ready", expectedShutdown: false},
+		{name: "unknown state", state: "unknown", status: "unknown", expectedShutdown: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			api.EXPECT().GetMachine(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, identity string) (*iaas.Machine, error) {
+				return &iaas.Machine{Identity: identity, State: tt.state, Status: iaas.ResourceStatus{Status: tt.status}}, nil
+			}).AnyTimes()
+			instances := &instancesV2{iaasClient: api}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: "thalassacloud://vm-1"}}
+
+			shutdown, err := instances.InstanceShutdown(context.Background(), node)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedShutdown, shutdown)
+		})
+	}
+}
+
+func TestInstancesV2_FindVirtualMachine(t *testing.T) {
+	machines := []iaas.Machine{
+		{Identity: "vm-other-vpc", Slug: "worker-1", Vpc: &iaas.Vpc{Identity: "vpc-other"}},
+		{Identity: "vm-no-vpc", Slug: "worker-1"},
+		{
+			Identity: "vm-1", Slug: "worker-1", Name: "Worker-1", Vpc: &iaas.Vpc{Identity: "vpc-test"},
+			Interfaces: []iaas.VirtualMachineInterface{{Name: "default", MacAddress: "52:54:00:00:00:01", IPAddresses: []string{"10.0.0.11"}}},
+			Labels:     map[string]string{"kubernetes.io/hostname": "node-a"},
+		},
+		{
+			Identity: "vm-2", Slug: "worker-2", Name: "worker-2", Vpc: &iaas.Vpc{Identity: "vpc-test"},
+			Interfaces: []iaas.VirtualMachineInterface{{Name: "default", MacAddress: "52:54:00:00:00:02", IPAddresses: []string{"10.0.0.12"}}},
+		},
+		{Identity: "vm-3", Slug: "worker-3", Name: "shared", Vpc: &iaas.Vpc{Identity: "vpc-test"}},
+		{Identity: "vm-4", Slug: "worker-4", Name: "shared", Vpc: &iaas.Vpc{Identity: "vpc-test"}},
+	}
+	tests := []struct {
+		name        string
+		node        *corev1.Node
+		strategies  []NodeMatchStrategy
+		listErr     error
+		expected    string
+		expectedErr string
+	}{
+		{name: "slug", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}, expected: "vm-1"},
+		{name: "other machine", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}}, expected: "vm-2"},
+		{name: "name of a node named after its fqdn", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1.example.com"}}, expected: "vm-1"},
+		{
+			name:     "internal ip",
+			node:     &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "ip-10-0-0-12"}, Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeHostName, Address: "10.0.0.11"}, {Type: corev1.NodeInternalIP, Address: "10.0.0.12"}}}},
+			expected: "vm-2",
+		},
+		{
+			name:     "mac address annotation",
+			node:     &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Annotations: map[string]string{NodeAnnotationMACAddress: "52:54:00:00:00:02"}}},
+			expected: "vm-2",
+		},
+		{
+			name:     "mac address label",
+			node:     &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{NodeAnnotationMACAddress: "52-54-00-00-00-01"}}},
+			expected: "vm-1",
+		},
+		{name: "machine label matches the node name", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}, expected: "vm-1"},
+		{
+			name:        "machine label compared with the node label",
+			node:        &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"kubernetes.io/hostname": "node-x"}}},
+			expectedErr: cloudprovider.InstanceNotFound.Error(),
+		},
+		{
+			name:       "strategy order",
+			node:       &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.12"}}}},
+			strategies: []NodeMatchStrategy{NodeMatchStrategyInternalIP, NodeMatchStrategySlug},
+			expected:   "vm-2",
+		},
+		{
+			name:        "disabled strategy",
+			node:        &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}},
+			strategies:  []NodeMatchStrategy{NodeMatchStrategyInternalIP},
+			expectedErr: cloudprovider.InstanceNotFound.Error(),
+		},
+		{name: "ambiguous", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}, expectedErr: "node shared matches multiple machines using the name strategy: vm-3, vm-4"},
+		{name: "unknown node", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-9"}}, expectedErr: cloudprovider.InstanceNotFound.Error()},
+		{name: "list fails", node: &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}, listErr: fmt.Errorf("unavailable"), expectedErr: "failed to list machines: unavailable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			api.EXPECT().ListMachines(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, listRequest *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+				require.Len(t, listRequest.Filters, 1)
+				return machines, tt.listErr
+			}).MaxTimes(1) // machines are listed once for all strategies
+			recorder := record.NewFakeRecorder(10)
+			instances := &instancesV2{
+				iaasClient:   api,
+				machineIndex: newMachineIndex(api, "vpc-test", 0),
+				vpcIdentity:  "vpc-test",
+				config:       &InstancesV2Config{NodeMatchStrategies: tt.strategies},
+				recorder:     recorder,
+			}
+
+			machine, err := instances.findVirtualMachine(context.Background(), tt.node)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, machine.Identity)
+		})
+	}
+}
+
+func TestInstancesV2_FindVirtualMachineAmbiguousEvent(t *testing.T) {
+	api := newMockCloudAPI(t)
+	api.EXPECT().ListMachines(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+		return []iaas.Machine{
+			{Identity: "vm-1", Slug: "worker", Vpc: &iaas.Vpc{Identity: "vpc-test"}},
+			{Identity: "vm-2", Slug: "worker", Vpc: &iaas.Vpc{Identity: "vpc-test"}},
+		}, nil
+	}).AnyTimes()
+	recorder := record.NewFakeRecorder(10)
+	instances := &instancesV2{iaasClient: api, machineIndex: newMachineIndex(api, "vpc-test", 0), vpcIdentity: "vpc-test", config: &InstancesV2Config{}, recorder: recorder}
+
+	_, err := instances.findVirtualMachine(context.Background(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker"}})
+	require.Error(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Warning AmbiguousMachineMatch Node matches multiple machines using the slug strategy: vm-1, vm-2", <-recorder.Events)
+}
+
+func TestInstancesV2_FindVirtualMachineByProviderID(t *testing.T) {
+	tests := []struct {
+		name        string
+		providerID  string
+		getErr      error
+		expectedErr string
+	}{
+		{name: "existing machine", providerID: "thalassacloud://vm-1"},
+		{name: "deleted machine", providerID: "thalassacloud://vm-9", getErr: thalassaclient.ErrNotFound, expectedErr: cloudprovider.InstanceNotFound.Error()},
+		{name: "api failure", providerID: "thalassacloud://vm-1", getErr: fmt.Errorf("unavailable"), expectedErr: "failed to get machine vm-1: unavailable"},
+		{name: "invalid provider id", providerID: "aws://i-123", expectedErr: `mismatched ProviderID "aws://i-123" didn't match expected format "thalassacloud://<instance-id>"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a node with a provider ID is not matched by the other strategies, so ListMachines is not expected
+			api := newMockCloudAPI(t)
+			api.EXPECT().GetMachine(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, identity string) (*iaas.Machine, error) {
+				if tt.getErr != nil {
+					return nil, tt.getErr
+				}
+				return &iaas.Machine{Identity: identity}, nil
+			}).AnyTimes()
+			instances := &instancesV2{iaasClient: api, machineIndex: newMachineIndex(api, "vpc-test", 0), vpcIdentity: "vpc-test", config: &InstancesV2Config{}}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: tt.providerID}}
+
+			machine, err := instances.findVirtualMachine(context.Background(), node)
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "vm-1", machine.Identity)
+		})
+	}
+}
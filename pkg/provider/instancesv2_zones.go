@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Event reasons emitted for inconsistent region and zone data
+const (
+	EventReasonInconsistentRegion = "InconsistentRegion"
+)
+
+// regionCatalogRetryInterval is the time after a failed listing of the regions before the regions are listed again
+const regionCatalogRetryInterval = time.Minute
+
+// regionCatalog normalizes the region and zone references of machines, which can be an identity, slug or name, to
+// the slugs used in the topology labels of nodes
+type regionCatalog struct {
+	// regions maps the lowercase identity, slug and name of each region to its slug
+	regions map[string]string
+	// zones maps the lowercase identity, slug and name of each zone to the zone
+	zones map[string]catalogZone
+}
+
+// catalogZone is a zone slug and the slug of its region
+type catalogZone struct {
+	slug   string
+	region string
+}
+
+func newRegionCatalog(regions []iaas.Region) *regionCatalog {
+	catalog := &regionCatalog{
+		regions: map[string]string{},
+		zones:   map[string]catalogZone{},
+	}
+	for _, region := range regions {
+		for _, key := range []string{region.Identity, region.Slug, region.Name} {
+			if key != "" {
+				catalog.regions[strings.ToLower(key)] = region.Slug
+			}
+		}
+		for _, zone := range region.Zones {
+			for _, key := range []string{zone.Identity, zone.Slug, zone.Name} {
+				if key != "" {
+					catalog.zones[strings.ToLower(key)] = catalogZone{slug: zone.Slug, region: region.Slug}
+				}
+			}
+		}
+	}
+	return catalog
+}
+
+// empty returns true for the catalog used while the regions cannot be listed, whose references cannot be compared
+func (c *regionCatalog) empty() bool {
+	return len(c.regions) == 0
+}
+
+// region returns the slug of the referenced region, or the reference itself if the region is unknown
+func (c *regionCatalog) region(reference string) string {
+	if slug, ok := c.regions[strings.ToLower(reference)]; ok {
+		return slug
+	}
+	klog.Warningf("region %q is not a known region, using it as is", reference)
+	return reference
+}
+
+// zone returns the slug of the referenced zone and the slug of its region, or the reference itself and an empty
+// region if the zone is unknown
+func (c *regionCatalog) zone(reference string) (string, string) {
+	if zone, ok := c.zones[strings.ToLower(reference)]; ok {
+		return zone.slug, zone.region
+	}
+	klog.Warningf("zone %q is not a known zone, using it as is", reference)
+	return reference, ""
+}
+
+// getRegionCatalog returns the regions and zones, listed on the first lookup. If the regions cannot be listed, the
+// region and zone references of machines are used as is, and the regions are listed again after
+// regionCatalogRetryInterval.
+func (i *instancesV2) getRegionCatalog(ctx context.Context) *regionCatalog {
+	i.cacheMu.Lock()
+	defer i.cacheMu.Unlock()
+	if i.regionCatalog != nil && (i.regionCatalogRetry.IsZero() || time.Now().Before(i.regionCatalogRetry)) {
+		return i.regionCatalog
+	}
+	regions, err := i.iaasClient.ListRegions(ctx, &iaas.ListRegionsRequest{})
+	if err != nil {
+		klog.Warningf("failed to list regions, using the region and zone of machines as is: %v", err)
+		if i.regionCatalog == nil {
+			i.regionCatalog = newRegionCatalog(nil)
+		}
+		i.regionCatalogRetry = time.Now().Add(regionCatalogRetryInterval)
+		return i.regionCatalog
+	}
+	i.regionCatalog = newRegionCatalog(regions)
+	i.regionCatalogRetry = time.Time{}
+	return i.regionCatalog
+}
+
+// getRegionAndZone returns the region and zone of the machine. The region of the machine is preferred, the region of
+// the VPC is used for machines without region. A machine in another region than its VPC, or with a zone outside its
+// region, is flagged with an event on the node.
+func (i *instancesV2) getRegionAndZone(ctx context.Context, node *corev1.Node, machine *iaas.Machine) (string, string, error) {
+	catalog := i.getRegionCatalog(ctx)
+
+	machineRegion := ""
+	if machine.Region != nil && *machine.Region != "" {
+		machineRegion = catalog.region(*machine.Region)
+	}
+	vpcRegion, err := i.getVpcRegion(ctx)
+	if err != nil {
+		if machineRegion == "" {
+			return "", "", err
+		}
+		// the VPC region is only needed for the consistency check
+		klog.Warningf("failed to get the region of vpc %s to check the region of machine %s: %v", i.vpcIdentity, machine.Identity, err)
+	}
+	if vpcRegion != "" {
+		vpcRegion = catalog.region(vpcRegion)
+	}
+
+	region := machineRegion
+	if region == "" {
+		region = vpcRegion
+	} else if vpcRegion != "" && region != vpcRegion && !catalog.empty() {
+		i.flagInconsistentRegion(node, "Machine %s is in region %s, but its VPC %s is in region %s", machine.Identity, region, i.vpcIdentity, vpcRegion)
+	}
+
+	zone := ""
+	if machine.AvailabilityZone != nil && *machine.AvailabilityZone != "" {
+		var zoneRegion string
+		zone, zoneRegion = catalog.zone(*machine.AvailabilityZone)
+		if zoneRegion != "" && zoneRegion != region {
+			i.flagInconsistentRegion(node, "Machine %s is in zone %s of region %s, but in region %s", machine.Identity, zone, zoneRegion, region)
+		}
+	}
+	return region, zone, nil
+}
+
+func (i *instancesV2) flagInconsistentRegion(node *corev1.Node, messageFmt string, args ...interface{}) {
+	klog.Warningf("node %s: %s", node.GetName(), fmt.Sprintf(messageFmt, args...))
+	if i.recorder != nil {
+		i.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonInconsistentRegion, messageFmt, args...)
+	}
+}
@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstancesV2_GetRegionAndZone(t *testing.T) {
+	regions := []iaas.Region{
+		{Identity: "region-nl-1", Name: "NL 1", Slug: "nl-1", Zones: []iaas.Zone{
+			{Identity: "zone-nl-1a", Name: "NL 1a", Slug: "nl-1a"},
+			{Identity: "zone-nl-1b", Name: "NL 1b", Slug: "nl-1b"},
+		}},
+		{Identity: "region-nl-2", Name: "NL 2", Slug: "nl-2", Zones: []iaas.Zone{
+			{Identity: "zone-nl-2a", Name: "NL 2a", Slug: "nl-2a"},
+		}},
+	}
+	tests := []struct {
+		name             string
+		machineRegion    *string
+		machineZone      *string
+		vpcRegion        *iaas.Region
+		vpcErr           error
+		regionsErr       error
+		expectedRegion   string
+		expectedZone     string
+		expectedEvents   int
+		expectedErrorMsg string
+	}{
+		{name: "machine region and zone slugs", machineRegion: ptr.To("nl-1"), machineZone: ptr.To("nl-1b"), expectedRegion: "nl-1", expectedZone: "nl-1b"},
+		{name: "machine region and zone identities", machineRegion: ptr.To("region-nl-1"), machineZone: ptr.To("zone-nl-1a"), expectedRegion: "nl-1", expectedZone: "nl-1a"},
+		{name: "zone name", machineZone: ptr.To("NL 1a"), expectedRegion: "nl-1", expectedZone: "nl-1a"},
+		{name: "vpc region fallback", expectedRegion: "nl-1"},
+		{name: "empty machine region", machineRegion: ptr.To(""), expectedRegion: "nl-1"},
+		{name: "unknown zone is used as is", machineZone: ptr.To("nl-1z"), expectedRegion: "nl-1", expectedZone: "nl-1z"},
+		{name: "machine region differs from vpc", machineRegion: ptr.To("nl-2"), machineZone: ptr.To("nl-2a"), expectedRegion: "nl-2", expectedZone: "nl-2a", expectedEvents: 1},
+		{name: "zone outside the region", machineRegion: ptr.To("nl-1"), machineZone: ptr.To("nl-2a"), expectedRegion: "nl-1", expectedZone: "nl-2a", expectedEvents: 1},
+		{name: "vpc failure with machine region", machineRegion: ptr.To("nl-1"), vpcErr: fmt.Errorf("unavailable"), expectedRegion: "nl-1"},
+		{name: "vpc failure without machine region", vpcErr: fmt.Errorf("unavailable"), expectedErrorMsg: "failed to get vpc vpc-test: unavailable"},
+		{name: "vpc without region", vpcRegion: &iaas.Region{}, expectedErrorMsg: "vpc vpc-test has no region"},
+		{name: "regions failure uses the references as is", machineRegion: ptr.To("region-nl-1"), machineZone: ptr.To("zone-nl-1a"), regionsErr: fmt.Errorf("unavailable"), expectedRegion: "region-nl-1", expectedZone: "zone-nl-1a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			api.EXPECT().ListRegions(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListRegionsRequest) ([]iaas.Region, error) {
+				return regions, tt.regionsErr
+			}).AnyTimes()
+			api.EXPECT().GetVpc(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, identity string) (*iaas.Vpc, error) {
+				if tt.vpcErr != nil {
+					return nil, tt.vpcErr
+				}
+				vpc := &iaas.Vpc{Identity: identity, CloudRegion: &iaas.Region{Identity: "region-nl-1", Slug: "nl-1"}}
+				if tt.vpcRegion != nil {
+					vpc.CloudRegion = nil
+					if tt.vpcRegion.Slug != "" {
+						vpc.CloudRegion = tt.vpcRegion
+					}
+				}
+				return vpc, nil
+			}).AnyTimes()
+			recorder := record.NewFakeRecorder(10)
+			instances := &instancesV2{iaasClient: api, vpcIdentity: "vpc-test", config: &InstancesV2Config{ZoneAndRegionEnabled: true}, recorder: recorder}
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+			machine := &iaas.Machine{Identity: "vm-1", Region: tt.machineRegion, AvailabilityZone: tt.machineZone}
+
+			region, zone, err := instances.getRegionAndZone(context.Background(), node, machine)
+			if tt.expectedErrorMsg != "" {
+				assert.EqualError(t, err, tt.expectedErrorMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedRegion, region)
+			assert.Equal(t, tt.expectedZone, zone)
+			assert.Len(t, recorder.Events, tt.expectedEvents)
+		})
+	}
+}
+
+func TestInstancesV2_GetRegionAndZoneRetriesRegions(t *testing.T) {
+	api := newMockCloudAPI(t)
+	gomock.InOrder(
+		api.EXPECT().ListRegions(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("unavailable")),
+		api.EXPECT().ListRegions(gomock.Any(), gomock.Any()).Return([]iaas.Region{
+			{Identity: "region-nl-1", Slug: "nl-1", Zones: []iaas.Zone{{Identity: "zone-nl-1a", Slug: "nl-1a"}}},
+		}, nil),
+	)
+	api.EXPECT().GetVpc(gomock.Any(), gomock.Any()).Return(&iaas.Vpc{Identity: "vpc-test", CloudRegion: &iaas.Region{Identity: "region-nl-1", Slug: "nl-1"}}, nil)
+	instances := &instancesV2{iaasClient: api, vpcIdentity: "vpc-test", config: &InstancesV2Config{ZoneAndRegionEnabled: true}}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}}
+	machine := &iaas.Machine{Identity: "vm-1", Region: ptr.To("nl-1"), AvailabilityZone: ptr.To("zone-nl-1a")}
+
+	region, zone, err := instances.getRegionAndZone(context.Background(), node, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "nl-1", region)
+	assert.Equal(t, "zone-nl-1a", zone, "the zone is used as is while the regions cannot be listed")
+
+	// the regions are not listed again before the retry interval has passed
+	_, zone, err = instances.getRegionAndZone(context.Background(), node, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "zone-nl-1a", zone)
+
+	instances.regionCatalogRetry = time.Now().Add(-time.Second)
+	_, zone, err = instances.getRegionAndZone(context.Background(), node, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "nl-1a", zone)
+
+	// the listed regions are kept
+	_, zone, err = instances.getRegionAndZone(context.Background(), node, machine)
+	require.NoError(t, err)
+	assert.Equal(t, "nl-1a", zone)
+}
+
+func TestInstancesV2_InstanceMetadataZoneAndRegionDisabled(t *testing.T) {
+	instances, cloud := newTestInstancesV2(t)
+	instances.config.ZoneAndRegionEnabled = false
+	// the region and zone are not read at all
+	cloud.failRequests(http.MethodGet, iaas.VpcEndpoint+"/*", http.StatusInternalServerError, -1)
+	cloud.failRequests(http.MethodGet, iaas.RegionEndpoint, http.StatusInternalServerError, -1)
+
+	metadata, err := instances.InstanceMetadata(context.Background(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}})
+	require.NoError(t, err)
+	assert.Empty(t, metadata.Region)
+	assert.Empty(t, metadata.Zone)
+	assert.Equal(t, "thalassacloud://vm-1", metadata.ProviderID)
+}
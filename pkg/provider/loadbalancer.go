@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"net"
@@ -13,9 +14,13 @@ import (
 	"github.com/thalassa-cloud/client-go/filters"
 	"github.com/thalassa-cloud/client-go/iaas"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 	cloudprovider "k8s.io/cloud-provider"
 	"k8s.io/klog/v2"
@@ -30,16 +35,24 @@ const (
 
 	// Default timeout between polling the service after creation
 	defaultLoadBalancerCreatePollTimeout = 5 * time.Minute
+
+	// toBeDeletedTaint is the taint of the cluster autoscaler on nodes it is about to delete, which the service
+	// controller excludes from load balancers
+	toBeDeletedTaint = "ToBeDeletedByClusterAutoscaler"
 )
 
 // endpointSliceResyncInterval is a safety-net reconciliation interval.
 var endpointSliceResyncInterval = 2 * time.Minute
 
+// defaultServiceResyncWorkers is the default number of goroutines processing the service resync queue.
+// The queue never hands out the same key to two workers at the same time.
+const defaultServiceResyncWorkers = 1
+
 // loadbalancer represents a load balancer configuration and its associated resources.
 // It includes the namespace, client, configuration, and infrastructure labels.
 // Additionally, it holds information about the tenant VPC name and external network details.
 type loadbalancer struct {
-	iaasClient *iaas.Client
+	iaasClient CloudAPI
 
 	config           LoadBalancerConfig
 	additionalLabels map[string]string
@@ -48,11 +61,33 @@ type loadbalancer struct {
 	defaultSubnet string
 	cluster       string
 
-	endpointSlicesClient clientset.Interface
 	endpointSliceWatcher *EndpointSliceWatcher
 
+	// kubeClient patches Services of the configured load balancer class, may be nil
+	kubeClient clientset.Interface
+
+	// Listers backed by the shared informer factory
+	informerFactory informers.SharedInformerFactory
+	serviceLister   corelisters.ServiceLister
+	nodeLister      corelisters.NodeLister
+
 	nodeFilter *NodeFilter
 
+	// machineIndex caches the machines labeled for target selectors, shared with the instances if they are enabled
+	machineIndex *machineIndex
+
+	// recorder emits Events on Services, may be nil
+	recorder record.EventRecorder
+
+	// serviceLocks serializes reconciles per Service across all entry points
+	serviceLocks keyedMutex
+	// sharedGroupLocks serializes reconciles of the Services sharing a load balancer, keyed by shared group
+	sharedGroupLocks keyedMutex
+
+	// pausedServices holds the keys of Services whose reconciliation is paused by annotation
+	pausedMu       sync.Mutex
+	pausedServices map[string]struct{}
+
 	// Queue for handling service resync requests
 	serviceQueue workqueue.TypedRateLimitingInterface[string]
 
@@ -63,7 +98,7 @@ type loadbalancer struct {
 
 // GetLoadBalancer returns the load balancerstatus for the specified service.
 func (lb *loadbalancer) GetLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service) (*corev1.LoadBalancerStatus, bool, error) {
-	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(ctx, clusterName, service)
+	vpcLoadbalancer, err := lb.fetchOwnedVpcLoadbalancer(ctx, clusterName, service)
 	if err != nil {
 		klog.Errorf("failed to get LoadBalancer for service: %v", err)
 		return nil, false, err
@@ -72,20 +107,12 @@ func (lb *loadbalancer) GetLoadBalancer(ctx context.Context, clusterName string,
 		return nil, false, nil
 	}
 
-	loadbalancerStatus := &corev1.LoadBalancerStatus{
-		Ingress: []corev1.LoadBalancerIngress{},
-	}
-
-	for _, ip := range vpcLoadbalancer.ExternalIpAddresses {
-		if ip != "" {
-			loadbalancerStatus.Ingress = append(loadbalancerStatus.Ingress, corev1.LoadBalancerIngress{
-				IP:       ip,
-				Hostname: vpcLoadbalancer.Hostname,
-				IPMode:   ptr.To(corev1.LoadBalancerIPModeProxy),
-			})
-		}
+	companion, err := lb.fetchInternalCompanion(ctx, service)
+	if err != nil {
+		klog.Errorf("failed to get internal LoadBalancer for service: %v", err)
+		return nil, false, err
 	}
-	return loadbalancerStatus, true, nil
+	return loadBalancerStatusFor(vpcLoadbalancer, companion), true, nil
 }
 
 // GetLoadBalancerName returns the name of the load balancer for the specified service.
@@ -99,6 +126,20 @@ func (lb *loadbalancer) GetLoadBalancerName(ctx context.Context, clusterName str
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (lb *loadbalancer) EnsureLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) (*corev1.LoadBalancerStatus, error) {
 	klog.Infof("EnsureLoadBalancer for service %s", service.GetName())
+	if !lb.shouldHandleService(service) {
+		return nil, cloudprovider.ImplementedElsewhere
+	}
+	unlock := lb.lockService(service)
+	defer unlock()
+	unlockGroup := lb.lockSharedGroup(service)
+	defer unlockGroup()
+
+	if lb.checkReconcilePaused(service) {
+		return lb.getPausedLoadBalancerStatus(ctx, clusterName, service)
+	}
+	if err := lb.checkTargetSelectorService(service); err != nil {
+		return nil, err
+	}
 
 	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(ctx, clusterName, service)
 	if err != nil {
@@ -112,13 +153,23 @@ func (lb *loadbalancer) EnsureLoadBalancer(ctx context.Context, clusterName stri
 	}
 
 	if vpcLoadbalancer != nil {
+		if err := lb.ensureAdopted(ctx, service, vpcLoadbalancer); err != nil {
+			return nil, err
+		}
 		klog.Infof("LoadBalancer service %s already exists, updating existing listener and target groups", vpcLoadbalancer.Identity)
 		return lb.updateVpcLoadbalancerListenersAndTargetGroups(ctx, clusterName, service, nodes, vpcLoadbalancer)
 	}
 
+	if identity := lb.getLoadBalancerIDForService(service); identity != "" {
+		return nil, fmt.Errorf("loadbalancer %s referenced by service %s does not exist", identity, getServiceKey(service))
+	}
+
 	klog.Infof("LoadBalancer service %s does not exist, creating new one", service.GetName())
 
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
+	if group := lb.getSharedGroupForService(service); group != "" {
+		lbName = lb.getSharedLoadBalancerName(group)
+	}
 	vpcLoadbalancer, err = lb.createVpcLoadbalancer(ctx, lbName, service)
 	if err != nil {
 		klog.Errorf("failed to create LoadBalancer service: %v", err)
@@ -156,19 +207,11 @@ func (lb *loadbalancer) EnsureLoadBalancer(ctx context.Context, clusterName stri
 
 	klog.Infof("LoadBalancer %q for service %q is ready", vpcLoadbalancer.Identity, service.GetName())
 
-	loadbalancerStatus := &corev1.LoadBalancerStatus{
-		Ingress: []corev1.LoadBalancerIngress{},
-	}
-	for _, ip := range vpcLoadbalancer.ExternalIpAddresses {
-		if ip != "" {
-			loadbalancerStatus.Ingress = append(loadbalancerStatus.Ingress, corev1.LoadBalancerIngress{
-				IP:       ip,
-				Hostname: vpcLoadbalancer.Hostname,
-				IPMode:   ptr.To(corev1.LoadBalancerIPModeProxy),
-			})
-		}
+	companion, err := lb.fetchInternalCompanion(ctx, service)
+	if err != nil {
+		return nil, err
 	}
-	return loadbalancerStatus, nil
+	return loadBalancerStatusFor(vpcLoadbalancer, companion), nil
 }
 
 // UpdateLoadBalancer updates the ports in the LoadBalancer Service, if needed
@@ -177,6 +220,22 @@ func (lb *loadbalancer) EnsureLoadBalancer(ctx context.Context, clusterName stri
 // Parameter 'clusterName' is the name of the cluster as presented to kube-controller-manager
 func (lb *loadbalancer) UpdateLoadBalancer(ctx context.Context, clusterName string, service *corev1.Service, nodes []*corev1.Node) error {
 	klog.Infof("UpdateLoadBalancer for service %s", service.GetName())
+	if !lb.shouldHandleService(service) {
+		return cloudprovider.ImplementedElsewhere
+	}
+	unlock := lb.lockService(service)
+	defer unlock()
+	unlockGroup := lb.lockSharedGroup(service)
+	defer unlockGroup()
+
+	if lb.checkReconcilePaused(service) {
+		klog.V(2).Infof("Reconciliation of service %s is paused, skipping update", getServiceKey(service))
+		return nil
+	}
+	if err := lb.checkTargetSelectorService(service); err != nil {
+		return err
+	}
+
 	lbService, err := lb.fetchVpcLoadbalancerFromCloud(ctx, clusterName, service)
 	if err != nil {
 		return fmt.Errorf("failed to get LoadBalancer service: %v", err)
@@ -184,6 +243,9 @@ func (lb *loadbalancer) UpdateLoadBalancer(ctx context.Context, clusterName stri
 	if lbService == nil {
 		return fmt.Errorf("LoadBalancer not found in Cloud API for service %s", service.GetName())
 	}
+	if err := lb.ensureAdopted(ctx, service, lbService); err != nil {
+		return err
+	}
 
 	nodes, err = lb.nodeFilter.Filter(ctx, service, nodes)
 	if err != nil {
@@ -196,15 +258,60 @@ func (lb *loadbalancer) UpdateLoadBalancer(ctx context.Context, clusterName stri
 	return nil
 }
 
+// EnsureLoadBalancerDeleted deletes the load balancer of the Service, if it exists.
+// It is not filtered by load balancer class: the class is cleared when the type of a Service changes, and only load
+// balancers created for the Service are found.
 func (lb *loadbalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterName string, service *corev1.Service) error {
 	klog.Infof("EnsureLoadBalancerDeleted for service %s", service.GetName())
-	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(ctx, clusterName, service)
+	unlock := lb.lockService(service)
+	defer unlock()
+	unlockGroup := lb.lockSharedGroup(service)
+	defer unlockGroup()
+
+	// keep the finalizer, and with it the load balancer, until reconciliation is resumed
+	if lb.checkReconcilePaused(service) {
+		return fmt.Errorf("reconciliation of service %s is paused, not deleting its load balancer", getServiceKey(service))
+	}
+
+	vpcLoadbalancer, err := lb.fetchOwnedVpcLoadbalancer(ctx, clusterName, service)
 	if err != nil {
 		klog.Errorf("Failed to get LoadBalancer service: %v", err)
 		return err
 	}
-	if vpcLoadbalancer != nil {
-
+	// the load balancer of an interrupted recreate is deleted with the replacement
+	if vpcLoadbalancer != nil && isReplacedVpcLoadbalancer(*vpcLoadbalancer) {
+		vpcLoadbalancer = nil
+	}
+	if vpcLoadbalancer != nil && lb.getSharedGroupForService(service) != "" {
+		inUse, err := lb.releaseSharedVpcLoadbalancer(ctx, service, vpcLoadbalancer)
+		if err != nil {
+			klog.Errorf("Failed to remove service from shared LoadBalancer: %v", err)
+			return err
+		}
+		if inUse {
+			vpcLoadbalancer = nil
+		}
+	}
+	retain := lb.getDeletionPolicy(service) == DeletionPolicyRetain
+	if vpcLoadbalancer != nil && !retain && vpcLoadbalancer.DeleteProtection {
+		lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerDeleteProtected, "Load balancer %s has delete protection enabled, disable it or set the %s annotation to %s", vpcLoadbalancer.Identity, LoadBalancerAnnotationDeletionPolicy, DeletionPolicyRetain)
+		return fmt.Errorf("loadbalancer %s of service %s has delete protection enabled", vpcLoadbalancer.Identity, getServiceKey(service))
+	}
+	// the internal companion forwards to the target groups of the load balancer and is never retained
+	if err := lb.deleteInternalCompanionOfService(ctx, service); err != nil {
+		klog.Errorf("Failed to delete internal LoadBalancer: %v", err)
+		return err
+	}
+	if err := lb.deleteVpcLoadbalancersWithRoles(ctx, service, LoadBalancerRoleReplacement, LoadBalancerRoleReplaced); err != nil {
+		klog.Errorf("Failed to delete LoadBalancers of interrupted recreate: %v", err)
+		return err
+	}
+	if vpcLoadbalancer != nil && retain {
+		if err := lb.retainVpcLoadbalancer(ctx, service, vpcLoadbalancer); err != nil {
+			klog.Errorf("Failed to retain LoadBalancer service: %v", err)
+			return err
+		}
+	} else if vpcLoadbalancer != nil {
 		// make sure we delete all target groups first
 		if err = lb.cleanupUnusedTargetGroups(ctx, service, vpcLoadbalancer, nil); err != nil {
 			klog.Errorf("Failed to cleanup unused target groups: %v", err)
@@ -231,8 +338,9 @@ func (lb *loadbalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterNa
 			klog.Errorf("Failed to wait for LoadBalancer service to be deleted: %v", err)
 			return err
 		}
-
-		// list all target groups and delete them
+	}
+	if !retain {
+		// list all target groups and delete them, also when the load balancer was deleted by an earlier attempt
 		targetGroups, err := lb.iaasClient.ListTargetGroups(ctx, &iaas.ListTargetGroupsRequest{
 			Filters: []filters.Filter{
 				&filters.LabelFilter{
@@ -257,14 +365,25 @@ func (lb *loadbalancer) EnsureLoadBalancerDeleted(ctx context.Context, clusterNa
 				return err
 			}
 		}
-
-		// delete managed security group if it exists
-		lb.deleteManagedSecurityGroup(ctx, service)
 	}
+	// delete managed security group if it exists, also when an earlier attempt detached it but failed to delete it
+	if err := lb.deleteManagedSecurityGroup(ctx, service); err != nil {
+		klog.Errorf("Failed to delete managed security group: %v", err)
+		return err
+	}
+	lb.nodeFilter.forget(getServiceKey(service))
+	lb.forgetReconcilePaused(getServiceKey(service))
 	return nil
 }
 
 func (lb *loadbalancer) fetchVpcLoadbalancerFromCloud(ctx context.Context, clusterName string, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
+	if identity := lb.getLoadBalancerIDForService(service); identity != "" {
+		if lb.getSharedGroupForService(service) != "" {
+			return nil, fmt.Errorf("the %s and %s annotations of service %s cannot be combined", LoadBalancerAnnotationID, LoadBalancerAnnotationSharedGroup, getServiceKey(service))
+		}
+		return lb.fetchAdoptedVpcLoadbalancer(ctx, identity, service)
+	}
+
 	loadbalancersInVpc, err := lb.iaasClient.ListLoadbalancers(ctx, &iaas.ListLoadbalancersRequest{
 		Filters: []filters.Filter{
 			&filters.FilterKeyValue{
@@ -286,14 +405,41 @@ func (lb *loadbalancer) fetchVpcLoadbalancerFromCloud(ctx context.Context, clust
 		return nil, nil
 	}
 
-	labels := lb.GetLabelsForVpcLoadbalancer(service)
+	labels := lb.getLabelsForVpcLoadbalancerOfService(service)
+	var matching, replaced *iaas.VpcLoadbalancer
 	for _, loadbalancer := range loadbalancersInVpc {
+		if isReplacedVpcLoadbalancer(loadbalancer) && replaced == nil && matchLabels(labels, loadbalancer.Labels) {
+			replaced = &loadbalancer
+		}
+		if hasLoadBalancerRole(loadbalancer) {
+			continue
+		}
 		if !matchLabels(labels, loadbalancer.Labels) {
 			klog.V(6).Infof("loadbalancer %q has different labels than expected, skipping (expected: %v, actual: %v)", loadbalancer.Identity, labels, loadbalancer.Labels)
 			continue
 		}
-		klog.V(4).Infof("loadbalancer %q has matching labels, returning", loadbalancer.Identity)
-		return &loadbalancer, nil
+		// an interrupted recreate can leave the old load balancer matching as well, prefer the recreated one
+		if !lb.needsRecreate(service, &loadbalancer) {
+			klog.V(4).Infof("loadbalancer %q has matching labels, returning", loadbalancer.Identity)
+			return &loadbalancer, nil
+		}
+		if matching == nil {
+			matching = &loadbalancer
+		}
+	}
+	if matching != nil {
+		klog.V(4).Infof("loadbalancer %q has matching labels and is to be recreated, returning", matching.Identity)
+		return matching, nil
+	}
+	// a recreate interrupted before the replacement was promoted continues with the replaced load balancer
+	if replaced != nil {
+		klog.V(4).Infof("loadbalancer %q was replaced by an interrupted recreate, returning", replaced.Identity)
+		return replaced, nil
+	}
+
+	if lb.getSharedGroupForService(service) != "" {
+		klog.V(4).Infof("no shared loadbalancer found in vpc %q with matching labels", lb.vpcIdentity)
+		return nil, nil
 	}
 
 	klog.V(4).Infof("warning: no loadbalancer found in vpc %q with matching labels, trying to find by name", lb.vpcIdentity)
@@ -301,7 +447,7 @@ func (lb *loadbalancer) fetchVpcLoadbalancerFromCloud(ctx context.Context, clust
 	// fallback to use name?
 	lbName := lb.GetLoadBalancerName(ctx, clusterName, service)
 	for _, loadbalancer := range loadbalancersInVpc {
-		if loadbalancer.Name == lbName {
+		if loadbalancer.Name == lbName && !isRetainedVpcLoadbalancer(loadbalancer) && !hasLoadBalancerRole(loadbalancer) {
 			klog.V(4).Infof("loadbalancer %q has matching name, returning", loadbalancer.Identity)
 			return &loadbalancer, nil
 		}
@@ -327,42 +473,39 @@ func (lb *loadbalancer) getSubnetIdentityForService(service *corev1.Service) str
 }
 
 func (lb *loadbalancer) createVpcLoadbalancer(ctx context.Context, lbName string, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
-	// find the vpc
-	vpc, err := lb.iaasClient.GetVpc(ctx, lb.vpcIdentity)
+	createLB, err := lb.getCreateVpcLoadbalancerRequest(ctx, lbName, service)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get vpc: %v", err)
+		return nil, err
 	}
-
-	if len(vpc.Subnets) == 0 {
-		return nil, fmt.Errorf("vpc %s has no subnets", lb.vpcIdentity)
+	created, err := lb.iaasClient.CreateLoadbalancer(ctx, *createLB)
+	if err != nil {
+		klog.Errorf("Failed to create vpc loadbalancer %s: %v", lbName, err)
+		return nil, err
 	}
 
-	var vpcSubnet *iaas.Subnet
-	requestedSubnetIdentity := lb.getSubnetIdentityForService(service)
-	if requestedSubnetIdentity != "" {
-		for _, subnet := range vpc.Subnets {
-			if subnet.Identity == requestedSubnetIdentity || subnet.Slug == requestedSubnetIdentity {
-				vpcSubnet = &subnet
-				break
-			}
-		}
-	} else {
-		if len(vpc.Subnets) == 0 {
-			return nil, fmt.Errorf("vpc %s has no subnets", lb.vpcIdentity)
-		}
-		vpcSubnet = ptr.To(vpc.Subnets[0])
-	}
-	if vpcSubnet == nil {
-		return nil, fmt.Errorf("no subnet found for deploying loadbalancer for service %s", service.GetName())
-	}
+	return created, nil
+}
 
-	internalLoadbalancer := false
-	if val, ok := service.Annotations[LoadbalancerAnnotationInternal]; ok {
-		internalLoadbalancer, _ = strconv.ParseBool(val)
+// getCreateVpcLoadbalancerRequest returns the request creating the load balancer of the Service, including its managed security group
+func (lb *loadbalancer) getCreateVpcLoadbalancerRequest(ctx context.Context, lbName string, service *corev1.Service) (*iaas.CreateLoadbalancer, error) {
+	vpcSubnet, err := lb.findVpcSubnet(ctx, service, lb.getSubnetIdentityForService(service))
+	if err != nil {
+		return nil, err
 	}
 
-	labels := lb.GetLabelsForVpcLoadbalancer(service)
+	internalLoadbalancer := lb.isInternalService(service)
+
+	labels := lb.getLabelsForVpcLoadbalancerOfService(service)
 	annotations := lb.GetAnnotationsForVpcLoadbalancer(service)
+	// record the settings that cannot be changed after creation, so changes can be detected and applied by recreating
+	annotations[AnnotationInternalLoadbalancer] = strconv.FormatBool(internalLoadbalancer)
+	if token := lb.getRecreateTokenForService(service); token != "" {
+		annotations[AnnotationRecreateToken] = token
+	}
+	description := fmt.Sprintf("Loadbalancer for Kubernetes service %s", service.GetName())
+	if group := lb.getSharedGroupForService(service); group != "" {
+		description = fmt.Sprintf("Shared loadbalancer for Kubernetes services of group %s", group)
+	}
 
 	securityGroups := lb.getSecurityGroupsForService(service)
 	if err := lb.verifySecurityGroupsExist(ctx, securityGroups); err != nil {
@@ -383,24 +526,50 @@ func (lb *loadbalancer) createVpcLoadbalancer(ctx context.Context, lbName string
 
 	createLB := iaas.CreateLoadbalancer{
 		Name:        lbName,
-		Description: fmt.Sprintf("Loadbalancer for Kubernetes service %s", service.GetName()),
+		Description: description,
 		Labels:      labels,
 		Annotations: annotations,
 
 		Subnet:                   vpcSubnet.Identity,
 		InternalLoadbalancer:     internalLoadbalancer,
 		SecurityGroupAttachments: securityGroups,
+		DeleteProtection:         ptr.Deref(lb.getDeleteProtection(service), false),
 	}
 	if rid := lb.getReservedIPIdentityForService(service); rid != "" {
 		createLB.ReservedIpID = ptr.To(rid)
 	}
-	created, err := lb.iaasClient.CreateLoadbalancer(ctx, createLB)
+	return &createLB, nil
+}
+
+// findVpcSubnet returns the subnet of the cluster VPC with the requested identity or slug, or the first subnet if none is requested
+func (lb *loadbalancer) findVpcSubnet(ctx context.Context, service *corev1.Service, requestedSubnetIdentity string) (*iaas.Subnet, error) {
+	vpc, err := lb.iaasClient.GetVpc(ctx, lb.vpcIdentity)
 	if err != nil {
-		klog.Errorf("Failed to create vpc loadbalancer %s: %v", lbName, err)
-		return nil, err
+		return nil, fmt.Errorf("failed to get vpc: %v", err)
 	}
 
-	return created, nil
+	if len(vpc.Subnets) == 0 {
+		return nil, fmt.Errorf("vpc %s has no subnets", lb.vpcIdentity)
+	}
+
+	var vpcSubnet *iaas.Subnet
+	if requestedSubnetIdentity != "" {
+		for _, subnet := range vpc.Subnets {
+			if subnet.Identity == requestedSubnetIdentity || subnet.Slug == requestedSubnetIdentity {
+				vpcSubnet = &subnet
+				break
+			}
+		}
+	} else {
+		if len(vpc.Subnets) == 0 {
+			return nil, fmt.Errorf("vpc %s has no subnets", lb.vpcIdentity)
+		}
+		vpcSubnet = ptr.To(vpc.Subnets[0])
+	}
+	if vpcSubnet == nil {
+		return nil, fmt.Errorf("no subnet found for deploying loadbalancer for service %s", service.GetName())
+	}
+	return vpcSubnet, nil
 }
 
 // verify security groups exists
@@ -505,10 +674,17 @@ func (lb *loadbalancer) updateVpcLoadbalancerListenersAndTargetGroups(ctx contex
 		return nil, fmt.Errorf("failed to create loadbalancer backends: %v", err)
 	}
 
+	// make-before-break: new target groups are created with their nodes attached before listeners are repointed to
+	// them, and target groups are only deleted once no listener uses them
 	tgs, err := lb.createOrUpdateTargetGroups(ctx, service, vpcLoadbalancer, desiredTgs, nodes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create or update target groups: %v", err)
 	}
+	if lb.needsRecreate(service, vpcLoadbalancer) || isReplacedVpcLoadbalancer(*vpcLoadbalancer) {
+		if vpcLoadbalancer, err = lb.recreateVpcLoadbalancer(ctx, service, vpcLoadbalancer, desiredListeners, tgs); err != nil {
+			return nil, fmt.Errorf("failed to recreate loadbalancer: %v", err)
+		}
+	}
 	// update listeners
 	if err := lb.updateVpcLoadbalancerListener(ctx, service, vpcLoadbalancer, desiredListeners, tgs); err != nil {
 		return nil, fmt.Errorf("failed to update loadbalancer listener: %v", err)
@@ -523,19 +699,15 @@ func (lb *loadbalancer) updateVpcLoadbalancerListenersAndTargetGroups(ctx contex
 		return nil, fmt.Errorf("failed to update loadbalancer: %v", err)
 	}
 
-	loadbalancerStatus := &corev1.LoadBalancerStatus{
-		Ingress: []corev1.LoadBalancerIngress{},
+	companion, err := lb.ensureInternalCompanion(ctx, service, desiredListeners, tgs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ensure internal loadbalancer: %v", err)
 	}
-	for _, ip := range vpcLoadbalancer.ExternalIpAddresses {
-		if ip != "" {
-			loadbalancerStatus.Ingress = append(loadbalancerStatus.Ingress, corev1.LoadBalancerIngress{
-				IP:       ip,
-				Hostname: vpcLoadbalancer.Hostname,
-				IPMode:   ptr.To(corev1.LoadBalancerIPModeProxy),
-			})
-		}
+	status := loadBalancerStatusFor(vpcLoadbalancer, companion)
+	if err := lb.deleteReplacedVpcLoadbalancers(ctx, service, status); err != nil {
+		return nil, fmt.Errorf("failed to delete replaced loadbalancers: %v", err)
 	}
-	return loadbalancerStatus, nil
+	return status, nil
 }
 
 func (lb *loadbalancer) updateVpcLoadbalancer(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer, desiredListeners []iaas.VpcLoadbalancerListener) error {
@@ -571,14 +743,36 @@ func (lb *loadbalancer) updateVpcLoadbalancer(ctx context.Context, service *core
 		preferredSubnetIdentity = vpcLoadbalancer.Subnet.Identity
 	}
 
+	// internal load balancers cannot be moved to another subnet, and the type is fixed at creation
+	internal := lb.isInternalVpcLoadbalancer(service, vpcLoadbalancer)
+	if internal != lb.isInternalService(service) {
+		lb.reportRecreateRequired(service, vpcLoadbalancer, "internal setting")
+	}
+	if internal && preferredSubnetIdentity != vpcLoadbalancer.Subnet.Identity && preferredSubnetIdentity != vpcLoadbalancer.Subnet.Slug {
+		lb.reportRecreateRequired(service, vpcLoadbalancer, "subnet")
+		preferredSubnetIdentity = vpcLoadbalancer.Subnet.Identity
+	}
+
 	desiredReservedIP := lb.getReservedIPIdentityForService(service)
 	currentReservedIP := vpcLoadbalancer.ReservedIpIdentity
 
+	// a shared load balancer keeps the security groups and reserved IP of the other Services in the group
+	if lb.getSharedGroupForService(service) != "" {
+		desiredSecurityGroups = mergeSharedSecurityGroups(currentSecurityGroupIdentities, desiredSecurityGroups)
+		if desiredReservedIP == "" {
+			desiredReservedIP = currentReservedIP
+		}
+	}
+
+	// delete protection is only managed when the Service sets the annotation
+	deleteProtection := ptr.Deref(lb.getDeleteProtection(service), vpcLoadbalancer.DeleteProtection)
+
 	sgNeedsUpdate := !reflect.DeepEqual(desiredSecurityGroups, currentSecurityGroupIdentities) || len(desiredSecurityGroups) != len(currentSecurityGroupIdentities)
 	subnetNeedsUpdate := vpcLoadbalancer.Subnet.Identity != preferredSubnetIdentity
 	reservedIPNeedsUpdate := desiredReservedIP != currentReservedIP
+	deleteProtectionNeedsUpdate := deleteProtection != vpcLoadbalancer.DeleteProtection
 
-	if sgNeedsUpdate || subnetNeedsUpdate || reservedIPNeedsUpdate {
+	if sgNeedsUpdate || subnetNeedsUpdate || reservedIPNeedsUpdate || deleteProtectionNeedsUpdate {
 		klog.Infof("loadbalancer %s needs to be updated", vpcLoadbalancer.Identity)
 		update := iaas.UpdateLoadbalancer{
 			Name:                     vpcLoadbalancer.Name,
@@ -586,7 +780,7 @@ func (lb *loadbalancer) updateVpcLoadbalancer(ctx context.Context, service *core
 			Labels:                   vpcLoadbalancer.Labels,
 			Annotations:              vpcLoadbalancer.Annotations,
 			Subnet:                   ptr.To(preferredSubnetIdentity),
-			DeleteProtection:         vpcLoadbalancer.DeleteProtection,
+			DeleteProtection:         deleteProtection,
 			SecurityGroupAttachments: desiredSecurityGroups,
 		}
 		if reservedIPNeedsUpdate {
@@ -639,30 +833,39 @@ func (lb *loadbalancer) processServiceResync(serviceKey string) {
 
 	namespace, name := parts[0], parts[1]
 
-	// Get the service from the API server
-	svc, err := lb.endpointSlicesClient.CoreV1().Services(namespace).Get(lb.ctx, name, metav1.GetOptions{})
+	// Get the service from the informer cache
+	svc, err := lb.serviceLister.Services(namespace).Get(name)
 	if err != nil {
+		if apierrors.IsNotFound(err) {
+			klog.V(4).Infof("Service %s no longer exists, skipping resync", serviceKey)
+			return
+		}
 		klog.Errorf("Failed to get service %s: %v", serviceKey, err)
 		return
 	}
 
-	// Check if this is a LoadBalancer service
-	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
-		klog.V(4).Infof("Service %s is not a LoadBalancer service, skipping resync", serviceKey)
+	// Check if this is a LoadBalancer service handled by this provider
+	if !lb.shouldHandleService(svc) {
+		klog.V(4).Infof("Service %s is not a LoadBalancer service handled by this provider, skipping resync", serviceKey)
 		return
 	}
 
 	// Get all nodes
-	nodes, err := lb.endpointSlicesClient.CoreV1().Nodes().List(lb.ctx, metav1.ListOptions{})
+	nodes, err := lb.nodeLister.List(labels.Everything())
 	if err != nil {
 		klog.Errorf("Failed to list nodes for service %s: %v", serviceKey, err)
 		return
 	}
-	// filter out nodes that are not ready
-	readyNodes := filterReadyNodes(nodes.Items)
+	// select the nodes the service controller passes to the cloud provider, so both agree on the targets
+	lbNodes := filterLoadBalancerNodes(nodes)
 	// Trigger load balancer update
 	klog.Infof("Processing resync for service %s", serviceKey)
-	if err := lb.UpdateLoadBalancer(lb.ctx, lb.cluster, svc, readyNodes); err != nil {
+	if lb.isLoadBalancerClassService(svc) {
+		err = lb.syncLoadBalancerClassService(lb.ctx, svc, lbNodes)
+	} else {
+		err = lb.UpdateLoadBalancer(lb.ctx, lb.cluster, svc, lbNodes)
+	}
+	if err != nil {
 		klog.Errorf("Failed to update load balancer for service %s: %v", serviceKey, err)
 		// Re-queue with backoff
 		lb.serviceQueue.AddRateLimited(serviceKey)
@@ -672,37 +875,67 @@ func (lb *loadbalancer) processServiceResync(serviceKey string) {
 	klog.Infof("Successfully processed resync for service %s", serviceKey)
 }
 
-func filterReadyNodes(nodes []corev1.Node) []*corev1.Node {
-	readyNodes := make([]*corev1.Node, 0, len(nodes))
+// filterLoadBalancerNodes returns the nodes that are load balancer targets, with the same predicates as the service
+// controller: nodes that are not being deleted, not labeled node.kubernetes.io/exclude-from-external-load-balancers and
+// not tainted for deletion by the cluster autoscaler. Like the service controller, NotReady nodes are kept and left to
+// the health checks of the load balancer.
+func filterLoadBalancerNodes(nodes []*corev1.Node) []*corev1.Node {
+	lbNodes := make([]*corev1.Node, 0, len(nodes))
 	for _, node := range nodes {
-		if IsNodeReady(&node) {
-			readyNodes = append(readyNodes, &node)
+		if isLoadBalancerNode(node) {
+			lbNodes = append(lbNodes, node)
 		}
 	}
-	return readyNodes
+	return lbNodes
 }
 
-func IsNodeReady(node *corev1.Node) bool {
-	if node == nil {
+// isLoadBalancerNode returns true if the service controller considers the node for load balancing
+func isLoadBalancerNode(node *corev1.Node) bool {
+	if !node.DeletionTimestamp.IsZero() {
 		return false
 	}
-	if node.Status.Conditions == nil {
+	if _, excluded := node.Labels[corev1.LabelNodeExcludeBalancers]; excluded {
 		return false
 	}
-	for _, condition := range node.Status.Conditions {
-		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionStatus(corev1.ConditionTrue) {
-			return true
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == toBeDeletedTaint {
+			return false
 		}
 	}
-	return false
+	return true
 }
 
-// startServiceQueueProcessor starts the service queue processor goroutine
+// run starts the endpoint slice watcher and the service queue processor. All goroutines stop
+// when the stop channel is closed and cleanup has been called.
+func (lb *loadbalancer) run(stop <-chan struct{}) {
+	// Create the endpoint slice watcher with the resync callback
+	lb.endpointSliceWatcher = NewEndpointSliceWatcher(lb.informerFactory, stop, lb.triggerServiceResync, lb.shouldHandleService)
+
+	// Services of the configured load balancer class are reconciled through the service queue
+	lb.registerLoadBalancerClassHandlers()
+
+	// Start the service queue processor
+	lb.startServiceQueueProcessor()
+
+	// Start the periodic drift detection, which repairs drift through the service queue
+	lb.startPeriodicDriftDetection()
+}
+
+// startServiceQueueProcessor starts the service queue processor goroutines
 func (lb *loadbalancer) startServiceQueueProcessor() {
-	go lb.processServiceQueue()
+	for i := 0; i < lb.getServiceResyncWorkers(); i++ {
+		go lb.processServiceQueue()
+	}
 	lb.startPeriodicServiceResync()
 }
 
+func (lb *loadbalancer) getServiceResyncWorkers() int {
+	if lb.config.ResyncWorkers == nil || *lb.config.ResyncWorkers <= 0 {
+		return defaultServiceResyncWorkers
+	}
+	return *lb.config.ResyncWorkers
+}
+
 // stopServiceQueueProcessor stops the service queue processor
 func (lb *loadbalancer) stopServiceQueueProcessor() {
 	if lb.cancel != nil {
@@ -711,18 +944,17 @@ func (lb *loadbalancer) stopServiceQueueProcessor() {
 	lb.serviceQueue.ShutDown()
 }
 
-// enqueueLocalTrafficPolicyLoadBalancers lists all services and enqueues resync for
+// enqueueLocalTrafficPolicyLoadBalancers lists all cached services and enqueues resync for
 // LoadBalancer services with externalTrafficPolicy=Local.
 func (lb *loadbalancer) enqueueLocalTrafficPolicyLoadBalancers() {
-	services, err := lb.endpointSlicesClient.CoreV1().Services("").List(lb.ctx, metav1.ListOptions{})
+	services, err := lb.serviceLister.List(labels.Everything())
 	if err != nil {
 		klog.Errorf("Failed to list services for periodic resync: %v", err)
 		return
 	}
 
-	for i := range services.Items {
-		svc := &services.Items[i]
-		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+	for _, svc := range services {
+		if !lb.shouldHandleService(svc) {
 			continue
 		}
 		if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
@@ -745,6 +977,8 @@ func (lb *loadbalancer) startPeriodicServiceResync() {
 		// Run an initial scan soon after startup so a missed watch event
 		// doesn't cause a long stale period.
 		lb.enqueueLocalTrafficPolicyLoadBalancers()
+		lb.enqueueLoadBalancerClassServices()
+		lb.syncPausedServices()
 
 		for {
 			select {
@@ -752,6 +986,8 @@ func (lb *loadbalancer) startPeriodicServiceResync() {
 				return
 			case <-ticker.C:
 				lb.enqueueLocalTrafficPolicyLoadBalancers()
+				lb.enqueueLoadBalancerClassServices()
+				lb.syncPausedServices()
 			}
 		}
 	}()
@@ -908,13 +1144,14 @@ func (lb *loadbalancer) generateSecurityGroupName(lbName string) string {
 	return base
 }
 
-// deleteManagedSecurityGroup removes the managed SG if present
-func (lb *loadbalancer) deleteManagedSecurityGroup(ctx context.Context, service *corev1.Service) {
+// deleteManagedSecurityGroup removes the managed SG if present. The SG must be detached from the load balancer.
+func (lb *loadbalancer) deleteManagedSecurityGroup(ctx context.Context, service *corev1.Service) error {
 	sg, err := lb.findManagedSecurityGroup(ctx, service)
 	if err != nil || sg == nil {
-		return
+		return err
 	}
 	if err := lb.iaasClient.DeleteSecurityGroup(ctx, sg.Identity); err != nil {
-		klog.Errorf("failed to delete managed security group %s: %v", sg.Identity, err)
+		return fmt.Errorf("failed to delete managed security group %s: %v", sg.Identity, err)
 	}
+	return nil
 }
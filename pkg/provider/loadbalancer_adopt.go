@@ -0,0 +1,255 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// Event reasons emitted when adopting an existing load balancer
+const (
+	EventReasonLoadBalancerAdopted          = "LoadBalancerAdopted"
+	EventReasonLoadBalancerListenerConflict = "LoadBalancerListenerConflict"
+	// EventReasonLoadBalancerNotOwned is emitted when the id annotation references a load balancer the Service cannot
+	// adopt, on the paths that ignore it
+	EventReasonLoadBalancerNotOwned = "LoadBalancerNotOwned"
+)
+
+// notOwnedLoadbalancerError is returned for a load balancer referenced by the id annotation that the Service cannot
+// adopt: it is in another VPC, shared or owned by another Service
+type notOwnedLoadbalancerError struct {
+	identity string
+	reason   string
+}
+
+func (e *notOwnedLoadbalancerError) Error() string {
+	return fmt.Sprintf("loadbalancer %s %s", e.identity, e.reason)
+}
+
+// getLoadBalancerIDForService returns the identity of an existing load balancer the Service adopts, or empty if unset
+func (lb *loadbalancer) getLoadBalancerIDForService(service *corev1.Service) string {
+	if val, ok := service.Annotations[LoadBalancerAnnotationID]; ok {
+		return strings.TrimSpace(val)
+	}
+	return ""
+}
+
+// isOwnedByService returns true if the labels link a cloud resource to this cluster and Service
+func (lb *loadbalancer) isOwnedByService(labels map[string]string, service *corev1.Service) bool {
+	return labels[LabelKubernetesCluster] == lb.cluster && labels[LabelKubernetesServiceUID] == string(service.UID)
+}
+
+// isOwnedByOtherService returns true if the labels link a cloud resource to another Service or cluster
+func (lb *loadbalancer) isOwnedByOtherService(labels map[string]string, service *corev1.Service) bool {
+	if uid, ok := labels[LabelKubernetesServiceUID]; ok && uid != string(service.UID) {
+		return true
+	}
+	if cluster, ok := labels[LabelKubernetesCluster]; ok && cluster != lb.cluster {
+		return true
+	}
+	return false
+}
+
+// fetchAdoptedVpcLoadbalancer returns the load balancer referenced by the id annotation, or nil if it does not exist.
+// It fails if the load balancer is in another VPC or owned by another Service.
+func (lb *loadbalancer) fetchAdoptedVpcLoadbalancer(ctx context.Context, identity string, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
+	vpcLoadbalancer, err := lb.iaasClient.GetLoadbalancer(ctx, identity)
+	if err != nil {
+		if thalassaclient.IsNotFound(err) {
+			klog.V(4).Infof("loadbalancer %q referenced by service %s not found", identity, getServiceKey(service))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get loadbalancer %s: %v", identity, err)
+	}
+	if vpcLoadbalancer == nil {
+		return nil, nil
+	}
+
+	vpcIdentity := vpcLoadbalancer.VpcIdentity
+	if vpcIdentity == "" && vpcLoadbalancer.Vpc != nil {
+		vpcIdentity = vpcLoadbalancer.Vpc.Identity
+	}
+	if vpcIdentity != lb.vpcIdentity {
+		return nil, &notOwnedLoadbalancerError{identity: identity, reason: fmt.Sprintf("is in vpc %q, not in the cluster vpc %q", vpcIdentity, lb.vpcIdentity)}
+	}
+	if group := vpcLoadbalancer.Labels[LabelSharedGroup]; group != "" {
+		return nil, &notOwnedLoadbalancerError{identity: identity, reason: fmt.Sprintf("is shared by the services of group %q", group)}
+	}
+	if lb.isOwnedByOtherService(vpcLoadbalancer.Labels, service) {
+		return nil, &notOwnedLoadbalancerError{identity: identity, reason: fmt.Sprintf("is owned by service %s/%s in cluster %q",
+			vpcLoadbalancer.Labels[LabelKubernetesServiceNamespace], vpcLoadbalancer.Labels[LabelKubernetesServiceName], vpcLoadbalancer.Labels[LabelKubernetesCluster])}
+	}
+	return vpcLoadbalancer, nil
+}
+
+// fetchOwnedVpcLoadbalancer returns the load balancer of the Service like fetchVpcLoadbalancerFromCloud, for the get
+// and delete paths. A load balancer referenced by the id annotation that the Service cannot adopt is reported and
+// treated as not found, so a Service with a wrong id can still be deleted. The load balancer is left untouched.
+func (lb *loadbalancer) fetchOwnedVpcLoadbalancer(ctx context.Context, clusterName string, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
+	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(ctx, clusterName, service)
+	var notOwned *notOwnedLoadbalancerError
+	if errors.As(err, &notOwned) {
+		klog.Warningf("ignoring the loadbalancer referenced by service %s: %v", getServiceKey(service), err)
+		lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerNotOwned, "Ignoring the load balancer referenced by the %s annotation: %v", LoadBalancerAnnotationID, err)
+		return nil, nil
+	}
+	return vpcLoadbalancer, err
+}
+
+// ensureAdopted adopts the load balancer if the Service references it by id and does not own it yet
+func (lb *loadbalancer) ensureAdopted(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) error {
+	if lb.getLoadBalancerIDForService(service) == "" || lb.isOwnedByService(vpcLoadbalancer.Labels, service) {
+		return nil
+	}
+	return lb.adoptVpcLoadbalancer(ctx, service, vpcLoadbalancer)
+}
+
+// adoptVpcLoadbalancer takes over an existing load balancer. Target groups of unmanaged listeners on ports of the
+// Service are labeled for the Service, so the following reconcile updates them in place or replaces them, and the
+// ownership labels are applied to the load balancer. Listeners are taken over by the reconcile itself.
+func (lb *loadbalancer) adoptVpcLoadbalancer(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) error {
+	klog.Infof("adopting loadbalancer %s for service %s", vpcLoadbalancer.Identity, getServiceKey(service))
+
+	if err := lb.adoptListenerTargetGroups(ctx, service, vpcLoadbalancer); err != nil {
+		return err
+	}
+
+	labels := iaas.Labels{}
+	for key, val := range vpcLoadbalancer.Labels {
+		labels[key] = val
+	}
+	delete(labels, LabelRetained)
+	for key, val := range lb.GetLabelsForVpcLoadbalancer(service) {
+		labels[key] = val
+	}
+
+	securityGroups := make([]string, 0, len(vpcLoadbalancer.SecurityGroups))
+	for _, securityGroup := range vpcLoadbalancer.SecurityGroups {
+		securityGroups = append(securityGroups, securityGroup.Identity)
+	}
+
+	updated, err := lb.iaasClient.UpdateLoadbalancer(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{
+		Name:                     vpcLoadbalancer.Name,
+		Description:              vpcLoadbalancer.Description,
+		Labels:                   labels,
+		Annotations:              vpcLoadbalancer.Annotations,
+		DeleteProtection:         vpcLoadbalancer.DeleteProtection,
+		SecurityGroupAttachments: securityGroups,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to label adopted loadbalancer: %v", err)
+	}
+	if updated != nil && updated.Identity != "" {
+		*vpcLoadbalancer = *updated
+	} else {
+		vpcLoadbalancer.Labels = labels
+	}
+
+	lb.eventf(service, corev1.EventTypeNormal, EventReasonLoadBalancerAdopted, "Adopted existing load balancer %s", vpcLoadbalancer.Identity)
+	return nil
+}
+
+// adoptListenerTargetGroups labels the target groups of unmanaged listeners on ports of the Service for the Service
+func (lb *loadbalancer) adoptListenerTargetGroups(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) error {
+	listeners, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
+		Loadbalancer: vpcLoadbalancer.Identity,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list listeners: %v", err)
+	}
+
+	servicePorts := map[int]corev1.ServicePort{}
+	for _, port := range service.Spec.Ports {
+		servicePorts[int(port.Port)] = port
+	}
+
+	// target group identity -> service port of the listener forwarding to it
+	adoptTargetGroups := map[string]corev1.ServicePort{}
+	for _, listener := range listeners {
+		port, ok := servicePorts[listener.Port]
+		if !ok || listener.TargetGroup == nil || listener.TargetGroup.Identity == "" {
+			continue
+		}
+		if lb.isOwnedByService(listener.Labels, service) || lb.isOwnedByOtherService(listener.Labels, service) {
+			continue
+		}
+		adoptTargetGroups[listener.TargetGroup.Identity] = port
+	}
+	if len(adoptTargetGroups) == 0 {
+		return nil
+	}
+
+	targetGroups, err := lb.iaasClient.ListTargetGroups(ctx, &iaas.ListTargetGroupsRequest{
+		Filters: []filters.Filter{
+			&filters.FilterKeyValue{
+				Key:   filters.FilterVpcIdentity,
+				Value: lb.vpcIdentity,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list target groups: %v", err)
+	}
+
+	for _, targetGroup := range targetGroups {
+		port, ok := adoptTargetGroups[targetGroup.Identity]
+		if !ok || lb.isOwnedByOtherService(targetGroup.Labels, service) {
+			continue
+		}
+		labels := iaas.Labels{}
+		for key, val := range targetGroup.Labels {
+			labels[key] = val
+		}
+		for key, val := range lb.GetLabelsForVpcLoadbalancerTargetGroup(service, int(port.Port), string(port.Protocol)) {
+			labels[key] = val
+		}
+
+		klog.Infof("adopting target group %q for port %d of service %s", targetGroup.Identity, port.Port, getServiceKey(service))
+		if _, err := lb.iaasClient.UpdateTargetGroup(ctx, iaas.UpdateTargetGroupRequest{
+			Identity: targetGroup.Identity,
+			UpdateTargetGroup: iaas.UpdateTargetGroup{
+				Name:                targetGroup.Name,
+				Description:         targetGroup.Description,
+				Labels:              labels,
+				Annotations:         targetGroup.Annotations,
+				TargetPort:          targetGroup.TargetPort,
+				Protocol:            targetGroup.Protocol,
+				TargetSelector:      targetGroup.TargetSelector,
+				EnableProxyProtocol: targetGroup.EnableProxyProtocol,
+				LoadbalancingPolicy: targetGroup.LoadbalancingPolicy,
+				HealthCheck:         targetGroup.HealthCheck,
+			},
+		}); err != nil {
+			return fmt.Errorf("failed to label adopted target group %s: %v", targetGroup.Identity, err)
+		}
+	}
+	return nil
+}
+
+// reportListenerConflict records that a listener not managed by the Service was left unchanged
+func (lb *loadbalancer) reportListenerConflict(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer, listener iaas.VpcLoadbalancerListener) {
+	klog.Warningf("listener %q on port %d of loadbalancer %q is not managed by service %s, leaving it unchanged", listener.Name, listener.Port, vpcLoadbalancer.Identity, getServiceKey(service))
+	lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerListenerConflict, "Listener %q on port %d of load balancer %s is not managed by this Service and was left unchanged", listener.Name, listener.Port, vpcLoadbalancer.Identity)
+}
+
+// isListenerConflict returns true if the listener must not be changed by the Service: it is owned by another Service,
+// or it is an unmanaged listener of an adopted or shared load balancer on a port the Service does not use
+func (lb *loadbalancer) isListenerConflict(service *corev1.Service, listener iaas.VpcLoadbalancerListener, desiredPort bool) bool {
+	if lb.isOwnedByOtherService(listener.Labels, service) {
+		return true
+	}
+	if desiredPort {
+		return false
+	}
+	if lb.getLoadBalancerIDForService(service) == "" && lb.getSharedGroupForService(service) == "" {
+		return false
+	}
+	return !lb.isOwnedByService(listener.Labels, service)
+}
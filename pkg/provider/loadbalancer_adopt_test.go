@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAdoptLoadBalancer adds the load balancer lb-legacy that was not created for the Service, with a listener on a
+// port of the Service and one on another port
+func newTestAdoptLoadBalancer(t *testing.T) (*loadbalancer, *corev1.Service, *fakeIaas, *record.FakeRecorder) {
+	lb, cloud, _ := newTestLifecycleLoadBalancer(t)
+	recorder := record.NewFakeRecorder(10)
+	lb.recorder = recorder
+	service := newTestLifecycleService(map[string]string{LoadBalancerAnnotationID: "lb-legacy"})
+
+	cloud.addSecurityGroup("sg-custom")
+	legacy := cloud.addLoadbalancer("lb-legacy", "legacy", iaas.Labels{"team": "web", LabelRetained: "true"})
+	legacy.SecurityGroups = []iaas.SecurityGroup{{Identity: "sg-custom"}}
+	cloud.addTargetGroup("tg-legacy", "legacy-http", 30080, iaas.Labels{"team": "web"})
+	cloud.addTargetGroup("tg-admin", "legacy-admin", 30808, nil)
+	cloud.addLoadbalancerListener("lb-legacy", "listener-80", iaas.CreateListener{Name: "http", Port: 80, Protocol: iaas.ProtocolTCP, TargetGroup: "tg-legacy"})
+	cloud.addLoadbalancerListener("lb-legacy", "listener-8080", iaas.CreateListener{Name: "admin", Port: 8080, Protocol: iaas.ProtocolTCP, TargetGroup: "tg-admin"})
+	return lb, service, cloud, recorder
+}
+
+// findTestTargetGroup returns the target group with the identity
+func findTestTargetGroup(t *testing.T, cloud *fakeIaas, identity string) iaas.VpcLoadbalancerTargetGroup {
+	for _, targetGroup := range cloud.targetGroupList() {
+		if targetGroup.Identity == identity {
+			return targetGroup
+		}
+	}
+	t.Fatalf("target group %s not found", identity)
+	return iaas.VpcLoadbalancerTargetGroup{}
+}
+
+func TestLoadBalancer_FetchAdoptedVpcLoadbalancer(t *testing.T) {
+	tests := []struct {
+		name        string
+		identity    string
+		modify      func(lb *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer)
+		expectFound bool
+		expectError bool
+	}{
+		{name: "unowned load balancer in cluster vpc", expectFound: true},
+		{
+			name: "already owned by the service",
+			modify: func(lb *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer) {
+				vpcLoadbalancer.Labels = lb.GetLabelsForVpcLoadbalancer(newTestLifecycleService(nil))
+			},
+			expectFound: true,
+		},
+		{
+			name:        "vpc from nested vpc",
+			modify:      func(_ *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer) { vpcLoadbalancer.VpcIdentity = "" },
+			expectFound: true,
+		},
+		{
+			name: "other vpc",
+			modify: func(_ *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer) {
+				vpcLoadbalancer.VpcIdentity = "vpc-other"
+				vpcLoadbalancer.Vpc = &iaas.Vpc{Identity: "vpc-other"}
+			},
+			expectError: true,
+		},
+		{
+			name: "owned by another service",
+			modify: func(_ *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer) {
+				vpcLoadbalancer.Labels = iaas.Labels{LabelKubernetesCluster: "cluster-test", LabelKubernetesServiceUID: "uid-other"}
+			},
+			expectError: true,
+		},
+		{
+			name: "owned by another cluster",
+			modify: func(_ *loadbalancer, vpcLoadbalancer *iaas.VpcLoadbalancer) {
+				vpcLoadbalancer.Labels = iaas.Labels{LabelKubernetesCluster: "cluster-other"}
+			},
+			expectError: true,
+		},
+		{name: "not found", identity: "lb-missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, service, cloud, _ := newTestAdoptLoadBalancer(t)
+			if tt.modify != nil {
+				cloud.mu.Lock()
+				tt.modify(lb, cloud.findLoadbalancer("lb-legacy"))
+				cloud.mu.Unlock()
+			}
+			if tt.identity != "" {
+				service.Annotations[LoadBalancerAnnotationID] = tt.identity
+			}
+
+			vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if !tt.expectFound {
+				assert.Nil(t, vpcLoadbalancer)
+				return
+			}
+			require.NotNil(t, vpcLoadbalancer)
+			assert.Equal(t, "lb-legacy", vpcLoadbalancer.Identity)
+		})
+	}
+}
+
+func TestLoadBalancer_NotOwnedAdoptedLoadBalancerDoesNotBlockDeletion(t *testing.T) {
+	lb, service, cloud, recorder := newTestAdoptLoadBalancer(t)
+	cloud.mu.Lock()
+	cloud.findLoadbalancer("lb-legacy").Labels = iaas.Labels{LabelKubernetesCluster: "cluster-test", LabelKubernetesServiceUID: "uid-other"}
+	cloud.mu.Unlock()
+	ctx := context.Background()
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nil)
+	assert.Error(t, err, "a load balancer owned by another service is not adopted")
+	assert.Error(t, lb.UpdateLoadBalancer(ctx, "cluster-test", service, nil))
+
+	cloud.resetRequestLog()
+	_, exists, err := lb.GetLoadBalancer(ctx, "cluster-test", service)
+	require.NoError(t, err)
+	assert.False(t, exists)
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+
+	for _, request := range cloud.requestLog() {
+		assert.True(t, strings.HasPrefix(request, "GET "), "the load balancer of the other service is left untouched: %s", request)
+	}
+	require.Len(t, cloud.loadbalancerList(), 1)
+	assert.Len(t, cloud.listenerList(), 2)
+	require.NotEmpty(t, recorder.Events)
+	assert.Contains(t, <-recorder.Events, EventReasonLoadBalancerNotOwned)
+}
+
+func TestLoadBalancer_EnsureLoadBalancerAdoptNotFound(t *testing.T) {
+	lb, service, cloud, _ := newTestAdoptLoadBalancer(t)
+	service.Annotations[LoadBalancerAnnotationID] = "lb-missing"
+
+	_, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nil)
+	assert.Error(t, err)
+	assert.Empty(t, cloud.requestLog(), "no load balancer must be created for a missing id")
+}
+
+func TestLoadBalancer_AdoptVpcLoadbalancer(t *testing.T) {
+	lb, service, cloud, recorder := newTestAdoptLoadBalancer(t)
+	vpcLoadbalancer, err := cloud.client().GetLoadbalancer(context.Background(), "lb-legacy")
+	require.NoError(t, err)
+
+	require.NoError(t, lb.ensureAdopted(context.Background(), service, vpcLoadbalancer))
+
+	assert.Equal(t, []string{
+		"PUT " + iaas.TargetGroupEndpoint + "/tg-legacy",
+		"PUT " + iaas.LoadbalancerEndpoint + "/lb-legacy",
+	}, cloud.requestLog(), "only the target group of the listener on a service port is adopted")
+
+	targetGroup := findTestTargetGroup(t, cloud, "tg-legacy")
+	assert.Equal(t, "legacy-http", targetGroup.Name)
+	assert.Equal(t, 30080, targetGroup.TargetPort)
+	assert.Equal(t, "web", targetGroup.Labels["team"])
+	assert.True(t, matchLabels(lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP"), targetGroup.Labels))
+	assert.Empty(t, findTestTargetGroup(t, cloud, "tg-admin").Labels)
+
+	adopted := cloud.loadbalancerList()[0]
+	expectedLabels := iaas.Labels{"team": "web"}
+	for key, val := range lb.GetLabelsForVpcLoadbalancer(service) {
+		expectedLabels[key] = val
+	}
+	assert.Equal(t, expectedLabels, adopted.Labels, "the retained label must be removed")
+	require.Len(t, adopted.SecurityGroups, 1)
+	assert.Equal(t, "sg-custom", adopted.SecurityGroups[0].Identity)
+	assert.True(t, lb.isOwnedByService(vpcLoadbalancer.Labels, service))
+
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Normal LoadBalancerAdopted")
+
+	// an owned load balancer is not adopted again
+	cloud.resetRequestLog()
+	require.NoError(t, lb.ensureAdopted(context.Background(), service, vpcLoadbalancer))
+	assert.Empty(t, cloud.requestLog())
+}
+
+func TestLoadBalancer_UpdateVpcLoadbalancerListenerConflicts(t *testing.T) {
+	lb, service, cloud, recorder := newTestAdoptLoadBalancer(t)
+	cloud.addLoadbalancerListener("lb-legacy", "listener-9090", iaas.CreateListener{Name: "stale", Port: 9090, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 9090, "TCP")})
+	cloud.addLoadbalancerListener("lb-legacy", "listener-443", iaas.CreateListener{Name: "other", Port: 443, Labels: iaas.Labels{LabelKubernetesCluster: "cluster-test", LabelKubernetesServiceUID: "uid-other"}})
+	targetGroups := []iaas.VpcLoadbalancerTargetGroup{
+		{Identity: "tg-legacy", Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP")},
+	}
+	vpcLoadbalancer, err := cloud.client().GetLoadbalancer(context.Background(), "lb-legacy")
+	require.NoError(t, err)
+
+	require.NoError(t, lb.updateVpcLoadbalancerListener(context.Background(), service, vpcLoadbalancer, lb.desiredVpcLoadbalancerListener(service), targetGroups))
+
+	assert.Equal(t, []string{
+		"PUT " + iaas.LoadbalancerEndpoint + "/lb-legacy/listeners/listener-80",
+		"DELETE " + iaas.LoadbalancerEndpoint + "/lb-legacy/listeners/listener-9090",
+	}, cloud.requestLog(), "the unmanaged listener on a service port is taken over, conflicting listeners are kept")
+	assert.Len(t, cloud.listenerList(), 3)
+
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, `Warning LoadBalancerListenerConflict Listener "admin" on port 8080`)
+	assert.Contains(t, <-recorder.Events, `Warning LoadBalancerListenerConflict Listener "other" on port 443`)
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedAdoptedIsRetained(t *testing.T) {
+	lb, service, cloud, _ := newTestAdoptLoadBalancer(t)
+	nodes := []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: getProviderID("vm-1")}}}
+	ctx := context.Background()
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1, "the adopted load balancer is not deleted")
+	assert.True(t, isRetainedVpcLoadbalancer(loadbalancers[0]))
+	listeners := cloud.listenerList()
+	require.Len(t, listeners, 1, "the listeners of the service are deleted")
+	assert.Equal(t, "listener-8080", listeners[0].Identity, "the unmanaged listener is kept")
+
+	// deleting an adopted load balancer requires the delete policy
+	service.Annotations[LoadBalancerAnnotationDeletionPolicy] = string(DeletionPolicyDelete)
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+	assert.Empty(t, cloud.loadbalancerList())
+}
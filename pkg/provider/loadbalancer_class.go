@@ -0,0 +1,139 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// Event reasons emitted while reconciling Services of the configured load balancer class, matching the service controller
+const (
+	EventReasonEnsuredLoadBalancer    = "EnsuredLoadBalancer"
+	EventReasonSyncLoadBalancerFailed = "SyncLoadBalancerFailed"
+)
+
+// shouldHandleService returns true if the Service is a LoadBalancer Service reconciled by this provider: a Service of
+// the configured load balancer class, or a Service without class unless handling those is disabled
+func (lb *loadbalancer) shouldHandleService(service *corev1.Service) bool {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return false
+	}
+	if service.Spec.LoadBalancerClass == nil {
+		return ptr.Deref(lb.config.HandleServicesWithoutClass, true)
+	}
+	return lb.isLoadBalancerClassService(service)
+}
+
+// isLoadBalancerClassService returns true if the Service requests the load balancer class of this provider.
+// The service controller of the cloud provider framework skips these Services, so they are reconciled by the service queue.
+func (lb *loadbalancer) isLoadBalancerClassService(service *corev1.Service) bool {
+	return lb.config.LoadBalancerClass != "" && service.Spec.LoadBalancerClass != nil && *service.Spec.LoadBalancerClass == lb.config.LoadBalancerClass
+}
+
+// registerLoadBalancerClassHandlers queues Services of the configured load balancer class when they are created or changed
+func (lb *loadbalancer) registerLoadBalancerClassHandlers() {
+	if lb.config.LoadBalancerClass == "" {
+		return
+	}
+	klog.Infof("reconciling LoadBalancer services with loadBalancerClass %q", lb.config.LoadBalancerClass)
+
+	_, err := lb.informerFactory.Core().V1().Services().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if svc, ok := obj.(*corev1.Service); ok && lb.isLoadBalancerClassService(svc) {
+				lb.triggerServiceResync(getServiceKey(svc))
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldSvc, ok := oldObj.(*corev1.Service)
+			if !ok {
+				return
+			}
+			newSvc, ok := newObj.(*corev1.Service)
+			if !ok {
+				return
+			}
+			if lb.isLoadBalancerClassService(newSvc) && needsLoadBalancerClassSync(oldSvc, newSvc) {
+				lb.triggerServiceResync(getServiceKey(newSvc))
+			}
+		},
+	})
+	if err != nil {
+		klog.Errorf("failed to register service event handler for loadBalancerClass %q: %v", lb.config.LoadBalancerClass, err)
+	}
+}
+
+// needsLoadBalancerClassSync returns true if the change of a Service of the configured class requires a reconcile.
+// Status updates, including those written by the reconcile itself, are ignored.
+func needsLoadBalancerClassSync(oldSvc, newSvc *corev1.Service) bool {
+	if !servicehelper.HasLBFinalizer(newSvc) {
+		return true
+	}
+	return oldSvc.Generation != newSvc.Generation || !reflect.DeepEqual(oldSvc.Annotations, newSvc.Annotations)
+}
+
+// enqueueLoadBalancerClassServices queues all Services of the configured load balancer class, so node changes are
+// applied to their load balancers
+func (lb *loadbalancer) enqueueLoadBalancerClassServices() {
+	if lb.config.LoadBalancerClass == "" {
+		return
+	}
+	services, err := lb.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list services for periodic resync: %v", err)
+		return
+	}
+	for _, svc := range services {
+		if lb.isLoadBalancerClassService(svc) {
+			lb.serviceQueue.Add(getServiceKey(svc))
+		}
+	}
+}
+
+// syncLoadBalancerClassService does what the service controller does for Services without class: it adds the load
+// balancer cleanup finalizer, ensures the load balancer and writes its status to the Service. The deletion is left to the
+// service controller, which cleans up every Service holding the finalizer through EnsureLoadBalancerDeleted.
+func (lb *loadbalancer) syncLoadBalancerClassService(ctx context.Context, service *corev1.Service, nodes []*corev1.Node) error {
+	if service.DeletionTimestamp != nil {
+		klog.V(4).Infof("Service %s is being deleted, leaving the cleanup to the service controller", getServiceKey(service))
+		return nil
+	}
+	if lb.kubeClient == nil {
+		return fmt.Errorf("no kubernetes client to reconcile service %s", getServiceKey(service))
+	}
+
+	if !servicehelper.HasLBFinalizer(service) {
+		updated := service.DeepCopy()
+		updated.Finalizers = append(updated.Finalizers, servicehelper.LoadBalancerCleanupFinalizer)
+		klog.V(2).Infof("Adding finalizer to service %s", getServiceKey(service))
+		patched, err := servicehelper.PatchService(lb.kubeClient.CoreV1(), service, updated)
+		if err != nil {
+			return fmt.Errorf("failed to add load balancer cleanup finalizer: %v", err)
+		}
+		service = patched
+	}
+
+	status, err := lb.EnsureLoadBalancer(ctx, lb.cluster, service, nodes)
+	if err != nil {
+		lb.eventf(service, corev1.EventTypeWarning, EventReasonSyncLoadBalancerFailed, "Error syncing load balancer: %v", err)
+		return err
+	}
+	if status == nil || servicehelper.LoadBalancerStatusEqual(&service.Status.LoadBalancer, status) {
+		return nil
+	}
+
+	updated := service.DeepCopy()
+	updated.Status.LoadBalancer = *status
+	klog.V(2).Infof("Patching status for service %s", getServiceKey(service))
+	if _, err := servicehelper.PatchService(lb.kubeClient.CoreV1(), service, updated); err != nil {
+		return fmt.Errorf("failed to update load balancer status: %v", err)
+	}
+	lb.eventf(service, corev1.EventTypeNormal, EventReasonEnsuredLoadBalancer, "Ensured load balancer")
+	return nil
+}
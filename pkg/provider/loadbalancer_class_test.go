@@ -0,0 +1,200 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	cloudprovider "k8s.io/cloud-provider"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testLoadBalancerClass = "thalassa.cloud/vpc"
+
+func newTestClassService(name string, class *string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: corev1.ServiceSpec{
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: class,
+		},
+	}
+}
+
+func TestLoadBalancer_ShouldHandleService(t *testing.T) {
+	clusterIP := newTestClassService("clusterip", nil)
+	clusterIP.Spec.Type = corev1.ServiceTypeClusterIP
+
+	tests := []struct {
+		name           string
+		config         LoadBalancerConfig
+		service        *corev1.Service
+		expectHandled  bool
+		expectClassful bool
+	}{
+		{name: "without class by default", service: newTestClassService("a", nil), expectHandled: true},
+		{name: "without class when enabled", config: LoadBalancerConfig{HandleServicesWithoutClass: ptr.To(true)}, service: newTestClassService("a", nil), expectHandled: true},
+		{name: "without class when disabled", config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass, HandleServicesWithoutClass: ptr.To(false)}, service: newTestClassService("a", nil)},
+		{name: "configured class", config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass}, service: newTestClassService("a", ptr.To(testLoadBalancerClass)), expectHandled: true, expectClassful: true},
+		{name: "other class", config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass}, service: newTestClassService("a", ptr.To("metallb.io/metallb"))},
+		{name: "class without configured class", service: newTestClassService("a", ptr.To(testLoadBalancerClass))},
+		{name: "not a load balancer", config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass}, service: clusterIP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &loadbalancer{config: tt.config}
+			assert.Equal(t, tt.expectHandled, lb.shouldHandleService(tt.service))
+			assert.Equal(t, tt.expectClassful, lb.isLoadBalancerClassService(tt.service))
+		})
+	}
+}
+
+func TestLoadBalancer_OtherClassIsImplementedElsewhere(t *testing.T) {
+	lb := &loadbalancer{config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass, HandleServicesWithoutClass: ptr.To(false)}}
+	service := newTestClassService("a", nil)
+
+	_, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nil)
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, err)
+	assert.Equal(t, cloudprovider.ImplementedElsewhere, lb.UpdateLoadBalancer(context.Background(), "cluster-test", service, nil))
+}
+
+func TestNeedsLoadBalancerClassSync(t *testing.T) {
+	service := newTestClassService("a", ptr.To(testLoadBalancerClass))
+	service.Finalizers = []string{servicehelper.LoadBalancerCleanupFinalizer}
+	service.Generation = 1
+
+	statusOnly := service.DeepCopy()
+	statusOnly.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.0.2.10"}}
+	assert.False(t, needsLoadBalancerClassSync(service, statusOnly))
+
+	specChange := service.DeepCopy()
+	specChange.Generation = 2
+	assert.True(t, needsLoadBalancerClassSync(service, specChange))
+
+	annotationChange := service.DeepCopy()
+	annotationChange.Annotations = map[string]string{LoadBalancerAnnotationReconcile: ReconcilePaused}
+	assert.True(t, needsLoadBalancerClassSync(service, annotationChange))
+
+	withoutFinalizer := service.DeepCopy()
+	withoutFinalizer.Finalizers = nil
+	assert.True(t, needsLoadBalancerClassSync(withoutFinalizer, withoutFinalizer))
+}
+
+func TestLoadBalancer_EnqueueLoadBalancerClassServices(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(newTestClassService("ours", ptr.To(testLoadBalancerClass))))
+	require.NoError(t, indexer.Add(newTestClassService("other", ptr.To("metallb.io/metallb"))))
+	require.NoError(t, indexer.Add(newTestClassService("classless", nil)))
+
+	lb := &loadbalancer{
+		config:        LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass},
+		serviceLister: corelisters.NewServiceLister(indexer),
+		serviceQueue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](0, 0),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "test-queue"},
+		),
+	}
+	defer lb.serviceQueue.ShutDown()
+
+	lb.enqueueLoadBalancerClassServices()
+	require.Equal(t, 1, lb.serviceQueue.Len())
+	item, _ := lb.serviceQueue.Get()
+	assert.Equal(t, "default/ours", item)
+	lb.serviceQueue.Done(item)
+}
+
+func TestLoadBalancer_SyncLoadBalancerClassService(t *testing.T) {
+	service := newTestClassService("web", ptr.To(testLoadBalancerClass))
+	// a paused Service reports the status of its existing load balancer without changing it
+	service.Annotations = map[string]string{LoadBalancerAnnotationReconcile: ReconcilePaused}
+	kubeClient := fake.NewSimpleClientset(service)
+	recorder := record.NewFakeRecorder(10)
+	lb := &loadbalancer{
+		config:      LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass},
+		vpcIdentity: "vpc-test",
+		cluster:     "cluster-test",
+		kubeClient:  kubeClient,
+		recorder:    recorder,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode([]iaas.VpcLoadbalancer{{
+			Identity:            "lb-web",
+			Labels:              lb.GetLabelsForVpcLoadbalancer(service),
+			ExternalIpAddresses: []string{"192.0.2.10"},
+		}})
+	}))
+	defer server.Close()
+	lb.iaasClient = newCloudAPI(newTestIaasClient(t, server.URL))
+
+	require.NoError(t, lb.syncLoadBalancerClassService(context.Background(), service, nil))
+
+	updated, err := kubeClient.CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, servicehelper.HasLBFinalizer(updated))
+	require.Len(t, updated.Status.LoadBalancer.Ingress, 1)
+	assert.Equal(t, "192.0.2.10", updated.Status.LoadBalancer.Ingress[0].IP)
+	assert.Contains(t, drainEvents(recorder), "Normal EnsuredLoadBalancer Ensured load balancer")
+
+	// an unchanged status is not written again
+	kubeClient.ClearActions()
+	require.NoError(t, lb.syncLoadBalancerClassService(context.Background(), updated, nil))
+	assert.Empty(t, kubeClient.Actions())
+
+	// deletion is left to the service controller
+	deleting := updated.DeepCopy()
+	deleting.DeletionTimestamp = ptr.To(metav1.Now())
+	require.NoError(t, lb.syncLoadBalancerClassService(context.Background(), deleting, nil))
+	assert.Empty(t, kubeClient.Actions())
+}
+
+func TestEndpointSliceWatcher_ServiceFilter(t *testing.T) {
+	lb := &loadbalancer{config: LoadBalancerConfig{LoadBalancerClass: testLoadBalancerClass}}
+	var resyncs []string
+	w := &EndpointSliceWatcher{
+		serviceFilter:         lb.shouldHandleService,
+		onEndpointSliceChange: func(serviceKey string) { resyncs = append(resyncs, serviceKey) },
+	}
+
+	other := newTestClassService("other", ptr.To("metallb.io/metallb"))
+	other.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	w.handleServiceAdd(other)
+	assert.False(t, w.hasLocalTrafficPolicy("default/other"), "services of other load balancer classes are not tracked")
+
+	ours := newTestClassService("ours", ptr.To(testLoadBalancerClass))
+	w.handleServiceAdd(ours)
+	local := ours.DeepCopy()
+	local.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+	w.handleServiceUpdate(ours, local)
+	assert.True(t, w.hasLocalTrafficPolicy("default/ours"))
+	assert.Equal(t, []string{"default/ours"}, resyncs)
+}
+
+// drainEvents returns all events recorded so far
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// LabelLoadBalancerRole distinguishes the additional load balancers of a Service from the load balancer it was created with
+	LabelLoadBalancerRole = "k8s.thalassa.cloud/loadbalancer-role"
+	// LoadBalancerRoleInternalCompanion is the role of the internal load balancer managed next to the public one
+	LoadBalancerRoleInternalCompanion = "internal-companion"
+)
+
+// hasInternalCompanion returns true if the Service requests an internal load balancer next to its public load balancer
+func (lb *loadbalancer) hasInternalCompanion(service *corev1.Service) bool {
+	val, ok := service.Annotations[LoadBalancerAnnotationInternalCompanion]
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		klog.Warningf("invalid value %q for annotation %s on service %s, ignoring", val, LoadBalancerAnnotationInternalCompanion, getServiceKey(service))
+		return false
+	}
+	if !enabled {
+		return false
+	}
+	if internal, _ := strconv.ParseBool(service.Annotations[LoadbalancerAnnotationInternal]); internal {
+		klog.Warningf("loadbalancer of service %s is internal, ignoring annotation %s", getServiceKey(service), LoadBalancerAnnotationInternalCompanion)
+		return false
+	}
+	return true
+}
+
+// isInternalCompanion returns true if the load balancer is the internal companion of a Service
+func isInternalCompanion(vpcLoadbalancer iaas.VpcLoadbalancer) bool {
+	return vpcLoadbalancer.Labels[LabelLoadBalancerRole] == LoadBalancerRoleInternalCompanion
+}
+
+// GetLabelsForInternalCompanion returns the labels for the internal companion load balancer of the Service
+func (lb *loadbalancer) GetLabelsForInternalCompanion(service *corev1.Service) map[string]string {
+	labels := lb.GetLabelsForVpcLoadbalancer(service)
+	labels[LabelLoadBalancerRole] = LoadBalancerRoleInternalCompanion
+	return labels
+}
+
+// fetchInternalCompanion returns the internal companion load balancer of the Service, or nil if it does not exist
+func (lb *loadbalancer) fetchInternalCompanion(ctx context.Context, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
+	loadbalancersInVpc, err := lb.iaasClient.ListLoadbalancers(ctx, &iaas.ListLoadbalancersRequest{
+		Filters: []filters.Filter{
+			&filters.FilterKeyValue{
+				Key:   "vpc",
+				Value: lb.vpcIdentity,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loadbalancers: %v", err)
+	}
+
+	labels := lb.GetLabelsForInternalCompanion(service)
+	for _, loadbalancer := range loadbalancersInVpc {
+		if matchLabels(labels, loadbalancer.Labels) {
+			return &loadbalancer, nil
+		}
+	}
+	return nil, nil
+}
+
+// ensureInternalCompanion creates the internal companion load balancer of the Service if requested and points its
+// listeners at the target groups of the public load balancer. A companion that is no longer requested is deleted.
+func (lb *loadbalancer) ensureInternalCompanion(ctx context.Context, service *corev1.Service, desiredListeners []iaas.VpcLoadbalancerListener, targetGroups []iaas.VpcLoadbalancerTargetGroup) (*iaas.VpcLoadbalancer, error) {
+	companion, err := lb.fetchInternalCompanion(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+
+	if !lb.hasInternalCompanion(service) {
+		if companion != nil {
+			if err := lb.deleteInternalCompanion(ctx, service, companion); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	if lb.getSharedGroupForService(service) != "" {
+		return nil, fmt.Errorf("the %s and %s annotations of service %s cannot be combined", LoadBalancerAnnotationInternalCompanion, LoadBalancerAnnotationSharedGroup, getServiceKey(service))
+	}
+
+	if companion == nil {
+		if companion, err = lb.createInternalCompanion(ctx, service); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := lb.updateVpcLoadbalancerListener(ctx, service, companion, desiredListeners, targetGroups); err != nil {
+		return nil, fmt.Errorf("failed to update listeners of internal loadbalancer: %v", err)
+	}
+	return companion, nil
+}
+
+// createInternalCompanion creates the internal companion load balancer in the companion subnet, or in the subnet of
+// the public load balancer, and waits until it has an address
+func (lb *loadbalancer) createInternalCompanion(ctx context.Context, service *corev1.Service) (*iaas.VpcLoadbalancer, error) {
+	requestedSubnetIdentity := strings.TrimSpace(service.Annotations[LoadBalancerAnnotationInternalCompanionSubnet])
+	if requestedSubnetIdentity == "" {
+		requestedSubnetIdentity = lb.getSubnetIdentityForService(service)
+	}
+	vpcSubnet, err := lb.findVpcSubnet(ctx, service, requestedSubnetIdentity)
+	if err != nil {
+		return nil, err
+	}
+
+	securityGroups := lb.getSecurityGroupsForService(service)
+	if lb.shouldCreateSecurityGroup(service) {
+		managedSecurityGroup, err := lb.findManagedSecurityGroup(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		if managedSecurityGroup != nil {
+			securityGroups = append(securityGroups, managedSecurityGroup.Identity)
+		}
+	}
+
+	lbName := fmt.Sprintf("%s-internal", lb.GetLoadBalancerName(ctx, lb.cluster, service))
+	klog.Infof("creating internal loadbalancer %q for service %s in subnet %q", lbName, getServiceKey(service), vpcSubnet.Identity)
+	companion, err := lb.iaasClient.CreateLoadbalancer(ctx, iaas.CreateLoadbalancer{
+		Name:                     lbName,
+		Description:              fmt.Sprintf("Internal loadbalancer for Kubernetes service %s", service.GetName()),
+		Labels:                   lb.GetLabelsForInternalCompanion(service),
+		Annotations:              lb.GetAnnotationsForVpcLoadbalancer(service),
+		Subnet:                   vpcSubnet.Identity,
+		InternalLoadbalancer:     true,
+		SecurityGroupAttachments: securityGroups,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create internal loadbalancer: %v", err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, lb.getLoadBalancerCreatePollInterval(), lb.getLoadBalancerCreatePollTimeout(), true, func(ctx context.Context) (bool, error) {
+		if companion.Status == "ready" && len(companion.ExternalIpAddresses) > 0 {
+			return true, nil
+		}
+		current, err := lb.iaasClient.GetLoadbalancer(ctx, companion.Identity)
+		if err != nil {
+			klog.Errorf("Failed to get internal loadbalancer %s: %v", companion.Identity, err)
+			return false, nil
+		}
+		companion = current
+		return companion.Status == "ready" && len(companion.ExternalIpAddresses) > 0, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for internal loadbalancer %s: %v", companion.Identity, err)
+	}
+	return companion, nil
+}
+
+// deleteInternalCompanion deletes the listeners and the internal companion load balancer. The target groups are
+// shared with the public load balancer and left to its cleanup.
+func (lb *loadbalancer) deleteInternalCompanion(ctx context.Context, service *corev1.Service, companion *iaas.VpcLoadbalancer) error {
+	klog.Infof("deleting internal loadbalancer %s of service %s", companion.Identity, getServiceKey(service))
+	if companion.DeleteProtection {
+		lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerDeleteProtected, "Load balancer %s has delete protection enabled, disable it to remove the internal load balancer", companion.Identity)
+		return fmt.Errorf("internal loadbalancer %s of service %s has delete protection enabled", companion.Identity, getServiceKey(service))
+	}
+	return lb.deleteVpcLoadbalancerWithListeners(ctx, companion)
+}
+
+// deleteInternalCompanionOfService deletes the internal companion load balancer of the Service, if it exists
+func (lb *loadbalancer) deleteInternalCompanionOfService(ctx context.Context, service *corev1.Service) error {
+	companion, err := lb.fetchInternalCompanion(ctx, service)
+	if err != nil || companion == nil {
+		return err
+	}
+	return lb.deleteInternalCompanion(ctx, service, companion)
+}
+
+// loadBalancerStatusFor returns the status publishing the addresses of the given load balancers, nil entries are skipped
+func loadBalancerStatusFor(vpcLoadbalancers ...*iaas.VpcLoadbalancer) *corev1.LoadBalancerStatus {
+	loadbalancerStatus := &corev1.LoadBalancerStatus{
+		Ingress: []corev1.LoadBalancerIngress{},
+	}
+	for _, vpcLoadbalancer := range vpcLoadbalancers {
+		if vpcLoadbalancer == nil {
+			continue
+		}
+		for _, ip := range vpcLoadbalancer.ExternalIpAddresses {
+			if ip != "" {
+				loadbalancerStatus.Ingress = append(loadbalancerStatus.Ingress, corev1.LoadBalancerIngress{
+					IP:       ip,
+					Hostname: vpcLoadbalancer.Hostname,
+					IPMode:   ptr.To(corev1.LoadBalancerIPModeProxy),
+				})
+			}
+		}
+	}
+	return loadbalancerStatus
+}
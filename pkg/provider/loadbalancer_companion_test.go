@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCompanionLoadBalancer adds the public load balancer lb-public of a Service requesting an internal companion in
+// the private subnet
+func newTestCompanionLoadBalancer(t *testing.T) (*loadbalancer, *corev1.Service, *fakeIaas) {
+	lb, cloud, _ := newTestLifecycleLoadBalancer(t)
+	service := newTestLifecycleService(map[string]string{
+		LoadBalancerAnnotationInternalCompanion:       "true",
+		LoadBalancerAnnotationInternalCompanionSubnet: "private",
+	})
+	cloud.addLoadbalancer("lb-public", "aweb", lb.GetLabelsForVpcLoadbalancer(service))
+	return lb, service, cloud
+}
+
+// findTestLoadbalancerWithRole returns the load balancer with the role, or nil
+func findTestLoadbalancerWithRole(cloud *fakeIaas, role string) *iaas.VpcLoadbalancer {
+	for _, vpcLoadbalancer := range cloud.loadbalancerList() {
+		if vpcLoadbalancer.Labels[LabelLoadBalancerRole] == role {
+			return &vpcLoadbalancer
+		}
+	}
+	return nil
+}
+
+func TestLoadBalancer_HasInternalCompanion(t *testing.T) {
+	lb := &loadbalancer{}
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{name: "not set", expected: false},
+		{name: "enabled", annotations: map[string]string{LoadBalancerAnnotationInternalCompanion: "true"}, expected: true},
+		{name: "disabled", annotations: map[string]string{LoadBalancerAnnotationInternalCompanion: "false"}, expected: false},
+		{name: "invalid", annotations: map[string]string{LoadBalancerAnnotationInternalCompanion: "yes please"}, expected: false},
+		{
+			name:        "primary is internal",
+			annotations: map[string]string{LoadBalancerAnnotationInternalCompanion: "true", LoadbalancerAnnotationInternal: "true"},
+			expected:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: tt.annotations}}
+			assert.Equal(t, tt.expected, lb.hasInternalCompanion(service))
+		})
+	}
+}
+
+func TestLoadBalancer_EnsureInternalCompanion(t *testing.T) {
+	lb, service, cloud := newTestCompanionLoadBalancer(t)
+	cloud.addTargetGroup("tg-80", "web-80", 30080, lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP"))
+	targetGroups := cloud.targetGroupList()
+
+	companion, err := lb.ensureInternalCompanion(context.Background(), service, lb.desiredVpcLoadbalancerListener(service), targetGroups)
+	require.NoError(t, err)
+	require.NotNil(t, companion)
+
+	created := findTestLoadbalancerWithRole(cloud, LoadBalancerRoleInternalCompanion)
+	require.NotNil(t, created)
+	assert.Equal(t, created.Identity, companion.Identity)
+	assert.Equal(t, "subnet-private", created.SubnetIdentity)
+	assert.True(t, strings.HasPrefix(created.ExternalIpAddresses[0], "10.0.1."), "the companion is internal")
+	assert.True(t, matchLabels(lb.GetLabelsForVpcLoadbalancer(service), created.Labels))
+	assert.Equal(t, []string{
+		"POST " + iaas.LoadbalancerEndpoint,
+		"POST " + iaas.LoadbalancerEndpoint + "/" + created.Identity + "/listeners",
+	}, cloud.requestLog())
+
+	// the public load balancer is still found for the Service
+	primary, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	require.NotNil(t, primary)
+	assert.Equal(t, "lb-public", primary.Identity)
+
+	status, exists, err := lb.GetLoadBalancer(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.Len(t, status.Ingress, 2)
+	assert.Equal(t, primary.ExternalIpAddresses[0], status.Ingress[0].IP)
+	assert.Equal(t, created.ExternalIpAddresses[0], status.Ingress[1].IP)
+
+	// an existing companion is not created again, and its listeners are kept
+	cloud.resetRequestLog()
+	_, err = lb.ensureInternalCompanion(context.Background(), service, lb.desiredVpcLoadbalancerListener(service), targetGroups)
+	require.NoError(t, err)
+	assert.NotContains(t, cloud.requestLog(), "POST "+iaas.LoadbalancerEndpoint)
+	assert.Len(t, cloud.loadbalancerList(), 2)
+	assert.Len(t, cloud.listenerList(), 1)
+}
+
+func TestLoadBalancer_EnsureInternalCompanionDisabled(t *testing.T) {
+	lb, service, cloud := newTestCompanionLoadBalancer(t)
+	cloud.addLoadbalancer("lb-internal", "aweb-internal", lb.GetLabelsForInternalCompanion(service))
+	service.Annotations[LoadBalancerAnnotationInternalCompanion] = "false"
+
+	companion, err := lb.ensureInternalCompanion(context.Background(), service, lb.desiredVpcLoadbalancerListener(service), nil)
+	require.NoError(t, err)
+	assert.Nil(t, companion)
+	assert.Equal(t, []string{"DELETE " + iaas.LoadbalancerEndpoint + "/lb-internal"}, cloud.requestLog())
+	assert.Nil(t, findTestLoadbalancerWithRole(cloud, LoadBalancerRoleInternalCompanion))
+}
+
+func TestLoadBalancer_EnsureInternalCompanionShared(t *testing.T) {
+	lb, service, cloud := newTestCompanionLoadBalancer(t)
+	service.Annotations[LoadBalancerAnnotationSharedGroup] = "web"
+
+	_, err := lb.ensureInternalCompanion(context.Background(), service, lb.desiredVpcLoadbalancerListener(service), nil)
+	assert.Error(t, err)
+	assert.Empty(t, cloud.requestLog())
+}
+
+func TestLoadBalancer_FetchVpcLoadbalancerIgnoresCompanion(t *testing.T) {
+	lb, cloud, _ := newTestLifecycleLoadBalancer(t)
+	service := newTestLifecycleService(map[string]string{LoadBalancerAnnotationInternalCompanion: "true"})
+	cloud.addLoadbalancer("lb-internal", "aweb", lb.GetLabelsForInternalCompanion(service))
+
+	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	assert.Nil(t, vpcLoadbalancer, "the internal companion must not be taken for the public load balancer")
+}
@@ -0,0 +1,536 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// DriftRemediation determines what happens when the drift detection finds cloud resources
+// of a Service that differ from the desired state.
+type DriftRemediation string
+
+const (
+	// DriftRemediationRepair reports the drift and reconciles the Service to restore the desired state.
+	DriftRemediationRepair DriftRemediation = "repair"
+	// DriftRemediationAlert only reports the drift as Event and metric.
+	DriftRemediationAlert DriftRemediation = "alert"
+)
+
+const (
+	// DefaultDriftRemediation is used when neither the Service nor the cloud config specify a remediation.
+	DefaultDriftRemediation = DriftRemediationRepair
+
+	// defaultDriftDetectionInterval is the default interval between two drift detection runs
+	defaultDriftDetectionInterval = 10 * time.Minute
+
+	// maxDriftsInEvent limits the number of drifted resources listed in a single Event message
+	maxDriftsInEvent = 5
+)
+
+// Event reasons emitted by the drift detection
+const (
+	EventReasonLoadBalancerDriftDetected  = "LoadBalancerDriftDetected"
+	EventReasonLoadBalancerDriftRepairing = "LoadBalancerDriftRepairing"
+)
+
+// Resource kinds reported by the drift detection
+const (
+	driftResourceLoadBalancer       = "loadbalancer"
+	driftResourceLoadBalancerConfig = "loadbalancer-config"
+	driftResourceListener           = "listener"
+	driftResourceTargetGroup        = "targetgroup"
+	driftResourceSecurityGroup      = "securitygroup"
+)
+
+// loadBalancerDrift describes a single cloud resource that differs from the desired state
+type loadBalancerDrift struct {
+	resource string
+	message  string
+}
+
+// ParseDriftRemediation parses a drift remediation from the cloud config or a Service annotation
+func ParseDriftRemediation(value string) (DriftRemediation, error) {
+	switch remediation := DriftRemediation(strings.ToLower(strings.TrimSpace(value))); remediation {
+	case DriftRemediationRepair, DriftRemediationAlert:
+		return remediation, nil
+	default:
+		return "", fmt.Errorf("invalid drift remediation %q, must be one of %s, %s", value, DriftRemediationRepair, DriftRemediationAlert)
+	}
+}
+
+// getDriftRemediation returns the drift remediation for the Service. The annotation takes precedence over the cloud config.
+func (lb *loadbalancer) getDriftRemediation(service *corev1.Service) DriftRemediation {
+	if val, ok := service.Annotations[LoadBalancerAnnotationDriftRemediation]; ok {
+		remediation, err := ParseDriftRemediation(val)
+		if err == nil {
+			return remediation
+		}
+		klog.Errorf("invalid drift remediation annotation on service %s/%s: %v", service.Namespace, service.Name, err)
+	}
+	if lb.config.DriftDetection.Remediation != "" {
+		return lb.config.DriftDetection.Remediation
+	}
+	return DefaultDriftRemediation
+}
+
+func (lb *loadbalancer) getDriftDetectionInterval() time.Duration {
+	if lb.config.DriftDetection.Interval == nil || *lb.config.DriftDetection.Interval <= 0 {
+		return defaultDriftDetectionInterval
+	}
+	return time.Duration(*lb.config.DriftDetection.Interval) * time.Second
+}
+
+// startPeriodicDriftDetection compares the cloud state of all managed LoadBalancer Services against
+// their desired state on every interval, until the loadbalancer context is cancelled.
+func (lb *loadbalancer) startPeriodicDriftDetection() {
+	if !lb.config.DriftDetection.Enabled {
+		klog.Infof("load balancer drift detection is disabled")
+		return
+	}
+
+	interval := lb.getDriftDetectionInterval()
+	klog.Infof("starting load balancer drift detection every %s", interval)
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lb.ctx.Done():
+				return
+			case <-ticker.C:
+				lb.detectDriftForAllServices()
+			}
+		}
+	}()
+}
+
+// detectDriftForAllServices runs the drift detection for every managed LoadBalancer Service
+func (lb *loadbalancer) detectDriftForAllServices() {
+	services, err := lb.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list services for drift detection: %v", err)
+		return
+	}
+	nodes, err := lb.nodeLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list nodes for drift detection: %v", err)
+		return
+	}
+	lbNodes := filterLoadBalancerNodes(nodes)
+
+	driftedServices := 0
+	for _, svc := range services {
+		if !isManagedLoadBalancerService(svc) || !lb.shouldHandleService(svc) {
+			continue
+		}
+		if lb.checkReconcilePaused(svc) {
+			klog.V(4).Infof("Reconciliation of service %s is paused, skipping drift detection", getServiceKey(svc))
+			continue
+		}
+		drifts, err := lb.detectServiceDrift(lb.ctx, svc, lbNodes)
+		if err != nil {
+			if lb.ctx.Err() != nil {
+				return
+			}
+			klog.Errorf("Failed to detect drift for service %s: %v", getServiceKey(svc), err)
+			loadBalancerDriftDetectionErrorsTotal.Inc()
+			continue
+		}
+		if len(drifts) == 0 {
+			continue
+		}
+		driftedServices++
+		lb.remediateDrift(svc, drifts)
+	}
+	loadBalancerDriftedServices.Set(float64(driftedServices))
+}
+
+// isManagedLoadBalancerService returns true if the service controller provisioned a load balancer for the Service
+func isManagedLoadBalancerService(service *corev1.Service) bool {
+	if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.DeletionTimestamp != nil {
+		return false
+	}
+	return servicehelper.HasLBFinalizer(service)
+}
+
+// remediateDrift reports the drift of a Service and, depending on the remediation, queues a reconcile to repair it
+func (lb *loadbalancer) remediateDrift(service *corev1.Service, drifts []loadBalancerDrift) {
+	repairable := true
+	for _, drift := range drifts {
+		loadBalancerDriftDetectedTotal.WithLabelValues(drift.resource).Inc()
+		if drift.resource == driftResourceLoadBalancer {
+			repairable = false
+		}
+	}
+
+	remediation := lb.getDriftRemediation(service)
+	loadBalancerDriftRemediationsTotal.WithLabelValues(string(remediation)).Inc()
+
+	serviceKey := getServiceKey(service)
+	summary := summarizeDrift(drifts)
+	klog.Warningf("Load balancer of service %s drifted from the desired state (remediation %q): %s", serviceKey, remediation, summary)
+	lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerDriftDetected, "Load balancer drifted from the desired state: %s", summary)
+
+	if remediation != DriftRemediationRepair {
+		return
+	}
+	if !repairable {
+		klog.Warningf("Load balancer of service %s cannot be repaired by a reconcile, skipping repair", serviceKey)
+		return
+	}
+	lb.eventf(service, corev1.EventTypeNormal, EventReasonLoadBalancerDriftRepairing, "Reconciling %d drifted load balancer resources", len(drifts))
+	lb.triggerServiceResync(serviceKey)
+}
+
+// summarizeDrift returns a short description of the drifted resources for logs and Events
+func summarizeDrift(drifts []loadBalancerDrift) string {
+	messages := make([]string, 0, maxDriftsInEvent)
+	for i, drift := range drifts {
+		if i == maxDriftsInEvent {
+			break
+		}
+		messages = append(messages, drift.message)
+	}
+	summary := strings.Join(messages, "; ")
+	if len(drifts) > maxDriftsInEvent {
+		summary = fmt.Sprintf("%s; and %d more", summary, len(drifts)-maxDriftsInEvent)
+	}
+	return summary
+}
+
+// detectServiceDrift compares the cloud resources of the Service against its desired state.
+// It holds the service lock so an in-flight reconcile is not reported as drift.
+func (lb *loadbalancer) detectServiceDrift(ctx context.Context, service *corev1.Service, nodes []*corev1.Node) ([]loadBalancerDrift, error) {
+	unlock := lb.lockService(service)
+	defer unlock()
+
+	vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(ctx, lb.cluster, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loadbalancer: %v", err)
+	}
+	if vpcLoadbalancer == nil {
+		return []loadBalancerDrift{{
+			resource: driftResourceLoadBalancer,
+			message:  "load balancer not found in the cloud, recreate the Service to provision a new one",
+		}}, nil
+	}
+
+	// the detection is read-only, it must not emit node filter events or update the last known good nodes
+	nodes, err = lb.nodeFilter.Peek(service, nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	desiredListeners := lb.desiredVpcLoadbalancerListener(service)
+	desiredTargetGroups, err := lb.getDesiredVpcLoadbalancerTargetGroups(service, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get desired target groups: %v", err)
+	}
+
+	listeners, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
+		Loadbalancer: vpcLoadbalancer.Identity,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list listeners: %v", err)
+	}
+	targetGroups, err := lb.iaasClient.ListTargetGroups(ctx, &iaas.ListTargetGroupsRequest{
+		Filters: []filters.Filter{
+			&filters.FilterKeyValue{
+				Key:   filters.FilterVpcIdentity,
+				Value: lb.vpcIdentity,
+			},
+			&filters.LabelFilter{
+				MatchLabels: lb.GetLabelsForVpcLoadbalancer(service),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list target groups: %v", err)
+	}
+
+	var managedSecurityGroup *iaas.SecurityGroup
+	if lb.shouldCreateSecurityGroup(service) {
+		managedSecurityGroup, err = lb.findManagedSecurityGroup(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	drifts := lb.detectTargetGroupDrift(desiredTargetGroups, targetGroups, nodes)
+	drifts = append(drifts, lb.detectListenerDrift(service, desiredListeners, listeners, targetGroups)...)
+	drifts = append(drifts, lb.detectVpcLoadbalancerDrift(service, vpcLoadbalancer, managedSecurityGroup)...)
+	if lb.shouldCreateSecurityGroup(service) {
+		drifts = append(drifts, lb.detectManagedSecurityGroupDrift(desiredListeners, managedSecurityGroup)...)
+	}
+	return drifts, nil
+}
+
+// detectVpcLoadbalancerDrift compares the subnet, reserved IP and security group attachments of the load balancer
+func (lb *loadbalancer) detectVpcLoadbalancerDrift(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer, managedSecurityGroup *iaas.SecurityGroup) []loadBalancerDrift {
+	drifts := []loadBalancerDrift{}
+
+	if desiredSubnet := lb.getSubnetIdentityForService(service); desiredSubnet != "" && vpcLoadbalancer.Subnet != nil &&
+		vpcLoadbalancer.Subnet.Identity != desiredSubnet && vpcLoadbalancer.Subnet.Slug != desiredSubnet {
+		drifts = append(drifts, loadBalancerDrift{
+			resource: driftResourceLoadBalancerConfig,
+			message:  fmt.Sprintf("load balancer is in subnet %s instead of %s", vpcLoadbalancer.Subnet.Identity, desiredSubnet),
+		})
+	}
+
+	shared := lb.getSharedGroupForService(service) != ""
+	if desiredReservedIP := lb.getReservedIPIdentityForService(service); desiredReservedIP != vpcLoadbalancer.ReservedIpIdentity && (!shared || desiredReservedIP != "") {
+		drifts = append(drifts, loadBalancerDrift{
+			resource: driftResourceLoadBalancerConfig,
+			message:  fmt.Sprintf("load balancer has reserved IP %q instead of %q", vpcLoadbalancer.ReservedIpIdentity, desiredReservedIP),
+		})
+	}
+
+	if deleteProtection := lb.getDeleteProtection(service); deleteProtection != nil && *deleteProtection != vpcLoadbalancer.DeleteProtection {
+		drifts = append(drifts, loadBalancerDrift{
+			resource: driftResourceLoadBalancerConfig,
+			message:  fmt.Sprintf("load balancer has delete protection %t instead of %t", vpcLoadbalancer.DeleteProtection, *deleteProtection),
+		})
+	}
+
+	desiredSecurityGroups := sets.New(lb.getSecurityGroupsForService(service)...)
+	if managedSecurityGroup != nil {
+		desiredSecurityGroups.Insert(managedSecurityGroup.Identity)
+	}
+	currentSecurityGroups := sets.New[string]()
+	for _, securityGroup := range vpcLoadbalancer.SecurityGroups {
+		currentSecurityGroups.Insert(securityGroup.Identity)
+	}
+	if missing := desiredSecurityGroups.Difference(currentSecurityGroups); missing.Len() > 0 {
+		drifts = append(drifts, loadBalancerDrift{
+			resource: driftResourceSecurityGroup,
+			message:  fmt.Sprintf("security groups %s are not attached to the load balancer", strings.Join(sets.List(missing), ",")),
+		})
+	}
+	// security groups of the other Services on a shared load balancer are expected
+	if unexpected := currentSecurityGroups.Difference(desiredSecurityGroups); unexpected.Len() > 0 && !shared {
+		drifts = append(drifts, loadBalancerDrift{
+			resource: driftResourceSecurityGroup,
+			message:  fmt.Sprintf("unexpected security groups %s are attached to the load balancer", strings.Join(sets.List(unexpected), ",")),
+		})
+	}
+	return drifts
+}
+
+// detectListenerDrift compares the existing listeners of the load balancer against the desired listeners
+func (lb *loadbalancer) detectListenerDrift(service *corev1.Service, desiredListeners []iaas.VpcLoadbalancerListener, existingListeners []iaas.VpcLoadbalancerListener, targetGroups []iaas.VpcLoadbalancerTargetGroup) []loadBalancerDrift {
+	drifts := []loadBalancerDrift{}
+
+	existingListenersPortMap := map[int]iaas.VpcLoadbalancerListener{}
+	for _, listener := range existingListeners {
+		existingListenersPortMap[listener.Port] = listener
+	}
+
+	desiredPorts := map[int]struct{}{}
+	for _, desired := range desiredListeners {
+		desiredPorts[desired.Port] = struct{}{}
+
+		existing, ok := existingListenersPortMap[desired.Port]
+		if !ok {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceListener,
+				message:  fmt.Sprintf("listener for port %d is missing", desired.Port),
+			})
+			continue
+		}
+		if lb.isListenerConflict(service, existing, true) {
+			continue
+		}
+
+		var differences []string
+		if !strings.EqualFold(string(existing.Protocol), string(desired.Protocol)) {
+			differences = append(differences, fmt.Sprintf("protocol %s instead of %s", existing.Protocol, desired.Protocol))
+		}
+		if !sets.New(existing.AllowedSources...).Equal(sets.New(desired.AllowedSources...)) {
+			differences = append(differences, fmt.Sprintf("allowed sources [%s] instead of [%s]", strings.Join(existing.AllowedSources, ","), strings.Join(desired.AllowedSources, ",")))
+		}
+		if ptr.Deref(existing.ConnectionIdleTimeout, 0) != ptr.Deref(desired.ConnectionIdleTimeout, 0) {
+			differences = append(differences, fmt.Sprintf("idle connection timeout %d instead of %d", ptr.Deref(existing.ConnectionIdleTimeout, 0), ptr.Deref(desired.ConnectionIdleTimeout, 0)))
+		}
+		if ptr.Deref(existing.MaxConnections, 0) != ptr.Deref(desired.MaxConnections, 0) {
+			differences = append(differences, fmt.Sprintf("max connections %d instead of %d", ptr.Deref(existing.MaxConnections, 0), ptr.Deref(desired.MaxConnections, 0)))
+		}
+		if targetGroupIdentity := lb.getTargetGroupIdentityForListener(service, desired, targetGroups); targetGroupIdentity != "" {
+			if existing.TargetGroup == nil || existing.TargetGroup.Identity != targetGroupIdentity {
+				differences = append(differences, fmt.Sprintf("not forwarding to target group %s", targetGroupIdentity))
+			}
+		}
+		if len(differences) > 0 {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceListener,
+				message:  fmt.Sprintf("listener for port %d has %s", desired.Port, strings.Join(differences, ", ")),
+			})
+		}
+	}
+
+	for _, existing := range existingListeners {
+		_, ok := desiredPorts[existing.Port]
+		if lb.isSharedGroupMemberListener(service, existing, ok) || lb.isListenerConflict(service, existing, ok) {
+			// conflicting listeners are reported by the reconcile and never changed
+			continue
+		}
+		if !ok {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceListener,
+				message:  fmt.Sprintf("unexpected listener %q for port %d", existing.Name, existing.Port),
+			})
+		}
+	}
+	return drifts
+}
+
+// detectTargetGroupDrift compares the existing target groups of the Service against the desired target groups and nodes
+func (lb *loadbalancer) detectTargetGroupDrift(desiredTargetGroups []iaas.VpcLoadbalancerTargetGroup, existingTargetGroups []iaas.VpcLoadbalancerTargetGroup, nodes []*corev1.Node) []loadBalancerDrift {
+	drifts := []loadBalancerDrift{}
+
+	existingTargetGroupsMap := map[string]iaas.VpcLoadbalancerTargetGroup{}
+	for _, targetGroup := range existingTargetGroups {
+		existingTargetGroupsMap[fmt.Sprintf("%s:%d", targetGroup.Protocol, targetGroup.TargetPort)] = targetGroup
+	}
+
+	desiredTargets := sets.New[string]()
+	for _, node := range nodes {
+		if machineIdentity, ok := getMachineIdentityForNode(node); ok {
+			desiredTargets.Insert(machineIdentity)
+		}
+	}
+
+	desiredKeys := map[string]struct{}{}
+	for _, desired := range desiredTargetGroups {
+		key := fmt.Sprintf("%s:%d", desired.Protocol, desired.TargetPort)
+		desiredKeys[key] = struct{}{}
+
+		existing, ok := existingTargetGroupsMap[key]
+		if !ok {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceTargetGroup,
+				message:  fmt.Sprintf("target group for %s is missing", key),
+			})
+			continue
+		}
+
+		var differences []string
+		if ptr.Deref(existing.EnableProxyProtocol, false) != ptr.Deref(desired.EnableProxyProtocol, false) {
+			differences = append(differences, fmt.Sprintf("proxy protocol %t instead of %t", ptr.Deref(existing.EnableProxyProtocol, false), ptr.Deref(desired.EnableProxyProtocol, false)))
+		}
+		existingPolicy := ptr.Deref(existing.LoadbalancingPolicy, iaas.LoadbalancingPolicyRoundRobin)
+		desiredPolicy := ptr.Deref(desired.LoadbalancingPolicy, iaas.LoadbalancingPolicyRoundRobin)
+		if existingPolicy != desiredPolicy {
+			differences = append(differences, fmt.Sprintf("loadbalancing policy %s instead of %s", existingPolicy, desiredPolicy))
+		}
+		if desired.HealthCheck != nil && !healthCheckEqual(existing.HealthCheck, desired.HealthCheck) {
+			differences = append(differences, "a different health check")
+		}
+
+		if len(desired.TargetSelector) > 0 {
+			// the targets of target groups with a target selector are attached by the API
+			if !maps.Equal(existing.TargetSelector, desired.TargetSelector) {
+				differences = append(differences, fmt.Sprintf("target selector %v instead of %v", existing.TargetSelector, desired.TargetSelector))
+			}
+		} else {
+			currentTargets := sets.New[string]()
+			for _, attachment := range existing.LoadbalancerTargetGroupAttachments {
+				if attachment.VirtualMachineInstance != nil {
+					currentTargets.Insert(attachment.VirtualMachineInstance.Identity)
+				}
+			}
+			if missing := desiredTargets.Difference(currentTargets); missing.Len() > 0 {
+				differences = append(differences, fmt.Sprintf("missing targets %s", strings.Join(sets.List(missing), ",")))
+			}
+			if unexpected := currentTargets.Difference(desiredTargets); unexpected.Len() > 0 {
+				differences = append(differences, fmt.Sprintf("unexpected targets %s", strings.Join(sets.List(unexpected), ",")))
+			}
+		}
+
+		if len(differences) > 0 {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceTargetGroup,
+				message:  fmt.Sprintf("target group %s has %s", existing.Identity, strings.Join(differences, ", ")),
+			})
+		}
+	}
+
+	for _, existing := range existingTargetGroups {
+		key := fmt.Sprintf("%s:%d", existing.Protocol, existing.TargetPort)
+		if _, ok := desiredKeys[key]; !ok {
+			drifts = append(drifts, loadBalancerDrift{
+				resource: driftResourceTargetGroup,
+				message:  fmt.Sprintf("unexpected target group %s for %s", existing.Identity, key),
+			})
+		}
+	}
+	return drifts
+}
+
+func healthCheckEqual(existing, desired *iaas.BackendHealthCheck) bool {
+	if existing == nil || desired == nil {
+		return existing == desired
+	}
+	return strings.EqualFold(string(existing.Protocol), string(desired.Protocol)) &&
+		existing.Port == desired.Port &&
+		existing.Path == desired.Path &&
+		existing.PeriodSeconds == desired.PeriodSeconds &&
+		existing.TimeoutSeconds == desired.TimeoutSeconds &&
+		existing.HealthyThreshold == desired.HealthyThreshold &&
+		existing.UnhealthyThreshold == desired.UnhealthyThreshold
+}
+
+// detectManagedSecurityGroupDrift compares the ingress rules of the managed security group against the listeners
+func (lb *loadbalancer) detectManagedSecurityGroupDrift(desiredListeners []iaas.VpcLoadbalancerListener, managedSecurityGroup *iaas.SecurityGroup) []loadBalancerDrift {
+	if managedSecurityGroup == nil {
+		return []loadBalancerDrift{{
+			resource: driftResourceSecurityGroup,
+			message:  "managed security group is missing",
+		}}
+	}
+
+	desiredRules := sets.New[string]()
+	for _, rule := range lb.buildIngressRulesFromListeners(desiredListeners) {
+		desiredRules.Insert(securityGroupRuleKey(rule))
+	}
+	currentRules := sets.New[string]()
+	for _, rule := range managedSecurityGroup.IngressRules {
+		currentRules.Insert(securityGroupRuleKey(rule))
+	}
+	if desiredRules.Equal(currentRules) {
+		return nil
+	}
+
+	missing := sets.List(desiredRules.Difference(currentRules))
+	unexpected := sets.List(currentRules.Difference(desiredRules))
+	return []loadBalancerDrift{{
+		resource: driftResourceSecurityGroup,
+		message: fmt.Sprintf("managed security group %s has different ingress rules (missing [%s], unexpected [%s])",
+			managedSecurityGroup.Identity, strings.Join(missing, ","), strings.Join(unexpected, ",")),
+	}}
+}
+
+// securityGroupRuleKey returns a comparable representation of the traffic a rule matches
+func securityGroupRuleKey(rule iaas.SecurityGroupRule) string {
+	return fmt.Sprintf("%s/%s/%d-%d/%s/%s", rule.IPVersion, rule.Protocol, rule.PortRangeMin, rule.PortRangeMax, ptr.Deref(rule.RemoteAddress, ""), rule.Policy)
+}
+
+// eventf records an Event on the Service if an event recorder is configured
+func (lb *loadbalancer) eventf(service *corev1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if lb.recorder == nil {
+		return
+	}
+	lb.recorder.Eventf(service, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,418 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDriftService(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "uid-web",
+			Annotations: annotations,
+			Finalizers:  []string{"service.kubernetes.io/load-balancer-cleanup"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+				{Name: "https", Port: 443, NodePort: 30443, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+}
+
+func newTestDriftLoadBalancer() (*loadbalancer, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &loadbalancer{
+		cluster:  "cluster-test",
+		recorder: recorder,
+		serviceQueue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "test-queue"},
+		),
+	}, recorder
+}
+
+// inSyncTargetGroups returns target groups matching the desired state of the service, attached to the given machines
+func inSyncTargetGroups(t *testing.T, lb *loadbalancer, service *corev1.Service, machines ...string) []iaas.VpcLoadbalancerTargetGroup {
+	t.Helper()
+	desired, err := lb.getDesiredVpcLoadbalancerTargetGroups(service, nil)
+	require.NoError(t, err)
+	for i := range desired {
+		desired[i].Identity = fmt.Sprintf("tg-%d", desired[i].TargetPort)
+		for _, machine := range machines {
+			desired[i].LoadbalancerTargetGroupAttachments = append(desired[i].LoadbalancerTargetGroupAttachments, iaas.LoadbalancerTargetGroupAttachment{
+				VirtualMachineInstance: &iaas.Machine{Identity: machine},
+			})
+		}
+	}
+	return desired
+}
+
+// inSyncListeners returns listeners matching the desired state of the service, forwarding to the target groups
+func inSyncListeners(lb *loadbalancer, service *corev1.Service, targetGroups []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerListener {
+	listeners := lb.desiredVpcLoadbalancerListener(service)
+	for i := range listeners {
+		listeners[i].Identity = "listener-" + listeners[i].Name
+		listeners[i].TargetGroup = &iaas.VpcLoadbalancerTargetGroup{
+			Identity: lb.getTargetGroupIdentityForListener(service, listeners[i], targetGroups),
+		}
+	}
+	return listeners
+}
+
+func TestParseDriftRemediation(t *testing.T) {
+	tests := []struct {
+		value       string
+		expected    DriftRemediation
+		expectError bool
+	}{
+		{value: "repair", expected: DriftRemediationRepair},
+		{value: " Alert ", expected: DriftRemediationAlert},
+		{value: "ignore", expectError: true},
+		{value: "", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			remediation, err := ParseDriftRemediation(tt.value)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, remediation)
+		})
+	}
+}
+
+func TestLoadBalancer_GetDriftRemediation(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		config      DriftRemediation
+		expected    DriftRemediation
+	}{
+		{name: "default", expected: DriftRemediationRepair},
+		{name: "cloud config", config: DriftRemediationAlert, expected: DriftRemediationAlert},
+		{
+			name:        "annotation overrides cloud config",
+			annotations: map[string]string{LoadBalancerAnnotationDriftRemediation: "repair"},
+			config:      DriftRemediationAlert,
+			expected:    DriftRemediationRepair,
+		},
+		{
+			name:        "invalid annotation falls back to cloud config",
+			annotations: map[string]string{LoadBalancerAnnotationDriftRemediation: "ignore"},
+			config:      DriftRemediationAlert,
+			expected:    DriftRemediationAlert,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &loadbalancer{config: LoadBalancerConfig{DriftDetection: DriftDetectionConfig{Remediation: tt.config}}}
+			assert.Equal(t, tt.expected, lb.getDriftRemediation(newTestDriftService(tt.annotations)))
+		})
+	}
+}
+
+func TestLoadBalancer_DetectListenerDrift(t *testing.T) {
+	lb, _ := newTestDriftLoadBalancer()
+	service := newTestDriftService(nil)
+	targetGroups := inSyncTargetGroups(t, lb, service)
+	desired := lb.desiredVpcLoadbalancerListener(service)
+
+	tests := []struct {
+		name     string
+		mutate   func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener
+		expected int
+	}{
+		{
+			name:   "in sync",
+			mutate: func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener { return listeners },
+		},
+		{
+			name: "missing listener",
+			mutate: func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener {
+				return listeners[:1]
+			},
+			expected: 1,
+		},
+		{
+			name: "unexpected listener",
+			mutate: func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener {
+				return append(listeners, iaas.VpcLoadbalancerListener{Name: "manual", Port: 8080, Protocol: "tcp"})
+			},
+			expected: 1,
+		},
+		{
+			name: "acl and idle timeout edited in the console",
+			mutate: func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener {
+				listeners[0].AllowedSources = []string{"10.0.0.0/8"}
+				listeners[0].ConnectionIdleTimeout = ptr.To(uint32(30))
+				return listeners
+			},
+			expected: 1,
+		},
+		{
+			name: "listener forwards to another target group",
+			mutate: func(listeners []iaas.VpcLoadbalancerListener) []iaas.VpcLoadbalancerListener {
+				listeners[1].TargetGroup = &iaas.VpcLoadbalancerTargetGroup{Identity: "tg-other"}
+				return listeners
+			},
+			expected: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := tt.mutate(inSyncListeners(lb, service, targetGroups))
+			drifts := lb.detectListenerDrift(service, desired, existing, targetGroups)
+			assert.Len(t, drifts, tt.expected, "drifts: %v", drifts)
+			for _, drift := range drifts {
+				assert.Equal(t, driftResourceListener, drift.resource)
+			}
+		})
+	}
+}
+
+func TestLoadBalancer_DetectTargetGroupDrift(t *testing.T) {
+	lb, _ := newTestDriftLoadBalancer()
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: corev1.NodeSpec{ProviderID: "thalassa://vm-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}, Spec: corev1.NodeSpec{ProviderID: "thalassa://vm-2"}},
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		mutate      func(targetGroups []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup
+		expected    []string
+	}{
+		{
+			name:   "in sync",
+			mutate: func(tgs []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup { return tgs },
+		},
+		{
+			name: "missing target group",
+			mutate: func(tgs []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup {
+				return tgs[1:]
+			},
+			expected: []string{"target group for tcp:30080 is missing"},
+		},
+		{
+			name: "target detached and unknown target attached",
+			mutate: func(tgs []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup {
+				tgs[0].LoadbalancerTargetGroupAttachments = []iaas.LoadbalancerTargetGroupAttachment{
+					{VirtualMachineInstance: &iaas.Machine{Identity: "vm-1"}},
+					{VirtualMachineInstance: &iaas.Machine{Identity: "vm-9"}},
+				}
+				return tgs
+			},
+			expected: []string{"target group tg-30080 has missing targets vm-2, unexpected targets vm-9"},
+		},
+		{
+			name:        "proxy protocol disabled in the console",
+			annotations: map[string]string{LoadbalancerAnnotationEnableProxyProtocol: "true"},
+			mutate: func(tgs []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup {
+				tgs[1].EnableProxyProtocol = ptr.To(false)
+				return tgs
+			},
+			expected: []string{"target group tg-30443 has proxy protocol false instead of true"},
+		},
+		{
+			name: "unexpected target group",
+			mutate: func(tgs []iaas.VpcLoadbalancerTargetGroup) []iaas.VpcLoadbalancerTargetGroup {
+				return append(tgs, iaas.VpcLoadbalancerTargetGroup{Identity: "tg-old", Protocol: "tcp", TargetPort: 31000})
+			},
+			expected: []string{"unexpected target group tg-old for tcp:31000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestDriftService(tt.annotations)
+			desired, err := lb.getDesiredVpcLoadbalancerTargetGroups(service, nodes)
+			require.NoError(t, err)
+			existing := tt.mutate(inSyncTargetGroups(t, lb, service, "vm-1", "vm-2"))
+
+			drifts := lb.detectTargetGroupDrift(desired, existing, nodes)
+			messages := []string{}
+			for _, drift := range drifts {
+				assert.Equal(t, driftResourceTargetGroup, drift.resource)
+				messages = append(messages, drift.message)
+			}
+			if len(tt.expected) == 0 {
+				assert.Empty(t, messages)
+				return
+			}
+			assert.Equal(t, tt.expected, messages)
+		})
+	}
+}
+
+func TestLoadBalancer_DetectVpcLoadbalancerDrift(t *testing.T) {
+	lb, _ := newTestDriftLoadBalancer()
+	lb.defaultSubnet = "subnet-default"
+	service := newTestDriftService(map[string]string{
+		LoadBalancerAnnotationSecurityGroups:      "sg-custom",
+		LoadBalancerAnnotationCreateSecurityGroup: "true",
+	})
+	managed := &iaas.SecurityGroup{Identity: "sg-managed"}
+
+	vpcLoadbalancer := &iaas.VpcLoadbalancer{
+		Subnet:         &iaas.Subnet{Identity: "subnet-default"},
+		SecurityGroups: []iaas.SecurityGroup{{Identity: "sg-custom"}, {Identity: "sg-managed"}},
+	}
+	assert.Empty(t, lb.detectVpcLoadbalancerDrift(service, vpcLoadbalancer, managed))
+
+	vpcLoadbalancer.SecurityGroups = []iaas.SecurityGroup{{Identity: "sg-managed"}, {Identity: "sg-console"}}
+	vpcLoadbalancer.ReservedIpIdentity = "rip-manual"
+	drifts := lb.detectVpcLoadbalancerDrift(service, vpcLoadbalancer, managed)
+	require.Len(t, drifts, 3)
+	assert.Equal(t, driftResourceLoadBalancerConfig, drifts[0].resource)
+	assert.Equal(t, "security groups sg-custom are not attached to the load balancer", drifts[1].message)
+	assert.Equal(t, "unexpected security groups sg-console are attached to the load balancer", drifts[2].message)
+}
+
+func TestLoadBalancer_DetectManagedSecurityGroupDrift(t *testing.T) {
+	lb, _ := newTestDriftLoadBalancer()
+	service := newTestDriftService(map[string]string{LoadbalancerAnnotationAclAllowedSources: "10.0.0.0/8"})
+	listeners := lb.desiredVpcLoadbalancerListener(service)
+
+	managed := &iaas.SecurityGroup{Identity: "sg-managed", IngressRules: lb.buildIngressRulesFromListeners(listeners)}
+	assert.Empty(t, lb.detectManagedSecurityGroupDrift(listeners, managed))
+
+	managed.IngressRules = managed.IngressRules[:1]
+	drifts := lb.detectManagedSecurityGroupDrift(listeners, managed)
+	require.Len(t, drifts, 1)
+	assert.Contains(t, drifts[0].message, "missing [ipv4/tcp/443-443/10.0.0.0/8/allow]")
+
+	drifts = lb.detectManagedSecurityGroupDrift(listeners, nil)
+	require.Len(t, drifts, 1)
+	assert.Equal(t, "managed security group is missing", drifts[0].message)
+}
+
+func TestLoadBalancer_RemediateDrift(t *testing.T) {
+	registerMetrics()
+	drift := []loadBalancerDrift{{resource: driftResourceListener, message: "listener for port 80 is missing"}}
+
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		drifts         []loadBalancerDrift
+		expectEnqueued bool
+		expectEvents   []string
+	}{
+		{
+			name:           "repair",
+			drifts:         drift,
+			expectEnqueued: true,
+			expectEvents: []string{
+				"Warning LoadBalancerDriftDetected Load balancer drifted from the desired state: listener for port 80 is missing",
+				"Normal LoadBalancerDriftRepairing Reconciling 1 drifted load balancer resources",
+			},
+		},
+		{
+			name:        "alert",
+			annotations: map[string]string{LoadBalancerAnnotationDriftRemediation: "alert"},
+			drifts:      drift,
+			expectEvents: []string{
+				"Warning LoadBalancerDriftDetected Load balancer drifted from the desired state: listener for port 80 is missing",
+			},
+		},
+		{
+			name:   "missing load balancer is not repaired",
+			drifts: []loadBalancerDrift{{resource: driftResourceLoadBalancer, message: "load balancer not found"}},
+			expectEvents: []string{
+				"Warning LoadBalancerDriftDetected Load balancer drifted from the desired state: load balancer not found",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, recorder := newTestDriftLoadBalancer()
+			defer lb.serviceQueue.ShutDown()
+			service := newTestDriftService(tt.annotations)
+			remediation := string(lb.getDriftRemediation(service))
+
+			before, err := testutil.GetCounterMetricValue(loadBalancerDriftRemediationsTotal.WithLabelValues(remediation))
+			require.NoError(t, err)
+
+			lb.remediateDrift(service, tt.drifts)
+
+			after, err := testutil.GetCounterMetricValue(loadBalancerDriftRemediationsTotal.WithLabelValues(remediation))
+			require.NoError(t, err)
+			assert.Equal(t, before+1, after)
+
+			if tt.expectEnqueued {
+				assert.Equal(t, 1, lb.serviceQueue.Len())
+				key, _ := lb.serviceQueue.Get()
+				assert.Equal(t, "default/web", key)
+			} else {
+				assert.Equal(t, 0, lb.serviceQueue.Len())
+			}
+
+			events := []string{}
+			for len(recorder.Events) > 0 {
+				select {
+				case event := <-recorder.Events:
+					events = append(events, event)
+				case <-time.After(time.Second):
+					t.Fatal("timed out reading events")
+				}
+			}
+			assert.Equal(t, tt.expectEvents, events)
+		})
+	}
+}
+
+func TestIsManagedLoadBalancerService(t *testing.T) {
+	service := newTestDriftService(nil)
+	assert.True(t, isManagedLoadBalancerService(service))
+
+	pending := service.DeepCopy()
+	pending.Finalizers = nil
+	assert.False(t, isManagedLoadBalancerService(pending), "services not yet provisioned by the service controller are skipped")
+
+	deleting := service.DeepCopy()
+	deleting.DeletionTimestamp = ptr.To(metav1.Now())
+	assert.False(t, isManagedLoadBalancerService(deleting))
+
+	clusterIP := service.DeepCopy()
+	clusterIP.Spec.Type = corev1.ServiceTypeClusterIP
+	assert.False(t, isManagedLoadBalancerService(clusterIP))
+}
+
+func TestSummarizeDrift(t *testing.T) {
+	drifts := make([]loadBalancerDrift, 0, 7)
+	for i := 0; i < 7; i++ {
+		drifts = append(drifts, loadBalancerDrift{resource: driftResourceListener, message: "m"})
+	}
+	assert.Equal(t, "m; m; m; m; m; and 2 more", summarizeDrift(drifts))
+	assert.Equal(t, "m", summarizeDrift(drifts[:1]))
+}
+
+func TestFilterLoadBalancerNodes(t *testing.T) {
+	now := metav1.Now()
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "ready"}, Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "not-ready"}, Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionFalse}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "excluded", Labels: map[string]string{corev1.LabelNodeExcludeBalancers: ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "to-be-deleted"}, Spec: corev1.NodeSpec{Taints: []corev1.Taint{{Key: toBeDeletedTaint, Effect: corev1.TaintEffectNoSchedule}}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "deleting", DeletionTimestamp: &now}},
+	}
+	assert.Equal(t, []string{"ready", "not-ready"}, nodeNames(filterLoadBalancerNodes(nodes)), "the same nodes as the service controller")
+}
@@ -0,0 +1,237 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLifecycleLoadBalancer returns a load balancer implementation against a fake IaaS API with the machines vm-1
+// and vm-2, and the nodes of these machines
+func newTestLifecycleLoadBalancer(t *testing.T) (*loadbalancer, *fakeIaas, []*corev1.Node) {
+	cloud := newFakeIaas(t)
+	cloud.addMachine("vm-1", "worker-1", "10.0.0.11")
+	cloud.addMachine("vm-2", "worker-2", "10.0.0.12")
+	iaasClient := cloud.client()
+	lb := &loadbalancer{
+		iaasClient:   iaasClient,
+		machineIndex: newMachineIndex(iaasClient, "vpc-test", 0),
+		vpcIdentity:  "vpc-test",
+		cluster:      "cluster-test",
+		config: LoadBalancerConfig{
+			CreationPollInterval: ptr.To(1),
+			CreationPollTimeout:  ptr.To(10),
+		},
+		nodeFilter: &NodeFilter{},
+		recorder:   record.NewFakeRecorder(100),
+	}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: getProviderID("vm-1")}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}, Spec: corev1.NodeSpec{ProviderID: getProviderID("vm-2")}},
+	}
+	return lb, cloud, nodes
+}
+
+func newTestLifecycleService(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			UID:         "uid-web",
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+}
+
+// attachedMachines returns the machines attached to the target group
+func attachedMachines(targetGroup iaas.VpcLoadbalancerTargetGroup) []string {
+	machines := []string{}
+	for _, attachment := range targetGroup.LoadbalancerTargetGroupAttachments {
+		machines = append(machines, attachment.VirtualMachineInstance.Identity)
+	}
+	return machines
+}
+
+func TestLoadBalancerLifecycle(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	ctx := context.Background()
+	service := newTestLifecycleService(map[string]string{
+		LoadBalancerAnnotationCreateSecurityGroup: "true",
+		LoadbalancerAnnotationAclAllowedSources:   "10.0.0.0/8",
+	})
+
+	// create
+	status, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1)
+	vpcLoadbalancer := loadbalancers[0]
+	assert.Equal(t, "subnet-public", vpcLoadbalancer.SubnetIdentity)
+	require.Len(t, status.Ingress, 1)
+	assert.Equal(t, vpcLoadbalancer.ExternalIpAddresses[0], status.Ingress[0].IP)
+
+	targetGroups := cloud.targetGroupList()
+	require.Len(t, targetGroups, 1)
+	assert.Equal(t, 30080, targetGroups[0].TargetPort)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, attachedMachines(targetGroups[0]))
+
+	listeners := cloud.listenerList()
+	require.Len(t, listeners, 1)
+	assert.Equal(t, 80, listeners[0].Port)
+	require.NotNil(t, listeners[0].TargetGroup)
+	assert.Equal(t, targetGroups[0].Identity, listeners[0].TargetGroup.Identity)
+
+	securityGroups := cloud.securityGroupList()
+	require.Len(t, securityGroups, 1)
+	require.Len(t, vpcLoadbalancer.SecurityGroups, 1)
+	assert.Equal(t, securityGroups[0].Identity, vpcLoadbalancer.SecurityGroups[0].Identity)
+
+	// reconciling again does not create anything
+	cloud.resetRequestLog()
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	assert.NotContains(t, cloud.requestLog(), "POST "+iaas.LoadbalancerEndpoint)
+	assert.NotContains(t, cloud.requestLog(), "POST "+iaas.TargetGroupEndpoint)
+	assert.Len(t, cloud.loadbalancerList(), 1)
+	assert.Len(t, cloud.targetGroupList(), 1)
+	assert.Len(t, cloud.listenerList(), 1)
+	assert.Len(t, cloud.securityGroupList(), 1)
+
+	// a new NodePort and a removed node
+	updated := service.DeepCopy()
+	updated.Spec.Ports[0].NodePort = 30081
+	require.NoError(t, lb.UpdateLoadBalancer(ctx, "cluster-test", updated, nodes[:1]))
+
+	targetGroups = cloud.targetGroupList()
+	require.Len(t, targetGroups, 1)
+	assert.Equal(t, 30081, targetGroups[0].TargetPort)
+	assert.Equal(t, []string{"vm-1"}, attachedMachines(targetGroups[0]))
+	listeners = cloud.listenerList()
+	require.Len(t, listeners, 1)
+	assert.Equal(t, targetGroups[0].Identity, listeners[0].TargetGroup.Identity)
+
+	// an additional port
+	updated.Spec.Ports = append(updated.Spec.Ports, corev1.ServicePort{Name: "https", Port: 443, NodePort: 30443, Protocol: corev1.ProtocolTCP})
+	require.NoError(t, lb.UpdateLoadBalancer(ctx, "cluster-test", updated, nodes))
+	assert.Len(t, cloud.targetGroupList(), 2)
+	assert.Len(t, cloud.listenerList(), 2)
+
+	// delete
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", updated))
+	assert.Empty(t, cloud.loadbalancerList())
+	assert.Empty(t, cloud.listenerList())
+	assert.Empty(t, cloud.targetGroupList())
+	assert.Empty(t, cloud.securityGroupList())
+
+	// deleting again is a no-op
+	cloud.resetRequestLog()
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", updated))
+	assert.Empty(t, cloud.requestLog())
+}
+
+func TestLoadBalancerLifecycle_ReservedIP(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	cloud.addReservedIP("rip-web", "198.51.100.7")
+	ctx := context.Background()
+	service := newTestLifecycleService(map[string]string{LoadBalancerAnnotationReservedIP: "rip-web"})
+
+	status, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	assert.Equal(t, "198.51.100.7", status.Ingress[0].IP)
+	assert.Equal(t, iaas.ReservedIpStatusAttached, cloud.reservedIP("rip-web").Status)
+
+	// removing the annotation detaches the reserved IP
+	delete(service.Annotations, LoadBalancerAnnotationReservedIP)
+	status, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, iaas.ReservedIpStatusAvailable, cloud.reservedIP("rip-web").Status)
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1)
+	assert.Empty(t, loadbalancers[0].ReservedIpIdentity)
+
+	status, exists, err := lb.GetLoadBalancer(ctx, "cluster-test", service)
+	require.NoError(t, err)
+	assert.True(t, exists)
+	require.Len(t, status.Ingress, 1)
+	assert.NotEqual(t, "198.51.100.7", status.Ingress[0].IP)
+
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+	assert.Empty(t, cloud.loadbalancerList())
+}
+
+func TestLoadBalancerLifecycle_SlowProvisioning(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	cloud.setProvisioningReads(4)
+	service := newTestLifecycleService(nil)
+
+	status, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nodes)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1, "the status is returned once the load balancer is ready")
+	assert.NotEmpty(t, status.Ingress[0].IP)
+}
+
+func TestLoadBalancerLifecycle_Faults(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	ctx := context.Background()
+	service := newTestLifecycleService(nil)
+
+	// a failing listener create fails the reconcile, the next reconcile converges without duplicates
+	cloud.failRequests(http.MethodPost, iaas.LoadbalancerEndpoint+"/*/listeners", http.StatusInternalServerError, 1)
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.Error(t, err)
+	assert.Len(t, cloud.loadbalancerList(), 1)
+	assert.Empty(t, cloud.listenerList())
+
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	assert.Len(t, cloud.loadbalancerList(), 1)
+	assert.Len(t, cloud.targetGroupList(), 1)
+	assert.Len(t, cloud.listenerList(), 1)
+
+	// a failing target group delete fails the delete, the next delete removes everything
+	cloud.failRequests(http.MethodDelete, iaas.TargetGroupEndpoint+"/*", http.StatusServiceUnavailable, 1)
+	require.Error(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+	assert.Empty(t, cloud.loadbalancerList())
+	assert.Empty(t, cloud.targetGroupList())
+
+	// a failing list fails before anything is created
+	cloud.failRequests(http.MethodGet, iaas.LoadbalancerEndpoint, http.StatusInternalServerError, 1)
+	cloud.resetRequestLog()
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.Error(t, err)
+	assert.Empty(t, cloud.requestLog())
+}
+
+func TestLoadBalancerLifecycle_Latency(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	cloud.setLatency(200 * time.Millisecond)
+	service := newTestLifecycleService(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.Error(t, err)
+	assert.Empty(t, cloud.loadbalancerList())
+
+	cloud.setLatency(5 * time.Millisecond)
+	status, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nodes)
+	require.NoError(t, err)
+	assert.Len(t, status.Ingress, 1)
+}
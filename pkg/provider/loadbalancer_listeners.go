@@ -30,6 +30,9 @@ func (lb *loadbalancer) getTargetGroupIdentityForListener(service *corev1.Servic
 	return ""
 }
 
+// updateVpcLoadbalancerListener reconciles the listeners of the load balancer make-before-break: existing listeners are
+// repointed first, then missing listeners are created, and only then listeners on ports the Service no longer uses are
+// deleted. A failure before the deletes leaves the old listeners serving.
 func (lb *loadbalancer) updateVpcLoadbalancerListener(ctx context.Context, service *corev1.Service, loadbalancer *iaas.VpcLoadbalancer, desiredListeners []iaas.VpcLoadbalancerListener, targetGroups []iaas.VpcLoadbalancerTargetGroup) error {
 	existingListenersForLoadBalancer, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
 		Loadbalancer: loadbalancer.Identity,
@@ -48,14 +51,26 @@ func (lb *loadbalancer) updateVpcLoadbalancerListener(ctx context.Context, servi
 		existingListenersPortMap[listener.Port] = listener
 	}
 
+	portConflicts := []int{}
+	listenersToDelete := []iaas.VpcLoadbalancerListener{}
 	if !equality.Semantic.DeepEqual(desiredListeners, existingListenersForLoadBalancer) {
 		// check which listeners to delete
 		for _, listener := range existingListenersForLoadBalancer {
-			if listenerToUpdate, ok := desiredListenersPortMap[listener.Port]; !ok {
-				klog.Infof("deleting listener %q for loadbalancer %q", listener.Name, loadbalancer.Name)
-				if err := lb.iaasClient.DeleteListener(ctx, loadbalancer.Identity, listener.Identity); err != nil {
-					return fmt.Errorf("failed to delete listener: %v", err)
+			listenerToUpdate, ok := desiredListenersPortMap[listener.Port]
+			if lb.isSharedGroupMemberListener(service, listener, ok) {
+				continue
+			}
+			if lb.isListenerConflict(service, listener, ok) {
+				if ok && lb.isOwnedByOtherService(listener.Labels, service) {
+					lb.reportPortConflict(service, loadbalancer, listener)
+					portConflicts = append(portConflicts, listener.Port)
+					continue
 				}
+				lb.reportListenerConflict(service, loadbalancer, listener)
+				continue
+			}
+			if !ok {
+				listenersToDelete = append(listenersToDelete, listener)
 			} else {
 				// TODO: only update the listener if the desired listener is different from the existing listener
 				// make sure the listener is up-to-date
@@ -110,6 +125,17 @@ func (lb *loadbalancer) updateVpcLoadbalancerListener(ctx context.Context, servi
 			}
 		}
 	}
+
+	// delete listeners on ports that are no longer used, after the new listeners are in place
+	for _, listener := range listenersToDelete {
+		klog.Infof("deleting listener %q for loadbalancer %q", listener.Name, loadbalancer.Name)
+		if err := lb.iaasClient.DeleteListener(ctx, loadbalancer.Identity, listener.Identity); err != nil {
+			return fmt.Errorf("failed to delete listener: %v", err)
+		}
+	}
+	if len(portConflicts) > 0 {
+		return fmt.Errorf("ports %v are already used by other services on loadbalancer %s", portConflicts, loadbalancer.Identity)
+	}
 	return nil
 }
 
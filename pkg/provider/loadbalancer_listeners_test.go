@@ -1,9 +1,12 @@
 package provider
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -395,3 +398,129 @@ func TestDesiredVpcLoadbalancerListener_WithPerPortAcl(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadBalancer_PortChangeIsMakeBeforeBreak(t *testing.T) {
+	lb, service, nodes, cloud := newTestOrderingLoadBalancer(t)
+	service.Spec.Ports[0].Port = 8080
+	vpcLoadbalancer, err := cloud.client().GetLoadbalancer(context.Background(), "lb-web")
+	require.NoError(t, err)
+
+	_, err = lb.updateVpcLoadbalancerListenersAndTargetGroups(context.Background(), "cluster-test", service, nodes, vpcLoadbalancer)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"PUT " + iaas.TargetGroupEndpoint + "/tg-old",
+		"POST " + iaas.TargetGroupEndpoint + "/tg-old/attachments",
+		"POST " + iaas.LoadbalancerEndpoint + "/lb-web/listeners",
+		"DELETE " + iaas.LoadbalancerEndpoint + "/lb-web/listeners/listener-80",
+	}, cloud.requestLog(), "the listener on the new port must be created before the old one is deleted")
+	listeners := cloud.listenerList()
+	require.Len(t, listeners, 1)
+	assert.Equal(t, 8080, listeners[0].Port)
+	assert.Equal(t, "tg-old", listeners[0].TargetGroup.Identity, "the target group on the unchanged NodePort is kept")
+}
+
+func TestLoadBalancer_UpdateVpcLoadbalancerListener(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	vpcLoadbalancer := &iaas.VpcLoadbalancer{Identity: "lb-web", Name: "web"}
+	lb := &loadbalancer{vpcIdentity: "vpc-test", cluster: "cluster-test"}
+	targetGroups := []iaas.VpcLoadbalancerTargetGroup{
+		{Identity: "tg-80", Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP")},
+	}
+	existingListener := iaas.VpcLoadbalancerListener{Identity: "listener-80", Port: 80, Protocol: "tcp", Labels: lb.GetLabelsForVpcLoadbalancer(service)}
+	unusedListener := iaas.VpcLoadbalancerListener{Identity: "listener-8080", Port: 8080, Protocol: "tcp", Labels: lb.GetLabelsForVpcLoadbalancer(service)}
+
+	tests := []struct {
+		name          string
+		existing      []iaas.VpcLoadbalancerListener
+		targetGroups  []iaas.VpcLoadbalancerTargetGroup
+		failCreate    bool
+		expectedCalls []string
+		expectError   bool
+	}{
+		{
+			name:          "creates a missing listener",
+			targetGroups:  targetGroups,
+			expectedCalls: []string{"ListListeners", "CreateListener"},
+		},
+		{
+			name:          "updates an existing listener",
+			existing:      []iaas.VpcLoadbalancerListener{existingListener},
+			targetGroups:  targetGroups,
+			expectedCalls: []string{"ListListeners", "UpdateListener"},
+		},
+		{
+			name:          "deletes an unused listener after creating the new one",
+			existing:      []iaas.VpcLoadbalancerListener{unusedListener},
+			targetGroups:  targetGroups,
+			expectedCalls: []string{"ListListeners", "CreateListener", "DeleteListener"},
+		},
+		{
+			name:          "skips a listener without target group",
+			expectedCalls: []string{"ListListeners"},
+		},
+		{
+			name:          "keeps the unused listener when the create fails",
+			existing:      []iaas.VpcLoadbalancerListener{unusedListener},
+			targetGroups:  targetGroups,
+			failCreate:    true,
+			expectedCalls: []string{"ListListeners", "CreateListener"},
+			expectError:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			lb := &loadbalancer{iaasClient: api, vpcIdentity: "vpc-test", cluster: "cluster-test"}
+			listListeners := func(_ context.Context, listRequest *iaas.ListLoadbalancerListenersRequest) ([]iaas.VpcLoadbalancerListener, error) {
+				assert.Equal(t, "lb-web", listRequest.Loadbalancer)
+				return tt.existing, nil
+			}
+			createListener := func(_ context.Context, loadbalancerID string, create iaas.CreateListener) (*iaas.VpcLoadbalancerListener, error) {
+				if tt.failCreate {
+					return nil, fmt.Errorf("unavailable")
+				}
+				assert.Equal(t, "lb-web", loadbalancerID)
+				assert.Equal(t, 80, create.Port)
+				assert.Equal(t, "tg-80", create.TargetGroup)
+				return &iaas.VpcLoadbalancerListener{Identity: "listener-new"}, nil
+			}
+			updateListener := func(_ context.Context, _ string, listenerID string, update iaas.UpdateListener) (*iaas.VpcLoadbalancerListener, error) {
+				assert.Equal(t, "listener-80", listenerID)
+				assert.Equal(t, "tg-80", update.TargetGroup)
+				return &iaas.VpcLoadbalancerListener{Identity: listenerID}, nil
+			}
+			deleteListener := func(_ context.Context, _ string, listenerID string) error {
+				assert.Equal(t, "listener-8080", listenerID)
+				return nil
+			}
+			var calls []any
+			for _, method := range tt.expectedCalls {
+				switch method {
+				case "ListListeners":
+					calls = append(calls, api.EXPECT().ListListeners(gomock.Any(), gomock.Any()).DoAndReturn(listListeners))
+				case "CreateListener":
+					calls = append(calls, api.EXPECT().CreateListener(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(createListener))
+				case "UpdateListener":
+					calls = append(calls, api.EXPECT().UpdateListener(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(updateListener))
+				case "DeleteListener":
+					calls = append(calls, api.EXPECT().DeleteListener(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(deleteListener))
+				}
+			}
+			gomock.InOrder(calls...)
+
+			err := lb.updateVpcLoadbalancerListener(context.Background(), service, vpcLoadbalancer, lb.desiredVpcLoadbalancerListener(service), tt.targetGroups)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
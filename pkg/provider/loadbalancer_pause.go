@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ReconcilePaused is the value of the reconcile annotation that stops the CCM from changing the load balancer of a Service
+	ReconcilePaused = "paused"
+)
+
+// Event reasons emitted when reconciliation of a Service is paused or resumed
+const (
+	EventReasonLoadBalancerReconcilePaused  = "LoadBalancerReconcilePaused"
+	EventReasonLoadBalancerReconcileResumed = "LoadBalancerReconcileResumed"
+)
+
+// isReconcilePaused returns true if the Service asks the CCM not to change its load balancer
+func isReconcilePaused(service *corev1.Service) bool {
+	val, ok := service.Annotations[LoadBalancerAnnotationReconcile]
+	return ok && strings.EqualFold(strings.TrimSpace(val), ReconcilePaused)
+}
+
+// checkReconcilePaused returns true if reconciliation of the Service is paused. Pausing and resuming
+// are reported once as Event on the Service and tracked in the paused services metric.
+func (lb *loadbalancer) checkReconcilePaused(service *corev1.Service) bool {
+	paused := isReconcilePaused(service)
+	serviceKey := getServiceKey(service)
+
+	lb.pausedMu.Lock()
+	defer lb.pausedMu.Unlock()
+	if lb.pausedServices == nil {
+		lb.pausedServices = map[string]struct{}{}
+	}
+
+	_, wasPaused := lb.pausedServices[serviceKey]
+	switch {
+	case paused && !wasPaused:
+		lb.pausedServices[serviceKey] = struct{}{}
+		klog.Warningf("Reconciliation of the load balancer for service %s is paused", serviceKey)
+		lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerReconcilePaused, "Load balancer reconciliation is paused by the %s annotation, changes are not applied", LoadBalancerAnnotationReconcile)
+	case !paused && wasPaused:
+		delete(lb.pausedServices, serviceKey)
+		klog.Infof("Reconciliation of the load balancer for service %s is resumed", serviceKey)
+		lb.eventf(service, corev1.EventTypeNormal, EventReasonLoadBalancerReconcileResumed, "Load balancer reconciliation is resumed")
+	}
+	loadBalancerPausedServices.Set(float64(len(lb.pausedServices)))
+	return paused
+}
+
+// forgetReconcilePaused drops the paused state of a Service that no longer has a load balancer
+func (lb *loadbalancer) forgetReconcilePaused(serviceKey string) {
+	lb.pausedMu.Lock()
+	defer lb.pausedMu.Unlock()
+	delete(lb.pausedServices, serviceKey)
+	loadBalancerPausedServices.Set(float64(len(lb.pausedServices)))
+}
+
+// syncPausedServices recomputes the paused Services from the service lister. Services that were deleted, or whose
+// annotation was removed, without a reconcile touching them are no longer counted as paused.
+func (lb *loadbalancer) syncPausedServices() {
+	services, err := lb.serviceLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list services to sync paused services: %v", err)
+		return
+	}
+	current := map[string]struct{}{}
+	for _, svc := range services {
+		if !lb.shouldHandleService(svc) || svc.DeletionTimestamp != nil {
+			continue
+		}
+		current[getServiceKey(svc)] = struct{}{}
+		lb.checkReconcilePaused(svc)
+	}
+
+	lb.pausedMu.Lock()
+	defer lb.pausedMu.Unlock()
+	for serviceKey := range lb.pausedServices {
+		if _, ok := current[serviceKey]; !ok {
+			delete(lb.pausedServices, serviceKey)
+		}
+	}
+	loadBalancerPausedServices.Set(float64(len(lb.pausedServices)))
+}
+
+// getPausedLoadBalancerStatus returns the current status of the load balancer of a paused Service without changing it
+func (lb *loadbalancer) getPausedLoadBalancerStatus(ctx context.Context, clusterName string, service *corev1.Service) (*corev1.LoadBalancerStatus, error) {
+	status, exists, err := lb.GetLoadBalancer(ctx, clusterName, service)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("reconciliation of service %s is paused and its load balancer does not exist", getServiceKey(service))
+	}
+	return status, nil
+}
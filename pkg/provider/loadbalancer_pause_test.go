@@ -0,0 +1,147 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPausedService(paused bool) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	if paused {
+		service.Annotations = map[string]string{LoadBalancerAnnotationReconcile: ReconcilePaused}
+	}
+	return service
+}
+
+func TestIsReconcilePaused(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{name: "no annotation", expected: false},
+		{name: "paused", annotations: map[string]string{LoadBalancerAnnotationReconcile: "paused"}, expected: true},
+		{name: "paused with different casing", annotations: map[string]string{LoadBalancerAnnotationReconcile: " Paused "}, expected: true},
+		{name: "other value", annotations: map[string]string{LoadBalancerAnnotationReconcile: "enabled"}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			assert.Equal(t, tt.expected, isReconcilePaused(service))
+		})
+	}
+}
+
+func TestLoadBalancer_CheckReconcilePaused(t *testing.T) {
+	registerMetrics()
+	recorder := record.NewFakeRecorder(10)
+	lb := &loadbalancer{recorder: recorder}
+
+	assert.True(t, lb.checkReconcilePaused(newTestPausedService(true)))
+	assert.True(t, lb.checkReconcilePaused(newTestPausedService(true)))
+	paused, err := testutil.GetGaugeMetricValue(loadBalancerPausedServices)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), paused)
+
+	assert.False(t, lb.checkReconcilePaused(newTestPausedService(false)))
+	paused, err = testutil.GetGaugeMetricValue(loadBalancerPausedServices)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), paused)
+
+	// pausing and resuming are reported once
+	require.Len(t, recorder.Events, 2)
+	assert.Contains(t, <-recorder.Events, "Warning LoadBalancerReconcilePaused")
+	assert.Contains(t, <-recorder.Events, "Normal LoadBalancerReconcileResumed")
+}
+
+func TestLoadBalancer_SyncPausedServices(t *testing.T) {
+	registerMetrics()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	recorder := record.NewFakeRecorder(10)
+	lb := &loadbalancer{recorder: recorder, serviceLister: corelisters.NewServiceLister(indexer)}
+
+	paused := newTestPausedService(true)
+	other := newTestPausedService(true)
+	other.Name = "api"
+	require.NoError(t, indexer.Add(paused))
+	require.NoError(t, indexer.Add(other))
+	lb.syncPausedServices()
+	value, err := testutil.GetGaugeMetricValue(loadBalancerPausedServices)
+	require.NoError(t, err)
+	assert.Equal(t, float64(2), value)
+
+	// the annotation is removed from one Service and the other is deleted, without a reconcile
+	require.NoError(t, indexer.Update(newTestPausedService(false)))
+	require.NoError(t, indexer.Delete(other))
+	lb.syncPausedServices()
+	value, err = testutil.GetGaugeMetricValue(loadBalancerPausedServices)
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), value)
+	assert.Empty(t, lb.pausedServices)
+}
+
+func TestLoadBalancer_PausedServiceIsNotMutated(t *testing.T) {
+	lb := &loadbalancer{vpcIdentity: "vpc-test", cluster: "cluster-test", recorder: record.NewFakeRecorder(10)}
+	service := newTestPausedService(true)
+
+	var mu sync.Mutex
+	var mutations []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			mu.Lock()
+			mutations = append(mutations, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == iaas.LoadbalancerEndpoint {
+			_ = json.NewEncoder(w).Encode([]iaas.VpcLoadbalancer{{
+				Identity:            "lb-web",
+				Labels:              lb.GetLabelsForVpcLoadbalancer(service),
+				ExternalIpAddresses: []string{"192.0.2.10"},
+			}})
+			return
+		}
+		_, _ = w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	lb.iaasClient = newCloudAPI(newTestIaasClient(t, server.URL))
+
+	ctx := context.Background()
+	status, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nil)
+	require.NoError(t, err)
+	require.Len(t, status.Ingress, 1)
+	assert.Equal(t, "192.0.2.10", status.Ingress[0].IP)
+
+	assert.NoError(t, lb.UpdateLoadBalancer(ctx, "cluster-test", service, nil))
+	assert.Error(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service), "paused services keep their load balancer")
+	assert.Empty(t, mutations)
+
+	// a paused Service without a load balancer reports an error instead of an empty status
+	other := newTestPausedService(true)
+	other.UID = "uid-other"
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", other, nil)
+	assert.Error(t, err)
+}
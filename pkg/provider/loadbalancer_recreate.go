@@ -0,0 +1,287 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/filters"
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	servicehelper "k8s.io/cloud-provider/service/helpers"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+// Annotations recorded on a load balancer at creation, to detect changes of settings that require recreating it
+const (
+	AnnotationInternalLoadbalancer = "k8s.thalassa.cloud/internal-loadbalancer"
+	AnnotationRecreateToken        = "k8s.thalassa.cloud/recreate-token"
+)
+
+// Roles of the load balancers of a Service while it is recreated
+const (
+	// LoadBalancerRoleReplacement is the role of the new load balancer until it has taken over the Service
+	LoadBalancerRoleReplacement = "replacement"
+	// LoadBalancerRoleReplaced is the role of the old load balancer until the Service status no longer publishes it
+	LoadBalancerRoleReplaced = "replaced"
+)
+
+// Event reasons emitted when the load balancer is recreated
+const (
+	EventReasonLoadBalancerRecreateRequired = "LoadBalancerRecreateRequired"
+	EventReasonLoadBalancerRecreated        = "LoadBalancerRecreated"
+)
+
+// isInternalService returns true if the Service requests an internal load balancer
+func (lb *loadbalancer) isInternalService(service *corev1.Service) bool {
+	internal, _ := strconv.ParseBool(service.Annotations[LoadbalancerAnnotationInternal])
+	return internal
+}
+
+// isInternalVpcLoadbalancer returns true if the load balancer was created internal. Load balancers created before the
+// setting was recorded are assumed to match the Service.
+func (lb *loadbalancer) isInternalVpcLoadbalancer(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) bool {
+	if recorded, err := strconv.ParseBool(vpcLoadbalancer.Annotations[AnnotationInternalLoadbalancer]); err == nil {
+		return recorded
+	}
+	return lb.isInternalService(service)
+}
+
+// getRecreateTokenForService returns the recreate token of the Service, or empty if unset
+func (lb *loadbalancer) getRecreateTokenForService(service *corev1.Service) string {
+	if val, ok := service.Annotations[LoadBalancerAnnotationRecreate]; ok {
+		return strings.TrimSpace(val)
+	}
+	return ""
+}
+
+// hasLoadBalancerRole returns true if the load balancer is not the primary load balancer of a Service, but its
+// internal companion or one side of a recreate
+func hasLoadBalancerRole(vpcLoadbalancer iaas.VpcLoadbalancer) bool {
+	return vpcLoadbalancer.Labels[LabelLoadBalancerRole] != ""
+}
+
+// isReplacedVpcLoadbalancer returns true if the load balancer was replaced by a recreate
+func isReplacedVpcLoadbalancer(vpcLoadbalancer iaas.VpcLoadbalancer) bool {
+	return vpcLoadbalancer.Labels[LabelLoadBalancerRole] == LoadBalancerRoleReplaced
+}
+
+// needsRecreate returns true if the Service requests a recreate token the load balancer was not created with
+func (lb *loadbalancer) needsRecreate(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) bool {
+	token := lb.getRecreateTokenForService(service)
+	return token != "" && vpcLoadbalancer.Annotations[AnnotationRecreateToken] != token
+}
+
+// reportRecreateRequired records that a setting of the Service cannot be applied without recreating the load balancer
+func (lb *loadbalancer) reportRecreateRequired(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer, setting string) {
+	klog.Warningf("%s of loadbalancer %s of service %s cannot be changed without recreating the loadbalancer", setting, vpcLoadbalancer.Identity, getServiceKey(service))
+	lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerRecreateRequired, "The %s of load balancer %s cannot be changed in place, set the %s annotation to a new value to recreate it", setting, vpcLoadbalancer.Identity, LoadBalancerAnnotationRecreate)
+}
+
+// fetchVpcLoadbalancersWithRole returns the load balancers of the Service with the given role
+func (lb *loadbalancer) fetchVpcLoadbalancersWithRole(ctx context.Context, service *corev1.Service, role string) ([]iaas.VpcLoadbalancer, error) {
+	loadbalancersInVpc, err := lb.iaasClient.ListLoadbalancers(ctx, &iaas.ListLoadbalancersRequest{
+		Filters: []filters.Filter{
+			&filters.FilterKeyValue{
+				Key:   "vpc",
+				Value: lb.vpcIdentity,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list loadbalancers: %v", err)
+	}
+
+	labels := lb.GetLabelsForVpcLoadbalancer(service)
+	labels[LabelLoadBalancerRole] = role
+	matching := []iaas.VpcLoadbalancer{}
+	for _, loadbalancer := range loadbalancersInVpc {
+		if matchLabels(labels, loadbalancer.Labels) {
+			matching = append(matching, loadbalancer)
+		}
+	}
+	return matching, nil
+}
+
+// recreateVpcLoadbalancer replaces the load balancer with a new one created with the current settings of the Service.
+// The new load balancer gets listeners on the target groups of the Service and takes over the reserved IP once it is
+// ready. The old load balancer is marked as replaced before the replacement is promoted, so the Service has a single
+// load balancer without role at any time, and it is deleted by deleteReplacedVpcLoadbalancers after the Service status
+// has switched. Every step can be repeated, so a failed recreate continues with the next reconcile, which finds the
+// replaced load balancer while there is no promoted one.
+func (lb *loadbalancer) recreateVpcLoadbalancer(ctx context.Context, service *corev1.Service, current *iaas.VpcLoadbalancer, desiredListeners []iaas.VpcLoadbalancerListener, targetGroups []iaas.VpcLoadbalancerTargetGroup) (*iaas.VpcLoadbalancer, error) {
+	if lb.getSharedGroupForService(service) != "" || lb.getLoadBalancerIDForService(service) != "" {
+		return nil, fmt.Errorf("loadbalancer %s of service %s cannot be recreated: shared and adopted loadbalancers are not recreated", current.Identity, getServiceKey(service))
+	}
+	klog.Infof("recreating loadbalancer %s of service %s", current.Identity, getServiceKey(service))
+
+	replacements, err := lb.fetchVpcLoadbalancersWithRole(ctx, service, LoadBalancerRoleReplacement)
+	if err != nil {
+		return nil, err
+	}
+	var replacement *iaas.VpcLoadbalancer
+	if len(replacements) > 0 {
+		replacement = &replacements[0]
+	} else {
+		createLB, err := lb.getCreateVpcLoadbalancerRequest(ctx, current.Name, service)
+		if err != nil {
+			return nil, err
+		}
+		createLB.Labels[LabelLoadBalancerRole] = LoadBalancerRoleReplacement
+		// the reserved IP moves once the replacement is ready
+		createLB.ReservedIpID = nil
+		if replacement, err = lb.iaasClient.CreateLoadbalancer(ctx, *createLB); err != nil {
+			return nil, fmt.Errorf("failed to create replacement loadbalancer: %v", err)
+		}
+		klog.Infof("created replacement loadbalancer %s for loadbalancer %s of service %s", replacement.Identity, current.Identity, getServiceKey(service))
+	}
+
+	if err := lb.updateVpcLoadbalancerListener(ctx, service, replacement, desiredListeners, targetGroups); err != nil {
+		return nil, fmt.Errorf("failed to update listeners of replacement loadbalancer: %v", err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, lb.getLoadBalancerCreatePollInterval(), lb.getLoadBalancerCreatePollTimeout(), true, func(ctx context.Context) (bool, error) {
+		if replacement.Status == "ready" {
+			return true, nil
+		}
+		vpcLB, err := lb.iaasClient.GetLoadbalancer(ctx, replacement.Identity)
+		if err != nil {
+			klog.Errorf("Failed to get replacement loadbalancer %s: %v", replacement.Identity, err)
+			return false, nil
+		}
+		replacement = vpcLB
+		return replacement.Status == "ready", nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for replacement loadbalancer %s: %v", replacement.Identity, err)
+	}
+
+	// the old load balancer is marked as replaced before the replacement is promoted, and the reserved IP is detached
+	// from it in the same update, as it can only be attached to one load balancer
+	reservedIP := lb.getReservedIPIdentityForService(service)
+	if reservedIP == "" {
+		reservedIP = current.ReservedIpIdentity
+	}
+	labels := iaas.Labels{}
+	for key, val := range current.Labels {
+		labels[key] = val
+	}
+	labels[LabelLoadBalancerRole] = LoadBalancerRoleReplaced
+	var detachReservedIP *string
+	if current.ReservedIpIdentity != "" {
+		detachReservedIP = ptr.To("")
+	}
+	if err := lb.updateVpcLoadbalancerMetadata(ctx, current, labels, current.Annotations, detachReservedIP); err != nil {
+		return nil, fmt.Errorf("failed to mark loadbalancer %s as replaced: %v", current.Identity, err)
+	}
+
+	labels = iaas.Labels{}
+	for key, val := range replacement.Labels {
+		labels[key] = val
+	}
+	delete(labels, LabelLoadBalancerRole)
+	annotations := iaas.Annotations{}
+	for key, val := range replacement.Annotations {
+		annotations[key] = val
+	}
+	annotations[AnnotationRecreateToken] = lb.getRecreateTokenForService(service)
+	var reservedIPID *string
+	if reservedIP != "" {
+		reservedIPID = ptr.To(reservedIP)
+	}
+	if err := lb.updateVpcLoadbalancerMetadata(ctx, replacement, labels, annotations, reservedIPID); err != nil {
+		return nil, fmt.Errorf("failed to promote replacement loadbalancer %s: %v", replacement.Identity, err)
+	}
+
+	lb.eventf(service, corev1.EventTypeNormal, EventReasonLoadBalancerRecreated, "Load balancer %s was replaced by load balancer %s", current.Identity, replacement.Identity)
+
+	promoted, err := lb.iaasClient.GetLoadbalancer(ctx, replacement.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get loadbalancer %s: %v", replacement.Identity, err)
+	}
+	return promoted, nil
+}
+
+// updateVpcLoadbalancerMetadata updates the labels, annotations and reserved IP of a load balancer and keeps its other
+// settings. Delete protection is disabled on a replaced load balancer, the Service requested its deletion.
+func (lb *loadbalancer) updateVpcLoadbalancerMetadata(ctx context.Context, vpcLoadbalancer *iaas.VpcLoadbalancer, labels iaas.Labels, annotations iaas.Annotations, reservedIPID *string) error {
+	securityGroups := make([]string, 0, len(vpcLoadbalancer.SecurityGroups))
+	for _, securityGroup := range vpcLoadbalancer.SecurityGroups {
+		securityGroups = append(securityGroups, securityGroup.Identity)
+	}
+	_, err := lb.iaasClient.UpdateLoadbalancer(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{
+		Name:                     vpcLoadbalancer.Name,
+		Description:              vpcLoadbalancer.Description,
+		Labels:                   labels,
+		Annotations:              annotations,
+		DeleteProtection:         vpcLoadbalancer.DeleteProtection && labels[LabelLoadBalancerRole] != LoadBalancerRoleReplaced,
+		SecurityGroupAttachments: securityGroups,
+		ReservedIpID:             reservedIPID,
+	})
+	return err
+}
+
+// deleteReplacedVpcLoadbalancers switches the Service status to the given status and then deletes the load
+// balancers the Service was moved away from
+func (lb *loadbalancer) deleteReplacedVpcLoadbalancers(ctx context.Context, service *corev1.Service, status *corev1.LoadBalancerStatus) error {
+	replaced, err := lb.fetchVpcLoadbalancersWithRole(ctx, service, LoadBalancerRoleReplaced)
+	if err != nil || len(replaced) == 0 {
+		return err
+	}
+
+	if lb.kubeClient != nil && !servicehelper.LoadBalancerStatusEqual(&service.Status.LoadBalancer, status) {
+		updated := service.DeepCopy()
+		updated.Status.LoadBalancer = *status
+		klog.V(2).Infof("Patching status for service %s before deleting its replaced loadbalancers", getServiceKey(service))
+		if _, err := servicehelper.PatchService(lb.kubeClient.CoreV1(), service, updated); err != nil {
+			return fmt.Errorf("failed to update load balancer status: %v", err)
+		}
+	}
+
+	for i := range replaced {
+		if err := lb.deleteVpcLoadbalancerWithListeners(ctx, &replaced[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVpcLoadbalancersWithRoles deletes the load balancers of the Service left behind by an interrupted recreate
+func (lb *loadbalancer) deleteVpcLoadbalancersWithRoles(ctx context.Context, service *corev1.Service, roles ...string) error {
+	for _, role := range roles {
+		vpcLoadbalancers, err := lb.fetchVpcLoadbalancersWithRole(ctx, service, role)
+		if err != nil {
+			return err
+		}
+		for i := range vpcLoadbalancers {
+			if err := lb.deleteVpcLoadbalancerWithListeners(ctx, &vpcLoadbalancers[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteVpcLoadbalancerWithListeners deletes the listeners and the load balancer. Target groups are left to the
+// cleanup of the Service, as they are shared with its other load balancers.
+func (lb *loadbalancer) deleteVpcLoadbalancerWithListeners(ctx context.Context, vpcLoadbalancer *iaas.VpcLoadbalancer) error {
+	klog.Infof("deleting loadbalancer %s with role %q", vpcLoadbalancer.Identity, vpcLoadbalancer.Labels[LabelLoadBalancerRole])
+	listeners, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
+		Loadbalancer: vpcLoadbalancer.Identity,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list listeners: %v", err)
+	}
+	for _, listener := range listeners {
+		if err := lb.iaasClient.DeleteListener(ctx, vpcLoadbalancer.Identity, listener.Identity); err != nil {
+			return fmt.Errorf("failed to delete listener: %v", err)
+		}
+	}
+	if err := lb.iaasClient.DeleteLoadbalancer(ctx, vpcLoadbalancer.Identity); err != nil {
+		return fmt.Errorf("failed to delete loadbalancer %s: %v", vpcLoadbalancer.Identity, err)
+	}
+	return nil
+}
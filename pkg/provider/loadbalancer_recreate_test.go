@@ -0,0 +1,213 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRecreateLoadBalancer adds the public, delete protected load balancer lb-old of a Service that requests an
+// internal load balancer and a recreate
+func newTestRecreateLoadBalancer(t *testing.T) (*loadbalancer, *corev1.Service, *fakeIaas) {
+	lb, cloud, _ := newTestLifecycleLoadBalancer(t)
+	service := newTestLifecycleService(map[string]string{
+		LoadbalancerAnnotationInternal: "true",
+		LoadBalancerAnnotationRecreate: "2024-06-01",
+	})
+	current := cloud.addLoadbalancer("lb-old", "aweb", lb.GetLabelsForVpcLoadbalancer(service))
+	current.Annotations[AnnotationInternalLoadbalancer] = "false"
+	current.DeleteProtection = true
+	cloud.addTargetGroup("tg-80", "web-80", 30080, lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP"))
+	status := loadBalancerStatusFor(ptr.To(cloud.loadbalancerList()[0]))
+	service.Status.LoadBalancer = *status
+	return lb, service, cloud
+}
+
+// findTestLoadbalancer returns the load balancer with the identity, or nil
+func findTestLoadbalancer(cloud *fakeIaas, identity string) *iaas.VpcLoadbalancer {
+	for _, vpcLoadbalancer := range cloud.loadbalancerList() {
+		if vpcLoadbalancer.Identity == identity {
+			return &vpcLoadbalancer
+		}
+	}
+	return nil
+}
+
+func TestLoadBalancer_NeedsRecreate(t *testing.T) {
+	lb := &loadbalancer{}
+	tests := []struct {
+		name        string
+		token       string
+		lbToken     string
+		expectation bool
+	}{
+		{name: "no token", expectation: false},
+		{name: "new token", token: "1", expectation: true},
+		{name: "changed token", token: "2", lbToken: "1", expectation: true},
+		{name: "same token", token: "1", lbToken: "1", expectation: false},
+		{name: "token removed", lbToken: "1", expectation: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{}}}
+			if tt.token != "" {
+				service.Annotations[LoadBalancerAnnotationRecreate] = tt.token
+			}
+			vpcLoadbalancer := &iaas.VpcLoadbalancer{Annotations: iaas.Annotations{}}
+			if tt.lbToken != "" {
+				vpcLoadbalancer.Annotations[AnnotationRecreateToken] = tt.lbToken
+			}
+			assert.Equal(t, tt.expectation, lb.needsRecreate(service, vpcLoadbalancer))
+		})
+	}
+}
+
+func TestLoadBalancer_RecreateVpcLoadbalancer(t *testing.T) {
+	lb, service, cloud := newTestRecreateLoadBalancer(t)
+	kubeClient := fake.NewSimpleClientset(service)
+	lb.kubeClient = kubeClient
+	targetGroups := cloud.targetGroupList()
+
+	current, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	require.True(t, lb.needsRecreate(service, current))
+
+	recreated, err := lb.recreateVpcLoadbalancer(context.Background(), service, current, lb.desiredVpcLoadbalancerListener(service), targetGroups)
+	require.NoError(t, err)
+	assert.NotEqual(t, "lb-old", recreated.Identity)
+	assert.False(t, lb.needsRecreate(service, recreated))
+
+	assert.Equal(t, []string{
+		"POST " + iaas.LoadbalancerEndpoint,
+		"POST " + iaas.LoadbalancerEndpoint + "/" + recreated.Identity + "/listeners",
+		"PUT " + iaas.LoadbalancerEndpoint + "/lb-old",
+		"PUT " + iaas.LoadbalancerEndpoint + "/" + recreated.Identity,
+	}, cloud.requestLog(), "the old load balancer is marked as replaced before the replacement is promoted")
+
+	replacement, old := findTestLoadbalancer(cloud, recreated.Identity), findTestLoadbalancer(cloud, "lb-old")
+	assert.True(t, strings.HasPrefix(replacement.ExternalIpAddresses[0], "10.0.1."), "the replacement is created with the current settings")
+	assert.Empty(t, replacement.Labels[LabelLoadBalancerRole])
+	assert.Equal(t, "true", replacement.Annotations[AnnotationInternalLoadbalancer])
+	assert.Equal(t, LoadBalancerRoleReplaced, old.Labels[LabelLoadBalancerRole])
+	assert.False(t, old.DeleteProtection, "the replaced load balancer must be deletable")
+
+	found, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	assert.Equal(t, recreated.Identity, found.Identity)
+
+	// the old load balancer is deleted after the Service status is switched
+	cloud.resetRequestLog()
+	status := loadBalancerStatusFor(recreated)
+	require.NoError(t, lb.deleteReplacedVpcLoadbalancers(context.Background(), service, status))
+	assert.Equal(t, []string{"DELETE " + iaas.LoadbalancerEndpoint + "/lb-old"}, cloud.requestLog())
+
+	patched, err := kubeClient.CoreV1().Services("default").Get(context.Background(), "web", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, patched.Status.LoadBalancer.Ingress, 1)
+	assert.Equal(t, replacement.ExternalIpAddresses[0], patched.Status.LoadBalancer.Ingress[0].IP)
+}
+
+func TestLoadBalancer_RecreateVpcLoadbalancerContinues(t *testing.T) {
+	lb, service, cloud := newTestRecreateLoadBalancer(t)
+	replacementLabels := lb.GetLabelsForVpcLoadbalancer(service)
+	replacementLabels[LabelLoadBalancerRole] = LoadBalancerRoleReplacement
+	cloud.addLoadbalancer("lb-new", "aweb", replacementLabels)
+
+	current, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+	require.NoError(t, err)
+	require.Equal(t, "lb-old", current.Identity, "the replacement must not be used before it is promoted")
+
+	recreated, err := lb.recreateVpcLoadbalancer(context.Background(), service, current, lb.desiredVpcLoadbalancerListener(service), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "lb-new", recreated.Identity)
+	assert.NotContains(t, cloud.requestLog(), "POST "+iaas.LoadbalancerEndpoint, "the existing replacement is reused")
+	assert.Len(t, cloud.loadbalancerList(), 2)
+}
+
+func TestLoadBalancer_RecreateSharedVpcLoadbalancer(t *testing.T) {
+	lb, service, cloud := newTestRecreateLoadBalancer(t)
+	service.Annotations[LoadBalancerAnnotationSharedGroup] = "web"
+
+	_, err := lb.recreateVpcLoadbalancer(context.Background(), service, findTestLoadbalancer(cloud, "lb-old"), nil, nil)
+	assert.Error(t, err)
+	assert.Empty(t, cloud.requestLog())
+}
+
+func TestLoadBalancerLifecycle_RecreateFaults(t *testing.T) {
+	tests := []struct {
+		name string
+		// failReplacement fails the promotion of the replacement instead of marking the old load balancer as replaced
+		failReplacement  bool
+		expectedReplaced bool
+	}{
+		{name: "mark replaced fails", failReplacement: false, expectedReplaced: false},
+		{name: "promote replacement fails", failReplacement: true, expectedReplaced: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+			cloud.addReservedIP("rip-web", "198.51.100.7")
+			ctx := context.Background()
+			service := newTestLifecycleService(map[string]string{LoadBalancerAnnotationReservedIP: "rip-web"})
+			_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+			require.NoError(t, err)
+			loadbalancers := cloud.loadbalancerList()
+			require.Len(t, loadbalancers, 1)
+			current := loadbalancers[0].Identity
+
+			// the replacement is the next resource created
+			failed := current
+			if tt.failReplacement {
+				cloud.mu.Lock()
+				failed = fmt.Sprintf("lb-%d", cloud.nextID+1)
+				cloud.mu.Unlock()
+			}
+			cloud.failRequests(http.MethodPut, iaas.LoadbalancerEndpoint+"/"+failed, http.StatusInternalServerError, -1)
+			service.Annotations[LoadBalancerAnnotationRecreate] = "1"
+			_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+			require.Error(t, err)
+
+			loadbalancers = cloud.loadbalancerList()
+			require.Len(t, loadbalancers, 2)
+			for _, vpcLoadbalancer := range loadbalancers {
+				if vpcLoadbalancer.Identity == current {
+					assert.Equal(t, tt.expectedReplaced, isReplacedVpcLoadbalancer(vpcLoadbalancer))
+				} else {
+					assert.Equal(t, LoadBalancerRoleReplacement, vpcLoadbalancer.Labels[LabelLoadBalancerRole], "the replacement is not promoted")
+				}
+			}
+
+			// the interrupted recreate is found and continued by the next reconcile
+			found, err := lb.fetchVpcLoadbalancerFromCloud(ctx, "cluster-test", service)
+			require.NoError(t, err)
+			require.NotNil(t, found)
+			assert.Equal(t, current, found.Identity)
+
+			cloud.mu.Lock()
+			cloud.faults = nil
+			cloud.mu.Unlock()
+			status, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+			require.NoError(t, err)
+			require.Len(t, status.Ingress, 1)
+			assert.Equal(t, "198.51.100.7", status.Ingress[0].IP)
+
+			loadbalancers = cloud.loadbalancerList()
+			require.Len(t, loadbalancers, 1, "the replaced load balancer is deleted")
+			assert.NotEqual(t, current, loadbalancers[0].Identity)
+			assert.Empty(t, loadbalancers[0].Labels[LabelLoadBalancerRole])
+			assert.Equal(t, "rip-web", loadbalancers[0].ReservedIpIdentity)
+			assert.False(t, lb.needsRecreate(service, &loadbalancers[0]))
+		})
+	}
+}
@@ -6,7 +6,8 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 
 	"github.com/stretchr/testify/assert"
@@ -14,35 +15,38 @@ import (
 )
 
 func TestLoadBalancer_EnqueueLocalTrafficPolicyLoadBalancers(t *testing.T) {
-	client := fake.NewSimpleClientset(
-		&corev1.Service{
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, svc := range []*corev1.Service{
+		{
 			ObjectMeta: metav1.ObjectMeta{Name: "lb-local", Namespace: "default"},
 			Spec: corev1.ServiceSpec{
 				Type:                  corev1.ServiceTypeLoadBalancer,
 				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
 			},
 		},
-		&corev1.Service{
+		{
 			ObjectMeta: metav1.ObjectMeta{Name: "lb-cluster", Namespace: "default"},
 			Spec: corev1.ServiceSpec{
 				Type:                  corev1.ServiceTypeLoadBalancer,
 				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
 			},
 		},
-		&corev1.Service{
+		{
 			ObjectMeta: metav1.ObjectMeta{Name: "clusterip-local", Namespace: "default"},
 			Spec: corev1.ServiceSpec{
 				Type:                  corev1.ServiceTypeClusterIP,
 				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
 			},
 		},
-	)
+	} {
+		require.NoError(t, indexer.Add(svc))
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	lb := &loadbalancer{
-		endpointSlicesClient: client,
+		serviceLister: corelisters.NewServiceLister(indexer),
 		serviceQueue: workqueue.NewTypedRateLimitingQueueWithConfig(
 			workqueue.NewTypedItemExponentialFailureRateLimiter[string](0, 0),
 			workqueue.TypedRateLimitingQueueConfig[string]{Name: "test-queue"},
@@ -58,4 +62,3 @@ func TestLoadBalancer_EnqueueLocalTrafficPolicyLoadBalancers(t *testing.T) {
 	assert.Equal(t, "default/lb-local", item)
 	lb.serviceQueue.Done(item)
 }
-
@@ -0,0 +1,161 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// DeletionPolicy determines what happens to the load balancer when its Service is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the load balancer and all its resources with the Service.
+	DeletionPolicyDelete DeletionPolicy = "delete"
+	// DeletionPolicyRetain detaches the load balancer from the cluster and keeps it, including its reserved IP.
+	DeletionPolicyRetain DeletionPolicy = "retain"
+)
+
+// Label and annotations recorded on a retained load balancer, so it can be adopted by a new Service
+const (
+	LabelRetained                 = "k8s.thalassa.cloud/retained"
+	AnnotationRetainedFromCluster = "k8s.thalassa.cloud/retained-from-cluster"
+	AnnotationRetainedFromService = "k8s.thalassa.cloud/retained-from-service"
+	AnnotationRetainedAt          = "k8s.thalassa.cloud/retained-at"
+	AnnotationRetainedReservedIP  = "k8s.thalassa.cloud/retained-reserved-ip"
+)
+
+// Event reasons emitted on Service deletion
+const (
+	EventReasonLoadBalancerRetained = "LoadBalancerRetained"
+	// EventReasonLoadBalancerDeleteProtected is emitted when a protected load balancer blocks the deletion of its Service
+	EventReasonLoadBalancerDeleteProtected = "LoadBalancerDeleteProtected"
+)
+
+// getDeleteProtection returns the delete protection requested by the Service, or nil if the Service does not manage it
+func (lb *loadbalancer) getDeleteProtection(service *corev1.Service) *bool {
+	val, ok := service.Annotations[LoadBalancerAnnotationDeleteProtection]
+	if !ok {
+		return nil
+	}
+	deleteProtection, err := strconv.ParseBool(strings.TrimSpace(val))
+	if err != nil {
+		klog.Errorf("invalid delete protection annotation on service %s: %v", getServiceKey(service), err)
+		return nil
+	}
+	return &deleteProtection
+}
+
+// getDeletionPolicy returns the deletion policy of the Service. It defaults to retain for Services that adopted an
+// existing load balancer by id, which was not created for the Service, and to delete otherwise.
+func (lb *loadbalancer) getDeletionPolicy(service *corev1.Service) DeletionPolicy {
+	defaultPolicy := DeletionPolicyDelete
+	if lb.getLoadBalancerIDForService(service) != "" {
+		defaultPolicy = DeletionPolicyRetain
+	}
+	val, ok := service.Annotations[LoadBalancerAnnotationDeletionPolicy]
+	if !ok {
+		return defaultPolicy
+	}
+	switch policy := DeletionPolicy(strings.ToLower(strings.TrimSpace(val))); policy {
+	case DeletionPolicyDelete, DeletionPolicyRetain:
+		return policy
+	default:
+		klog.Errorf("invalid deletion policy %q on service %s, must be one of %s, %s; using %s", val, getServiceKey(service), DeletionPolicyDelete, DeletionPolicyRetain, defaultPolicy)
+		return defaultPolicy
+	}
+}
+
+// isRetainedVpcLoadbalancer returns true if the load balancer was retained on deletion of its Service
+func isRetainedVpcLoadbalancer(vpcLoadbalancer iaas.VpcLoadbalancer) bool {
+	return vpcLoadbalancer.Labels[LabelRetained] == "true"
+}
+
+// retainVpcLoadbalancer detaches the load balancer from the cluster instead of deleting it. The listeners and target
+// groups of the Service, which forward to the nodes of the cluster, and the managed security group are deleted. Other
+// listeners, e.g. unmanaged listeners of an adopted load balancer, are kept. The load balancer
+// keeps its reserved IP and user provided security groups, loses its ownership labels and records where it came from.
+func (lb *loadbalancer) retainVpcLoadbalancer(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) error {
+	klog.Infof("retaining loadbalancer %s of service %s", vpcLoadbalancer.Identity, getServiceKey(service))
+
+	listeners, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
+		Loadbalancer: vpcLoadbalancer.Identity,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list listeners: %v", err)
+	}
+	for _, listener := range listeners {
+		// listeners not created for the Service, e.g. on an adopted load balancer, are left in place
+		if !lb.isOwnedByService(listener.Labels, service) {
+			continue
+		}
+		klog.Infof("deleting listener %q of retained loadbalancer %q", listener.Name, vpcLoadbalancer.Identity)
+		if err := lb.iaasClient.DeleteListener(ctx, vpcLoadbalancer.Identity, listener.Identity); err != nil {
+			return fmt.Errorf("failed to delete listener: %v", err)
+		}
+	}
+
+	if err := lb.cleanupUnusedTargetGroups(ctx, service, vpcLoadbalancer, nil); err != nil {
+		return fmt.Errorf("failed to cleanup target groups: %v", err)
+	}
+
+	managedSecurityGroup, err := lb.findManagedSecurityGroup(ctx, service)
+	if err != nil {
+		return err
+	}
+	securityGroups := make([]string, 0, len(vpcLoadbalancer.SecurityGroups))
+	for _, securityGroup := range vpcLoadbalancer.SecurityGroups {
+		if managedSecurityGroup != nil && securityGroup.Identity == managedSecurityGroup.Identity {
+			continue
+		}
+		securityGroups = append(securityGroups, securityGroup.Identity)
+	}
+
+	labels := iaas.Labels{}
+	for key, val := range vpcLoadbalancer.Labels {
+		labels[key] = val
+	}
+	for _, key := range ownershipLabels {
+		delete(labels, key)
+	}
+	labels[LabelRetained] = "true"
+
+	annotations := iaas.Annotations{}
+	for key, val := range vpcLoadbalancer.Annotations {
+		annotations[key] = val
+	}
+	annotations[AnnotationRetainedFromCluster] = lb.cluster
+	annotations[AnnotationRetainedFromService] = getServiceKey(service)
+	annotations[AnnotationRetainedAt] = time.Now().UTC().Format(time.RFC3339)
+	if vpcLoadbalancer.ReservedIpIdentity != "" {
+		annotations[AnnotationRetainedReservedIP] = vpcLoadbalancer.ReservedIpIdentity
+	}
+
+	// the security groups are sent even if none is left, so the managed security group is detached before its delete
+	if _, err := lb.iaasClient.UpdateLoadbalancerSecurityGroups(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{
+		Name:                     vpcLoadbalancer.Name,
+		Description:              fmt.Sprintf("Retained loadbalancer of Kubernetes service %s", getServiceKey(service)),
+		Labels:                   labels,
+		Annotations:              annotations,
+		DeleteProtection:         vpcLoadbalancer.DeleteProtection,
+		SecurityGroupAttachments: securityGroups,
+	}); err != nil {
+		return fmt.Errorf("failed to update loadbalancer: %v", err)
+	}
+
+	if managedSecurityGroup != nil {
+		if err := lb.deleteManagedSecurityGroup(ctx, service); err != nil {
+			return err
+		}
+	}
+
+	lb.eventf(service, corev1.EventTypeNormal, EventReasonLoadBalancerRetained, "Load balancer %s was detached from the cluster and retained with its external addresses %s",
+		vpcLoadbalancer.Identity, strings.Join(vpcLoadbalancer.ExternalIpAddresses, ","))
+	return nil
+}
@@ -0,0 +1,210 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRetainLoadBalancer creates the load balancer of the Service with a custom and a managed security group and a
+// reserved IP, and labels it with an additional label
+func newTestRetainLoadBalancer(t *testing.T, annotations map[string]string) (*loadbalancer, *corev1.Service, *fakeIaas, *record.FakeRecorder) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	cloud.addSecurityGroup("sg-custom")
+	cloud.addReservedIP("rip-web", "192.0.2.10")
+	service := newTestLifecycleService(map[string]string{
+		LoadBalancerAnnotationSecurityGroups:      "sg-custom",
+		LoadBalancerAnnotationCreateSecurityGroup: "true",
+		LoadBalancerAnnotationReservedIP:          "rip-web",
+	})
+	for key, val := range annotations {
+		service.Annotations[key] = val
+	}
+	_, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nodes)
+	require.NoError(t, err)
+
+	cloud.mu.Lock()
+	cloud.loadbalancers[0].Labels["team"] = "web"
+	cloud.mu.Unlock()
+	cloud.resetRequestLog()
+	recorder := record.NewFakeRecorder(10)
+	lb.recorder = recorder
+	return lb, service, cloud, recorder
+}
+
+func TestLoadBalancer_GetDeleteProtection(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    *bool
+	}{
+		{name: "not set", expected: nil},
+		{name: "enabled", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "true"}, expected: ptr.To(true)},
+		{name: "disabled", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "false"}, expected: ptr.To(false)},
+		{name: "invalid", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "yes please"}, expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &loadbalancer{}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			assert.Equal(t, tt.expected, lb.getDeleteProtection(service))
+		})
+	}
+}
+
+func TestLoadBalancer_GetDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    DeletionPolicy
+	}{
+		{name: "not set", expected: DeletionPolicyDelete},
+		{name: "retain", annotations: map[string]string{LoadBalancerAnnotationDeletionPolicy: "Retain"}, expected: DeletionPolicyRetain},
+		{name: "delete", annotations: map[string]string{LoadBalancerAnnotationDeletionPolicy: "delete"}, expected: DeletionPolicyDelete},
+		{name: "invalid", annotations: map[string]string{LoadBalancerAnnotationDeletionPolicy: "orphan"}, expected: DeletionPolicyDelete},
+		{name: "adopted", annotations: map[string]string{LoadBalancerAnnotationID: "lb-1"}, expected: DeletionPolicyRetain},
+		{name: "adopted and delete", annotations: map[string]string{LoadBalancerAnnotationID: "lb-1", LoadBalancerAnnotationDeletionPolicy: "delete"}, expected: DeletionPolicyDelete},
+		{name: "adopted and invalid", annotations: map[string]string{LoadBalancerAnnotationID: "lb-1", LoadBalancerAnnotationDeletionPolicy: "orphan"}, expected: DeletionPolicyRetain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &loadbalancer{}
+			service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Annotations: tt.annotations}}
+			assert.Equal(t, tt.expected, lb.getDeletionPolicy(service))
+		})
+	}
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedRetain(t *testing.T) {
+	lb, service, cloud, recorder := newTestRetainLoadBalancer(t, map[string]string{LoadBalancerAnnotationDeletionPolicy: "retain"})
+	vpcLoadbalancer := cloud.loadbalancerList()[0]
+	listener := cloud.listenerList()[0]
+	targetGroup := cloud.targetGroupList()[0]
+	var managedSecurityGroup string
+	for _, securityGroup := range cloud.securityGroupList() {
+		if securityGroup.Identity != "sg-custom" {
+			managedSecurityGroup = securityGroup.Identity
+		}
+	}
+
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(context.Background(), "cluster-test", service))
+
+	assert.Equal(t, []string{
+		"DELETE " + iaas.LoadbalancerEndpoint + "/" + vpcLoadbalancer.Identity + "/listeners/" + listener.Identity,
+		"DELETE " + iaas.TargetGroupEndpoint + "/" + targetGroup.Identity,
+		"PUT " + iaas.LoadbalancerEndpoint + "/" + vpcLoadbalancer.Identity,
+		"DELETE " + iaas.SecurityGroupEndpoint + "/" + managedSecurityGroup,
+	}, cloud.requestLog(), "the load balancer itself must not be deleted")
+
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1)
+	retained := loadbalancers[0]
+	assert.Equal(t, iaas.Labels{"team": "web", LabelRetained: "true"}, retained.Labels, "ownership labels must be removed")
+	assert.Equal(t, "cluster-test", retained.Annotations[AnnotationRetainedFromCluster])
+	assert.Equal(t, "default/web", retained.Annotations[AnnotationRetainedFromService])
+	assert.Equal(t, "rip-web", retained.Annotations[AnnotationRetainedReservedIP])
+	assert.NotEmpty(t, retained.Annotations[AnnotationRetainedAt])
+	assert.Equal(t, "rip-web", retained.ReservedIpIdentity, "the reserved IP must stay attached")
+	require.Len(t, retained.SecurityGroups, 1)
+	assert.Equal(t, "sg-custom", retained.SecurityGroups[0].Identity)
+
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Normal LoadBalancerRetained Load balancer "+vpcLoadbalancer.Identity+" was detached from the cluster")
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedProtected(t *testing.T) {
+	lb, service, cloud, recorder := newTestRetainLoadBalancer(t, map[string]string{LoadBalancerAnnotationDeleteProtection: "true"})
+
+	assert.Error(t, lb.EnsureLoadBalancerDeleted(context.Background(), "cluster-test", service))
+	assert.Empty(t, cloud.requestLog())
+	assert.Len(t, cloud.loadbalancerList(), 1)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning LoadBalancerDeleteProtected")
+}
+
+func TestLoadBalancer_UpdateVpcLoadbalancerDeleteProtection(t *testing.T) {
+	tests := []struct {
+		name           string
+		annotations    map[string]string
+		current        bool
+		expectUpdate   bool
+		expectedResult bool
+	}{
+		{name: "annotation not set keeps protection", current: true, expectUpdate: false, expectedResult: true},
+		{name: "enable protection", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "true"}, expectUpdate: true, expectedResult: true},
+		{name: "disable protection", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "false"}, current: true, expectUpdate: true, expectedResult: false},
+		{name: "already protected", annotations: map[string]string{LoadBalancerAnnotationDeleteProtection: "true"}, current: true, expectUpdate: false, expectedResult: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, service, cloud, _ := newTestRetainLoadBalancer(t, map[string]string{LoadBalancerAnnotationDeleteProtection: strconv.FormatBool(tt.current)})
+			delete(service.Annotations, LoadBalancerAnnotationDeleteProtection)
+			for key, val := range tt.annotations {
+				service.Annotations[key] = val
+			}
+			vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+			require.NoError(t, err)
+			require.Equal(t, tt.current, vpcLoadbalancer.DeleteProtection)
+
+			require.NoError(t, lb.updateVpcLoadbalancer(context.Background(), service, vpcLoadbalancer, nil))
+			update := "PUT " + iaas.LoadbalancerEndpoint + "/" + vpcLoadbalancer.Identity
+			if tt.expectUpdate {
+				assert.Contains(t, cloud.requestLog(), update)
+			} else {
+				assert.NotContains(t, cloud.requestLog(), update)
+			}
+			assert.Equal(t, tt.expectedResult, cloud.loadbalancerList()[0].DeleteProtection)
+		})
+	}
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedRetainDetachesManagedSecurityGroup(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	ctx := context.Background()
+	service := newTestLifecycleService(map[string]string{
+		LoadBalancerAnnotationCreateSecurityGroup: "true",
+		LoadBalancerAnnotationDeletionPolicy:      string(DeletionPolicyRetain),
+	})
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+	require.Len(t, cloud.loadbalancerList()[0].SecurityGroups, 1, "the managed security group is the only security group")
+
+	// a failing delete of the managed security group fails the delete, the next delete removes it
+	cloud.failRequests(http.MethodDelete, iaas.SecurityGroupEndpoint+"/*", http.StatusInternalServerError, 1)
+	require.Error(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", service))
+
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1)
+	assert.True(t, isRetainedVpcLoadbalancer(loadbalancers[0]))
+	assert.Empty(t, loadbalancers[0].SecurityGroups)
+	assert.Empty(t, cloud.securityGroupList())
+}
+
+func TestCloudAPI_UpdateLoadbalancerSecurityGroups(t *testing.T) {
+	cloud := newFakeIaas(t)
+	ctx := context.Background()
+	securityGroup, err := cloud.client().CreateSecurityGroup(ctx, iaas.CreateSecurityGroupRequest{Name: "sg", VpcIdentity: "vpc-test"})
+	require.NoError(t, err)
+	vpcLoadbalancer, err := cloud.client().CreateLoadbalancer(ctx, iaas.CreateLoadbalancer{Name: "lb", Subnet: "subnet-public", SecurityGroupAttachments: []string{securityGroup.Identity}})
+	require.NoError(t, err)
+
+	// the IaaS client omits an empty list, which keeps the security groups
+	_, err = cloud.client().UpdateLoadbalancer(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{Name: "lb", SecurityGroupAttachments: []string{}})
+	require.NoError(t, err)
+	assert.Len(t, cloud.loadbalancerList()[0].SecurityGroups, 1)
+
+	_, err = cloud.client().UpdateLoadbalancerSecurityGroups(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{Name: "lb"})
+	require.NoError(t, err)
+	assert.Empty(t, cloud.loadbalancerList()[0].SecurityGroups)
+}
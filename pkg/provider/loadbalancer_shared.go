@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+// EventReasonLoadBalancerPortConflict is emitted when a port of the Service is used by another Service on a shared load balancer
+const EventReasonLoadBalancerPortConflict = "LoadBalancerPortConflict"
+
+// getSharedGroupForService returns the shared group of the Service, or empty if the Service has its own load balancer
+func (lb *loadbalancer) getSharedGroupForService(service *corev1.Service) string {
+	if val, ok := service.Annotations[LoadBalancerAnnotationSharedGroup]; ok {
+		return strings.TrimSpace(val)
+	}
+	return ""
+}
+
+// getLabelsForVpcLoadbalancerOfService returns the labels identifying the load balancer used by the Service
+func (lb *loadbalancer) getLabelsForVpcLoadbalancerOfService(service *corev1.Service) map[string]string {
+	if group := lb.getSharedGroupForService(service); group != "" {
+		return lb.GetLabelsForSharedVpcLoadbalancer(group)
+	}
+	return lb.GetLabelsForVpcLoadbalancer(service)
+}
+
+// getSharedLoadBalancerName returns the name of the load balancer of a shared group
+func (lb *loadbalancer) getSharedLoadBalancerName(group string) string {
+	return fmt.Sprintf("shared-%s", group)
+}
+
+// lockSharedGroup serializes the reconciles of all Services in the shared group of the Service, so only one of them
+// creates the load balancer and the last one to leave deletes it. The returned function releases the lock.
+func (lb *loadbalancer) lockSharedGroup(service *corev1.Service) func() {
+	group := lb.getSharedGroupForService(service)
+	if group == "" {
+		return func() {}
+	}
+	return lb.sharedGroupLocks.Lock(group)
+}
+
+// isSharedGroupMemberListener returns true if the listener belongs to another Service of the shared group and is on a
+// port the Service does not use. Such listeners are left alone.
+func (lb *loadbalancer) isSharedGroupMemberListener(service *corev1.Service, listener iaas.VpcLoadbalancerListener, desiredPort bool) bool {
+	return !desiredPort && lb.getSharedGroupForService(service) != "" && lb.isOwnedByOtherService(listener.Labels, service)
+}
+
+// reportPortConflict records that a port of the Service is used by a listener of another Service
+func (lb *loadbalancer) reportPortConflict(service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer, listener iaas.VpcLoadbalancerListener) {
+	owner := fmt.Sprintf("%s/%s", listener.Labels[LabelKubernetesServiceNamespace], listener.Labels[LabelKubernetesServiceName])
+	klog.Warningf("port %d of service %s is used by service %s on loadbalancer %q", listener.Port, getServiceKey(service), owner, vpcLoadbalancer.Identity)
+	lb.eventf(service, corev1.EventTypeWarning, EventReasonLoadBalancerPortConflict, "Port %d is already used by Service %s on load balancer %s", listener.Port, owner, vpcLoadbalancer.Identity)
+}
+
+// hasOtherSharedGroupMembers returns true if another LoadBalancer Service of the cluster is in the shared group
+func (lb *loadbalancer) hasOtherSharedGroupMembers(service *corev1.Service, group string) (bool, error) {
+	if lb.serviceLister == nil {
+		return false, nil
+	}
+	services, err := lb.serviceLister.List(labels.Everything())
+	if err != nil {
+		return false, fmt.Errorf("failed to list services: %v", err)
+	}
+	for _, svc := range services {
+		if svc.UID == service.UID || !lb.shouldHandleService(svc) || svc.DeletionTimestamp != nil {
+			continue
+		}
+		if lb.getSharedGroupForService(svc) == group {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// releaseSharedVpcLoadbalancer removes the listeners, target groups and managed security group of the Service from
+// the shared load balancer. It returns true if other Services still use the load balancer, in which case it is kept.
+func (lb *loadbalancer) releaseSharedVpcLoadbalancer(ctx context.Context, service *corev1.Service, vpcLoadbalancer *iaas.VpcLoadbalancer) (bool, error) {
+	group := lb.getSharedGroupForService(service)
+	klog.Infof("removing service %s from shared group %q on loadbalancer %s", getServiceKey(service), group, vpcLoadbalancer.Identity)
+
+	listeners, err := lb.iaasClient.ListListeners(ctx, &iaas.ListLoadbalancerListenersRequest{
+		Loadbalancer: vpcLoadbalancer.Identity,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to list listeners: %v", err)
+	}
+	inUse := false
+	for _, listener := range listeners {
+		if !lb.isOwnedByService(listener.Labels, service) {
+			if listener.Labels[LabelKubernetesCluster] == lb.cluster {
+				inUse = true
+			}
+			continue
+		}
+		klog.Infof("deleting listener %q of service %s from shared loadbalancer %q", listener.Name, getServiceKey(service), vpcLoadbalancer.Identity)
+		if err := lb.iaasClient.DeleteListener(ctx, vpcLoadbalancer.Identity, listener.Identity); err != nil {
+			return false, fmt.Errorf("failed to delete listener: %v", err)
+		}
+	}
+
+	if err := lb.cleanupUnusedTargetGroups(ctx, service, vpcLoadbalancer, nil); err != nil {
+		return false, fmt.Errorf("failed to cleanup target groups: %v", err)
+	}
+
+	if !inUse {
+		if inUse, err = lb.hasOtherSharedGroupMembers(service, group); err != nil {
+			return false, err
+		}
+	}
+	if !inUse {
+		// the last member deletes or retains the load balancer, including the managed security group
+		return false, nil
+	}
+
+	managedSecurityGroup, err := lb.findManagedSecurityGroup(ctx, service)
+	if err != nil {
+		return false, err
+	}
+	if managedSecurityGroup != nil {
+		securityGroups := make([]string, 0, len(vpcLoadbalancer.SecurityGroups))
+		for _, securityGroup := range vpcLoadbalancer.SecurityGroups {
+			if securityGroup.Identity != managedSecurityGroup.Identity {
+				securityGroups = append(securityGroups, securityGroup.Identity)
+			}
+		}
+		// the security groups are sent even if none is left, so the managed security group is detached before its delete
+		if _, err := lb.iaasClient.UpdateLoadbalancerSecurityGroups(ctx, vpcLoadbalancer.Identity, iaas.UpdateLoadbalancer{
+			Name:                     vpcLoadbalancer.Name,
+			Description:              vpcLoadbalancer.Description,
+			Labels:                   vpcLoadbalancer.Labels,
+			Annotations:              vpcLoadbalancer.Annotations,
+			DeleteProtection:         vpcLoadbalancer.DeleteProtection,
+			SecurityGroupAttachments: securityGroups,
+		}); err != nil {
+			return false, fmt.Errorf("failed to detach managed security group: %v", err)
+		}
+		if err := lb.deleteManagedSecurityGroup(ctx, service); err != nil {
+			return false, err
+		}
+	}
+
+	klog.Infof("shared loadbalancer %s is still used by other services of group %q, keeping it", vpcLoadbalancer.Identity, group)
+	return true, nil
+}
+
+// mergeSharedSecurityGroups returns the current security groups of a shared load balancer with the desired security
+// groups of the Service added. Security groups of other members are never detached.
+func mergeSharedSecurityGroups(current []string, desired []string) []string {
+	merged := append([]string{}, current...)
+	for _, securityGroup := range desired {
+		found := false
+		for _, existing := range merged {
+			if existing == securityGroup {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, securityGroup)
+		}
+	}
+	return merged
+}
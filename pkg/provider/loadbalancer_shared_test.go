@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSharedService(name string, group string, ports ...int32) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	if group != "" {
+		service.Annotations = map[string]string{LoadBalancerAnnotationSharedGroup: group}
+	}
+	for _, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{Port: port, NodePort: 30000 + port, Protocol: corev1.ProtocolTCP})
+	}
+	return service
+}
+
+// newTestSharedLoadBalancer adds the load balancer lb-shared of shared group web, the services are listed by the
+// service lister
+func newTestSharedLoadBalancer(t *testing.T, services ...*corev1.Service) (*loadbalancer, *fakeIaas, *record.FakeRecorder) {
+	lb, cloud, _ := newTestLifecycleLoadBalancer(t)
+	recorder := record.NewFakeRecorder(10)
+	lb.recorder = recorder
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, service := range services {
+		require.NoError(t, indexer.Add(service))
+	}
+	lb.serviceLister = corelisters.NewServiceLister(indexer)
+	cloud.addLoadbalancer("lb-shared", "shared-web", lb.GetLabelsForSharedVpcLoadbalancer("web"))
+	return lb, cloud, recorder
+}
+
+func TestLoadBalancer_GetLabelsForVpcLoadbalancerOfService(t *testing.T) {
+	lb := &loadbalancer{cluster: "cluster-test", additionalLabels: map[string]string{"env": "test"}}
+
+	shared := lb.getLabelsForVpcLoadbalancerOfService(newTestSharedService("a", "web"))
+	assert.Equal(t, map[string]string{
+		LabelKubernetesCluster:    "cluster-test",
+		LabelCloudProviderManaged: "true",
+		LabelSharedGroup:          "web",
+		"env":                     "test",
+	}, shared, "a shared load balancer must not carry the labels of a single Service")
+
+	service := newTestSharedService("a", "")
+	assert.Equal(t, lb.GetLabelsForVpcLoadbalancer(service), lb.getLabelsForVpcLoadbalancerOfService(service))
+}
+
+func TestLoadBalancer_FetchSharedVpcLoadbalancer(t *testing.T) {
+	tests := []struct {
+		name        string
+		service     *corev1.Service
+		expectFound bool
+	}{
+		{name: "member of the group", service: newTestSharedService("a", "web", 80), expectFound: true},
+		{name: "other member of the group", service: newTestSharedService("b", " web ", 443), expectFound: true},
+		{name: "member of another group", service: newTestSharedService("c", "api", 80), expectFound: false},
+		{name: "not shared", service: newTestSharedService("shared-web", "", 80), expectFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb, _, _ := newTestSharedLoadBalancer(t)
+			vpcLoadbalancer, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", tt.service)
+			require.NoError(t, err)
+			if tt.expectFound {
+				require.NotNil(t, vpcLoadbalancer)
+				assert.Equal(t, "lb-shared", vpcLoadbalancer.Identity)
+			} else {
+				assert.Nil(t, vpcLoadbalancer)
+			}
+		})
+	}
+
+	t.Run("combined with id annotation", func(t *testing.T) {
+		lb, _, _ := newTestSharedLoadBalancer(t)
+		service := newTestSharedService("a", "web", 80)
+		service.Annotations[LoadBalancerAnnotationID] = "lb-shared"
+		_, err := lb.fetchVpcLoadbalancerFromCloud(context.Background(), "cluster-test", service)
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadBalancer_UpdateVpcLoadbalancerListenerShared(t *testing.T) {
+	a := newTestSharedService("a", "web", 80, 443)
+	b := newTestSharedService("b", "web", 443, 8443)
+	lb, cloud, recorder := newTestSharedLoadBalancer(t, a, b)
+	cloud.addLoadbalancerListener("lb-shared", "listener-b-443", iaas.CreateListener{Name: "b-443", Port: 443, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(b, 443, "TCP")})
+	cloud.addLoadbalancerListener("lb-shared", "listener-b-8443", iaas.CreateListener{Name: "b-8443", Port: 8443, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(b, 8443, "TCP")})
+	cloud.addLoadbalancerListener("lb-shared", "listener-a-9090", iaas.CreateListener{Name: "a-9090", Port: 9090, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(a, 9090, "TCP")})
+	cloud.addTargetGroup("tg-a-80", "a-80", 30080, lb.GetLabelsForVpcLoadbalancerTargetGroup(a, 80, "TCP"))
+	cloud.addTargetGroup("tg-a-443", "a-443", 30443, lb.GetLabelsForVpcLoadbalancerTargetGroup(a, 443, "TCP"))
+	targetGroups := cloud.targetGroupList()
+	vpcLoadbalancer, err := cloud.client().GetLoadbalancer(context.Background(), "lb-shared")
+	require.NoError(t, err)
+
+	err = lb.updateVpcLoadbalancerListener(context.Background(), a, vpcLoadbalancer, lb.desiredVpcLoadbalancerListener(a), targetGroups)
+	assert.ErrorContains(t, err, "ports [443] are already used")
+
+	assert.Equal(t, []string{
+		"POST " + iaas.LoadbalancerEndpoint + "/lb-shared/listeners",
+		"DELETE " + iaas.LoadbalancerEndpoint + "/lb-shared/listeners/listener-a-9090",
+	}, cloud.requestLog(), "listeners of other members must never be changed")
+	ports := []int{}
+	for _, listener := range cloud.listenerList() {
+		ports = append(ports, listener.Port)
+	}
+	assert.ElementsMatch(t, []int{80, 443, 8443}, ports)
+
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "Warning LoadBalancerPortConflict Port 443 is already used by Service default/b")
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedShared(t *testing.T) {
+	a := newTestSharedService("a", "web", 80)
+	b := newTestSharedService("b", "web", 443)
+
+	tests := []struct {
+		name             string
+		otherListener    bool
+		otherService     bool
+		expectLBDeletion bool
+	}{
+		{name: "other member has listeners", otherListener: true, otherService: true},
+		{name: "other member has no listeners yet", otherService: true},
+		{name: "last member", expectLBDeletion: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := []*corev1.Service{a}
+			if tt.otherService {
+				services = append(services, b)
+			}
+			lb, cloud, _ := newTestSharedLoadBalancer(t, services...)
+			cloud.addTargetGroup("tg-a-80", "a-80", 30080, lb.GetLabelsForVpcLoadbalancerTargetGroup(a, 80, "TCP"))
+			cloud.addLoadbalancerListener("lb-shared", "listener-a-80", iaas.CreateListener{Port: 80, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(a, 80, "TCP"), TargetGroup: "tg-a-80"})
+			if tt.otherListener {
+				cloud.addLoadbalancerListener("lb-shared", "listener-b-443", iaas.CreateListener{Port: 443, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(b, 443, "TCP")})
+			}
+
+			require.NoError(t, lb.EnsureLoadBalancerDeleted(context.Background(), "cluster-test", a))
+
+			requests := cloud.requestLog()
+			require.GreaterOrEqual(t, len(requests), 2)
+			assert.Equal(t, "DELETE "+iaas.LoadbalancerEndpoint+"/lb-shared/listeners/listener-a-80", requests[0])
+			assert.Equal(t, "DELETE "+iaas.TargetGroupEndpoint+"/tg-a-80", requests[1])
+			deleted := false
+			for _, request := range requests {
+				assert.False(t, strings.Contains(request, "listener-b"), "listeners of other members must be kept")
+				if request == "DELETE "+iaas.LoadbalancerEndpoint+"/lb-shared" {
+					deleted = true
+				}
+			}
+			assert.Equal(t, tt.expectLBDeletion, deleted)
+			assert.Equal(t, !tt.expectLBDeletion, len(cloud.loadbalancerList()) == 1)
+		})
+	}
+}
+
+func TestMergeSharedSecurityGroups(t *testing.T) {
+	assert.Equal(t, []string{"sg-b", "sg-a"}, mergeSharedSecurityGroups([]string{"sg-b"}, []string{"sg-a", "sg-b"}))
+	assert.Equal(t, []string{"sg-b"}, mergeSharedSecurityGroups([]string{"sg-b"}, nil), "security groups of other members are kept")
+}
+
+func TestLoadBalancer_EnsureLoadBalancerDeletedSharedDetachesManagedSecurityGroup(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	ctx := context.Background()
+	a := newTestSharedService("a", "web", 80)
+	a.Annotations[LoadBalancerAnnotationCreateSecurityGroup] = "true"
+	b := newTestSharedService("b", "web", 443)
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(a))
+	require.NoError(t, indexer.Add(b))
+	lb.serviceLister = corelisters.NewServiceLister(indexer)
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", a, nodes)
+	require.NoError(t, err)
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", b, nodes)
+	require.NoError(t, err)
+	require.Len(t, cloud.loadbalancerList(), 1)
+	require.Len(t, cloud.loadbalancerList()[0].SecurityGroups, 1, "the managed security group of a is the only security group")
+
+	// a failing delete of the managed security group fails the delete, the next delete removes it
+	cloud.failRequests(http.MethodDelete, iaas.SecurityGroupEndpoint+"/*", http.StatusInternalServerError, 1)
+	require.Error(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", a))
+	require.NoError(t, lb.EnsureLoadBalancerDeleted(ctx, "cluster-test", a))
+
+	loadbalancers := cloud.loadbalancerList()
+	require.Len(t, loadbalancers, 1, "b still uses the shared load balancer")
+	assert.Empty(t, loadbalancers[0].SecurityGroups)
+	assert.Empty(t, cloud.securityGroupList())
+	assert.Len(t, cloud.listenerList(), 1)
+}
@@ -79,6 +79,7 @@ func (l *loadbalancer) getDesiredVpcLoadbalancerTargetGroups(service *corev1.Ser
 			Labels:              l.GetLabelsForVpcLoadbalancerTargetGroup(service, int(svcPort.Port), string(svcPort.Protocol)),
 			EnableProxyProtocol: ptr.To(enableProxyProtocol),
 			LoadbalancingPolicy: &loadbalancingPolicy,
+			TargetSelector:      l.getTargetSelector(service),
 
 			// EnableHealthCheck: service.Spec.HealthCheckNodePort > 0, // TODO: implement health check
 			// EnableStickySessions: enableStickySessions,
@@ -187,6 +188,13 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 	klog.Infof("existing target groups: %d", len(existingTargetGroups))
 	klog.Infof("desired target groups: %d", len(desiredTargetGroups))
 
+	// with target selectors the API attaches the labeled machines, the machines of the nodes only need their labels
+	if l.config.TargetSelector.Enabled {
+		if err := l.labelNodeMachines(ctx, nodes); err != nil {
+			return nil, err
+		}
+	}
+
 	// create missing target groups
 	for _, targetGroup := range desiredTargetGroups {
 		if _, ok := existingTargetGroupsMap[fmt.Sprintf("%s:%d", targetGroup.Protocol, targetGroup.TargetPort)]; !ok {
@@ -202,6 +210,7 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 				HealthCheck:         targetGroup.HealthCheck,
 				EnableProxyProtocol: targetGroup.EnableProxyProtocol,
 				LoadbalancingPolicy: targetGroup.LoadbalancingPolicy,
+				TargetSelector:      targetGroup.TargetSelector,
 			})
 			if err != nil {
 				return nil, fmt.Errorf("failed to create target group: %v", err)
@@ -212,6 +221,9 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 			klog.Infof("created target group %q", created.Identity)
 
 			tgs = append(tgs, *created)
+			if len(created.TargetSelector) > 0 {
+				continue
+			}
 			if err := l.upgradeTargetGroupAttachments(ctx, *created, nodes); err != nil {
 				return nil, fmt.Errorf("failed to upgrade target group attachments: %v", err)
 			}
@@ -233,7 +245,12 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 		}
 
 		klog.Infof("updating target group %q", targetGroup.Name)
-		updated, err := l.iaasClient.UpdateTargetGroup(ctx, iaas.UpdateTargetGroupRequest{
+		updateTargetGroup := l.iaasClient.UpdateTargetGroup
+		if len(targetGroup.TargetSelector) > 0 && len(desiredTargetGroup.TargetSelector) == 0 {
+			// target selectors were disabled, the selector must be removed explicitly before attaching the nodes
+			updateTargetGroup = l.iaasClient.UpdateTargetGroupSelector
+		}
+		updated, err := updateTargetGroup(ctx, iaas.UpdateTargetGroupRequest{
 			Identity: targetGroup.Identity,
 			UpdateTargetGroup: iaas.UpdateTargetGroup{
 				Name:                desiredTargetGroup.Name,
@@ -245,6 +262,7 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 				HealthCheck:         desiredTargetGroup.HealthCheck,
 				EnableProxyProtocol: desiredTargetGroup.EnableProxyProtocol,
 				LoadbalancingPolicy: desiredTargetGroup.LoadbalancingPolicy,
+				TargetSelector:      desiredTargetGroup.TargetSelector,
 			},
 		})
 		if err != nil {
@@ -255,6 +273,15 @@ func (l *loadbalancer) createOrUpdateTargetGroups(ctx context.Context, service *
 		}
 		tgs = append(tgs, *updated)
 		klog.Infof("updated target group %s", updated.Identity)
+		if len(desiredTargetGroup.TargetSelector) > 0 {
+			if len(targetGroup.TargetSelector) == 0 {
+				// target selectors were enabled, the nodes attached explicitly before are detached
+				if err := l.clearTargetGroupAttachments(ctx, *updated); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
 		if err := l.upgradeTargetGroupAttachments(ctx, *updated, nodes); err != nil {
 			return nil, fmt.Errorf("failed to upgrade target group attachments: %v", err)
 		}
@@ -270,16 +297,10 @@ func (l *loadbalancer) upgradeTargetGroupAttachments(ctx context.Context, target
 
 	attachments := []iaas.AttachTarget{}
 	for _, node := range nodes {
-		providerId := node.Spec.ProviderID
-		if providerId == "" {
-			continue
-		}
-		providerIdParts := strings.Split(providerId, "://")
-		if len(providerIdParts) != 2 {
-			klog.Infof("failed to get provider ID for node %s", node.Name)
+		machineIdentity, ok := getMachineIdentityForNode(node)
+		if !ok {
 			continue
 		}
-		machineIdentity := providerIdParts[1]
 
 		attachments = append(attachments, iaas.AttachTarget{
 			ServerIdentity: machineIdentity,
@@ -296,3 +317,29 @@ func (l *loadbalancer) upgradeTargetGroupAttachments(ctx context.Context, target
 	}
 	return nil
 }
+
+// clearTargetGroupAttachments detaches the machines attached explicitly to the target group
+func (l *loadbalancer) clearTargetGroupAttachments(ctx context.Context, targetGroup iaas.VpcLoadbalancerTargetGroup) error {
+	klog.Infof("detaching the explicitly attached nodes from target group %s", targetGroup.Identity)
+	if err := l.iaasClient.SetTargetGroupServerAttachments(ctx, iaas.TargetGroupAttachmentsBatch{
+		TargetGroupID: targetGroup.Identity,
+		Attachments:   []iaas.AttachTarget{},
+	}); err != nil {
+		return fmt.Errorf("failed to clear target group attachments: %v", err)
+	}
+	return nil
+}
+
+// getMachineIdentityForNode returns the machine identity from the provider ID of the node
+func getMachineIdentityForNode(node *corev1.Node) (string, bool) {
+	providerId := node.Spec.ProviderID
+	if providerId == "" {
+		return "", false
+	}
+	providerIdParts := strings.Split(providerId, "://")
+	if len(providerIdParts) != 2 {
+		klog.Infof("failed to get provider ID for node %s", node.Name)
+		return "", false
+	}
+	return providerIdParts[1], true
+}
@@ -1,10 +1,14 @@
 package provider
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/ptr"
@@ -472,3 +476,154 @@ func TestGetDesiredVpcLoadbalancerTargetGroups(t *testing.T) {
 		})
 	}
 }
+
+// newTestOrderingLoadBalancer returns a load balancer serving port 80 of the Service on NodePort 30080 of vm-1
+func newTestOrderingLoadBalancer(t *testing.T) (*loadbalancer, *corev1.Service, []*corev1.Node, *fakeIaas) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	service := newTestLifecycleService(nil)
+	cloud.addLoadbalancer("lb-web", "aweb", lb.GetLabelsForVpcLoadbalancer(service))
+	cloud.addTargetGroup("tg-old", "web-80", 30080, lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP"))
+	cloud.mu.Lock()
+	cloud.findTargetGroup("tg-old").attachments = []string{"vm-1"}
+	cloud.mu.Unlock()
+	cloud.addLoadbalancerListener("lb-web", "listener-80", iaas.CreateListener{
+		Name: "web-80", Port: 80, Protocol: iaas.ProtocolTCP, Labels: lb.GetLabelsForVpcLoadbalancerTargetGroup(service, 80, "TCP"), TargetGroup: "tg-old",
+	})
+	return lb, service, nodes[:1], cloud
+}
+
+func TestLoadBalancer_NodePortChangeIsMakeBeforeBreak(t *testing.T) {
+	lb, service, nodes, cloud := newTestOrderingLoadBalancer(t)
+	service.Spec.Ports[0].NodePort = 30081
+	vpcLoadbalancer, err := cloud.client().GetLoadbalancer(context.Background(), "lb-web")
+	require.NoError(t, err)
+
+	_, err = lb.updateVpcLoadbalancerListenersAndTargetGroups(context.Background(), "cluster-test", service, nodes, vpcLoadbalancer)
+	require.NoError(t, err)
+
+	targetGroups := cloud.targetGroupList()
+	require.Len(t, targetGroups, 1, "the old target group is deleted")
+	created := targetGroups[0]
+	assert.NotEqual(t, "tg-old", created.Identity)
+	assert.Equal(t, 30081, created.TargetPort)
+	assert.Equal(t, []string{"vm-1"}, attachedMachines(created))
+	assert.Equal(t, []string{
+		"POST " + iaas.TargetGroupEndpoint,
+		"POST " + iaas.TargetGroupEndpoint + "/" + created.Identity + "/attachments",
+		"PUT " + iaas.LoadbalancerEndpoint + "/lb-web/listeners/listener-80",
+		"DELETE " + iaas.TargetGroupEndpoint + "/tg-old",
+	}, cloud.requestLog(), "the new target group must serve before the listener moves and the old one is deleted")
+
+	listeners := cloud.listenerList()
+	require.Len(t, listeners, 1)
+	assert.Equal(t, created.Identity, listeners[0].TargetGroup.Identity)
+}
+
+func TestLoadBalancer_CreateOrUpdateTargetGroups(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+				{Name: "https", Port: 443, NodePort: 30443, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}, Spec: corev1.NodeSpec{ProviderID: "thalassacloud://vm-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2"}},
+	}
+
+	api := newMockCloudAPI(t)
+	lb := &loadbalancer{iaasClient: api, vpcIdentity: "vpc-test", cluster: "cluster-test"}
+	desiredTargetGroups, err := lb.getDesiredVpcLoadbalancerTargetGroups(service, nodes)
+	require.NoError(t, err)
+
+	list := api.EXPECT().ListTargetGroups(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, listRequest *iaas.ListTargetGroupsRequest) ([]iaas.VpcLoadbalancerTargetGroup, error) {
+		require.Len(t, listRequest.Filters, 2)
+		return []iaas.VpcLoadbalancerTargetGroup{{Identity: "tg-http", Protocol: "tcp", TargetPort: 30080}}, nil
+	})
+	attachments := map[string][]iaas.AttachTarget{}
+	setAttachments := func(_ context.Context, setRequest iaas.TargetGroupAttachmentsBatch) error {
+		attachments[setRequest.TargetGroupID] = setRequest.Attachments
+		return nil
+	}
+	gomock.InOrder(
+		list,
+		api.EXPECT().CreateTargetGroup(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, create iaas.CreateTargetGroup) (*iaas.VpcLoadbalancerTargetGroup, error) {
+			assert.Equal(t, "vpc-test", create.Vpc)
+			assert.Equal(t, 30443, create.TargetPort)
+			return &iaas.VpcLoadbalancerTargetGroup{Identity: "tg-https", TargetPort: create.TargetPort, Protocol: create.Protocol}, nil
+		}),
+		api.EXPECT().SetTargetGroupServerAttachments(gomock.Any(), gomock.Any()).DoAndReturn(setAttachments),
+		api.EXPECT().UpdateTargetGroup(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error) {
+			assert.Equal(t, "tg-http", update.Identity)
+			return &iaas.VpcLoadbalancerTargetGroup{Identity: update.Identity, TargetPort: update.TargetPort, Protocol: update.Protocol}, nil
+		}),
+		api.EXPECT().SetTargetGroupServerAttachments(gomock.Any(), gomock.Any()).DoAndReturn(setAttachments),
+	)
+
+	targetGroups, err := lb.createOrUpdateTargetGroups(context.Background(), service, nil, desiredTargetGroups, nodes)
+	require.NoError(t, err)
+	require.Len(t, targetGroups, 2)
+	assert.Equal(t, "tg-https", targetGroups[0].Identity)
+	assert.Equal(t, "tg-http", targetGroups[1].Identity)
+	// nodes without a provider ID are not attached
+	assert.Equal(t, []iaas.AttachTarget{{ServerIdentity: "vm-1"}}, attachments["tg-http"])
+	assert.Equal(t, []iaas.AttachTarget{{ServerIdentity: "vm-1"}}, attachments["tg-https"])
+}
+
+func TestLoadBalancer_CreateOrUpdateTargetGroupsErrors(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeLoadBalancer,
+			Ports: []corev1.ServicePort{{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	tests := []struct {
+		name  string
+		setup func(api *mockCloudAPI)
+	}{
+		{
+			name: "list fails",
+			setup: func(api *mockCloudAPI) {
+				api.EXPECT().ListTargetGroups(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("unavailable"))
+			},
+		},
+		{
+			name: "create fails",
+			setup: func(api *mockCloudAPI) {
+				gomock.InOrder(
+					api.EXPECT().ListTargetGroups(gomock.Any(), gomock.Any()).Return(nil, nil),
+					api.EXPECT().CreateTargetGroup(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("quota exceeded")),
+				)
+			},
+		},
+		{
+			name: "attachments fail",
+			setup: func(api *mockCloudAPI) {
+				gomock.InOrder(
+					api.EXPECT().ListTargetGroups(gomock.Any(), gomock.Any()).Return([]iaas.VpcLoadbalancerTargetGroup{{Identity: "tg-http", Protocol: "tcp", TargetPort: 30080}}, nil),
+					api.EXPECT().UpdateTargetGroup(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, update iaas.UpdateTargetGroupRequest) (*iaas.VpcLoadbalancerTargetGroup, error) {
+						return &iaas.VpcLoadbalancerTargetGroup{Identity: update.Identity}, nil
+					}),
+					api.EXPECT().SetTargetGroupServerAttachments(gomock.Any(), gomock.Any()).Return(fmt.Errorf("machine not found")),
+				)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			tt.setup(api)
+			lb := &loadbalancer{iaasClient: api, vpcIdentity: "vpc-test", cluster: "cluster-test"}
+			desiredTargetGroups, err := lb.getDesiredVpcLoadbalancerTargetGroups(service, nil)
+			require.NoError(t, err)
+
+			_, err = lb.createOrUpdateTargetGroups(context.Background(), service, nil, desiredTargetGroups, nil)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// MachineLabelCluster is the machine label with the cluster of the node, matched by the target selector of target groups
+	MachineLabelCluster = NodeLabelPrefix + "cluster"
+	// MachineLabelNodePool is the machine label with the node pool of the node, matched by the target selector of target
+	// groups of Services with the target-node-pool annotation
+	MachineLabelNodePool = NodeLabelPrefix + "node-pool"
+
+	// DefaultNodePoolLabel is the node label with the node pool of the node
+	DefaultNodePoolLabel = NodeLabelPrefix + "node-pool"
+)
+
+// TargetSelectorConfig configures target groups that select their targets by machine label
+type TargetSelectorConfig struct {
+	// Enabled labels the machines of nodes with the cluster and node pool, and creates target groups with a target
+	// selector on these labels instead of attaching the nodes explicitly
+	Enabled bool `yaml:"enabled"`
+	// NodePoolLabel is the node label with the node pool of the node. Defaults to k8s.thalassa.cloud/node-pool.
+	NodePoolLabel string `yaml:"nodePoolLabel,omitempty"`
+}
+
+// machineTargetLabels returns the labels of the machine of the node matched by target selectors: the cluster, and the
+// node pool if the node has the node pool label
+func machineTargetLabels(config TargetSelectorConfig, cluster string, node *corev1.Node) map[string]string {
+	labels := map[string]string{MachineLabelCluster: sanitizeLabelValue(cluster)}
+	nodePoolLabel := config.NodePoolLabel
+	if nodePoolLabel == "" {
+		nodePoolLabel = DefaultNodePoolLabel
+	}
+	if nodePool := sanitizeLabelValue(node.Labels[nodePoolLabel]); nodePool != "" {
+		labels[MachineLabelNodePool] = nodePool
+	}
+	return labels
+}
+
+// targetLabelsMatch returns true if the target selector labels of the machine are the labels
+func targetLabelsMatch(labels map[string]string, machineLabels iaas.Labels) bool {
+	for _, key := range []string{MachineLabelCluster, MachineLabelNodePool} {
+		value, ok := machineLabels[key]
+		desired, desiredOk := labels[key]
+		if ok != desiredOk || value != desired {
+			return false
+		}
+	}
+	return true
+}
+
+// ensureMachineLabels sets the target selector labels of the machine to the labels: missing labels are added, and the
+// target selector labels not in labels are removed, e.g. the node pool of a node whose node pool label was removed.
+// Other labels of the machine are kept. The machine can come from the machine index, so it is read again before it is
+// updated.
+func ensureMachineLabels(ctx context.Context, iaasClient MachineAPI, machine *iaas.Machine, labels map[string]string) error {
+	if targetLabelsMatch(labels, machine.Labels) {
+		return nil
+	}
+	identity := machine.Identity
+	machine, err := iaasClient.GetMachine(ctx, identity)
+	if err != nil {
+		return fmt.Errorf("failed to get machine %s: %v", identity, err)
+	}
+	if targetLabelsMatch(labels, machine.Labels) {
+		return nil
+	}
+	updatedLabels := iaas.Labels{}
+	for key, val := range machine.Labels {
+		if key == MachineLabelCluster || key == MachineLabelNodePool {
+			continue
+		}
+		updatedLabels[key] = val
+	}
+	for key, val := range labels {
+		updatedLabels[key] = val
+	}
+
+	klog.Infof("setting the target selector labels of machine %s: %v", machine.Identity, labels)
+	if _, err := iaasClient.UpdateMachine(ctx, machine.Identity, iaas.UpdateMachine{
+		Name:             machine.Name,
+		Description:      ptr.Deref(machine.Description, ""),
+		Labels:           updatedLabels,
+		Annotations:      machine.Annotations,
+		DeleteProtection: ptr.To(machine.DeleteProtection),
+	}); err != nil {
+		return fmt.Errorf("failed to label machine %s: %v", machine.Identity, err)
+	}
+	return nil
+}
+
+// checkTargetSelectorService returns an error for a Service whose nodes cannot be selected by target selectors. The
+// machine labels are shared by all Services of the cluster, so they cannot select only the nodes with endpoints of a
+// Service with externalTrafficPolicy Local.
+func (l *loadbalancer) checkTargetSelectorService(service *corev1.Service) error {
+	if !l.config.TargetSelector.Enabled || service.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+		return nil
+	}
+	return fmt.Errorf("service %s has externalTrafficPolicy Local, which is not supported with target selectors", getServiceKey(service))
+}
+
+// getTargetSelector returns the target selector of the target groups of the Service, nil if target selectors are disabled
+func (l *loadbalancer) getTargetSelector(service *corev1.Service) map[string]string {
+	if !l.config.TargetSelector.Enabled {
+		return nil
+	}
+	selector := map[string]string{MachineLabelCluster: sanitizeLabelValue(l.cluster)}
+	if nodePool := sanitizeLabelValue(service.Annotations[LoadBalancerAnnotationTargetNodePool]); nodePool != "" {
+		selector[MachineLabelNodePool] = nodePool
+	}
+	return selector
+}
+
+// labelNodeMachines labels the machines of the nodes for the target selectors, and removes the labels from the
+// machines of the nodes excluded from load balancing, e.g. labeled node.kubernetes.io/exclude-from-external-load-balancers
+// or being deleted. Nodes are normally labeled when they are initialized; this covers the nodes that joined before
+// target selectors were enabled, and the nodes excluded since.
+func (l *loadbalancer) labelNodeMachines(ctx context.Context, nodes []*corev1.Node) error {
+	snapshot, err := l.machineIndex.get(ctx)
+	if err != nil {
+		return err
+	}
+	targets := make(map[string]struct{}, len(nodes))
+	for _, node := range nodes {
+		targets[node.Name] = struct{}{}
+		machineIdentity, ok := getMachineIdentityForNode(node)
+		if !ok {
+			continue
+		}
+		machine, ok := snapshot.byIdentity[machineIdentity]
+		if !ok {
+			klog.Warningf("machine %s of node %s not found in vpc %s, not labeling it for target selectors", machineIdentity, node.Name, l.vpcIdentity)
+			continue
+		}
+		if err := ensureMachineLabels(ctx, l.iaasClient, machine, machineTargetLabels(l.config.TargetSelector, l.cluster, node)); err != nil {
+			return err
+		}
+	}
+
+	if l.nodeLister == nil {
+		return nil
+	}
+	allNodes, err := l.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range allNodes {
+		if _, ok := targets[node.Name]; ok || isLoadBalancerNode(node) {
+			continue
+		}
+		machineIdentity, ok := getMachineIdentityForNode(node)
+		if !ok || snapshot.byIdentity[machineIdentity] == nil {
+			continue
+		}
+		// the index can predate the labels set by the last reconcile, so the machine is read again to remove them
+		machine, err := l.iaasClient.GetMachine(ctx, machineIdentity)
+		if err != nil {
+			return fmt.Errorf("failed to get machine %s: %v", machineIdentity, err)
+		}
+		if err := ensureMachineLabels(ctx, l.iaasClient, machine, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBalancerTargetSelector(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	lb.config.TargetSelector = TargetSelectorConfig{Enabled: true}
+	nodes[0].Labels = map[string]string{DefaultNodePoolLabel: "web"}
+	nodes[1].Labels = map[string]string{DefaultNodePoolLabel: "batch"}
+	ctx := context.Background()
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+
+	assert.Equal(t, iaas.Labels{MachineLabelCluster: "cluster-test", MachineLabelNodePool: "web"}, cloud.findMachine("vm-1").Labels)
+	assert.Equal(t, iaas.Labels{MachineLabelCluster: "cluster-test", MachineLabelNodePool: "batch"}, cloud.findMachine("vm-2").Labels)
+	targetGroups := cloud.targetGroupList()
+	require.Len(t, targetGroups, 1)
+	assert.Equal(t, map[string]string{MachineLabelCluster: "cluster-test"}, targetGroups[0].TargetSelector)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, attachedMachines(targetGroups[0]))
+	for _, request := range cloud.requestLog() {
+		assert.False(t, strings.HasSuffix(request, "/attachments"), "targets are not attached explicitly: %s", request)
+	}
+
+	// a node initialized after the reconcile is a target without reconciling the Service again
+	cloud.addMachine("vm-3", "worker-3", "10.0.0.13")
+	instances := &instancesV2{
+		iaasClient:     lb.iaasClient,
+		machineIndex:   newMachineIndex(lb.iaasClient, "vpc-test", 0),
+		vpcIdentity:    "vpc-test",
+		cluster:        "cluster-test",
+		targetSelector: lb.config.TargetSelector,
+	}
+	_, err = instances.InstanceMetadata(ctx, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker-3", Labels: map[string]string{DefaultNodePoolLabel: "web"}}})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2", "vm-3"}, attachedMachines(cloud.targetGroupList()[0]))
+
+	// machines are only updated when their labels change
+	cloud.resetRequestLog()
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+	for _, request := range cloud.requestLog() {
+		assert.False(t, strings.HasPrefix(request, "PUT "+iaas.MachineEndpoint), "unexpected machine update: %s", request)
+	}
+}
+
+func TestLoadBalancerTargetSelector_NodePool(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	lb.config.TargetSelector = TargetSelectorConfig{Enabled: true, NodePoolLabel: "example.com/pool"}
+	nodes[0].Labels = map[string]string{"example.com/pool": "web"}
+	ctx := context.Background()
+	service := newTestLifecycleService(map[string]string{LoadBalancerAnnotationTargetNodePool: "web"})
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", service, nodes)
+	require.NoError(t, err)
+
+	targetGroups := cloud.targetGroupList()
+	require.Len(t, targetGroups, 1)
+	assert.Equal(t, map[string]string{MachineLabelCluster: "cluster-test", MachineLabelNodePool: "web"}, targetGroups[0].TargetSelector)
+	assert.Equal(t, []string{"vm-1"}, attachedMachines(targetGroups[0]))
+	assert.Equal(t, iaas.Labels{MachineLabelCluster: "cluster-test"}, cloud.findMachine("vm-2").Labels, "nodes without node pool label only get the cluster label")
+}
+
+func TestLoadBalancerTargetSelector_SwitchMode(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	ctx := context.Background()
+	explicitAttachments := func() []string {
+		cloud.mu.Lock()
+		defer cloud.mu.Unlock()
+		return cloud.findTargetGroup(cloud.targetGroups[0].targetGroup.Identity).attachments
+	}
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+	require.Len(t, cloud.targetGroupList(), 1)
+	assert.Empty(t, cloud.targetGroupList()[0].TargetSelector)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, explicitAttachments())
+
+	// enabling target selectors detaches the nodes attached explicitly
+	lb.config.TargetSelector = TargetSelectorConfig{Enabled: true}
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{MachineLabelCluster: "cluster-test"}, cloud.targetGroupList()[0].TargetSelector)
+	assert.Empty(t, explicitAttachments())
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, attachedMachines(cloud.targetGroupList()[0]))
+
+	// disabling target selectors removes the target selector and attaches the nodes explicitly
+	lb.config.TargetSelector = TargetSelectorConfig{}
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+	assert.Empty(t, cloud.targetGroupList()[0].TargetSelector)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, explicitAttachments())
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, attachedMachines(cloud.targetGroupList()[0]))
+}
+
+func TestLoadBalancerTargetSelector_Drift(t *testing.T) {
+	lb := &loadbalancer{cluster: "cluster-test", config: LoadBalancerConfig{TargetSelector: TargetSelectorConfig{Enabled: true}}}
+	nodes := []*corev1.Node{{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: getProviderID("vm-1")}}}
+	desired := []iaas.VpcLoadbalancerTargetGroup{{Protocol: "tcp", TargetPort: 30080, TargetSelector: lb.getTargetSelector(newTestLifecycleService(nil))}}
+
+	existing := []iaas.VpcLoadbalancerTargetGroup{{Identity: "tg-1", Protocol: "tcp", TargetPort: 30080, TargetSelector: map[string]string{MachineLabelCluster: "cluster-test"}}}
+	assert.Empty(t, lb.detectTargetGroupDrift(desired, existing, nodes), "targets attached by the API are not compared with the nodes")
+
+	existing[0].TargetSelector = map[string]string{MachineLabelCluster: "other"}
+	drifts := lb.detectTargetGroupDrift(desired, existing, nodes)
+	require.Len(t, drifts, 1)
+	assert.Contains(t, drifts[0].message, "target selector")
+}
+
+func TestLoadBalancerTargetSelector_ExcludedNodes(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	lb.config.TargetSelector = TargetSelectorConfig{Enabled: true}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	lb.nodeLister = corelisters.NewNodeLister(indexer)
+	for _, node := range nodes {
+		require.NoError(t, indexer.Add(node))
+	}
+	nodes[0].Labels = map[string]string{DefaultNodePoolLabel: "web"}
+	cloud.findMachine("vm-1").Labels = iaas.Labels{"team": "platform"}
+	ctx := context.Background()
+
+	_, err := lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"vm-1", "vm-2"}, attachedMachines(cloud.targetGroupList()[0]))
+
+	// the service controller no longer passes an excluded node, its machine is no longer selected
+	excluded := nodes[1].DeepCopy()
+	excluded.Labels = map[string]string{corev1.LabelNodeExcludeBalancers: ""}
+	require.NoError(t, indexer.Update(excluded))
+	// a node moved out of its node pool loses the node pool label of its machine
+	nodes[0].Labels = nil
+	_, err = lb.EnsureLoadBalancer(ctx, "cluster-test", newTestLifecycleService(nil), nodes[:1])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vm-1"}, attachedMachines(cloud.targetGroupList()[0]))
+	assert.Equal(t, iaas.Labels{"team": "platform", MachineLabelCluster: "cluster-test"}, cloud.findMachine("vm-1").Labels)
+	assert.Empty(t, cloud.findMachine("vm-2").Labels)
+
+	// an excluded node is not labeled when it is initialized
+	instances := &instancesV2{
+		iaasClient:     lb.iaasClient,
+		machineIndex:   newMachineIndex(lb.iaasClient, "vpc-test", 0),
+		vpcIdentity:    "vpc-test",
+		cluster:        "cluster-test",
+		targetSelector: lb.config.TargetSelector,
+	}
+	_, err = instances.InstanceMetadata(ctx, excluded)
+	require.NoError(t, err)
+	assert.Empty(t, cloud.findMachine("vm-2").Labels)
+}
+
+func TestLoadBalancerTargetSelector_ExternalTrafficPolicyLocal(t *testing.T) {
+	lb, cloud, nodes := newTestLifecycleLoadBalancer(t)
+	lb.config.TargetSelector = TargetSelectorConfig{Enabled: true}
+	service := newTestLifecycleService(nil)
+	service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeLocal
+
+	_, err := lb.EnsureLoadBalancer(context.Background(), "cluster-test", service, nodes)
+	assert.EqualError(t, err, "service default/web has externalTrafficPolicy Local, which is not supported with target selectors")
+	assert.Empty(t, cloud.loadbalancerList())
+	assert.Error(t, lb.UpdateLoadBalancer(context.Background(), "cluster-test", service, nodes))
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadBalancer_EnsureManagedSecurityGroup(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", UID: "uid-web"},
+	}
+	desiredListeners := []iaas.VpcLoadbalancerListener{
+		{Port: 443, Protocol: "tcp", AllowedSources: []string{"10.0.0.0/8", "2001:db8::/32"}},
+	}
+	lb := &loadbalancer{vpcIdentity: "vpc-test", cluster: "cluster-test"}
+	managed := iaas.SecurityGroup{Identity: "sg-web", Name: "sg-web", ObjectVersion: 3, Labels: lb.GetLabelsForVpcLoadbalancer(service)}
+
+	tests := []struct {
+		name          string
+		existing      []iaas.SecurityGroup
+		listErr       error
+		expectedCalls []string
+		expectError   bool
+	}{
+		{
+			name:          "creates the security group",
+			expectedCalls: []string{"ListSecurityGroups", "CreateSecurityGroup"},
+		},
+		{
+			name:          "updates the existing security group",
+			existing:      []iaas.SecurityGroup{{Identity: "sg-other", Labels: map[string]string{LabelKubernetesCluster: "cluster-test"}}, managed},
+			expectedCalls: []string{"ListSecurityGroups", "UpdateSecurityGroup"},
+		},
+		{
+			name:          "fails when the security groups cannot be listed",
+			listErr:       fmt.Errorf("unavailable"),
+			expectedCalls: []string{"ListSecurityGroups"},
+			expectError:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newMockCloudAPI(t)
+			lb := &loadbalancer{iaasClient: api, vpcIdentity: "vpc-test", cluster: "cluster-test"}
+			listSecurityGroups := func(context.Context, *iaas.ListSecurityGroupsRequest) ([]iaas.SecurityGroup, error) {
+				return tt.existing, tt.listErr
+			}
+			createSecurityGroup := func(_ context.Context, create iaas.CreateSecurityGroupRequest) (*iaas.SecurityGroup, error) {
+				assert.Equal(t, "vpc-test", create.VpcIdentity)
+				assert.LessOrEqual(t, len(create.Name), 16)
+				assert.Len(t, create.IngressRules, 2)
+				assert.Equal(t, iaas.SecurityGroupIPVersionIPv6, create.IngressRules[1].IPVersion)
+				return &iaas.SecurityGroup{Identity: "sg-new"}, nil
+			}
+			updateSecurityGroup := func(_ context.Context, identity string, update iaas.UpdateSecurityGroupRequest) (*iaas.SecurityGroup, error) {
+				assert.Equal(t, "sg-web", identity)
+				assert.Equal(t, 3, update.ObjectVersion)
+				assert.Len(t, update.IngressRules, 2)
+				return &iaas.SecurityGroup{Identity: identity}, nil
+			}
+			var calls []any
+			for _, method := range tt.expectedCalls {
+				switch method {
+				case "ListSecurityGroups":
+					calls = append(calls, api.EXPECT().ListSecurityGroups(gomock.Any(), gomock.Any()).DoAndReturn(listSecurityGroups))
+				case "CreateSecurityGroup":
+					calls = append(calls, api.EXPECT().CreateSecurityGroup(gomock.Any(), gomock.Any()).DoAndReturn(createSecurityGroup))
+				case "UpdateSecurityGroup":
+					calls = append(calls, api.EXPECT().UpdateSecurityGroup(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(updateSecurityGroup))
+				}
+			}
+			gomock.InOrder(calls...)
+
+			securityGroup, err := lb.ensureManagedSecurityGroup(context.Background(), service, desiredListeners)
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.NotNil(t, securityGroup)
+			}
+		})
+	}
+}
@@ -7,15 +7,53 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
+// Labels linking cloud resources to the Kubernetes cluster and Service that own them
+const (
+	LabelKubernetesCluster          = "k8s.thalassa.cloud/kubernetes-cluster"
+	LabelCloudProviderManaged       = "k8s.thalassa.cloud/cloud-provider-managed"
+	LabelKubernetesServiceName      = "k8s.thalassa.cloud/kubernetes-service-name"
+	LabelKubernetesServiceNamespace = "k8s.thalassa.cloud/kubernetes-service-namespace"
+	LabelKubernetesServiceUID       = "k8s.thalassa.cloud/kubernetes-service-uid"
+	// LabelSharedGroup replaces the Service labels on a load balancer shared by the Services of a shared group
+	LabelSharedGroup = "k8s.thalassa.cloud/shared-group"
+)
+
+// ownershipLabels are removed from a load balancer that is retained on Service deletion
+var ownershipLabels = []string{
+	LabelKubernetesCluster,
+	LabelCloudProviderManaged,
+	LabelKubernetesServiceName,
+	LabelKubernetesServiceNamespace,
+	LabelKubernetesServiceUID,
+	LabelSharedGroup,
+}
+
 // GetLabelsForVpcLoadbalancer returns the labels for the VPC Loadbalancer
 // The labels are used to identify the loadbalancer in the VPC and are used to link the loadbalancer to the service
 func (lb *loadbalancer) GetLabelsForVpcLoadbalancer(service *corev1.Service) map[string]string {
 	labels := map[string]string{
-		"k8s.thalassa.cloud/kubernetes-cluster":           lb.cluster,
-		"k8s.thalassa.cloud/cloud-provider-managed":       "true",
-		"k8s.thalassa.cloud/kubernetes-service-name":      service.GetName(),
-		"k8s.thalassa.cloud/kubernetes-service-namespace": service.GetNamespace(),
-		"k8s.thalassa.cloud/kubernetes-service-uid":       string(service.UID),
+		LabelKubernetesCluster:          lb.cluster,
+		LabelCloudProviderManaged:       "true",
+		LabelKubernetesServiceName:      service.GetName(),
+		LabelKubernetesServiceNamespace: service.GetNamespace(),
+		LabelKubernetesServiceUID:       string(service.UID),
+	}
+
+	for key, val := range lb.additionalLabels {
+		if _, ok := labels[key]; !ok {
+			labels[key] = val
+		}
+	}
+	return labels
+}
+
+// GetLabelsForSharedVpcLoadbalancer returns the labels for a VPC Loadbalancer shared by the Services of a shared group.
+// Listeners and target groups on a shared loadbalancer keep the labels of the Service they belong to.
+func (lb *loadbalancer) GetLabelsForSharedVpcLoadbalancer(group string) map[string]string {
+	labels := map[string]string{
+		LabelKubernetesCluster:    lb.cluster,
+		LabelCloudProviderManaged: "true",
+		LabelSharedGroup:          group,
 	}
 
 	for key, val := range lb.additionalLabels {
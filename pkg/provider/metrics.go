@@ -0,0 +1,87 @@
+package provider
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const (
+	metricsNamespace             = "thalassa_cloud_controller_manager"
+	metricsSubsystemLoadBalancer = "loadbalancer"
+)
+
+var (
+	// loadBalancerDriftDetectedTotal counts drifted cloud resources found by the drift detection, by resource kind
+	loadBalancerDriftDetectedTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      metricsSubsystemLoadBalancer,
+			Name:           "drift_detected_total",
+			Help:           "Number of drifted load balancer resources found by the drift detection, by resource kind.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource"},
+	)
+
+	// loadBalancerDriftedServices is the number of Services with drift in the last drift detection run
+	loadBalancerDriftedServices = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      metricsSubsystemLoadBalancer,
+			Name:           "drifted_services",
+			Help:           "Number of LoadBalancer Services whose cloud resources drifted from the desired state in the last drift detection run.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// loadBalancerDriftRemediationsTotal counts drifted Services by the remediation that was applied
+	loadBalancerDriftRemediationsTotal = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      metricsSubsystemLoadBalancer,
+			Name:           "drift_remediations_total",
+			Help:           "Number of drifted LoadBalancer Services, by remediation (repair or alert).",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"remediation"},
+	)
+
+	// loadBalancerDriftDetectionErrorsTotal counts Services for which the drift detection failed
+	loadBalancerDriftDetectionErrorsTotal = metrics.NewCounter(
+		&metrics.CounterOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      metricsSubsystemLoadBalancer,
+			Name:           "drift_detection_errors_total",
+			Help:           "Number of LoadBalancer Services for which the drift detection failed.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+
+	// loadBalancerPausedServices is the number of Services whose load balancer reconciliation is paused
+	loadBalancerPausedServices = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Namespace:      metricsNamespace,
+			Subsystem:      metricsSubsystemLoadBalancer,
+			Name:           "reconcile_paused_services",
+			Help:           "Number of LoadBalancer Services whose reconciliation is paused by annotation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+var registerMetricsOnce sync.Once
+
+// registerMetrics registers the provider metrics with the legacy registry served by the cloud controller manager
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		legacyregistry.MustRegister(
+			loadBalancerDriftDetectedTotal,
+			loadBalancerDriftedServices,
+			loadBalancerDriftRemediationsTotal,
+			loadBalancerDriftDetectionErrorsTotal,
+			loadBalancerPausedServices,
+		)
+	})
+}
@@ -2,20 +2,71 @@ package provider
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 
 	corev1 "k8s.io/api/core/v1"
 	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 )
 
+// EmptyEndpointsPolicy determines which nodes are kept as load balancer targets when a
+// Service with externalTrafficPolicy=Local has no ready (or serving) endpoints.
+type EmptyEndpointsPolicy string
+
+const (
+	// EmptyEndpointsPolicyKeepAll keeps all nodes attached and relies on the Thalassa health check to mark them down.
+	EmptyEndpointsPolicyKeepAll EmptyEndpointsPolicy = "keep-all"
+	// EmptyEndpointsPolicyDetachAll detaches all nodes from the target groups.
+	EmptyEndpointsPolicyDetachAll EmptyEndpointsPolicy = "detach-all"
+	// EmptyEndpointsPolicyLastKnownGood keeps the nodes that last hosted a ready endpoint for the Service.
+	EmptyEndpointsPolicyLastKnownGood EmptyEndpointsPolicy = "last-known-good"
+)
+
+const (
+	// DefaultEmptyEndpointsPolicy is the policy used when neither the Service nor the cloud config specify one.
+	DefaultEmptyEndpointsPolicy = EmptyEndpointsPolicyKeepAll
+)
+
+// Event reasons emitted by the node filter
+const (
+	EventReasonNoReadyEndpoints       = "NoReadyEndpoints"
+	EventReasonReadyEndpointsRestored = "ReadyEndpointsRestored"
+)
+
 type NodeFilter struct {
 	epSliceLister discoverylisters.EndpointSliceLister
+
+	// defaultPolicy is the cluster-wide empty endpoints policy, overridable per Service
+	defaultPolicy EmptyEndpointsPolicy
+
+	recorder record.EventRecorder
+
+	mu sync.Mutex
+	// lastKnownGood tracks the last non-empty set of endpoint nodes per Service key
+	lastKnownGood map[string]map[string]struct{}
+	// noReadyEndpoints tracks the Services that currently have no ready endpoints, so events are only emitted on transitions
+	noReadyEndpoints map[string]bool
+}
+
+// ParseEmptyEndpointsPolicy validates an empty endpoints policy value.
+func ParseEmptyEndpointsPolicy(value string) (EmptyEndpointsPolicy, error) {
+	switch policy := EmptyEndpointsPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case EmptyEndpointsPolicyKeepAll, EmptyEndpointsPolicyDetachAll, EmptyEndpointsPolicyLastKnownGood:
+		return policy, nil
+	default:
+		return DefaultEmptyEndpointsPolicy, fmt.Errorf("invalid empty endpoints policy: %s, must be one of: %s, %s, %s", value, EmptyEndpointsPolicyKeepAll, EmptyEndpointsPolicyDetachAll, EmptyEndpointsPolicyLastKnownGood)
+	}
 }
 
 // Filter drops every node that does NOT host a ready endpoint for the Service
 // when externalTrafficPolicy is Local. For Cluster policy we leave the list intact.
+// If no endpoint is ready, serving-but-terminating endpoints are used instead so graceful
+// rollouts keep draining traffic. If there are none of those either, the empty endpoints policy applies.
 func (f *NodeFilter) Filter(
 	ctx context.Context,
 	svc *corev1.Service,
@@ -27,45 +78,187 @@ func (f *NodeFilter) Filter(
 	}
 	klog.Infof("Filtering nodes for service %s in namespace %s", svc.Name, svc.Namespace)
 
-	readyNodes := map[string]struct{}{}
-	slices, err := f.epSliceLister.EndpointSlices(svc.Namespace).List(labels.Set{discoveryv1.LabelServiceName: svc.Name}.AsSelector())
+	endpointNodes, err := f.getEndpointNodes(svc)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(slices) == 0 {
-		klog.Infof("No endpoint slices found for service %s in namespace %s", svc.Name, svc.Namespace)
+	serviceKey := fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
+	if len(endpointNodes) == 0 {
+		klog.Infof("No ready nodes found for service %s in namespace %s", svc.Name, svc.Namespace)
+		return f.filterWithoutEndpoints(svc, serviceKey, nodes), nil
+	}
+	f.recordEndpointNodes(svc, serviceKey, endpointNodes)
+
+	filtered := filterEndpointNodes(svc, nodes, endpointNodes)
+	klog.Infof("Filtered %d nodes for service %s in namespace %s", len(filtered), svc.Name, svc.Namespace)
+	return filtered, nil
+}
+
+// Peek returns the nodes Filter would return, without emitting events or updating the last known good nodes of the
+// Service. It is used by read-only paths like the drift detection.
+func (f *NodeFilter) Peek(svc *corev1.Service, nodes []*corev1.Node) ([]*corev1.Node, error) {
+	if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
 		return nodes, nil
 	}
+	endpointNodes, err := f.getEndpointNodes(svc)
+	if err != nil {
+		return nil, err
+	}
+	if len(endpointNodes) == 0 {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.applyEmptyEndpointsPolicy(svc, fmt.Sprintf("%s/%s", svc.Namespace, svc.Name), nodes), nil
+	}
+	return filterEndpointNodes(svc, nodes, endpointNodes), nil
+}
 
+// getEndpointNodes returns the nodes hosting ready endpoints of the Service, or the nodes hosting serving terminating
+// endpoints if none is ready
+func (f *NodeFilter) getEndpointNodes(svc *corev1.Service) (map[string]struct{}, error) {
+	slices, err := f.epSliceLister.EndpointSlices(svc.Namespace).List(labels.Set{discoveryv1.LabelServiceName: svc.Name}.AsSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	readyNodes, servingNodes := map[string]struct{}{}, map[string]struct{}{}
 	for _, sl := range slices {
-		for _, ep := range sl.Endpoints {
-			if ep.NodeName == nil {
-				continue
-			}
-			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
-				continue
-			}
-			if ep.Conditions.Terminating != nil && *ep.Conditions.Terminating {
-				continue
-			}
-			readyNodes[*ep.NodeName] = struct{}{}
+		ready, serving := collectEndpointNodes(sl.Endpoints)
+		for name := range ready {
+			readyNodes[name] = struct{}{}
+		}
+		for name := range serving {
+			servingNodes[name] = struct{}{}
 		}
 	}
 
-	if len(readyNodes) == 0 {
-		klog.Infof("No ready nodes found for service %s in namespace %s", svc.Name, svc.Namespace)
-		return nodes, nil
+	if len(readyNodes) == 0 && len(servingNodes) > 0 {
+		klog.V(4).Infof("No ready endpoints found for service %s in namespace %s, using %d serving terminating endpoint nodes", svc.Name, svc.Namespace, len(servingNodes))
+		return servingNodes, nil
 	}
+	return readyNodes, nil
+}
 
+// filterEndpointNodes returns the nodes hosting an endpoint
+func filterEndpointNodes(svc *corev1.Service, nodes []*corev1.Node, endpointNodes map[string]struct{}) []*corev1.Node {
 	var filtered []*corev1.Node
 	for _, n := range nodes {
-		if _, ok := readyNodes[n.Name]; ok {
+		if _, ok := endpointNodes[n.Name]; ok {
 			filtered = append(filtered, n)
 		} else {
-			klog.Infof("Node %s is not available for service %s in namespace %s", n.Name, svc.Name, svc.Namespace)
+			klog.V(4).Infof("Node %s is not available for service %s in namespace %s", n.Name, svc.Name, svc.Namespace)
 		}
 	}
-	klog.Infof("Filtered %d nodes for service %s in namespace %s", len(filtered), svc.Name, svc.Namespace)
-	return filtered, nil
+	return filtered
+}
+
+// filterWithoutEndpoints applies the empty endpoints policy of the Service, emitting an event when the Service
+// loses its last ready endpoint
+func (f *NodeFilter) filterWithoutEndpoints(svc *corev1.Service, serviceKey string, nodes []*corev1.Node) []*corev1.Node {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.noReadyEndpoints[serviceKey] {
+		if f.noReadyEndpoints == nil {
+			f.noReadyEndpoints = map[string]bool{}
+		}
+		f.noReadyEndpoints[serviceKey] = true
+		f.eventf(svc, corev1.EventTypeWarning, EventReasonNoReadyEndpoints, "Service has no ready endpoints on any node, applying empty endpoints policy %q", f.getEmptyEndpointsPolicy(svc))
+	}
+	return f.applyEmptyEndpointsPolicy(svc, serviceKey, nodes)
+}
+
+// applyEmptyEndpointsPolicy returns the nodes kept by the empty endpoints policy of the Service. The caller must hold the lock.
+func (f *NodeFilter) applyEmptyEndpointsPolicy(svc *corev1.Service, serviceKey string, nodes []*corev1.Node) []*corev1.Node {
+	switch policy := f.getEmptyEndpointsPolicy(svc); policy {
+	case EmptyEndpointsPolicyDetachAll:
+		klog.Infof("Detaching all nodes for service %s in namespace %s (empty endpoints policy %q)", svc.Name, svc.Namespace, policy)
+		return []*corev1.Node{}
+	case EmptyEndpointsPolicyLastKnownGood:
+		lastKnownGood, ok := f.lastKnownGood[serviceKey]
+		if !ok {
+			klog.Infof("No last known good nodes for service %s in namespace %s, keeping all nodes", svc.Name, svc.Namespace)
+			return nodes
+		}
+		filtered := []*corev1.Node{}
+		for _, n := range nodes {
+			if _, ok := lastKnownGood[n.Name]; ok {
+				filtered = append(filtered, n)
+			}
+		}
+		klog.Infof("Keeping %d last known good nodes for service %s in namespace %s", len(filtered), svc.Name, svc.Namespace)
+		return filtered
+	default:
+		return nodes
+	}
+}
+
+// recordEndpointNodes stores the endpoint nodes as the last known good set for the Service
+func (f *NodeFilter) recordEndpointNodes(svc *corev1.Service, serviceKey string, endpointNodes map[string]struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lastKnownGood == nil {
+		f.lastKnownGood = map[string]map[string]struct{}{}
+	}
+	f.lastKnownGood[serviceKey] = endpointNodes
+
+	if f.noReadyEndpoints[serviceKey] {
+		delete(f.noReadyEndpoints, serviceKey)
+		f.eventf(svc, corev1.EventTypeNormal, EventReasonReadyEndpointsRestored, "Service has ready endpoints on %d nodes again", len(endpointNodes))
+	}
+}
+
+// forget drops all state kept for the Service
+func (f *NodeFilter) forget(serviceKey string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.lastKnownGood, serviceKey)
+	delete(f.noReadyEndpoints, serviceKey)
+}
+
+// getEmptyEndpointsPolicy returns the empty endpoints policy from the service annotation, or the cluster default
+func (f *NodeFilter) getEmptyEndpointsPolicy(svc *corev1.Service) EmptyEndpointsPolicy {
+	if val, ok := svc.Annotations[LoadBalancerAnnotationEmptyEndpointsPolicy]; ok {
+		policy, err := ParseEmptyEndpointsPolicy(val)
+		if err == nil {
+			return policy
+		}
+		klog.Errorf("failed to get empty endpoints policy for service %s/%s: %v", svc.Namespace, svc.Name, err)
+	}
+	if f.defaultPolicy != "" {
+		return f.defaultPolicy
+	}
+	return DefaultEmptyEndpointsPolicy
+}
+
+func (f *NodeFilter) eventf(svc *corev1.Service, eventType, reason, messageFmt string, args ...interface{}) {
+	if f.recorder == nil {
+		return
+	}
+	f.recorder.Eventf(svc, eventType, reason, messageFmt, args...)
+}
+
+// collectEndpointNodes returns the nodes hosting ready endpoints, and the nodes hosting
+// serving endpoints that are terminating.
+func collectEndpointNodes(endpoints []discoveryv1.Endpoint) (map[string]struct{}, map[string]struct{}) {
+	ready := map[string]struct{}{}
+	serving := map[string]struct{}{}
+	for _, ep := range endpoints {
+		if ep.NodeName == nil {
+			continue
+		}
+		terminating := ep.Conditions.Terminating != nil && *ep.Conditions.Terminating
+		if terminating {
+			if ep.Conditions.Serving != nil && *ep.Conditions.Serving {
+				serving[*ep.NodeName] = struct{}{}
+			}
+			continue
+		}
+		if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+			continue
+		}
+		ready[*ep.NodeName] = struct{}{}
+	}
+	return ready, serving
 }
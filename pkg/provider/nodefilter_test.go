@@ -0,0 +1,241 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	discoverylisters "k8s.io/client-go/listers/discovery/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func newTestNodeFilter(t *testing.T, policy EmptyEndpointsPolicy, slices ...*discoveryv1.EndpointSlice) (*NodeFilter, cache.Indexer, *record.FakeRecorder) {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, sl := range slices {
+		require.NoError(t, indexer.Add(sl))
+	}
+	recorder := record.NewFakeRecorder(10)
+	return &NodeFilter{
+		epSliceLister: discoverylisters.NewEndpointSliceLister(indexer),
+		defaultPolicy: policy,
+		recorder:      recorder,
+	}, indexer, recorder
+}
+
+func newTestEndpointSlice(endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-service-abc123",
+			Namespace: "default",
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: "test-service",
+			},
+		},
+		Endpoints: endpoints,
+	}
+}
+
+func newTestLocalService(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-service",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                  corev1.ServiceTypeLoadBalancer,
+			ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+		},
+	}
+}
+
+func testNodes(names ...string) []*corev1.Node {
+	nodes := make([]*corev1.Node, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+	}
+	return nodes
+}
+
+func nodeNames(nodes []*corev1.Node) []string {
+	names := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	return names
+}
+
+func TestNodeFilter_Filter(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      EmptyEndpointsPolicy
+		annotations map[string]string
+		endpoints   []discoveryv1.Endpoint
+		expected    []string
+	}{
+		{
+			name:   "ready endpoints select their nodes",
+			policy: EmptyEndpointsPolicyKeepAll,
+			endpoints: []discoveryv1.Endpoint{
+				{NodeName: ptr.To("node-1"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+				{NodeName: ptr.To("node-2"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false)}},
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name:   "serving terminating endpoints are used when nothing is ready",
+			policy: EmptyEndpointsPolicyDetachAll,
+			endpoints: []discoveryv1.Endpoint{
+				{NodeName: ptr.To("node-2"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false), Serving: ptr.To(true), Terminating: ptr.To(true)}},
+				{NodeName: ptr.To("node-3"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false), Serving: ptr.To(false), Terminating: ptr.To(true)}},
+			},
+			expected: []string{"node-2"},
+		},
+		{
+			name:   "serving terminating endpoints are ignored when other endpoints are ready",
+			policy: EmptyEndpointsPolicyKeepAll,
+			endpoints: []discoveryv1.Endpoint{
+				{NodeName: ptr.To("node-1"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+				{NodeName: ptr.To("node-2"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(false), Serving: ptr.To(true), Terminating: ptr.To(true)}},
+			},
+			expected: []string{"node-1"},
+		},
+		{
+			name:     "keep-all keeps all nodes without endpoints",
+			policy:   EmptyEndpointsPolicyKeepAll,
+			expected: []string{"node-1", "node-2", "node-3"},
+		},
+		{
+			name:     "detach-all removes all nodes without endpoints",
+			policy:   EmptyEndpointsPolicyDetachAll,
+			expected: []string{},
+		},
+		{
+			name:        "annotation overrides the cluster default",
+			policy:      EmptyEndpointsPolicyKeepAll,
+			annotations: map[string]string{LoadBalancerAnnotationEmptyEndpointsPolicy: "detach-all"},
+			expected:    []string{},
+		},
+		{
+			name:        "invalid annotation falls back to the cluster default",
+			policy:      EmptyEndpointsPolicyDetachAll,
+			annotations: map[string]string{LoadBalancerAnnotationEmptyEndpointsPolicy: "invalid"},
+			expected:    []string{},
+		},
+		{
+			name:     "last-known-good without history keeps all nodes",
+			policy:   EmptyEndpointsPolicyLastKnownGood,
+			expected: []string{"node-1", "node-2", "node-3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var slices []*discoveryv1.EndpointSlice
+			if tt.endpoints != nil {
+				slices = append(slices, newTestEndpointSlice(tt.endpoints...))
+			}
+			filter, _, _ := newTestNodeFilter(t, tt.policy, slices...)
+
+			filtered, err := filter.Filter(context.Background(), newTestLocalService(tt.annotations), testNodes("node-1", "node-2", "node-3"))
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, nodeNames(filtered))
+		})
+	}
+}
+
+func TestNodeFilter_LastKnownGood(t *testing.T) {
+	slice := newTestEndpointSlice(
+		discoveryv1.Endpoint{NodeName: ptr.To("node-2"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+	)
+	filter, indexer, recorder := newTestNodeFilter(t, EmptyEndpointsPolicyLastKnownGood, slice)
+	service := newTestLocalService(nil)
+	nodes := testNodes("node-1", "node-2", "node-3")
+
+	filtered, err := filter.Filter(context.Background(), service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-2"}, nodeNames(filtered))
+
+	// all endpoints become unready
+	unready := slice.DeepCopy()
+	unready.Endpoints[0].Conditions.Ready = ptr.To(false)
+	require.NoError(t, indexer.Update(unready))
+
+	filtered, err = filter.Filter(context.Background(), service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-2"}, nodeNames(filtered))
+
+	// a second reconcile without endpoints must not emit another event
+	_, err = filter.Filter(context.Background(), service, nodes)
+	require.NoError(t, err)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonNoReadyEndpoints)
+
+	// endpoints recover
+	require.NoError(t, indexer.Update(slice))
+	filtered, err = filter.Filter(context.Background(), service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-2"}, nodeNames(filtered))
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonReadyEndpointsRestored)
+}
+
+func TestNodeFilter_ClusterPolicyIsNotFiltered(t *testing.T) {
+	filter, _, _ := newTestNodeFilter(t, EmptyEndpointsPolicyDetachAll)
+	service := newTestLocalService(nil)
+	service.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+
+	filtered, err := filter.Filter(context.Background(), service, testNodes("node-1", "node-2"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1", "node-2"}, nodeNames(filtered))
+}
+
+func TestParseEmptyEndpointsPolicy(t *testing.T) {
+	policy, err := ParseEmptyEndpointsPolicy(" Last-Known-Good ")
+	require.NoError(t, err)
+	assert.Equal(t, EmptyEndpointsPolicyLastKnownGood, policy)
+
+	_, err = ParseEmptyEndpointsPolicy("drop")
+	assert.Error(t, err)
+}
+
+func TestNodeFilter_Peek(t *testing.T) {
+	slice := newTestEndpointSlice(
+		discoveryv1.Endpoint{NodeName: ptr.To("node-2"), Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+	)
+	filter, indexer, recorder := newTestNodeFilter(t, EmptyEndpointsPolicyLastKnownGood, slice)
+	service := newTestLocalService(nil)
+	nodes := testNodes("node-1", "node-2", "node-3")
+
+	peeked, err := filter.Peek(service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-2"}, nodeNames(peeked))
+	assert.Empty(t, filter.lastKnownGood, "peek does not record the last known good nodes")
+
+	// without endpoints, peek applies the policy without emitting events
+	unready := slice.DeepCopy()
+	unready.Endpoints[0].Conditions.Ready = ptr.To(false)
+	require.NoError(t, indexer.Update(unready))
+	peeked, err = filter.Peek(service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-1", "node-2", "node-3"}, nodeNames(peeked), "no last known good nodes were recorded")
+	assert.Empty(t, recorder.Events)
+	assert.Empty(t, filter.noReadyEndpoints)
+
+	// the last known good nodes recorded by Filter are used
+	require.NoError(t, indexer.Update(slice))
+	_, err = filter.Filter(context.Background(), service, nodes)
+	require.NoError(t, err)
+	require.NoError(t, indexer.Update(unready))
+	peeked, err = filter.Peek(service, nodes)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-2"}, nodeNames(peeked))
+	assert.Empty(t, recorder.Events)
+}
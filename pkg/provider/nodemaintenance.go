@@ -0,0 +1,226 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
+	v1helper "k8s.io/cloud-provider/node/helpers"
+	nodeutil "k8s.io/component-helpers/node/util"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DefaultNodeMaintenanceKey is the machine annotation marking machines for maintenance, if neither an annotation
+	// nor a label is configured
+	DefaultNodeMaintenanceKey = "maintenance"
+	// DefaultNodeMaintenanceValue is the value of the annotation or label marking machines for maintenance
+	DefaultNodeMaintenanceValue = "scheduled"
+
+	// NodeTaintMaintenance is the NoSchedule taint of nodes whose machine is marked for maintenance
+	NodeTaintMaintenance = NodeLabelPrefix + "maintenance"
+	// NodeConditionMaintenance is the node condition reporting if the machine of the node is marked for maintenance
+	NodeConditionMaintenance corev1.NodeConditionType = "MachineMaintenance"
+
+	// defaultNodeMaintenanceInterval is the default interval between two syncs of the maintenance state of nodes
+	defaultNodeMaintenanceInterval = time.Minute
+)
+
+// Reasons of the maintenance node condition and events
+const (
+	EventReasonMaintenanceScheduled = "MaintenanceScheduled"
+	EventReasonMaintenanceCompleted = "MaintenanceCompleted"
+)
+
+// NodeMaintenanceConfig configures syncing the maintenance state of machines to their nodes
+type NodeMaintenanceConfig struct {
+	// Enabled activates tainting the nodes of machines marked for maintenance
+	Enabled bool `yaml:"enabled"`
+	// Annotation is the machine annotation marking the machine for maintenance. Defaults to maintenance if no label is set.
+	Annotation string `yaml:"annotation,omitempty"`
+	// Label is the machine label marking the machine for maintenance
+	Label string `yaml:"label,omitempty"`
+	// Value is the value of the annotation or label marking the machine for maintenance. Defaults to scheduled, empty
+	// matches any value.
+	Value string `yaml:"value"`
+	// Interval is the interval in seconds between two syncs of the maintenance state of nodes. Defaults to 60.
+	Interval *int `yaml:"interval,omitempty"`
+}
+
+// nodeMaintenanceController taints the nodes whose machine is marked for maintenance with a NoSchedule taint and
+// sets their maintenance condition, so workloads can be drained before the maintenance. Both are removed when the
+// machine is no longer marked.
+type nodeMaintenanceController struct {
+	config NodeMaintenanceConfig
+
+	machineIndex *machineIndex
+	kubeClient   clientset.Interface
+	nodeLister   corelisters.NodeLister
+
+	recorder record.EventRecorder
+}
+
+func (c *nodeMaintenanceController) getInterval() time.Duration {
+	if c.config.Interval == nil || *c.config.Interval <= 0 {
+		return defaultNodeMaintenanceInterval
+	}
+	return time.Duration(*c.config.Interval) * time.Second
+}
+
+// run syncs the maintenance state of all nodes on every interval, until the stop channel is closed
+func (c *nodeMaintenanceController) run(stop <-chan struct{}) {
+	interval := c.getInterval()
+	klog.Infof("starting node maintenance sync every %s", interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer cancel()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := c.sync(ctx); err != nil {
+					klog.Errorf("failed to sync the maintenance state of nodes: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// sync taints or untaints every node with a provider ID according to the maintenance state of its machine
+func (c *nodeMaintenanceController) sync(ctx context.Context) error {
+	snapshot, err := c.machineIndex.get(ctx)
+	if err != nil {
+		return err
+	}
+	nodes, err := c.nodeLister.List(labels.Everything())
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for _, node := range nodes {
+		if node.Spec.ProviderID == "" {
+			continue
+		}
+		instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
+		if err != nil {
+			klog.V(4).Infof("skipping maintenance sync of node %s: %v", node.Name, err)
+			continue
+		}
+		machine, ok := snapshot.byIdentity[instanceID]
+		if !ok {
+			// the machine may be deleted, the node lifecycle controller handles the node
+			continue
+		}
+		if err := c.syncNode(node, machine); err != nil {
+			klog.Errorf("failed to sync the maintenance state of node %s: %v", node.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *nodeMaintenanceController) syncNode(node *corev1.Node, machine *iaas.Machine) error {
+	marker, value, maintenance := c.machineMaintenance(machine)
+	taint := &corev1.Taint{Key: NodeTaintMaintenance, Value: sanitizeLabelValue(value), Effect: corev1.TaintEffectNoSchedule}
+	_, condition := nodeutil.GetNodeCondition(&node.Status, NodeConditionMaintenance)
+
+	existing := findTaint(node, taint)
+	if maintenance {
+		if existing == nil || existing.Value != taint.Value {
+			klog.Infof("machine %s of node %s is marked for maintenance by %s=%s, tainting the node", machine.Identity, node.Name, marker, value)
+			if err := v1helper.AddOrUpdateTaintOnNode(c.kubeClient, node.Name, taint); err != nil {
+				return fmt.Errorf("failed to taint node: %v", err)
+			}
+			if c.recorder != nil {
+				c.recorder.Eventf(node, corev1.EventTypeWarning, EventReasonMaintenanceScheduled, "Machine %s is marked for maintenance by %s=%s", machine.Identity, marker, value)
+			}
+		}
+		message := fmt.Sprintf("Machine %s is marked for maintenance by %s=%s", machine.Identity, marker, value)
+		if condition == nil || condition.Status != corev1.ConditionTrue || condition.Message != message {
+			return c.setCondition(node, condition, corev1.ConditionTrue, EventReasonMaintenanceScheduled, message)
+		}
+		return nil
+	}
+
+	if existing != nil {
+		klog.Infof("machine %s of node %s is no longer marked for maintenance, removing the taint", machine.Identity, node.Name)
+		if err := v1helper.RemoveTaintOffNode(c.kubeClient, node.Name, node, taint); err != nil {
+			return fmt.Errorf("failed to remove taint: %v", err)
+		}
+		if c.recorder != nil {
+			c.recorder.Eventf(node, corev1.EventTypeNormal, EventReasonMaintenanceCompleted, "Machine %s is no longer marked for maintenance", machine.Identity)
+		}
+	}
+	// nodes that were never marked do not get the condition
+	if condition != nil && condition.Status != corev1.ConditionFalse {
+		return c.setCondition(node, condition, corev1.ConditionFalse, EventReasonMaintenanceCompleted, fmt.Sprintf("Machine %s is not marked for maintenance", machine.Identity))
+	}
+	return nil
+}
+
+// setCondition sets the maintenance condition of the node, keeping the transition time of the current condition if
+// its status does not change
+func (c *nodeMaintenanceController) setCondition(node *corev1.Node, current *corev1.NodeCondition, status corev1.ConditionStatus, reason string, message string) error {
+	now := metav1.Now()
+	transition := now
+	if current != nil && current.Status == status {
+		transition = current.LastTransitionTime
+	}
+	err := nodeutil.SetNodeCondition(c.kubeClient, types.NodeName(node.Name), corev1.NodeCondition{
+		Type:               NodeConditionMaintenance,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastHeartbeatTime:  now,
+		LastTransitionTime: transition,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set condition %s: %v", NodeConditionMaintenance, err)
+	}
+	return nil
+}
+
+// machineMaintenance returns the annotation or label marking the machine for maintenance and its value, and if the
+// machine is marked
+func (c *nodeMaintenanceController) machineMaintenance(machine *iaas.Machine) (string, string, bool) {
+	annotation := c.config.Annotation
+	if annotation == "" && c.config.Label == "" {
+		annotation = DefaultNodeMaintenanceKey
+	}
+	if annotation != "" {
+		if value, ok := machine.Annotations[annotation]; ok && c.matchesValue(value) {
+			return annotation, value, true
+		}
+	}
+	if c.config.Label != "" {
+		if value, ok := machine.Labels[c.config.Label]; ok && c.matchesValue(value) {
+			return c.config.Label, value, true
+		}
+	}
+	return "", "", false
+}
+
+func (c *nodeMaintenanceController) matchesValue(value string) bool {
+	return c.config.Value == "" || value == c.config.Value
+}
+
+// findTaint returns the taint of the node with the key and effect of the taint, or nil
+func findTaint(node *corev1.Node, taint *corev1.Taint) *corev1.Taint {
+	for idx := range node.Spec.Taints {
+		if node.Spec.Taints[idx].MatchTaint(taint) {
+			return &node.Spec.Taints[idx]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	nodeutil "k8s.io/component-helpers/node/util"
+)
+
+func TestNodeMaintenanceController_Sync(t *testing.T) {
+	machines := []iaas.Machine{
+		{Identity: "vm-1", Slug: "worker-1", Vpc: &iaas.Vpc{Identity: "vpc-test"}, Annotations: iaas.Annotations{"maintenance": "scheduled"}},
+		{Identity: "vm-2", Slug: "worker-2", Vpc: &iaas.Vpc{Identity: "vpc-test"}},
+	}
+	api := newMockCloudAPI(t)
+	api.EXPECT().ListMachines(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ *iaas.ListMachinesRequest) ([]iaas.Machine, error) {
+		return machines, nil
+	}).AnyTimes()
+	now := time.Now()
+	index := newMachineIndex(api, "vpc-test", time.Minute)
+	index.now = func() time.Time { return now }
+
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-1"}, Spec: corev1.NodeSpec{ProviderID: "thalassacloud://vm-1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker-2"}, Spec: corev1.NodeSpec{ProviderID: "thalassacloud://vm-2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "uninitialized"}},
+	}
+	client := fake.NewSimpleClientset()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, node := range nodes {
+		_, err := client.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.NoError(t, indexer.Add(node))
+	}
+	recorder := record.NewFakeRecorder(10)
+	controller := &nodeMaintenanceController{
+		config:       NodeMaintenanceConfig{Value: DefaultNodeMaintenanceValue},
+		machineIndex: index,
+		kubeClient:   client,
+		nodeLister:   corelisters.NewNodeLister(indexer),
+		recorder:     recorder,
+	}
+	// resync updates the lister from the client, like the node informer
+	resync := func() map[string]*corev1.Node {
+		current := map[string]*corev1.Node{}
+		for _, node := range nodes {
+			updated, err := client.CoreV1().Nodes().Get(context.Background(), node.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			require.NoError(t, indexer.Update(updated))
+			current[node.Name] = updated
+		}
+		return current
+	}
+
+	require.NoError(t, controller.sync(context.Background()))
+	current := resync()
+	require.Len(t, current["worker-1"].Spec.Taints, 1)
+	assert.Equal(t, corev1.Taint{Key: NodeTaintMaintenance, Value: "scheduled", Effect: corev1.TaintEffectNoSchedule}, current["worker-1"].Spec.Taints[0])
+	_, condition := nodeutil.GetNodeCondition(&current["worker-1"].Status, NodeConditionMaintenance)
+	require.NotNil(t, condition)
+	assert.Equal(t, corev1.ConditionTrue, condition.Status)
+	assert.Equal(t, EventReasonMaintenanceScheduled, condition.Reason)
+	assert.Empty(t, current["worker-2"].Spec.Taints)
+	_, condition = nodeutil.GetNodeCondition(&current["worker-2"].Status, NodeConditionMaintenance)
+	assert.Nil(t, condition, "nodes that were never marked do not get the condition")
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonMaintenanceScheduled)
+
+	// an unchanged maintenance state does not update the node again
+	client.ClearActions()
+	require.NoError(t, controller.sync(context.Background()))
+	assert.Empty(t, client.Actions())
+
+	// the taint is removed and the condition cleared once the machine is no longer marked
+	machines[0].Annotations = nil
+	now = now.Add(time.Minute)
+	require.NoError(t, controller.sync(context.Background()))
+	current = resync()
+	assert.Empty(t, current["worker-1"].Spec.Taints)
+	_, condition = nodeutil.GetNodeCondition(&current["worker-1"].Status, NodeConditionMaintenance)
+	require.NotNil(t, condition)
+	assert.Equal(t, corev1.ConditionFalse, condition.Status)
+	assert.Equal(t, EventReasonMaintenanceCompleted, condition.Reason)
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, EventReasonMaintenanceCompleted)
+}
+
+func TestNodeMaintenanceController_MachineMaintenance(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         NodeMaintenanceConfig
+		annotations    iaas.Annotations
+		labels         iaas.Labels
+		expectedMarker string
+		expectedValue  string
+		expectedMarked bool
+	}{
+		{
+			name:           "default annotation",
+			config:         NodeMaintenanceConfig{Value: DefaultNodeMaintenanceValue},
+			annotations:    iaas.Annotations{"maintenance": "scheduled"},
+			expectedMarker: "maintenance", expectedValue: "scheduled", expectedMarked: true,
+		},
+		{
+			name:        "other value",
+			config:      NodeMaintenanceConfig{Value: DefaultNodeMaintenanceValue},
+			annotations: iaas.Annotations{"maintenance": "done"},
+		},
+		{
+			name:           "any value",
+			config:         NodeMaintenanceConfig{Annotation: "example.com/evacuation"},
+			annotations:    iaas.Annotations{"example.com/evacuation": "2026-11-01"},
+			expectedMarker: "example.com/evacuation", expectedValue: "2026-11-01", expectedMarked: true,
+		},
+		{
+			name:           "label",
+			config:         NodeMaintenanceConfig{Label: "maintenance", Value: DefaultNodeMaintenanceValue},
+			labels:         iaas.Labels{"maintenance": "scheduled"},
+			expectedMarker: "maintenance", expectedValue: "scheduled", expectedMarked: true,
+		},
+		{
+			name:        "annotation is not checked if only a label is configured",
+			config:      NodeMaintenanceConfig{Label: "maintenance", Value: DefaultNodeMaintenanceValue},
+			annotations: iaas.Annotations{"maintenance": "scheduled"},
+		},
+		{
+			name:   "not marked",
+			config: NodeMaintenanceConfig{Value: DefaultNodeMaintenanceValue},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller := &nodeMaintenanceController{config: tt.config}
+			marker, value, marked := controller.machineMaintenance(&iaas.Machine{Identity: "vm-1", Annotations: tt.annotations, Labels: tt.labels})
+			assert.Equal(t, tt.expectedMarker, marker)
+			assert.Equal(t, tt.expectedValue, value)
+			assert.Equal(t, tt.expectedMarked, marked)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"fmt"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// keyedMutex provides mutual exclusion per key. Entries are reference counted
+// and removed once no goroutine holds or waits for the key.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedMutexEntry
+}
+
+type keyedMutexEntry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock blocks until the key is available and returns the function that releases it.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = map[string]*keyedMutexEntry{}
+	}
+	entry, ok := k.locks[key]
+	if !ok {
+		entry = &keyedMutexEntry{}
+		k.locks[key] = entry
+	}
+	entry.refs++
+	k.mu.Unlock()
+
+	entry.mu.Lock()
+	return func() {
+		entry.mu.Unlock()
+
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// len returns the number of keys currently held or waited for
+func (k *keyedMutex) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.locks)
+}
+
+// getServiceKey returns the namespace/name key of a service
+func getServiceKey(service *corev1.Service) string {
+	return fmt.Sprintf("%s/%s", service.GetNamespace(), service.GetName())
+}
+
+// lockService serializes reconciles of the same Service across the service controller
+// and the internal resync queue. The returned function releases the lock.
+func (lb *loadbalancer) lockService(service *corev1.Service) func() {
+	return lb.serviceLocks.Lock(getServiceKey(service))
+}
@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thalassa-cloud/client-go/iaas"
+	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/ptr"
+)
+
+func newTestIaasClient(t *testing.T, baseURL string) *iaas.Client {
+	t.Helper()
+	client, err := thalassaclient.NewClient(
+		thalassaclient.WithBaseURL(baseURL),
+		thalassaclient.WithOrganisation("org-test"),
+		thalassaclient.WithAuthPersonalToken("token"),
+	)
+	require.NoError(t, err)
+	iaasClient, err := iaas.New(client)
+	require.NoError(t, err)
+	return iaasClient
+}
+
+// concurrencyTracker records the maximum number of concurrent in-flight requests
+type concurrencyTracker struct {
+	inFlight atomic.Int32
+	max      atomic.Int32
+}
+
+func (c *concurrencyTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	current := c.inFlight.Add(1)
+	defer c.inFlight.Add(-1)
+	for {
+		max := c.max.Load()
+		if current <= max || c.max.CompareAndSwap(max, current) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("[]"))
+}
+
+func TestKeyedMutex(t *testing.T) {
+	var locks keyedMutex
+	var active, maxActive atomic.Int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := locks.Lock("default/svc")
+			defer unlock()
+			if current := active.Add(1); current > maxActive.Load() {
+				maxActive.Store(current)
+			}
+			time.Sleep(time.Millisecond)
+			active.Add(-1)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxActive.Load())
+	assert.Equal(t, 0, locks.len(), "released keys must be removed")
+
+	// different keys do not block each other
+	unlockA := locks.Lock("default/a")
+	done := make(chan struct{})
+	go func() {
+		unlockB := locks.Lock("default/b")
+		unlockB()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock on a different key was blocked")
+	}
+	unlockA()
+}
+
+func TestLoadBalancer_ReconcilesAreSerializedPerService(t *testing.T) {
+	tracker := &concurrencyTracker{}
+	server := httptest.NewServer(tracker)
+	defer server.Close()
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc", Namespace: "default", UID: "uid-svc"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	require.NoError(t, indexer.Add(service))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lb := &loadbalancer{
+		iaasClient:    newCloudAPI(newTestIaasClient(t, server.URL)),
+		vpcIdentity:   "vpc-test",
+		cluster:       "cluster-test",
+		config:        LoadBalancerConfig{ResyncWorkers: ptr.To(4)},
+		serviceLister: corelisters.NewServiceLister(indexer),
+		nodeLister:    corelisters.NewNodeLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})),
+		nodeFilter:    &NodeFilter{},
+		serviceQueue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](time.Hour, time.Hour),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "test-queue"},
+		),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < lb.getServiceResyncWorkers(); i++ {
+		go lb.processServiceQueue()
+	}
+	defer lb.stopServiceQueueProcessor()
+
+	// the service controller and the resync queue reconcile the same service at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		lb.triggerServiceResync("default/svc")
+		go func() {
+			defer wg.Done()
+			_ = lb.UpdateLoadBalancer(ctx, "cluster-test", service, nil)
+		}()
+	}
+	wg.Wait()
+	require.Eventually(t, func() bool { return lb.serviceQueue.Len() == 0 && tracker.inFlight.Load() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), tracker.max.Load(), "reconciles of the same service must not overlap")
+
+	// reconciles of different services run in parallel
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		other := service.DeepCopy()
+		other.Name = fmt.Sprintf("svc-%d", i)
+		go func() {
+			defer wg.Done()
+			_ = lb.UpdateLoadBalancer(ctx, "cluster-test", other, nil)
+		}()
+	}
+	wg.Wait()
+	assert.Greater(t, tracker.max.Load(), int32(1), "reconciles of different services should run in parallel")
+	assert.Equal(t, 0, lb.serviceLocks.len())
+}
@@ -0,0 +1,238 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	thalassaclient "github.com/thalassa-cloud/client-go/pkg/client"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	clientset "k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	// DefaultVolumeCSIDriver is the CSI driver of Thalassa Cloud block volumes
+	DefaultVolumeCSIDriver = "csi.thalassa.cloud"
+
+	// volumeZonesDelimiter separates the zones of a volume that can be attached in multiple zones in the zone label,
+	// as understood by the VolumeZone plugin of the scheduler
+	volumeZonesDelimiter = "__"
+)
+
+// Event reasons emitted by the volume labeler
+const (
+	EventReasonVolumeNotFound = "VolumeNotFound"
+)
+
+// VolumeLabelerConfig configures the topology of PersistentVolumes backed by Thalassa Cloud block volumes
+type VolumeLabelerConfig struct {
+	// Enabled activates labeling PersistentVolumes with the region and zones of their volume
+	Enabled bool `yaml:"enabled"`
+	// CSIDrivers are the CSI drivers whose volume handle is the identity of a Thalassa Cloud volume. Defaults to csi.thalassa.cloud.
+	CSIDrivers []string `yaml:"csiDrivers,omitempty"`
+	// NodeAffinity sets the node affinity of PersistentVolumes without node affinity to the region and zones of their
+	// volume. Defaults to true.
+	NodeAffinity *bool `yaml:"nodeAffinity,omitempty"`
+}
+
+// volumeLabeler sets the topology.kubernetes.io/region and zone labels, and the node affinity, of PersistentVolumes
+// backed by Thalassa Cloud block volumes, so pods using statically provisioned volumes are scheduled in a zone the
+// volume can be attached in
+type volumeLabeler struct {
+	config VolumeLabelerConfig
+
+	iaasClient VolumeAPI
+	kubeClient clientset.Interface
+	pvLister   corelisters.PersistentVolumeLister
+
+	queue    workqueue.TypedRateLimitingInterface[string]
+	recorder record.EventRecorder
+}
+
+func newVolumeLabeler(config VolumeLabelerConfig, iaasClient VolumeAPI, kubeClient clientset.Interface, informerFactory informers.SharedInformerFactory, recorder record.EventRecorder) *volumeLabeler {
+	labeler := &volumeLabeler{
+		config:     config,
+		iaasClient: iaasClient,
+		kubeClient: kubeClient,
+		pvLister:   informerFactory.Core().V1().PersistentVolumes().Lister(),
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.NewTypedItemExponentialFailureRateLimiter[string](5*time.Second, 5*time.Minute),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: "volume-labeler"},
+		),
+		recorder: recorder,
+	}
+	_, err := informerFactory.Core().V1().PersistentVolumes().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: labeler.enqueue,
+		UpdateFunc: func(_, newObj interface{}) {
+			labeler.enqueue(newObj)
+		},
+	})
+	if err != nil {
+		klog.Errorf("failed to register persistent volume event handler: %v", err)
+	}
+	return labeler
+}
+
+func (l *volumeLabeler) enqueue(obj interface{}) {
+	pv, ok := obj.(*corev1.PersistentVolume)
+	if !ok {
+		return
+	}
+	if _, ok := l.volumeIdentity(pv); ok {
+		l.queue.Add(pv.Name)
+	}
+}
+
+// run processes the queued PersistentVolumes until the stop channel is closed
+func (l *volumeLabeler) run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+		l.queue.ShutDown()
+	}()
+	go func() {
+		for l.processNextItem(ctx) {
+		}
+	}()
+}
+
+func (l *volumeLabeler) processNextItem(ctx context.Context) bool {
+	name, shutdown := l.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer l.queue.Done(name)
+
+	if err := l.sync(ctx, name); err != nil {
+		klog.Errorf("failed to label persistent volume %s: %v", name, err)
+		l.queue.AddRateLimited(name)
+		return true
+	}
+	l.queue.Forget(name)
+	return true
+}
+
+// sync sets the topology labels and node affinity of the PersistentVolume from its Thalassa Cloud volume
+func (l *volumeLabeler) sync(ctx context.Context, name string) error {
+	pv, err := l.pvLister.Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get persistent volume: %v", err)
+	}
+	if pv.DeletionTimestamp != nil {
+		return nil
+	}
+	volumeIdentity, ok := l.volumeIdentity(pv)
+	if !ok {
+		return nil
+	}
+
+	volume, err := l.iaasClient.GetVolume(ctx, volumeIdentity)
+	if err != nil {
+		if thalassaclient.IsNotFound(err) {
+			klog.Warningf("volume %s of persistent volume %s not found", volumeIdentity, pv.Name)
+			if l.recorder != nil {
+				l.recorder.Eventf(pv, corev1.EventTypeWarning, EventReasonVolumeNotFound, "Volume %s not found, the topology of the persistent volume is not set", volumeIdentity)
+			}
+			return nil
+		}
+		return fmt.Errorf("failed to get volume %s: %v", volumeIdentity, err)
+	}
+
+	region := ""
+	if volume.Region != nil {
+		region = volume.Region.Slug
+	}
+	var zones []string
+	for _, zone := range volume.AvailabilityZones {
+		if zone.Slug != "" && !slices.Contains(zones, zone.Slug) {
+			zones = append(zones, zone.Slug)
+		}
+	}
+	slices.Sort(zones)
+
+	labels := map[string]string{}
+	if region != "" && pv.Labels[corev1.LabelTopologyRegion] != region {
+		labels[corev1.LabelTopologyRegion] = region
+	}
+	if zoneLabel := strings.Join(zones, volumeZonesDelimiter); zoneLabel != "" && pv.Labels[corev1.LabelTopologyZone] != zoneLabel {
+		labels[corev1.LabelTopologyZone] = zoneLabel
+	}
+	var nodeAffinity *corev1.VolumeNodeAffinity
+	// the node affinity can only be set on PersistentVolumes without node affinity
+	if ptr.Deref(l.config.NodeAffinity, true) && pv.Spec.NodeAffinity == nil {
+		nodeAffinity = volumeNodeAffinity(region, zones)
+	}
+	if len(labels) == 0 && nodeAffinity == nil {
+		return nil
+	}
+
+	patch := map[string]interface{}{}
+	if len(labels) > 0 {
+		patch["metadata"] = map[string]interface{}{"labels": labels}
+	}
+	if nodeAffinity != nil {
+		patch["spec"] = map[string]interface{}{"nodeAffinity": nodeAffinity}
+	}
+	patchBytes, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal patch: %v", err)
+	}
+	klog.Infof("setting the topology of persistent volume %s to region %q and zones %v of volume %s", pv.Name, region, zones, volumeIdentity)
+	if _, err := l.kubeClient.CoreV1().PersistentVolumes().Patch(ctx, pv.Name, types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to patch persistent volume: %v", err)
+	}
+	return nil
+}
+
+// volumeIdentity returns the identity of the Thalassa Cloud volume of the PersistentVolume, which is the volume handle
+// of PersistentVolumes of the configured CSI drivers
+func (l *volumeLabeler) volumeIdentity(pv *corev1.PersistentVolume) (string, bool) {
+	if pv.Spec.CSI == nil || pv.Spec.CSI.VolumeHandle == "" {
+		return "", false
+	}
+	drivers := l.config.CSIDrivers
+	if len(drivers) == 0 {
+		drivers = []string{DefaultVolumeCSIDriver}
+	}
+	if !slices.Contains(drivers, pv.Spec.CSI.Driver) {
+		return "", false
+	}
+	return pv.Spec.CSI.VolumeHandle, true
+}
+
+// volumeNodeAffinity returns the node affinity requiring the region and one of the zones, nil if both are unknown
+func volumeNodeAffinity(region string, zones []string) *corev1.VolumeNodeAffinity {
+	var expressions []corev1.NodeSelectorRequirement
+	if region != "" {
+		expressions = append(expressions, corev1.NodeSelectorRequirement{Key: corev1.LabelTopologyRegion, Operator: corev1.NodeSelectorOpIn, Values: []string{region}})
+	}
+	if len(zones) > 0 {
+		expressions = append(expressions, corev1.NodeSelectorRequirement{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: zones})
+	}
+	if len(expressions) == 0 {
+		return nil
+	}
+	return &corev1.VolumeNodeAffinity{
+		Required: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: expressions}},
+		},
+	}
+}
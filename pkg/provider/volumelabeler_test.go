@@ -0,0 +1,156 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/thalassa-cloud/client-go/iaas"
+	"go.uber.org/mock/gomock"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+)
+
+func newTestPersistentVolume(name string, driver string, volumeHandle string) *corev1.PersistentVolume {
+	return &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: volumeHandle},
+			},
+		},
+	}
+}
+
+func TestVolumeLabeler_Sync(t *testing.T) {
+	zoneAffinity := &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+		MatchExpressions: []corev1.NodeSelectorRequirement{{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"nl-1b"}}},
+	}}}}
+
+	tests := []struct {
+		name                 string
+		config               VolumeLabelerConfig
+		pv                   *corev1.PersistentVolume
+		expectedLabels       map[string]string
+		expectedNodeAffinity *corev1.VolumeNodeAffinity
+		expectedEvent        string
+	}{
+		{
+			name:           "single zone",
+			pv:             newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-1"),
+			expectedLabels: map[string]string{corev1.LabelTopologyRegion: "nl-1", corev1.LabelTopologyZone: "nl-1a"},
+			expectedNodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: corev1.LabelTopologyRegion, Operator: corev1.NodeSelectorOpIn, Values: []string{"nl-1"}},
+					{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"nl-1a"}},
+				},
+			}}}},
+		},
+		{
+			name:           "multiple zones",
+			pv:             newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-2"),
+			expectedLabels: map[string]string{corev1.LabelTopologyRegion: "nl-1", corev1.LabelTopologyZone: "nl-1a__nl-1b"},
+			expectedNodeAffinity: &corev1.VolumeNodeAffinity{Required: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: corev1.LabelTopologyRegion, Operator: corev1.NodeSelectorOpIn, Values: []string{"nl-1"}},
+					{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"nl-1a", "nl-1b"}},
+				},
+			}}}},
+		},
+		{
+			name: "node affinity is not changed",
+			pv: func() *corev1.PersistentVolume {
+				pv := newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-1")
+				pv.Spec.NodeAffinity = zoneAffinity
+				return pv
+			}(),
+			expectedLabels:       map[string]string{corev1.LabelTopologyRegion: "nl-1", corev1.LabelTopologyZone: "nl-1a"},
+			expectedNodeAffinity: zoneAffinity,
+		},
+		{
+			name:           "node affinity disabled",
+			config:         VolumeLabelerConfig{NodeAffinity: ptr.To(false)},
+			pv:             newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-1"),
+			expectedLabels: map[string]string{corev1.LabelTopologyRegion: "nl-1", corev1.LabelTopologyZone: "nl-1a"},
+		},
+		{
+			name:   "configured driver",
+			config: VolumeLabelerConfig{CSIDrivers: []string{"example.com/csi"}},
+			pv:     newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-1"),
+		},
+		{
+			name: "other driver",
+			pv:   newTestPersistentVolume("pv-1", "ebs.csi.aws.com", "vol-1"),
+		},
+		{
+			name:          "volume not found",
+			pv:            newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-missing"),
+			expectedEvent: EventReasonVolumeNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := newFakeIaas(t)
+			cloud.addVolume("vol-1", "nl-1a")
+			cloud.addVolume("vol-2", "nl-1b", "nl-1a")
+
+			client := fake.NewSimpleClientset(tt.pv)
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			require.NoError(t, indexer.Add(tt.pv))
+			recorder := record.NewFakeRecorder(10)
+			labeler := &volumeLabeler{
+				config:     tt.config,
+				iaasClient: cloud.client(),
+				kubeClient: client,
+				pvLister:   corelisters.NewPersistentVolumeLister(indexer),
+				recorder:   recorder,
+			}
+
+			require.NoError(t, labeler.sync(context.Background(), tt.pv.Name))
+			pv, err := client.CoreV1().PersistentVolumes().Get(context.Background(), tt.pv.Name, metav1.GetOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedLabels, pv.Labels)
+			assert.Equal(t, tt.expectedNodeAffinity, pv.Spec.NodeAffinity)
+			if tt.expectedEvent != "" {
+				require.Len(t, recorder.Events, 1)
+				assert.Contains(t, <-recorder.Events, tt.expectedEvent)
+			} else {
+				assert.Empty(t, recorder.Events)
+			}
+
+			// a labeled PersistentVolume is not patched again
+			require.NoError(t, indexer.Update(pv))
+			client.ClearActions()
+			require.NoError(t, labeler.sync(context.Background(), tt.pv.Name))
+			assert.Empty(t, client.Actions())
+		})
+	}
+}
+
+func TestVolumeLabeler_SyncError(t *testing.T) {
+	api := newMockCloudAPI(t)
+	api.EXPECT().GetVolume(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ string) (*iaas.Volume, error) {
+		return nil, errors.New("unavailable")
+	}).AnyTimes()
+	pv := newTestPersistentVolume("pv-1", DefaultVolumeCSIDriver, "vol-1")
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	require.NoError(t, indexer.Add(pv))
+	labeler := &volumeLabeler{
+		iaasClient: api,
+		kubeClient: fake.NewSimpleClientset(pv),
+		pvLister:   corelisters.NewPersistentVolumeLister(indexer),
+	}
+
+	err := labeler.sync(context.Background(), "pv-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "vol-1")
+	assert.NoError(t, labeler.sync(context.Background(), "deleted"), "deleted persistent volumes are skipped")
+}